@@ -0,0 +1,217 @@
+package analysis
+
+import (
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+)
+
+/*
+基本块，表示指令表中 [Start, End) 区间内的一段指令，
+控制流只能从第一条指令进入，从最后一条指令离开
+*/
+type BasicBlock struct {
+	// 在 CFG.Blocks 中的下标
+	Index int
+	// 基本块的第一条指令
+	Start int
+	// 基本块最后一条指令的下一条指令
+	End int
+	// 前驱和后继基本块
+	Preds []*BasicBlock
+	Succs []*BasicBlock
+	// 直接支配者，入口块以及不可达的块为 nil
+	Idom *BasicBlock
+	// 在逆后序遍历中的序号，不可达的块为 -1
+	rpo int
+}
+
+/*
+返回基本块的最后一条指令
+*/
+func (self *BasicBlock) Last() int {
+	return self.End - 1
+}
+
+/*
+判断基本块是否可以从入口块到达
+*/
+func (self *BasicBlock) Reachable() bool {
+	return self.rpo >= 0
+}
+
+/*
+控制流图，Blocks 按照起始指令的顺序排列，第一个块即为入口块
+*/
+type CFG struct {
+	Proto  *Prototype
+	Blocks []*BasicBlock
+	// 每条指令所在的基本块的下标
+	blockOf []int
+	// 按逆后序排列的可达基本块
+	rpo []*BasicBlock
+}
+
+/*
+返回入口块，指令表为空时返回 nil
+*/
+func (self *CFG) Entry() *BasicBlock {
+	if len(self.Blocks) == 0 {
+		return nil
+	}
+	return self.Blocks[0]
+}
+
+/*
+返回 pc 处的指令所在的基本块
+*/
+func (self *CFG) BlockAt(pc int) *BasicBlock {
+	return self.Blocks[self.blockOf[pc]]
+}
+
+/*
+返回按逆后序排列的可达基本块
+*/
+func (self *CFG) ReversePostorder() []*BasicBlock {
+	return self.rpo
+}
+
+/*
+判断跳转指令的目标，对于非跳转指令返回 -1
+*/
+func jumpTarget(i Instruction, pc int) int {
+	switch i.Opcode() {
+	case OP_JMP, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP:
+		_, sbx := i.AsBx()
+		return pc + 1 + sbx
+	case OP_LOADBOOL:
+		if _, _, c := i.ABC(); c != 0 {
+			return pc + 2
+		}
+	}
+	return -1
+}
+
+/*
+返回 pc 处的指令执行之后可能到达的所有指令，超出指令表的目标会被忽略
+*/
+func Successors(f *Prototype, pc int) []int {
+	i := Instruction(f.Code[pc])
+	_, _, c := i.ABC()
+	var succs []int
+	add := func(target int) {
+		if target >= 0 && target < len(f.Code) {
+			succs = append(succs, target)
+		}
+	}
+
+	switch op := i.Opcode(); {
+	case op == OP_RETURN:
+		// 函数返回，没有后继
+	case op == OP_JMP || op == OP_FORPREP:
+		add(jumpTarget(i, pc))
+	case op == OP_FORLOOP || op == OP_TFORLOOP:
+		add(pc + 1)
+		add(jumpTarget(i, pc))
+	case op == OP_LOADBOOL && jumpTarget(i, pc) >= 0:
+		add(jumpTarget(i, pc))
+	case i.IsTest():
+		// 条件成立时执行下一条 JMP，否则跳过它
+		add(pc + 1)
+		add(pc + 2)
+	case op == OP_LOADKX || op == OP_SETLIST && c == 0:
+		// 跳过作为附加参数的 EXTRAARG
+		add(pc + 2)
+	default:
+		add(pc + 1)
+	}
+	return succs
+}
+
+/*
+判断 pc 处的指令是否会结束一个基本块：
+跳转指令、test 指令、FORPREP/FORLOOP、TFORLOOP、C 不为 0 的 LOADBOOL，以及 RETURN/TAILCALL
+*/
+func endsBlock(i Instruction) bool {
+	switch i.Opcode() {
+	case OP_JMP, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP, OP_RETURN, OP_TAILCALL:
+		return true
+	case OP_LOADBOOL:
+		_, _, c := i.ABC()
+		return c != 0
+	default:
+		return i.IsTest()
+	}
+}
+
+/*
+把 Prototype 的指令表划分成基本块，并计算前驱、后继以及支配关系
+*/
+func BuildCFG(f *Prototype) *CFG {
+	n := len(f.Code)
+	leaders := make([]bool, n+1)
+	if n > 0 {
+		leaders[0] = true
+	}
+	for pc := 0; pc < n; pc++ {
+		i := Instruction(f.Code[pc])
+		if endsBlock(i) {
+			leaders[pc+1] = true
+		}
+		if target := jumpTarget(i, pc); target >= 0 && target < n {
+			leaders[target] = true
+		}
+		if i.IsTest() && pc+2 <= n {
+			leaders[pc+2] = true
+		}
+	}
+
+	cfg := &CFG{Proto: f, blockOf: make([]int, n)}
+	for pc := 0; pc < n; pc++ {
+		if leaders[pc] {
+			cfg.Blocks = append(cfg.Blocks, &BasicBlock{Index: len(cfg.Blocks), Start: pc, rpo: -1})
+		}
+		block := cfg.Blocks[len(cfg.Blocks)-1]
+		block.End = pc + 1
+		cfg.blockOf[pc] = block.Index
+	}
+
+	for _, block := range cfg.Blocks {
+		for _, target := range Successors(f, block.Last()) {
+			succ := cfg.BlockAt(target)
+			block.Succs = append(block.Succs, succ)
+			succ.Preds = append(succ.Preds, block)
+		}
+	}
+
+	if len(cfg.Blocks) > 0 {
+		cfg.computeRPO()
+		cfg.computeDominators()
+	}
+	return cfg
+}
+
+/*
+从入口块开始进行深度优先遍历，计算逆后序
+*/
+func (self *CFG) computeRPO() {
+	visited := make([]bool, len(self.Blocks))
+	var postorder []*BasicBlock
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		visited[b.Index] = true
+		for _, succ := range b.Succs {
+			if !visited[succ.Index] {
+				visit(succ)
+			}
+		}
+		postorder = append(postorder, b)
+	}
+	visit(self.Entry())
+
+	self.rpo = make([]*BasicBlock, len(postorder))
+	for i, b := range postorder {
+		idx := len(postorder) - 1 - i
+		self.rpo[idx] = b
+		b.rpo = idx
+	}
+}
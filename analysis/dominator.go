@@ -0,0 +1,78 @@
+package analysis
+
+/*
+使用 Cooper、Harvey 和 Kennedy 提出的迭代算法计算每个可达基本块的直接支配者，
+详见论文 "A Simple, Fast Dominance Algorithm"
+*/
+func (self *CFG) computeDominators() {
+	entry := self.Entry()
+	// 计算过程中入口块的直接支配者暂时设为其自身，结束后再改回 nil
+	entry.Idom = entry
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range self.rpo[1:] {
+			var idom *BasicBlock
+			for _, pred := range b.Preds {
+				if pred.Idom == nil {
+					// 前驱尚未处理或不可达
+					continue
+				}
+				if idom == nil {
+					idom = pred
+				} else {
+					idom = intersect(pred, idom)
+				}
+			}
+			if b.Idom != idom {
+				b.Idom = idom
+				changed = true
+			}
+		}
+	}
+	entry.Idom = nil
+}
+
+/*
+沿着支配树向上查找两个基本块最近的公共支配者
+*/
+func intersect(b1, b2 *BasicBlock) *BasicBlock {
+	for b1 != b2 {
+		for b1.rpo > b2.rpo {
+			b1 = b1.Idom
+		}
+		for b2.rpo > b1.rpo {
+			b2 = b2.Idom
+		}
+	}
+	return b1
+}
+
+/*
+判断基本块 a 是否支配基本块 b，即从入口块到 b 的所有路径都经过 a；
+每个块都支配其自身，不可达的块不被任何块支配
+*/
+func (self *CFG) Dominates(a, b *BasicBlock) bool {
+	if !a.Reachable() || !b.Reachable() {
+		return false
+	}
+	for ; b != nil; b = b.Idom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+返回被基本块 b 直接支配的所有基本块，即其在支配树中的子节点
+*/
+func (self *CFG) Dominated(b *BasicBlock) []*BasicBlock {
+	var children []*BasicBlock
+	for _, other := range self.rpo {
+		if other.Idom == b {
+			children = append(children, other)
+		}
+	}
+	return children
+}
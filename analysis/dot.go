@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	. "lua-vm/binchunk"
+	"strings"
+)
+
+/*
+把控制流图以 Graphviz 的 DOT 格式写入 w，每个节点中包含该基本块反汇编后的指令；
+实线表示控制流，不可达的基本块以灰色显示
+*/
+func (self *CFG) WriteDOT(w io.Writer) error {
+	f := self.Proto
+	opts := DisasmOptions{Address: func(p *Prototype) string {
+		for i, sub := range f.Protos {
+			if sub == p {
+				return fmt.Sprintf("function[%d]", i)
+			}
+		}
+		return "?"
+	}}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(fmt.Sprintf("%s:%d,%d", f.Source, f.LineDefined, f.LastLineDefined)))
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+
+	for _, block := range self.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "B%d\n", block.Index)
+		for pc := block.Start; pc < block.End; pc++ {
+			line := "-"
+			if pc < len(f.LineInfo) {
+				line = fmt.Sprintf("%d", f.LineInfo[pc])
+			}
+			text := strings.Replace(FormatInstruction(f, pc, opts), "\t", " ", -1)
+			fmt.Fprintf(&label, "%d [%s] %s\n", pc+1, line, text)
+		}
+
+		attrs := ""
+		if !block.Reachable() {
+			attrs = ", color=gray, fontcolor=gray"
+		}
+		fmt.Fprintf(&b, "\tB%d [label=%s%s];\n", block.Index, dotLabel(label.String()), attrs)
+	}
+
+	for _, block := range self.Blocks {
+		for _, succ := range block.Succs {
+			fmt.Fprintf(&b, "\tB%d -> B%d;\n", block.Index, succ.Index)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+/*
+把字符串转换成 DOT 中带双引号的字符串
+*/
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+/*
+把多行文本转换成 DOT 中左对齐的标签，每一行都以 \l 结尾
+*/
+func dotLabel(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\l", -1)
+	return "\"" + s + "\""
+}
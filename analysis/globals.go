@@ -0,0 +1,137 @@
+package analysis
+
+import (
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+)
+
+/*
+一次对全局变量的访问，即通过 _ENV 以常量字符串为键的 GETTABUP/SETTABUP；
+无法确定访问的是哪个全局变量时，Unresolved 说明了原因
+*/
+type GlobalAccess struct {
+	// 访问所在的函数，Path 为它在函数原型树中的位置（从主函数开始依次经过的子函数下标）
+	Func *Prototype
+	Path []int
+	Pc   int
+	// 源代码行号，没有行号信息时为 0
+	Line  int
+	Name  string
+	Write bool
+	// 动态的键、局部变量 _ENV、把 _ENV 当作普通的值使用等情况下不为空，此时 Name 可能为空
+	Unresolved string
+}
+
+/*
+Upvalue 与 _ENV 的关系
+*/
+const (
+	envNone   = iota // 与 _ENV 无关
+	envGlobal        // 沿着外层函数一直指向主函数的第一个 Upvalue，即全局环境
+	envLocal         // 名字为 _ENV，但指向某个外层函数中名为 _ENV 的局部变量
+)
+
+/*
+遍历整个函数原型树，按照函数的先序以及指令的顺序返回所有对全局变量的访问。
+主函数的第一个 Upvalue 就是全局环境，子函数的 Upvalue 沿着 Instack/Idx 逐层解析，
+因此即使 UpvalueNames 被剔除也能找到全局变量；
+只有名字被保留时才能识别出 local _ENV 这样的别名
+*/
+func FindGlobals(main *Prototype) []GlobalAccess {
+	var env []int
+	for i := range main.Upvalues {
+		kind := envNone
+		if i == 0 {
+			kind = envGlobal
+		}
+		env = append(env, kind)
+	}
+	var accesses []GlobalAccess
+	findGlobals(main, nil, env, &accesses)
+	return accesses
+}
+
+func findGlobals(f *Prototype, path []int, env []int, accesses *[]GlobalAccess) {
+	report := func(pc int, name string, write bool, unresolved string) {
+		*accesses = append(*accesses, GlobalAccess{
+			Func: f, Path: path, Pc: pc, Line: f.LineAt(pc),
+			Name: name, Write: write, Unresolved: unresolved,
+		})
+	}
+	isEnv := func(upval int) int {
+		if upval < len(env) {
+			return env[upval]
+		}
+		return envNone
+	}
+
+	for pc := range f.Code {
+		i := Instruction(f.Code[pc])
+		a, b, c := i.ABC()
+		switch i.Opcode() {
+		case OP_GETTABUP:
+			if kind := isEnv(b); kind != envNone {
+				name, reason := globalKey(f, c, kind)
+				report(pc, name, false, reason)
+			}
+		case OP_SETTABUP:
+			if kind := isEnv(a); kind != envNone {
+				name, reason := globalKey(f, b, kind)
+				report(pc, name, true, reason)
+			}
+		case OP_GETTABLE:
+			if name, _ := f.LocalName(b, pc); name == "_ENV" {
+				name, _ := globalKey(f, c, envLocal)
+				report(pc, name, false, "_ENV is a local variable")
+			}
+		case OP_SETTABLE:
+			if name, _ := f.LocalName(a, pc); name == "_ENV" {
+				name, _ := globalKey(f, b, envLocal)
+				report(pc, name, true, "_ENV is a local variable")
+			}
+		case OP_GETUPVAL:
+			if isEnv(b) == envGlobal {
+				report(pc, "", false, "_ENV used as a value")
+			}
+		case OP_SETUPVAL:
+			if isEnv(b) == envGlobal {
+				report(pc, "", true, "_ENV is assigned")
+			}
+		}
+	}
+
+	for idx, child := range f.Protos {
+		var childEnv []int
+		for i, upval := range child.Upvalues {
+			kind := envNone
+			if upval.Instack == 0 {
+				if int(upval.Idx) < len(env) {
+					kind = env[upval.Idx]
+				}
+			} else if i < len(child.UpvalueNames) && child.UpvalueNames[i] == "_ENV" {
+				kind = envLocal
+			}
+			childEnv = append(childEnv, kind)
+		}
+		childPath := append(append([]int(nil), path...), idx)
+		findGlobals(child, childPath, childEnv, accesses)
+	}
+}
+
+/*
+解析访问全局变量时 RK 形式的键，只有常量字符串才能确定变量的名字；
+通过局部变量 _ENV 访问时即使知道名字也视为无法确定
+*/
+func globalKey(f *Prototype, rk int, kind int) (name, reason string) {
+	if kind == envLocal {
+		reason = "_ENV is a local variable"
+	}
+	if !ISK(rk) {
+		return "", "dynamic key"
+	}
+	idx := INDEXK(rk)
+	if idx >= len(f.Constants) || !f.Constants[idx].IsString() {
+		return "", "non-string key"
+	}
+	return f.Constants[idx].Value.(string), reason
+}
@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+)
+
+/*
+返回 pc 处的指令读取的寄存器（包括被 CLOSURE 捕获的寄存器）以及一定会写入的寄存器。
+读取的寄存器可以多算：B 或 C 为 0 时读取到栈顶，这里视为一直读取到 MaxStackSize；
+写入的寄存器只能少算：条件写入（TESTSET、TFORLOOP、FORLOOP 的 A+3）以及个数不定的返回值都不计入
+*/
+func RegisterUses(f *Prototype, pc int) (uses, defs []int) {
+	i := Instruction(f.Code[pc])
+	a, b, c := i.ABC()
+	top := int(f.MaxStackSize) - 1
+	span := func(regs []int, from, to int) []int {
+		for r := from; r <= to; r++ {
+			regs = append(regs, r)
+		}
+		return regs
+	}
+	rk := func(regs []int, x int) []int {
+		if !ISK(x) {
+			regs = append(regs, x)
+		}
+		return regs
+	}
+	// B 为 0 时读取到栈顶
+	open := func(from, n int) []int {
+		if n == 0 {
+			return span(nil, from, top)
+		}
+		return span(nil, from, from+n-1)
+	}
+
+	switch op := i.Opcode(); op {
+	case OP_MOVE, OP_UNM, OP_BNOT, OP_NOT, OP_LEN:
+		return []int{b}, []int{a}
+	case OP_LOADK, OP_LOADKX, OP_LOADBOOL, OP_GETUPVAL, OP_NEWTABLE:
+		return nil, []int{a}
+	case OP_LOADNIL:
+		return nil, span(nil, a, a+b)
+	case OP_GETTABUP:
+		return rk(nil, c), []int{a}
+	case OP_GETTABLE:
+		return rk([]int{b}, c), []int{a}
+	case OP_SETTABUP:
+		return rk(rk(nil, b), c), nil
+	case OP_SETUPVAL:
+		return []int{a}, nil
+	case OP_SETTABLE:
+		return rk(rk([]int{a}, b), c), nil
+	case OP_SELF:
+		return rk([]int{b}, c), []int{a, a + 1}
+	case OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR:
+		return rk(rk(nil, b), c), []int{a}
+	case OP_CONCAT:
+		return span(nil, b, c), []int{a}
+	case OP_EQ, OP_LT, OP_LE:
+		return rk(rk(nil, b), c), nil
+	case OP_TEST:
+		return []int{a}, nil
+	case OP_TESTSET:
+		return []int{b}, nil
+	case OP_CALL:
+		if c > 1 {
+			defs = span(nil, a, a+c-2)
+		}
+		return open(a, b), defs
+	case OP_TAILCALL:
+		return open(a, b), nil
+	case OP_RETURN:
+		if b == 0 {
+			return span(nil, a, top), nil
+		}
+		return span(nil, a, a+b-2), nil
+	case OP_FORLOOP:
+		return span(nil, a, a+2), []int{a}
+	case OP_FORPREP:
+		return span(nil, a, a+2), []int{a}
+	case OP_TFORCALL:
+		return span(nil, a, a+2), span(nil, a+3, a+2+c)
+	case OP_TFORLOOP:
+		return []int{a + 1}, nil
+	case OP_SETLIST:
+		if b == 0 {
+			return span(nil, a, top), nil
+		}
+		return span(nil, a, a+b), nil
+	case OP_CLOSURE:
+		_, bx := i.ABx()
+		for _, upval := range f.Protos[bx].Upvalues {
+			if upval.Instack == 1 {
+				uses = append(uses, int(upval.Idx))
+			}
+		}
+		return uses, []int{a}
+	case OP_VARARG:
+		if b > 1 {
+			defs = span(nil, a, a+b-2)
+		}
+		return nil, defs
+	}
+	return nil, nil
+}
+
+/*
+返回每个局部变量所在的寄存器，与 luac 一样，局部变量的寄存器等于在它开始时仍然有效的局部变量的个数
+*/
+func LocalRegisters(f *Prototype) []int {
+	regs := make([]int, len(f.LocVars))
+	for i, v := range f.LocVars {
+		for j := 0; j < i; j++ {
+			if w := f.LocVars[j]; w.StartPc <= v.StartPc && v.StartPc < w.EndPc {
+				regs[i]++
+			}
+		}
+	}
+	return regs
+}
@@ -0,0 +1,88 @@
+package analysis
+
+import (
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+)
+
+/*
+根据指令用到的寄存器（包括被子函数捕获的寄存器）计算函数所需的寄存器数量，至少为 2，与 luac 一致；
+B 或 C 为 0 的指令使用到栈顶为止的寄存器，它们由前面的 CALL 或 VARARG 动态产生，不计入其中
+*/
+func StackSize(p *Prototype) int {
+	top := int(p.NumParams)
+	use := func(reg int) {
+		if reg+1 > top {
+			top = reg + 1
+		}
+	}
+	rk := func(x int) {
+		if !ISK(x) {
+			use(x)
+		}
+	}
+
+	for _, code := range p.Code {
+		i := Instruction(code)
+		a, b, c := i.ABC()
+		switch op := i.Opcode(); op {
+		case OP_JMP, OP_EXTRAARG:
+		case OP_SETTABUP:
+			rk(b)
+			rk(c)
+		case OP_LOADNIL:
+			use(a + b)
+		case OP_SELF:
+			use(a + 1)
+			rk(c)
+		case OP_CALL:
+			use(a + b - 1)
+			use(a + c - 2)
+		case OP_TAILCALL:
+			use(a + b - 1)
+		case OP_RETURN, OP_VARARG:
+			use(a + b - 2)
+		case OP_FORLOOP, OP_FORPREP:
+			use(a + 3)
+		case OP_TFORCALL:
+			// 调用之前先把 R(A)、R(A+1)、R(A+2) 复制到 R(A+3) 开始的位置，C 小于 3 时也要用到 R(A+5)
+			use(a + 5)
+			use(a + 2 + c)
+		case OP_TFORLOOP:
+			use(a + 1)
+		case OP_SETLIST:
+			use(a + b)
+		case OP_CLOSURE:
+			use(a)
+			_, bx := i.ABx()
+			if bx < len(p.Protos) {
+				for _, upval := range p.Protos[bx].Upvalues {
+					if upval.Instack == 1 {
+						use(int(upval.Idx))
+					}
+				}
+			}
+		default:
+			use(a)
+			if i.OpMode() != IABC {
+				break
+			}
+			switch i.BMode() {
+			case OpArgR:
+				use(b)
+			case OpArgK:
+				rk(b)
+			}
+			switch i.CMode() {
+			case OpArgR:
+				use(c)
+			case OpArgK:
+				rk(c)
+			}
+		}
+	}
+	if top < 2 {
+		top = 2
+	}
+	return top
+}
@@ -12,3 +12,14 @@ const (
 	LUA_TUSERDATA
 	LUA_TTHREAD
 )
+
+/* thread status */
+const (
+	LUA_OK = iota
+	LUA_YIELD
+	LUA_ERRRUN
+	LUA_ERRSYNTAX
+	LUA_ERRMEM
+	LUA_ERRGCMM
+	LUA_ERRERR
+)
@@ -41,4 +41,6 @@ type LuaState interface {
 	PushInteger(n int64)
 	PushNumber(n float64)
 	PushString(s string)
+	/* 'load' function (load Lua code) */
+	Load(chunk []byte, chunkName, mode string) int
 }
@@ -0,0 +1,392 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	. "lua-vm/binchunk"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+汇编过程中发现的错误，Line 为出错的行号
+*/
+type Error struct {
+	Chunk string
+	Line  int
+	Msg   string
+}
+
+func (self *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", self.Chunk, self.Line, self.Msg)
+}
+
+func errorf(format string, a ...interface{}) error {
+	return fmt.Errorf(format, a...)
+}
+
+/*
+把文本形式的汇编代码翻译成函数原型，chunkName 用于错误信息以及主函数默认的 Source。
+
+输入可以直接是 Disassemble（或 `luac -l -l`）的输出：函数头、参数行以及常量表、局部变量表和
+Upvalue 表都会被识别，子函数按照参数行中 "N functions" 的数量还原成树形结构；
+只有 `luac -l` 的输出时缺少常量表和 Upvalue 表：被指令引用的常量从指令的注释中恢复，
+其中浮点数只有注释中的 14 位有效数字，没有被引用的常量用 nil 占位；主函数默认拥有 _ENV，
+子函数的 Upvalue 则需要用 .upvalue 手动补上。
+
+也可以手写，每行一条指令或一条伪指令，`;` 之后的内容为注释：
+
+	.source "@file.lua"        函数的 Source
+	.linedefined 3 5           起始和结束行号
+	.params 2                  固定参数的个数
+	.vararg                    变长参数函数（主函数默认如此）
+	.maxstack 8                寄存器数量，缺省时根据用到的寄存器计算
+	.upvalue _ENV 1 0          Upvalue 的名字、instack 和 idx，名字为 - 表示没有名字
+	.const name "value"        命名常量，可以在操作数中用名字引用
+	.local name start end      局部变量，start 和 end 为标签或者（与 luac 一致的）从 1 开始的指令序号
+	.line 10                   之后的指令所在的行号
+	.function name ... .end    子函数，CLOSURE 的 Bx 可以使用它的名字
+	name:                      标签，iAsBx 指令的 sBx 可以使用标签作为跳转目标
+
+指令由指令名和操作数组成，操作数以空白或逗号分隔，可以在行首带上序号和 [行号]。
+RK 操作数和常量操作数中，非负整数为寄存器（对 Bx、Ax 为原始值），负数 -1-k 为常量表的第 k 项，
+此外还可以写 "str"、#1、#1.5、#true、#nil 这样的常量字面量或者命名常量，它们会被加入常量表；
+Upvalue 操作数可以写成 Upvalue 的名字。所有操作数都会按照指令的模式检查取值范围
+*/
+func Assemble(r io.Reader, chunkName string) (proto *Prototype, err error) {
+	a := &assembler{chunkName: chunkName}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*Error); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		a.line++
+		a.parseLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a.finish(), nil
+}
+
+/*
+汇编器的状态，函数原型在输入全部读完之后才统一生成，这样前向引用的标签、常量和子函数都能被解析
+*/
+type assembler struct {
+	chunkName string
+	// 当前的行号
+	line int
+	// 主函数
+	root *function
+	// 当前正在定义的函数
+	cur *function
+	// 外层 .function 块中的函数
+	blocks []*function
+	// 按照 luac 输出中的函数头定义的、子函数数量尚未满足的函数
+	listing []*function
+	// 当前所在的 constants、locals 或 upvalues 表
+	section string
+}
+
+/*
+抛出一个带有当前行号的错误
+*/
+func (self *assembler) errorf(format string, a ...interface{}) {
+	panic(&Error{self.chunkName, self.line, fmt.Sprintf(format, a...)})
+}
+
+func (self *assembler) check(err error) {
+	if err != nil {
+		self.errorf("%s", err)
+	}
+}
+
+var (
+	headerPattern  = regexp.MustCompile(`^(main|function) <(.*):(\d+),(\d+)> \(\d+ instructions? at [^)]*\)$`)
+	paramsPattern  = regexp.MustCompile(`^(\d+)(\+?) params?, (\d+) slots?, \d+ upvalues?, \d+ locals?, (\d+) constants?, (\d+) functions?$`)
+	sectionPattern = regexp.MustCompile(`^(constants|locals|upvalues) \(\d+\) for .*:$`)
+)
+
+func (self *assembler) parseLine(line string) {
+	text, comment := splitComment(line)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	if m := headerPattern.FindStringSubmatch(text); m != nil {
+		self.parseHeader(m)
+		return
+	}
+	if m := paramsPattern.FindStringSubmatch(text); m != nil {
+		self.parseParams(m)
+		return
+	}
+	if m := sectionPattern.FindStringSubmatch(text); m != nil {
+		self.function()
+		self.section = m[1]
+		return
+	}
+
+	tokens, err := tokenize(text)
+	self.check(err)
+	if tokens[0][0] == '.' {
+		self.section = ""
+		self.parseDirective(tokens)
+		return
+	}
+	if self.section != "" {
+		self.parseSection(tokens)
+		return
+	}
+
+	f := self.function()
+	for len(tokens) > 0 && strings.HasSuffix(tokens[0], ":") {
+		f.defineLabel(self, strings.TrimSuffix(tokens[0], ":"))
+		tokens = tokens[1:]
+	}
+	if len(tokens) > 0 {
+		f.parseInstruction(self, tokens, comment)
+	}
+}
+
+/*
+返回当前的函数，手写的汇编代码中第一次用到时创建主函数
+*/
+func (self *assembler) function() *function {
+	if self.cur == nil {
+		self.root = newFunction(nil)
+		self.root.srcLine = self.line
+		self.cur = self.root
+	}
+	return self.cur
+}
+
+/*
+luac 输出的函数头，函数按照先序排列，父函数由尚未满足的子函数数量确定
+*/
+func (self *assembler) parseHeader(m []string) {
+	if len(self.blocks) > 0 {
+		self.errorf("function header inside .function block")
+	}
+	self.section = ""
+	for len(self.listing) > 0 && self.listing[len(self.listing)-1].remaining == 0 {
+		self.listing = self.listing[:len(self.listing)-1]
+	}
+
+	var f *function
+	switch {
+	case len(self.listing) > 0:
+		parent := self.listing[len(self.listing)-1]
+		parent.remaining--
+		f = newFunction(parent)
+		parent.children = append(parent.children, f)
+	case self.root == nil:
+		f = newFunction(nil)
+		self.root = f
+	default:
+		self.errorf("unexpected function header")
+	}
+
+	if m[2] != "?" {
+		f.proto.Source = "@" + m[2]
+	}
+	f.proto.LineDefined = uint32(self.number(m[3], 0, 1<<31))
+	f.proto.LastLineDefined = uint32(self.number(m[4], 0, 1<<31))
+	f.hasSource = m[2] != "?"
+	f.srcLine = self.line
+	self.listing = append(self.listing, f)
+	self.cur = f
+}
+
+/*
+luac 输出中函数头下面的参数行
+*/
+func (self *assembler) parseParams(m []string) {
+	f := self.function()
+	f.proto.NumParams = byte(self.number(m[1], 0, 255))
+	f.vararg = m[2] == "+"
+	f.hasVararg = true
+	f.proto.MaxStackSize = byte(self.number(m[3], 0, 255))
+	f.hasMaxStack = true
+	f.numConsts = self.number(m[4], 0, 1<<31)
+	f.remaining = self.number(m[5], 0, 1<<31)
+}
+
+/*
+常量表、局部变量表和 Upvalue 表中的一行，格式与 luac 的输出相同，局部变量的 pc 从 1 开始
+*/
+func (self *assembler) parseSection(tokens []string) {
+	f := self.function()
+	if len(tokens) < 2 {
+		self.errorf("bad %s entry", self.section)
+	}
+	idx := self.number(tokens[0], 0, 1<<31)
+
+	switch self.section {
+	case "constants":
+		f.hasConstants = true
+		if len(tokens) != 2 {
+			self.errorf("bad constant entry")
+		}
+		val, err := parseValue(tokens[1])
+		self.check(err)
+		if idx < 1 || f.explicit[idx-1] != nil {
+			self.errorf("bad constant index %d", idx)
+		}
+		k := NewConstant(val)
+		f.explicit[idx-1] = &k
+	case "locals":
+		if len(tokens) < 4 {
+			self.errorf("bad local entry")
+		}
+		n := len(tokens)
+		name := strings.Join(tokens[1:n-2], " ")
+		f.locals = append(f.locals, localDecl{name, tokens[n-2], tokens[n-1], self.line})
+	case "upvalues":
+		if len(tokens) != 4 {
+			self.errorf("bad upvalue entry")
+		}
+		f.addUpvalue(self, tokens[1], tokens[2], tokens[3])
+	}
+}
+
+func (self *assembler) parseDirective(tokens []string) {
+	args := tokens[1:]
+	want := func(n int) {
+		if len(args) != n {
+			self.errorf("%s expects %d argument%s", tokens[0], n, plural(n))
+		}
+	}
+
+	switch tokens[0] {
+	case ".function":
+		if len(args) > 1 {
+			self.errorf(".function expects at most 1 argument")
+		}
+		parent := self.function()
+		if len(args) == 1 {
+			name := args[0]
+			if !isName(name) {
+				self.errorf("bad function name %s", name)
+			}
+			if _, ok := parent.childNames[name]; ok {
+				self.errorf("function %s redefined", name)
+			}
+			parent.childNames[name] = len(parent.children)
+		}
+		f := newFunction(parent)
+		f.srcLine = self.line
+		parent.children = append(parent.children, f)
+		self.blocks = append(self.blocks, parent)
+		self.cur = f
+		return
+	case ".end":
+		want(0)
+		if len(self.blocks) == 0 {
+			self.errorf(".end without .function")
+		}
+		self.cur = self.blocks[len(self.blocks)-1]
+		self.blocks = self.blocks[:len(self.blocks)-1]
+		return
+	}
+
+	f := self.function()
+	switch tokens[0] {
+	case ".source":
+		want(1)
+		s, err := unquote(args[0])
+		self.check(err)
+		f.proto.Source = s
+		f.hasSource = true
+	case ".linedefined":
+		want(2)
+		f.proto.LineDefined = uint32(self.number(args[0], 0, 1<<31))
+		f.proto.LastLineDefined = uint32(self.number(args[1], 0, 1<<31))
+	case ".params":
+		want(1)
+		f.proto.NumParams = byte(self.number(args[0], 0, 255))
+	case ".vararg":
+		want(0)
+		f.vararg = true
+		f.hasVararg = true
+	case ".maxstack":
+		want(1)
+		f.proto.MaxStackSize = byte(self.number(args[0], 0, 255))
+		f.hasMaxStack = true
+	case ".upvalue":
+		want(3)
+		f.addUpvalue(self, args[0], args[1], args[2])
+	case ".const":
+		want(2)
+		if !isName(args[0]) {
+			self.errorf("bad constant name %s", args[0])
+		}
+		if _, ok := f.consts[args[0]]; ok {
+			self.errorf("constant %s redefined", args[0])
+		}
+		val, err := parseValue(args[1])
+		self.check(err)
+		f.consts[args[0]] = NewConstant(val)
+	case ".local":
+		want(3)
+		f.locals = append(f.locals, localDecl{args[0], args[1], args[2], self.line})
+	case ".line":
+		want(1)
+		f.lineNo = uint32(self.number(args[0], 0, 1<<31))
+	default:
+		self.errorf("unknown directive %s", tokens[0])
+	}
+}
+
+/*
+解析 [min, max] 范围内的十进制整数
+*/
+func (self *assembler) number(s string, min, max int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		self.errorf("bad number %s", s)
+	}
+	if n < min || n > max {
+		self.errorf("%d out of range [%d, %d]", n, min, max)
+	}
+	return n
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+/*
+所有输入读完之后检查是否有未结束的块，然后生成函数原型
+*/
+func (self *assembler) finish() *Prototype {
+	if len(self.blocks) > 0 {
+		self.errorf("missing .end")
+	}
+	for _, f := range self.listing {
+		if f.remaining > 0 {
+			self.errorf("missing %d function%s", f.remaining, plural(f.remaining))
+		}
+	}
+	if self.root == nil {
+		self.errorf("empty input")
+	}
+	if !self.root.hasSource && self.chunkName != "" {
+		self.root.proto.Source = "@" + self.chunkName
+	}
+	return self.root.build(self, "")
+}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+func TestAssembleShortListing(t *testing.T) {
+	src := `local t = {x = 1, "s", 2.5}
+t.y = t.x + 10 .. "z"
+print(t[1] == "s", t.y ~= nil, -1 < t.x)
+local function f(a) return a * 3, a .. "!" end
+x = f(0x7fffffffffffffff)
+`
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	var b bytes.Buffer
+	if err := Disassemble(&b, proto, DisasmOptions{}); err != nil {
+		t.Fatalf("disassemble: %v", err)
+	}
+	got, err := Assemble(&b, "test.lua")
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	sameFunction(t, "main", proto, got)
+}
+
+func TestAssembleUnreferencedConstant(t *testing.T) {
+	// 常量折叠之后 "x" 不再被引用，luac -l 的输出中只剩下常量的数量
+	proto, err := compiler.Compile(`local a = not "x" print(a, "y")`, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	var b bytes.Buffer
+	Disassemble(&b, proto, DisasmOptions{})
+	got, err := Assemble(&b, "test.lua")
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	if len(got.Constants) != len(proto.Constants) {
+		t.Fatalf("got %d constants, want %d", len(got.Constants), len(proto.Constants))
+	}
+	for i, k := range proto.Constants {
+		want := k.Value
+		if k.Value == "x" {
+			want = nil
+		}
+		if got.Constants[i].Value != want {
+			t.Errorf("constant %d: got %v, want %v", i+1, got.Constants[i].Value, want)
+		}
+	}
+}
+
+func TestAssembleMissingConstant(t *testing.T) {
+	// 有常量表时，缺少的常量仍然是错误
+	listing := `
+main <test.lua:0,0> (2 instructions at 0)
+0+ params, 2 slots, 1 upvalue, 0 locals, 2 constants, 0 functions
+	1	[1]	LOADK    	0 -2	; "b"
+	2	[1]	RETURN   	0 1
+constants (2) for 0:
+	2	"b"
+`
+	_, err := Assemble(strings.NewReader(listing), "test.lasm")
+	if err == nil || !strings.Contains(err.Error(), "constant 1 is missing") {
+		t.Fatalf("got error %v, want constant 1 is missing", err)
+	}
+}
+
+func sameFunction(t *testing.T, name string, want, got *Prototype) {
+	t.Helper()
+	if len(got.Code) != len(want.Code) {
+		t.Fatalf("%s: got %d instructions, want %d", name, len(got.Code), len(want.Code))
+	}
+	for pc := range want.Code {
+		if got.Code[pc] != want.Code[pc] {
+			t.Errorf("%s pc %d: got %#08x, want %#08x", name, pc+1, got.Code[pc], want.Code[pc])
+		}
+	}
+	if len(got.Constants) != len(want.Constants) {
+		t.Fatalf("%s: got %d constants, want %d", name, len(got.Constants), len(want.Constants))
+	}
+	for i, k := range want.Constants {
+		if got.Constants[i] != k {
+			t.Errorf("%s constant %d: got %v, want %v", name, i+1, got.Constants[i].Value, k.Value)
+		}
+	}
+	if len(got.Protos) != len(want.Protos) {
+		t.Fatalf("%s: got %d functions, want %d", name, len(got.Protos), len(want.Protos))
+	}
+	for i := range want.Protos {
+		sameFunction(t, fmt.Sprintf("%s/%d", name, i), want.Protos[i], got.Protos[i])
+	}
+}
@@ -0,0 +1,334 @@
+package asm
+
+import (
+	"fmt"
+	"lua-vm/analysis"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+	"math"
+)
+
+/*
+用于在 Go 代码中直接生成函数原型，供以虚拟机为目标的编译器使用：
+跳转可以指向尚未确定位置的标签，常量会被去重，常量过多时自动使用 LOADKX，
+MaxStackSize 根据生成的指令自动计算，子函数由嵌套的 FunctionBuilder 生成。
+操作数越界属于调用者的错误，由 vm.Create* 直接 panic；
+只有在生成结束时才能发现的问题（标签未放置、跳转过远等）由 Build 以 error 的形式返回
+*/
+type FunctionBuilder struct {
+	parent     *FunctionBuilder
+	proto      *Prototype
+	constants  map[constantKey]int
+	children   []*FunctionBuilder
+	labels     []*Label
+	lines      []uint32
+	line       uint32
+	hasLines   bool
+	minStack   int
+	openLocals []int
+}
+
+/*
+跳转目标，在 MarkLabel 之前就可以被跳转指令引用，放置之后这些指令会被自动修正
+*/
+type Label struct {
+	pc    int
+	jumps []int
+}
+
+/*
+创建主函数的 FunctionBuilder，与 luac 生成的主函数一样，它是变长参数函数，并且唯一的 Upvalue 是 _ENV
+*/
+func NewFunctionBuilder(source string) *FunctionBuilder {
+	b := newBuilder(nil)
+	b.proto.Source = source
+	b.proto.IsVararg = 1
+	b.AddUpvalue("_ENV", true, 0)
+	return b
+}
+
+func newBuilder(parent *FunctionBuilder) *FunctionBuilder {
+	return &FunctionBuilder{
+		parent:    parent,
+		proto:     &Prototype{},
+		constants: map[constantKey]int{},
+	}
+}
+
+/*
+创建一个子函数，返回它的 FunctionBuilder 以及它在 Protos 中的索引（即 CLOSURE 的 Bx）
+*/
+func (self *FunctionBuilder) NewChild() (*FunctionBuilder, int) {
+	child := newBuilder(self)
+	self.children = append(self.children, child)
+	return child, len(self.children) - 1
+}
+
+/*
+设置固定参数的个数以及是否为变长参数函数
+*/
+func (self *FunctionBuilder) SetParams(n int, isVararg bool) {
+	self.proto.NumParams = byte(n)
+	self.proto.IsVararg = 0
+	if isVararg {
+		self.proto.IsVararg = 1
+	}
+}
+
+/*
+设置函数的起始和结束行号
+*/
+func (self *FunctionBuilder) SetLineDefined(first, last int) {
+	self.proto.LineDefined = uint32(first)
+	self.proto.LastLineDefined = uint32(last)
+}
+
+/*
+设置之后生成的指令所在的行号，只要有一条指令带有行号，Build 就会生成行号表
+*/
+func (self *FunctionBuilder) SetLine(line int) {
+	self.line = uint32(line)
+	if line > 0 {
+		self.hasLines = true
+	}
+}
+
+/*
+添加一个 Upvalue，instack 为 true 时 idx 为外层函数的寄存器，否则为外层函数的 Upvalue 索引；返回其索引
+*/
+func (self *FunctionBuilder) AddUpvalue(name string, instack bool, idx int) int {
+	upval := Upvalue{Idx: byte(idx)}
+	if instack {
+		upval.Instack = 1
+	}
+	self.proto.Upvalues = append(self.proto.Upvalues, upval)
+	self.proto.UpvalueNames = append(self.proto.UpvalueNames, name)
+	return len(self.proto.Upvalues) - 1
+}
+
+/*
+根据名字查找 Upvalue
+*/
+func (self *FunctionBuilder) Upvalue(name string) (int, bool) {
+	for i, n := range self.proto.UpvalueNames {
+		if n == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+/*
+返回常量在常量表中的索引，值相同（包括类型相同）的常量只会被加入一次；
+val 的类型与 binchunk.NewConstant 相同
+*/
+func (self *FunctionBuilder) Constant(val interface{}) int {
+	k := NewConstant(val)
+	key := keyOf(k)
+	if idx, ok := self.constants[key]; ok {
+		return idx
+	}
+	self.proto.Constants = append(self.proto.Constants, k)
+	idx := len(self.proto.Constants) - 1
+	self.constants[key] = idx
+	return idx
+}
+
+/*
+常量去重时使用的键，浮点数按照二进制表示比较：
+0.0 和 -0.0 相等但却是两个不同的常量，NaN 虽然不等于自身，相同的 NaN 也只需要一个常量
+*/
+type constantKey struct {
+	tag   byte
+	value interface{}
+}
+
+func keyOf(k Constant) constantKey {
+	if f, ok := k.Value.(float64); ok {
+		return constantKey{k.Tag, math.Float64bits(f)}
+	}
+	return constantKey{k.Tag, k.Value}
+}
+
+/*
+返回常量作为 RK 操作数的值；常量表索引超过 MAXINDEXRK 时无法用作 RK 操作数，此时 ok 为 false，
+调用者需要先用 LoadK 把它载入寄存器
+*/
+func (self *FunctionBuilder) ConstantRK(val interface{}) (rk int, ok bool) {
+	idx := self.Constant(val)
+	if idx > MAXINDEXRK {
+		return 0, false
+	}
+	return idx | BITRK, true
+}
+
+/*
+保证函数至少拥有 n 个寄存器，用于那些无法从指令中推断出的寄存器
+*/
+func (self *FunctionBuilder) ReserveRegisters(n int) {
+	if n > self.minStack {
+		self.minStack = n
+	}
+}
+
+/*
+返回下一条指令的位置
+*/
+func (self *FunctionBuilder) PC() int {
+	return len(self.proto.Code)
+}
+
+/*
+追加一条指令，返回它的位置
+*/
+func (self *FunctionBuilder) Emit(i Instruction) int {
+	self.proto.Code = append(self.proto.Code, uint32(i))
+	self.lines = append(self.lines, self.line)
+	return len(self.proto.Code) - 1
+}
+
+func (self *FunctionBuilder) EmitABC(op, a, b, c int) int {
+	return self.Emit(CreateABC(op, a, b, c))
+}
+
+func (self *FunctionBuilder) EmitABx(op, a, bx int) int {
+	return self.Emit(CreateABx(op, a, bx))
+}
+
+func (self *FunctionBuilder) EmitAsBx(op, a, sbx int) int {
+	return self.Emit(CreateAsBx(op, a, sbx))
+}
+
+func (self *FunctionBuilder) EmitAx(op, ax int) int {
+	return self.Emit(CreateAx(op, ax))
+}
+
+/*
+把常量载入寄存器 a，常量表索引超过 MAXARG_Bx 时使用 LOADKX 和 EXTRAARG；返回第一条指令的位置
+*/
+func (self *FunctionBuilder) LoadK(a int, val interface{}) int {
+	idx := self.Constant(val)
+	if idx <= MAXARG_Bx {
+		return self.EmitABx(OP_LOADK, a, idx)
+	}
+	pc := self.EmitABx(OP_LOADKX, a, 0)
+	self.EmitAx(OP_EXTRAARG, idx)
+	return pc
+}
+
+/*
+生成创建子函数闭包的 CLOSURE 指令
+*/
+func (self *FunctionBuilder) Closure(a int, child *FunctionBuilder) int {
+	for idx, c := range self.children {
+		if c == child {
+			return self.EmitABx(OP_CLOSURE, a, idx)
+		}
+	}
+	panic("FunctionBuilder Error: not a child of this function")
+}
+
+/*
+创建一个尚未放置的标签
+*/
+func (self *FunctionBuilder) NewLabel() *Label {
+	l := &Label{pc: -1}
+	self.labels = append(self.labels, l)
+	return l
+}
+
+/*
+把标签放在下一条指令的位置
+*/
+func (self *FunctionBuilder) MarkLabel(l *Label) {
+	if l.pc >= 0 {
+		panic("FunctionBuilder Error: label marked twice")
+	}
+	l.pc = self.PC()
+}
+
+/*
+生成一条跳转到标签的 iAsBx 指令（JMP、FORLOOP、FORPREP 或 TFORLOOP），
+跳转偏移在 Build 时填入；返回指令的位置
+*/
+func (self *FunctionBuilder) EmitJump(op, a int, l *Label) int {
+	pc := self.EmitAsBx(op, a, 0)
+	l.jumps = append(l.jumps, pc)
+	return pc
+}
+
+/*
+声明一个从下一条指令开始生效的局部变量，返回其在局部变量表中的索引
+*/
+func (self *FunctionBuilder) BeginLocal(name string) int {
+	pc := uint32(self.PC())
+	self.proto.LocVars = append(self.proto.LocVars, LocVar{VarName: name, StartPc: pc, EndPc: pc})
+	self.openLocals = append(self.openLocals, len(self.proto.LocVars)-1)
+	return len(self.proto.LocVars) - 1
+}
+
+/*
+结束局部变量的作用域，它在下一条指令处失效；没有结束的局部变量一直有效到函数末尾
+*/
+func (self *FunctionBuilder) EndLocal(idx int) {
+	self.proto.LocVars[idx].EndPc = uint32(self.PC())
+	for i, open := range self.openLocals {
+		if open == idx {
+			self.openLocals = append(self.openLocals[:i], self.openLocals[i+1:]...)
+			break
+		}
+	}
+}
+
+/*
+生成函数原型，子函数一同生成；返回标签未放置、跳转超出范围或寄存器过多等错误
+*/
+func (self *FunctionBuilder) Build() (*Prototype, error) {
+	p := self.proto
+	for _, l := range self.labels {
+		if len(l.jumps) == 0 {
+			continue
+		}
+		if l.pc < 0 {
+			return nil, fmt.Errorf("label used by instruction %d is never marked", l.jumps[0])
+		}
+		for _, pc := range l.jumps {
+			sbx := l.pc - (pc + 1)
+			if sbx < -MAXARG_sBx || sbx > MAXARG_Bx-MAXARG_sBx {
+				return nil, fmt.Errorf("jump at instruction %d out of range", pc)
+			}
+			i := Instruction(p.Code[pc])
+			a, _ := i.AsBx()
+			p.Code[pc] = uint32(CreateAsBx(i.Opcode(), a, sbx))
+		}
+	}
+	for _, idx := range self.openLocals {
+		p.LocVars[idx].EndPc = uint32(len(p.Code))
+	}
+
+	p.LineInfo = nil
+	if self.hasLines {
+		p.LineInfo = append([]uint32(nil), self.lines...)
+	}
+	if self.parent != nil && p.Source == "" {
+		p.Source = self.parent.proto.Source
+	}
+	p.Protos = nil
+	for _, child := range self.children {
+		cp, err := child.Build()
+		if err != nil {
+			return nil, err
+		}
+		p.Protos = append(p.Protos, cp)
+	}
+
+	size := analysis.StackSize(p)
+	if self.minStack > size {
+		size = self.minStack
+	}
+	if size > MAXARG_A {
+		return nil, fmt.Errorf("function needs %d registers", size)
+	}
+	p.MaxStackSize = byte(size)
+	return p, nil
+}
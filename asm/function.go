@@ -0,0 +1,512 @@
+package asm
+
+import (
+	"lua-vm/analysis"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+	"strconv"
+	"strings"
+)
+
+/*
+一个正在汇编的函数，指令的操作数在函数定义完成之后才解析
+*/
+type function struct {
+	parent *function
+	// 定义函数的行号，用于报告与具体指令无关的错误
+	srcLine int
+	// 由伪指令或者 luac 的函数头直接设置的字段
+	proto       *Prototype
+	hasSource   bool
+	vararg      bool
+	hasVararg   bool
+	hasMaxStack bool
+	// 按照 luac 输出定义时还没有出现的子函数的数量
+	remaining int
+	// 参数行中常量的数量，以及是否有常量表
+	numConsts    int
+	hasConstants bool
+
+	insts      []*inst
+	labels     map[string]int
+	consts     map[string]Constant
+	explicit   map[int]*Constant
+	upvalNames []string
+	locals     []localDecl
+	children   []*function
+	childNames map[string]int
+	// .line 设置的当前行号，以及是否有指令带有行号
+	lineNo   uint32
+	hasLines bool
+}
+
+/*
+一条尚未编码的指令
+*/
+type inst struct {
+	op      int
+	args    []string
+	line    uint32
+	srcLine int
+	// 根据 SETLIST 的注释自动补上的 EXTRAARG，args[0] 为整条指令的原始值
+	auto bool
+	// 指令的注释，luac -l 的输出中常量只出现在这里
+	comment string
+}
+
+type localDecl struct {
+	name       string
+	start, end string
+	srcLine    int
+}
+
+func newFunction(parent *function) *function {
+	return &function{
+		parent:     parent,
+		proto:      &Prototype{},
+		labels:     map[string]int{},
+		consts:     map[string]Constant{},
+		explicit:   map[int]*Constant{},
+		childNames: map[string]int{},
+	}
+}
+
+func (self *function) defineLabel(a *assembler, name string) {
+	if !isName(name) {
+		a.errorf("bad label %s", name)
+	}
+	if _, ok := self.labels[name]; ok {
+		a.errorf("label %s redefined", name)
+	}
+	self.labels[name] = len(self.insts)
+}
+
+func (self *function) addUpvalue(a *assembler, name, instack, idx string) {
+	if name == "-" {
+		name = ""
+	}
+	self.proto.Upvalues = append(self.proto.Upvalues, Upvalue{
+		Instack: byte(a.number(instack, 0, 1)),
+		Idx:     byte(a.number(idx, 0, 255)),
+	})
+	self.upvalNames = append(self.upvalNames, name)
+}
+
+/*
+根据名字查找操作码，不区分大小写
+*/
+func opcodeByName(name string) (int, bool) {
+	for op := 0; op < NUM_OPCODES; op++ {
+		if strings.EqualFold(Instruction(op).OpName(), name) {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+/*
+返回指令在文本中的操作数个数，与反汇编输出的操作数一致
+*/
+func numOperands(op int) int {
+	i := Instruction(op)
+	switch i.OpMode() {
+	case IABC:
+		n := 1
+		if i.BMode() != OpArgN {
+			n++
+		}
+		if i.CMode() != OpArgN {
+			n++
+		}
+		return n
+	case IABx:
+		if i.BMode() == OpArgN {
+			return 1
+		}
+		return 2
+	case IAsBx:
+		return 2
+	default:
+		return 1
+	}
+}
+
+/*
+解析一行指令，行首可以有 luac 输出的序号和行号；
+luac 在 SETLIST 的 C 为 0 时不输出下一条 EXTRAARG，而是把它的原始值写在注释里，这里据此补上
+*/
+func (self *function) parseInstruction(a *assembler, tokens []string, comment string) {
+	if len(tokens) > 1 && isDigits(tokens[0]) {
+		tokens = tokens[1:]
+	}
+	line := self.lineNo
+	if t := tokens[0]; len(tokens) > 1 && strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+		line = 0
+		if t != "[-]" {
+			line = uint32(a.number(t[1:len(t)-1], 0, 1<<31))
+		}
+		tokens = tokens[1:]
+	}
+
+	op, ok := opcodeByName(tokens[0])
+	if !ok {
+		a.errorf("unknown instruction %s", tokens[0])
+	}
+	args := tokens[1:]
+	if n := numOperands(op); len(args) != n {
+		a.errorf("%s expects %d operand%s", Instruction(op).OpName(), n, plural(n))
+	}
+
+	if n := len(self.insts); op == OP_EXTRAARG && n > 0 && self.insts[n-1].auto {
+		self.insts = self.insts[:n-1]
+	}
+	if line > 0 {
+		self.hasLines = true
+	}
+	self.insts = append(self.insts, &inst{op: op, args: args, line: line, srcLine: a.line, comment: comment})
+
+	if op == OP_SETLIST && args[2] == "0" && isDigits(comment) {
+		self.insts = append(self.insts, &inst{
+			op: OP_EXTRAARG, args: []string{comment}, line: line, srcLine: a.line, auto: true,
+		})
+	}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+生成函数原型，子函数的 Source 缺省时与父函数相同
+*/
+func (self *function) build(a *assembler, parentSource string) *Prototype {
+	p := self.proto
+	a.line = self.srcLine
+	if !self.hasSource {
+		p.Source = parentSource
+	}
+	if self.hasVararg {
+		p.IsVararg = 0
+		if self.vararg {
+			p.IsVararg = 1
+		}
+	} else if self.parent == nil {
+		p.IsVararg = 1
+	}
+	// 主函数的第一个 Upvalue 是 _ENV
+	if self.parent == nil && len(p.Upvalues) == 0 {
+		p.Upvalues = []Upvalue{{Instack: 1, Idx: 0}}
+		self.upvalNames = []string{"_ENV"}
+	}
+	for _, name := range self.upvalNames {
+		if name != "" {
+			p.UpvalueNames = self.upvalNames
+			break
+		}
+	}
+
+	for _, in := range self.insts {
+		a.line = in.srcLine
+		self.recoverConstants(in)
+	}
+	// 只有 luac -l 的输出时，没有被指令引用的常量无法恢复，用 nil 占位
+	if !self.hasConstants {
+		for i := 0; i < self.numConsts; i++ {
+			if self.explicit[i] == nil {
+				k := NewConstant(nil)
+				self.explicit[i] = &k
+			}
+		}
+	}
+	for i := 0; i < len(self.explicit); i++ {
+		k := self.explicit[i]
+		if k == nil {
+			a.errorf("constant %d is missing", i+1)
+		}
+		p.Constants = append(p.Constants, *k)
+	}
+
+	p.Code = make([]uint32, len(self.insts))
+	for pc, in := range self.insts {
+		a.line = in.srcLine
+		p.Code[pc] = self.encode(a, pc, in)
+	}
+	if self.hasLines {
+		p.LineInfo = make([]uint32, len(self.insts))
+		for pc, in := range self.insts {
+			p.LineInfo[pc] = in.line
+		}
+	}
+
+	for _, l := range self.locals {
+		a.line = l.srcLine
+		p.LocVars = append(p.LocVars, LocVar{
+			VarName: l.name,
+			StartPc: uint32(self.pcRef(a, l.start)),
+			EndPc:   uint32(self.pcRef(a, l.end)),
+		})
+	}
+
+	for _, child := range self.children {
+		p.Protos = append(p.Protos, child.build(a, p.Source))
+	}
+	if !self.hasMaxStack {
+		p.MaxStackSize = byte(analysis.StackSize(p))
+	}
+	return p
+}
+
+/*
+只有 `luac -l` 的输出时没有常量表，用 -1-k 引用的常量从指令的注释中恢复，与常量表中的写法相同：
+LOADK 和 EXTRAARG 的注释为常量本身，GETTABUP 和 SETTABUP 的注释以 Upvalue 的名字开头，之后是
+常量形式的 RK 操作数，其余指令的注释依次对应 B、C 两个 RK 操作数，不是常量的操作数写成 -。
+常量表中已经有的常量不会被覆盖，无法识别的注释被忽略
+*/
+func (self *function) recoverConstants(in *inst) {
+	if in.auto || in.comment == "" {
+		return
+	}
+	tokens, err := tokenize(in.comment)
+	if err != nil {
+		return
+	}
+	var operands []int
+	switch in.op {
+	case OP_LOADK:
+		operands = []int{1}
+	case OP_EXTRAARG:
+		operands = []int{0}
+	case OP_GETTABUP, OP_SETTABUP:
+		if len(tokens) == 0 {
+			return
+		}
+		tokens = tokens[1:]
+		for i := 1; i < len(in.args); i++ {
+			if _, ok := constRef(in.args[i]); ok {
+				operands = append(operands, i)
+			}
+		}
+	default:
+		i := Instruction(in.op)
+		if i.OpMode() != IABC || i.CMode() != OpArgK {
+			return
+		}
+		operands = []int{2}
+		if i.BMode() == OpArgK {
+			operands = []int{1, 2}
+		}
+	}
+
+	for n, i := range operands {
+		if n >= len(tokens) || i >= len(in.args) {
+			return
+		}
+		k, ok := constRef(in.args[i])
+		if !ok || self.explicit[k] != nil {
+			continue
+		}
+		if val, err := parseValue(tokens[n]); err == nil {
+			c := NewConstant(val)
+			self.explicit[k] = &c
+		}
+	}
+}
+
+/*
+-1-k 形式的操作数引用常量表的第 k 项
+*/
+func constRef(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n >= 0 {
+		return 0, false
+	}
+	return -1 - n, true
+}
+
+/*
+局部变量表中的 pc，可以是标签或者从 1 开始的指令序号
+*/
+func (self *function) pcRef(a *assembler, s string) int {
+	if pc, ok := self.labels[s]; ok {
+		return pc
+	}
+	return a.number(s, 1, len(self.insts)+1) - 1
+}
+
+/*
+按照指令的模式解析操作数并编码
+*/
+func (self *function) encode(a *assembler, pc int, in *inst) uint32 {
+	op := in.op
+	i := Instruction(op)
+	args := in.args
+	if in.auto {
+		raw, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || Instruction(raw).Opcode() != OP_EXTRAARG {
+			a.errorf("bad EXTRAARG %s", args[0])
+		}
+		return uint32(raw)
+	}
+
+	switch i.OpMode() {
+	case IABC:
+		var ra, rb, rc int
+		if op == OP_SETTABUP {
+			ra = self.upvalue(a, args[0])
+		} else {
+			ra = a.number(args[0], 0, MAXARG_A)
+		}
+		args = args[1:]
+		if i.BMode() != OpArgN {
+			upval := op == OP_GETUPVAL || op == OP_SETUPVAL || op == OP_GETTABUP
+			rb = self.argBC(a, i.BMode(), args[0], upval)
+			args = args[1:]
+		}
+		if i.CMode() != OpArgN {
+			rc = self.argBC(a, i.CMode(), args[0], false)
+		}
+		return uint32(CreateABC(op, ra, rb, rc))
+	case IABx:
+		ra := a.number(args[0], 0, MAXARG_A)
+		bx := 0
+		switch i.BMode() {
+		case OpArgK:
+			bx = self.constIndex(a, args[1], MAXARG_Bx)
+		case OpArgU:
+			if idx, ok := self.childNames[args[1]]; ok {
+				bx = idx
+			} else if isName(args[1]) {
+				a.errorf("unknown function %s", args[1])
+			} else {
+				bx = a.number(args[1], 0, MAXARG_Bx)
+			}
+		}
+		return uint32(CreateABx(op, ra, bx))
+	case IAsBx:
+		ra := a.number(args[0], 0, MAXARG_A)
+		var sbx int
+		if target, ok := self.labels[args[1]]; ok {
+			sbx = target - (pc + 1)
+			if sbx < -MAXARG_sBx || sbx > MAXARG_Bx-MAXARG_sBx {
+				a.errorf("jump to %s out of range", args[1])
+			}
+		} else if isName(args[1]) {
+			a.errorf("unknown label %s", args[1])
+		} else {
+			sbx = a.number(args[1], -MAXARG_sBx, MAXARG_Bx-MAXARG_sBx)
+		}
+		return uint32(CreateAsBx(op, ra, sbx))
+	default:
+		ax := self.constIndex(a, args[0], MAXARG_Ax)
+		return uint32(CreateAx(op, ax))
+	}
+}
+
+/*
+iABC 指令的 B、C 操作数
+*/
+func (self *function) argBC(a *assembler, mode byte, s string, upval bool) int {
+	switch {
+	case mode == OpArgK:
+		return self.rk(a, s)
+	case mode == OpArgR:
+		return a.number(s, 0, 0xFF)
+	case upval:
+		return self.upvalue(a, s)
+	default:
+		return a.number(s, 0, MAXARG_B)
+	}
+}
+
+/*
+RK 操作数：非负整数为寄存器，其余为常量
+*/
+func (self *function) rk(a *assembler, s string) int {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		if n > MAXINDEXRK {
+			a.errorf("register %d out of range", n)
+		}
+		return n
+	}
+	idx := self.constIndex(a, s, MAXINDEXRK)
+	return idx | BITRK
+}
+
+/*
+常量操作数：负数 -1-k 表示常量表的第 k 项，非负整数为原始值，其余为常量字面量或命名常量
+*/
+func (self *function) constIndex(a *assembler, s string, max int) int {
+	var idx int
+	if n, err := strconv.Atoi(s); err == nil {
+		idx = n
+		if n < 0 {
+			idx = -1 - n
+		}
+	} else {
+		idx = self.addConstant(self.constant(a, s))
+	}
+	if idx > max {
+		a.errorf("constant index %d out of range [0, %d]", idx, max)
+	}
+	return idx
+}
+
+/*
+解析常量字面量或者命名常量，命名常量可以定义在外层函数中
+*/
+func (self *function) constant(a *assembler, s string) Constant {
+	var val interface{}
+	var err error
+	switch {
+	case strings.HasPrefix(s, "\""):
+		val, err = unquote(s)
+	case strings.HasPrefix(s, "#"):
+		val, err = parseValue(s[1:])
+	default:
+		for f := self; f != nil; f = f.parent {
+			if k, ok := f.consts[s]; ok {
+				return k
+			}
+		}
+		a.errorf("unknown constant %s", s)
+	}
+	a.check(err)
+	return NewConstant(val)
+}
+
+/*
+把常量加入常量表，已经存在的常量直接返回其索引，与 FunctionBuilder 一样按照 keyOf 比较
+*/
+func (self *function) addConstant(k Constant) int {
+	key := keyOf(k)
+	for i, x := range self.proto.Constants {
+		if keyOf(x) == key {
+			return i
+		}
+	}
+	self.proto.Constants = append(self.proto.Constants, k)
+	return len(self.proto.Constants) - 1
+}
+
+/*
+Upvalue 操作数，可以是索引或者名字
+*/
+func (self *function) upvalue(a *assembler, s string) int {
+	for i, name := range self.upvalNames {
+		if name != "" && name == s {
+			return i
+		}
+	}
+	if isName(s) {
+		a.errorf("unknown upvalue %s", s)
+	}
+	return a.number(s, 0, 0xFF)
+}
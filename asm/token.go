@@ -0,0 +1,174 @@
+package asm
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+去掉行尾以 ; 开始的注释，字符串中的 ; 不算；返回去掉注释之后的部分以及注释的内容
+*/
+func splitComment(line string) (string, string) {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inString:
+			i++
+		case c == '"':
+			inString = !inString
+		case c == ';' && !inString:
+			return line[:i], strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, ""
+}
+
+/*
+把一行文本切分成单词，以空白或逗号分隔，双引号括起来的字符串作为一个整体
+*/
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		if c == ' ' || c == '\t' || c == ',' || c == '\r' {
+			i++
+			continue
+		}
+
+		start := i
+		if c == '"' || c == '#' && i+1 < len(line) && line[i+1] == '"' {
+			if c == '#' {
+				i++
+			}
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(line) {
+				return nil, errorf("unfinished string")
+			}
+			i++
+		} else {
+			for i < len(line) && !strings.ContainsRune(" \t,\r", rune(line[i])) {
+				i++
+			}
+		}
+		tokens = append(tokens, line[start:i])
+	}
+	return tokens, nil
+}
+
+/*
+解析以双引号括起来的字符串，支持 Lua 中的转义序列，包括 luac 输出的 \ddd
+*/
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", errorf("invalid string %s", s)
+	}
+	s = s[1 : len(s)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", errorf("invalid escape sequence")
+		}
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"', '\'':
+			b.WriteByte(c)
+		case 'x':
+			if i+3 > len(s) {
+				return "", errorf("invalid escape sequence '\\x'")
+			}
+			n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", errorf("invalid escape sequence '\\x%s'", s[i+1:i+3])
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			if c < '0' || c > '9' {
+				return "", errorf("invalid escape sequence '\\%c'", c)
+			}
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(s[i:j])
+			if n > 255 {
+				return "", errorf("decimal escape too large")
+			}
+			b.WriteByte(byte(n))
+			i = j - 1
+		}
+	}
+	return b.String(), nil
+}
+
+/*
+解析常量的值，格式与 luac 输出常量表时相同：
+nil、true、false、整数、浮点数（包括 inf、-inf、nan）以及双引号括起来的字符串
+*/
+func parseValue(s string) (interface{}, error) {
+	switch s {
+	case "nil":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "-nan":
+		return math.NaN(), nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		return unquote(s)
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return nil, errorf("invalid constant %s", s)
+}
+
+/*
+判断字符串是否是合法的标识符，用作标签、常量和函数的名字
+*/
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
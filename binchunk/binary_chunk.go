@@ -1,5 +1,7 @@
 package binchunk
 
+import "bytes"
+
 /*
 二进制 Chunk 结构体
 */
@@ -70,8 +72,8 @@ type Prototype struct {
 	//  0x13 integer Lua 整数
 	//  0x04 string 短字符串
 	//  0x14 string 长字符串
-	// 表开头有一个 cint 表示表大小
-	Constants []interface{}
+	// 表开头有一个 cint 表示表大小，每一项都保留了原本的 tag，详见 Constant 结构体
+	Constants []Constant
 	// Upvalue 表，表开头有一个 cint 表示表大小
 	Upvalues []Upvalue
 	// 子函数原型表，表开头有一个 cint 表示表大小
@@ -133,16 +135,16 @@ const (
 )
 
 /*
-用来从 BinChunk 中读取数据并返回主函数的 Prototype
+用来从 BinChunk 中读取数据并返回主函数的 Prototype，格式错误时 panic；
+各个表的长度不会超过 data 本身所能容纳的范围，不可信的输入应当使用 UndumpReader 并设置限制
 */
 func Undump(data []byte) *Prototype {
-	r := reader{data}
-	r.checkHeader()
-	// 跳过主函数的 Upvalue 数量，因为这个值从 Prototype 中也可以拿到
-	r.readByte()
-	ret := r.readProto("")
-
-	if len(r.data) != 0 {
+	r := bytes.NewReader(data)
+	ret, err := UndumpReader(r, Limits{MaxBytes: int64(len(data))})
+	if err != nil {
+		panic(err.Error())
+	}
+	if r.Len() != 0 {
 		panic("Undump Error")
 	}
 	return ret
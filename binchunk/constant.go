@@ -0,0 +1,157 @@
+package binchunk
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 短字符串的最大长度，超过该长度的字符串在 luac 中以长字符串（TAG_LONG_STR）的形式保存
+const LUAI_MAXSHORTLEN = 40
+
+/*
+常量表中的一项，保留了其在二进制 chunk 中原本的 tag，
+这样无论是反编译、重新 dump 还是运行时加载，看到的都是同一个值；
+Value 的 Go 类型与 tag 的对应关系如下：
+	TAG_NIL       nil
+	TAG_BOOLEAN   bool
+	TAG_NUMBER    float64
+	TAG_INTEGER   int64
+	TAG_SHORT_STR string
+	TAG_LONG_STR  string
+*/
+type Constant struct {
+	Tag   byte
+	Value interface{}
+}
+
+/*
+根据 Go 值创建对应的常量，字符串根据其长度选择短字符串或长字符串，
+与 luac 中 luaS_newlstr 的规则一致
+*/
+func NewConstant(val interface{}) Constant {
+	switch x := val.(type) {
+	case nil:
+		return Constant{TAG_NIL, nil}
+	case bool:
+		return Constant{TAG_BOOLEAN, x}
+	case float64:
+		return Constant{TAG_NUMBER, x}
+	case int64:
+		return Constant{TAG_INTEGER, x}
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			return Constant{TAG_SHORT_STR, x}
+		}
+		return Constant{TAG_LONG_STR, x}
+	default:
+		panic(fmt.Sprintf("Constant Type Error: %T", val))
+	}
+}
+
+/*
+判断常量是否是一个字符串（短字符串或长字符串）
+*/
+func (self Constant) IsString() bool {
+	return self.Tag == TAG_SHORT_STR || self.Tag == TAG_LONG_STR
+}
+
+/*
+返回 tag 对应的 Lua 类型名
+*/
+func (self Constant) TypeName() string {
+	switch self.Tag {
+	case TAG_NIL:
+		return "nil"
+	case TAG_BOOLEAN:
+		return "boolean"
+	case TAG_NUMBER, TAG_INTEGER:
+		return "number"
+	case TAG_SHORT_STR, TAG_LONG_STR:
+		return "string"
+	default:
+		return "?"
+	}
+}
+
+/*
+返回常量的字符串形式，格式与 luac 中的 PrintConstant 一致：
+浮点数使用 "%.14g" 格式，看起来像整数时补上 ".0"；字符串使用 PrintString 的转义规则
+*/
+func (self Constant) String() string {
+	switch x := self.Value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprintf("%t", x)
+	case float64:
+		return formatFloat(x)
+	case int64:
+		return fmt.Sprintf("%d", x)
+	case string:
+		return quoteString(x)
+	default:
+		return fmt.Sprintf("? type=%d", self.Tag)
+	}
+}
+
+/*
+按照 C 语言中 "%.14g" 的格式输出浮点数，如果结果中只有数字和负号，那么补上 ".0"
+*/
+func formatFloat(n float64) string {
+	var s string
+	switch {
+	case math.IsInf(n, 1):
+		s = "inf"
+	case math.IsInf(n, -1):
+		s = "-inf"
+	case math.IsNaN(n):
+		s = "nan"
+	default:
+		s = fmt.Sprintf("%.14g", n)
+	}
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
+}
+
+/*
+用双引号包裹字符串，并按照 luac 中 PrintString 的规则转义：
+常见的控制字符使用 C 风格的转义，其余不可打印字符使用 \ddd 的形式
+*/
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			b.WriteString("\\\"")
+		case '\\':
+			b.WriteString("\\\\")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		case '\f':
+			b.WriteString("\\f")
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\v':
+			b.WriteString("\\v")
+		default:
+			if c >= 0x20 && c < 0x7F {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "\\%03d", c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
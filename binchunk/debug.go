@@ -0,0 +1,89 @@
+package binchunk
+
+/*
+在某条指令处有效的局部变量
+*/
+type ActiveLocal struct {
+	LocVar
+	// 在 LocVars 中的下标
+	Index int
+	// 局部变量所在的寄存器
+	Register int
+}
+
+/*
+返回 pc 处指令的行号，没有行号信息（被 `luac -s` 剔除）或者 pc 越界时返回 0
+*/
+func (self *Prototype) LineAt(pc int) int {
+	if pc < 0 || pc >= len(self.LineInfo) {
+		return 0
+	}
+	return int(self.LineInfo[pc])
+}
+
+/*
+返回在 pc 处有效的局部变量，按照寄存器的顺序排列。
+与 luaF_getlocalname 一致：按顺序遍历 StartPc 不超过 pc 的局部变量，
+其中 pc 仍在其范围内的第 n 个局部变量位于寄存器 n（从 0 开始）
+*/
+func (self *Prototype) LocalsAt(pc int) []ActiveLocal {
+	var locals []ActiveLocal
+	for i, v := range self.LocVars {
+		if int(v.StartPc) > pc {
+			break
+		}
+		if pc < int(v.EndPc) {
+			locals = append(locals, ActiveLocal{LocVar: v, Index: i, Register: len(locals)})
+		}
+	}
+	return locals
+}
+
+/*
+返回 pc 处寄存器 reg 中的局部变量名，寄存器中没有局部变量时 ok 为 false
+*/
+func (self *Prototype) LocalName(reg, pc int) (name string, ok bool) {
+	if locals := self.LocalsAt(pc); reg >= 0 && reg < len(locals) {
+		return locals[reg].VarName, true
+	}
+	return "", false
+}
+
+/*
+返回行号为 line 的所有指令，按 pc 从小到大排列；没有行号信息时返回 nil
+*/
+func (self *Prototype) PCsForLine(line int) []int {
+	var pcs []int
+	for pc, l := range self.LineInfo {
+		if int(l) == line {
+			pcs = append(pcs, pc)
+		}
+	}
+	return pcs
+}
+
+/*
+返回第 i 个 Upvalue 的名字，名字被剔除或者 i 越界时返回空字符串
+*/
+func (self *Prototype) UpvalueName(i int) string {
+	if i < 0 || i >= len(self.UpvalueNames) {
+		return ""
+	}
+	return self.UpvalueNames[i]
+}
+
+/*
+返回以 self 为根的函数原型树中包含第 line 行的最内层函数，没有函数包含该行时返回 nil；
+主函数（LineDefined 为 0）包含所有的行
+*/
+func (self *Prototype) FunctionAt(line int) *Prototype {
+	if self.LineDefined > 0 && (line < int(self.LineDefined) || line > int(self.LastLineDefined)) {
+		return nil
+	}
+	for _, child := range self.Protos {
+		if f := child.FunctionAt(line); f != nil {
+			return f
+		}
+	}
+	return self
+}
@@ -0,0 +1,301 @@
+package binchunk
+
+import (
+	"fmt"
+	"io"
+	. "lua-vm/vm"
+	"strings"
+)
+
+/*
+反汇编选项
+*/
+type DisasmOptions struct {
+	// 为 true 时额外输出常量表、局部变量表和 Upvalue 表，相当于 `luac -l -l`
+	Full bool
+	// 用于生成函数地址的字符串，对应 luac 输出中的 %p；
+	// 为 nil 时使用 Prototype 在 Go 中的地址，写对比测试时可以替换成固定的值
+	Address func(f *Prototype) string
+}
+
+/*
+递归地把 Prototype 的反汇编结果写入 w，格式与 `luac -l`（Full 为 true 时为 `luac -l -l`）完全一致；
+返回写入过程中遇到的第一个错误
+*/
+func Disassemble(w io.Writer, f *Prototype, opts DisasmOptions) error {
+	d := &disassembler{w: w, opts: opts}
+	if d.opts.Address == nil {
+		d.opts.Address = defaultAddress
+	}
+	d.printFunction(f)
+	return d.err
+}
+
+/*
+默认使用 Prototype 在 Go 中的地址作为函数地址
+*/
+func defaultAddress(f *Prototype) string {
+	return fmt.Sprintf("%p", f)
+}
+
+/*
+反汇编器，内部记录第一个写入错误，之后的写入全部忽略
+*/
+type disassembler struct {
+	w    io.Writer
+	opts DisasmOptions
+	err  error
+}
+
+func (self *disassembler) printf(format string, a ...interface{}) {
+	if self.err == nil {
+		_, self.err = fmt.Fprintf(self.w, format, a...)
+	}
+}
+
+/*
+对应 luac 中的 PrintFunction
+*/
+func (self *disassembler) printFunction(f *Prototype) {
+	self.printHeader(f)
+	self.printCode(f)
+	if self.opts.Full {
+		self.printDebug(f)
+	}
+	for _, p := range f.Protos {
+		self.printFunction(p)
+	}
+}
+
+/*
+数量为 1 时返回空字符串，否则返回 "s"，用于输出单复数
+*/
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+/*
+返回 Source 在输出时的形式：
+以 @ 或 = 开头时去掉第一个字符，以 ESC 开头时为 "(bstring)"，其余情况为 "(string)"；
+被 `luac -s` 剔除了 Source 的函数视为 "=?"
+*/
+func SourceName(source string) string {
+	if source == "" {
+		source = "=?"
+	}
+	switch source[0] {
+	case '@', '=':
+		return source[1:]
+	case LUA_SIGNATURE[0]:
+		return "(bstring)"
+	default:
+		return "(string)"
+	}
+}
+
+/*
+对应 luac 中的 PrintHeader
+*/
+func (self *disassembler) printHeader(f *Prototype) {
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+
+	varargFlag := ""
+	if f.IsVararg != 0 {
+		varargFlag = "+"
+	}
+
+	self.printf("\n%s <%s:%d,%d> (%d instruction%s at %s)\n",
+		funcType, SourceName(f.Source), f.LineDefined, f.LastLineDefined,
+		len(f.Code), plural(len(f.Code)), self.opts.Address(f))
+
+	self.printf("%d%s param%s, %d slot%s, %d upvalue%s, ",
+		f.NumParams, varargFlag, plural(int(f.NumParams)),
+		f.MaxStackSize, plural(int(f.MaxStackSize)), len(f.Upvalues), plural(len(f.Upvalues)))
+
+	self.printf("%d local%s, %d constant%s, %d function%s\n",
+		len(f.LocVars), plural(len(f.LocVars)), len(f.Constants), plural(len(f.Constants)),
+		len(f.Protos), plural(len(f.Protos)))
+}
+
+/*
+luac 中常量表索引以 -1-x 的形式输出，这样就能和寄存器索引区分开
+*/
+func myk(x int) int {
+	return -1 - x
+}
+
+/*
+返回常量表中第 idx 项的字符串形式，索引越界时返回 "?"，以免反汇编被篡改的字节码时出错
+*/
+func constantAt(f *Prototype, idx int) string {
+	if idx < 0 || idx >= len(f.Constants) {
+		return "?"
+	}
+	return f.Constants[idx].String()
+}
+
+/*
+返回第 idx 个 Upvalue 的名字，被剔除时返回 "-"
+*/
+func upvalueName(f *Prototype, idx int) string {
+	if name := f.UpvalueName(idx); name != "" {
+		return name
+	}
+	return "-"
+}
+
+/*
+对应 luac 中的 PrintCode
+*/
+func (self *disassembler) printCode(f *Prototype) {
+	for pc := 0; pc < len(f.Code); pc++ {
+		self.printf("\t%d\t", pc+1)
+		if pc < len(f.LineInfo) && f.LineInfo[pc] > 0 {
+			self.printf("[%d]\t", f.LineInfo[pc])
+		} else {
+			self.printf("[-]\t")
+		}
+		self.printf("%s\n", FormatInstruction(f, pc, self.opts))
+
+		// 与 luac 一致，SETLIST 的 C 为 0 时跳过下一条 EXTRAARG 指令
+		i := Instruction(f.Code[pc])
+		if _, _, c := i.ABC(); i.Opcode() == OP_SETLIST && c == 0 {
+			pc++
+		}
+	}
+}
+
+/*
+返回 pc 处指令的反汇编结果，包括指令名、操作数以及 `;` 之后的注释，格式与 luac 一致，
+但不包括行首的序号和行号
+*/
+func FormatInstruction(f *Prototype, pc int, opts DisasmOptions) string {
+	if opts.Address == nil {
+		opts.Address = defaultAddress
+	}
+
+	var b strings.Builder
+	i := Instruction(f.Code[pc])
+	op := i.Opcode()
+	a, bb, c := i.ABC()
+	_, bx := i.ABx()
+	_, sbx := i.AsBx()
+	ax := i.Ax()
+
+	fmt.Fprintf(&b, "%-9s\t", i.OpName())
+	switch i.OpMode() {
+	case IABC:
+		fmt.Fprintf(&b, "%d", a)
+		if i.BMode() != OpArgN {
+			fmt.Fprintf(&b, " %d", rkOperand(bb))
+		}
+		if i.CMode() != OpArgN {
+			fmt.Fprintf(&b, " %d", rkOperand(c))
+		}
+	case IABx:
+		fmt.Fprintf(&b, "%d", a)
+		if i.BMode() == OpArgK {
+			fmt.Fprintf(&b, " %d", myk(bx))
+		}
+		if i.BMode() == OpArgU {
+			fmt.Fprintf(&b, " %d", bx)
+		}
+	case IAsBx:
+		fmt.Fprintf(&b, "%d %d", a, sbx)
+	case IAx:
+		fmt.Fprintf(&b, "%d", myk(ax))
+	}
+
+	switch op {
+	case OP_LOADK:
+		fmt.Fprintf(&b, "\t; %s", constantAt(f, bx))
+	case OP_GETUPVAL, OP_SETUPVAL:
+		fmt.Fprintf(&b, "\t; %s", upvalueName(f, bb))
+	case OP_GETTABUP:
+		fmt.Fprintf(&b, "\t; %s", upvalueName(f, bb))
+		if ISK(c) {
+			fmt.Fprintf(&b, " %s", constantAt(f, INDEXK(c)))
+		}
+	case OP_SETTABUP:
+		fmt.Fprintf(&b, "\t; %s", upvalueName(f, a))
+		if ISK(bb) {
+			fmt.Fprintf(&b, " %s", constantAt(f, INDEXK(bb)))
+		}
+		if ISK(c) {
+			fmt.Fprintf(&b, " %s", constantAt(f, INDEXK(c)))
+		}
+	case OP_GETTABLE, OP_SELF:
+		if ISK(c) {
+			fmt.Fprintf(&b, "\t; %s", constantAt(f, INDEXK(c)))
+		}
+	case OP_SETTABLE, OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR, OP_EQ, OP_LT, OP_LE:
+		if ISK(bb) || ISK(c) {
+			fmt.Fprintf(&b, "\t; %s %s", rkComment(f, bb), rkComment(f, c))
+		}
+	case OP_JMP, OP_FORLOOP, OP_FORPREP, OP_TFORLOOP:
+		fmt.Fprintf(&b, "\t; to %d", sbx+pc+2)
+	case OP_CLOSURE:
+		if bx < len(f.Protos) {
+			fmt.Fprintf(&b, "\t; %s", opts.Address(f.Protos[bx]))
+		}
+	case OP_SETLIST:
+		// 与 luac 一致，C 为 0 时直接输出下一条 EXTRAARG 指令的原始值
+		if c == 0 && pc+1 < len(f.Code) {
+			fmt.Fprintf(&b, "\t; %d", f.Code[pc+1])
+		} else {
+			fmt.Fprintf(&b, "\t; %d", c)
+		}
+	case OP_EXTRAARG:
+		fmt.Fprintf(&b, "\t; %s", constantAt(f, ax))
+	}
+	return b.String()
+}
+
+/*
+RK 操作数在输出时的形式，常量表索引输出成 -1-x
+*/
+func rkOperand(x int) int {
+	if ISK(x) {
+		return myk(INDEXK(x))
+	}
+	return x
+}
+
+/*
+RK 操作数在注释中的形式，常量输出其值，寄存器输出 "-"
+*/
+func rkComment(f *Prototype, x int) string {
+	if ISK(x) {
+		return constantAt(f, INDEXK(x))
+	}
+	return "-"
+}
+
+/*
+对应 luac 中的 PrintDebug，注意局部变量的 pc 范围在输出时都加了 1
+*/
+func (self *disassembler) printDebug(f *Prototype) {
+	addr := self.opts.Address(f)
+
+	self.printf("constants (%d) for %s:\n", len(f.Constants), addr)
+	for i, k := range f.Constants {
+		self.printf("\t%d\t%s\n", i+1, k)
+	}
+
+	self.printf("locals (%d) for %s:\n", len(f.LocVars), addr)
+	for i, locVar := range f.LocVars {
+		self.printf("\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPc+1, locVar.EndPc+1)
+	}
+
+	self.printf("upvalues (%d) for %s:\n", len(f.Upvalues), addr)
+	for i, upval := range f.Upvalues {
+		self.printf("\t%d\t%s\t%d\t%d\n", i, upvalueName(f, i), upval.Instack, upval.Idx)
+	}
+}
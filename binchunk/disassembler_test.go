@@ -0,0 +1,149 @@
+package binchunk_test
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+// 与 golden 对应的源代码
+const goldenSource = `local t = {1, 2, n = "x"}
+local function add(a, b)
+  return a + b, t.n
+end
+x = add(1, 2.5)
+for i = 1, 2 do
+  if i == 2 then print(i) end
+end
+t:m("\n")
+`
+
+// luac 5.3 对 goldenSource 的 `luac -l -l` 输出，函数地址换成了固定的值
+const goldenFull = `
+main <test.lua:0,0> (25 instructions at 0x0000)
+0+ params, 8 slots, 1 upvalue, 6 locals, 8 constants, 1 function
+	1	[1]	NEWTABLE 	0 2 1
+	2	[1]	LOADK    	1 -1	; 1
+	3	[1]	LOADK    	2 -2	; 2
+	4	[1]	SETTABLE 	0 -3 -4	; "n" "x"
+	5	[1]	SETLIST  	0 2 1	; 1
+	6	[4]	CLOSURE  	1 0	; 0x0002
+	7	[5]	MOVE     	2 1
+	8	[5]	LOADK    	3 -1	; 1
+	9	[5]	LOADK    	4 -5	; 2.5
+	10	[5]	CALL     	2 3 2
+	11	[5]	SETTABUP 	0 -4 2	; _ENV "x"
+	12	[6]	LOADK    	2 -1	; 1
+	13	[6]	LOADK    	3 -2	; 2
+	14	[6]	LOADK    	4 -1	; 1
+	15	[6]	FORPREP  	2 5	; to 21
+	16	[7]	EQ       	0 5 -2	; - 2
+	17	[7]	JMP      	0 3	; to 21
+	18	[7]	GETTABUP 	6 0 -6	; _ENV "print"
+	19	[7]	MOVE     	7 5
+	20	[7]	CALL     	6 2 1
+	21	[6]	FORLOOP  	2 -6	; to 16
+	22	[9]	SELF     	2 0 -7	; "m"
+	23	[9]	LOADK    	4 -8	; "\n"
+	24	[9]	CALL     	2 3 1
+	25	[9]	RETURN   	0 1
+constants (8) for 0x0000:
+	1	1
+	2	2
+	3	"n"
+	4	"x"
+	5	2.5
+	6	"print"
+	7	"m"
+	8	"\n"
+locals (6) for 0x0000:
+	0	t	6	26
+	1	add	7	26
+	2	(for index)	15	22
+	3	(for limit)	15	22
+	4	(for step)	15	22
+	5	i	16	21
+upvalues (1) for 0x0000:
+	0	_ENV	1	0
+
+function <test.lua:2,4> (4 instructions at 0x0002)
+2 params, 4 slots, 1 upvalue, 2 locals, 1 constant, 0 functions
+	1	[3]	ADD      	2 0 1
+	2	[3]	GETTABUP 	3 0 -1	; t "n"
+	3	[3]	RETURN   	2 3
+	4	[4]	RETURN   	0 1
+constants (1) for 0x0002:
+	1	"n"
+locals (2) for 0x0002:
+	0	a	1	5
+	1	b	1	5
+upvalues (1) for 0x0002:
+	0	t	1	0
+`
+
+/*
+用 LineDefined 代替 %p，使输出与 golden 中的地址一致
+*/
+func goldenAddress(f *Prototype) string {
+	return fmt.Sprintf("0x%04x", f.LineDefined)
+}
+
+func goldenProto(t *testing.T) *Prototype {
+	proto, err := compiler.Compile(goldenSource, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return proto
+}
+
+func TestDisassembleFull(t *testing.T) {
+	var b strings.Builder
+	if err := Disassemble(&b, goldenProto(t), DisasmOptions{Full: true, Address: goldenAddress}); err != nil {
+		t.Fatalf("Disassemble: %v", err)
+	}
+	diffLines(t, b.String(), goldenFull)
+}
+
+func TestDisassembleShort(t *testing.T) {
+	// `luac -l` 的输出没有常量表、局部变量表和 Upvalue 表
+	var want strings.Builder
+	skip := false
+	for _, line := range strings.SplitAfter(goldenFull, "\n") {
+		if strings.HasPrefix(line, "constants ") {
+			skip = true
+		} else if line == "\n" {
+			skip = false
+		}
+		if !skip {
+			want.WriteString(line)
+		}
+	}
+
+	var b strings.Builder
+	if err := Disassemble(&b, goldenProto(t), DisasmOptions{Address: goldenAddress}); err != nil {
+		t.Fatalf("Disassemble: %v", err)
+	}
+	diffLines(t, b.String(), want.String())
+}
+
+/*
+逐行比较，只报告第一处不同
+*/
+func diffLines(t *testing.T, got, want string) {
+	t.Helper()
+	g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl {
+			t.Fatalf("line %d:\ngot:  %q\nwant: %q\nfull output:\n%s", i+1, gl, wl, got)
+		}
+	}
+}
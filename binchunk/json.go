@@ -0,0 +1,559 @@
+package binchunk
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	. "lua-vm/vm"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+/*
+JSON 格式的二进制 chunk，用于导出给其他工具（比如网页版的字节码查看器）使用；
+所有 pc 均从 0 开始计数，与 LocVar 中的 StartPc/EndPc 保持一致
+*/
+type jsonChunk struct {
+	Header jsonHeader `json:"header"`
+	Main   *jsonProto `json:"main"`
+}
+
+/*
+解码后的头部信息，字段含义详见 header 结构体；
+签名和 LUAC_DATA 中含有不可打印的字节，因此以十六进制字符串的形式保存
+*/
+type jsonHeader struct {
+	Signature       string  `json:"signature"`
+	Version         int     `json:"version"`
+	Format          int     `json:"format"`
+	LuacData        string  `json:"luacData"`
+	CintSize        int     `json:"cintSize"`
+	SizetSize       int     `json:"sizetSize"`
+	InstructionSize int     `json:"instructionSize"`
+	LuaIntegerSize  int     `json:"luaIntegerSize"`
+	LuaNumberSize   int     `json:"luaNumberSize"`
+	LuacInt         int64   `json:"luacInt"`
+	LuacNum         float64 `json:"luacNum"`
+}
+
+/*
+JSON 格式的函数原型
+*/
+type jsonProto struct {
+	Source          string            `json:"source"`
+	LineDefined     uint32            `json:"lineDefined"`
+	LastLineDefined uint32            `json:"lastLineDefined"`
+	NumParams       byte              `json:"numParams"`
+	IsVararg        bool              `json:"isVararg"`
+	MaxStackSize    byte              `json:"maxStackSize"`
+	Code            []jsonInstruction `json:"code"`
+	Constants       []jsonConstant    `json:"constants"`
+	Upvalues        []jsonUpvalue     `json:"upvalues"`
+	LocVars         []jsonLocVar      `json:"locals"`
+	LineInfo        []uint32          `json:"lineInfo"`
+	Protos          []*jsonProto      `json:"protos"`
+}
+
+/*
+JSON 格式的指令，只输出当前指令模式下存在的操作数；
+导入时以 name 和各个操作数为准，与 raw 一致时直接使用 raw，这样不使用的操作数（OpArgN）中的位
+不会丢失，否则根据 name 和操作数重新编码；
+非法的操作码（47～63）导出时 name 为空，这样导入后可以得到原样的指令
+*/
+type jsonInstruction struct {
+	Pc     int    `json:"pc"`
+	Line   uint32 `json:"line,omitempty"`
+	Raw    uint32 `json:"raw"`
+	Opcode int    `json:"opcode"`
+	Name   string `json:"name"`
+	Mode   string `json:"mode"`
+	A      *int   `json:"a,omitempty"`
+	B      *int   `json:"b,omitempty"`
+	C      *int   `json:"c,omitempty"`
+	Bx     *int   `json:"bx,omitempty"`
+	SBx    *int   `json:"sbx,omitempty"`
+	Ax     *int   `json:"ax,omitempty"`
+	// 以下字段只用于导出，方便查看，导入时会被忽略
+	RKB    *jsonRK       `json:"rkb,omitempty"`
+	RKC    *jsonRK       `json:"rkc,omitempty"`
+	Kst    *jsonConstant `json:"constant,omitempty"`
+	Target *int          `json:"target,omitempty"`
+}
+
+/*
+解析后的 RK 操作数
+*/
+type jsonRK struct {
+	// "register" 或 "constant"
+	Kind     string        `json:"kind"`
+	Index    int           `json:"index"`
+	Constant *jsonConstant `json:"constant,omitempty"`
+}
+
+/*
+JSON 格式的常量，type 保留了原本的 tag：
+nil、boolean、float、integer、shortString、longString；
+无法用 JSON 数字表示的浮点数（inf、-inf、nan）以字符串的形式保存，
+不是合法 UTF-8 的字符串以 base64 的形式保存在 bytes 字段中
+*/
+type jsonConstant struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value,omitempty"`
+	Bytes string      `json:"bytes,omitempty"`
+}
+
+type jsonUpvalue struct {
+	Name    string `json:"name,omitempty"`
+	Instack bool   `json:"instack"`
+	Idx     byte   `json:"idx"`
+}
+
+type jsonLocVar struct {
+	Name    string `json:"name"`
+	StartPc uint32 `json:"startPc"`
+	EndPc   uint32 `json:"endPc"`
+}
+
+/*
+把主函数的 Prototype 以 JSON 的形式写入 w，包括头部信息以及所有的子函数
+*/
+func ExportJSON(w io.Writer, proto *Prototype) error {
+	chunk := jsonChunk{
+		Header: jsonHeader{
+			Signature:       hex.EncodeToString([]byte(LUA_SIGNATURE)),
+			Version:         LUAC_VERSION,
+			Format:          LUAC_FORMAT,
+			LuacData:        hex.EncodeToString([]byte(LUAC_DATA)),
+			CintSize:        CINT_SIZE,
+			SizetSize:       CSIZET_SIZE,
+			InstructionSize: INSTRUCTION_SIZE,
+			LuaIntegerSize:  LUA_INTEGER_SIZE,
+			LuaNumberSize:   LUA_NUMBER_SIZE,
+			LuacInt:         LUAC_INT,
+			LuacNum:         LUAC_NUM,
+		},
+		Main: exportProto(proto),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(chunk)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+/*
+返回指令模式对应的名字
+*/
+func opModeName(mode byte) string {
+	switch mode {
+	case IABC:
+		return "iABC"
+	case IABx:
+		return "iABx"
+	case IAsBx:
+		return "iAsBx"
+	default:
+		return "iAx"
+	}
+}
+
+func exportProto(f *Prototype) *jsonProto {
+	jp := &jsonProto{
+		Source:          f.Source,
+		LineDefined:     f.LineDefined,
+		LastLineDefined: f.LastLineDefined,
+		NumParams:       f.NumParams,
+		IsVararg:        f.IsVararg != 0,
+		MaxStackSize:    f.MaxStackSize,
+		Code:            make([]jsonInstruction, len(f.Code)),
+		Constants:       make([]jsonConstant, len(f.Constants)),
+		Upvalues:        make([]jsonUpvalue, len(f.Upvalues)),
+		LocVars:         make([]jsonLocVar, len(f.LocVars)),
+		LineInfo:        f.LineInfo,
+		Protos:          make([]*jsonProto, len(f.Protos)),
+	}
+	if jp.LineInfo == nil {
+		jp.LineInfo = []uint32{}
+	}
+	for pc := range f.Code {
+		jp.Code[pc] = exportInstruction(f, pc)
+	}
+	for i, k := range f.Constants {
+		jp.Constants[i] = exportConstant(k)
+	}
+	for i, upval := range f.Upvalues {
+		jp.Upvalues[i] = jsonUpvalue{Instack: upval.Instack != 0, Idx: upval.Idx}
+		if i < len(f.UpvalueNames) {
+			jp.Upvalues[i].Name = f.UpvalueNames[i]
+		}
+	}
+	for i, locVar := range f.LocVars {
+		jp.LocVars[i] = jsonLocVar{locVar.VarName, locVar.StartPc, locVar.EndPc}
+	}
+	for i, p := range f.Protos {
+		jp.Protos[i] = exportProto(p)
+	}
+	return jp
+}
+
+/*
+解码一条指令，RK 操作数会被解析成寄存器或常量，跳转指令会给出跳转目标
+*/
+func exportInstruction(f *Prototype, pc int) jsonInstruction {
+	i := Instruction(f.Code[pc])
+	ji := jsonInstruction{
+		Pc:     pc,
+		Raw:    f.Code[pc],
+		Opcode: i.Opcode(),
+		Name:   i.OpName(),
+		Mode:   opModeName(i.OpMode()),
+	}
+	if !i.IsValid() {
+		ji.Name = ""
+	}
+	if pc < len(f.LineInfo) {
+		ji.Line = f.LineInfo[pc]
+	}
+
+	switch i.OpMode() {
+	case IABC:
+		a, b, c := i.ABC()
+		ji.A = intPtr(a)
+		if i.BMode() != OpArgN {
+			ji.B = intPtr(b)
+			if i.BMode() == OpArgK {
+				ji.RKB = exportRK(f, b)
+			}
+		}
+		if i.CMode() != OpArgN {
+			ji.C = intPtr(c)
+			if i.CMode() == OpArgK {
+				ji.RKC = exportRK(f, c)
+			}
+		}
+		if i.Opcode() == OP_LOADBOOL && c != 0 {
+			ji.Target = intPtr(pc + 2)
+		}
+	case IABx:
+		a, bx := i.ABx()
+		ji.A = intPtr(a)
+		ji.Bx = intPtr(bx)
+		if i.BMode() == OpArgK && bx < len(f.Constants) {
+			k := exportConstant(f.Constants[bx])
+			ji.Kst = &k
+		}
+	case IAsBx:
+		a, sbx := i.AsBx()
+		ji.A = intPtr(a)
+		ji.SBx = intPtr(sbx)
+		ji.Target = intPtr(pc + 1 + sbx)
+	case IAx:
+		ax := i.Ax()
+		ji.Ax = intPtr(ax)
+		if pc > 0 && Instruction(f.Code[pc-1]).Opcode() == OP_LOADKX && ax < len(f.Constants) {
+			k := exportConstant(f.Constants[ax])
+			ji.Kst = &k
+		}
+	}
+	return ji
+}
+
+func exportRK(f *Prototype, x int) *jsonRK {
+	if !ISK(x) {
+		return &jsonRK{Kind: "register", Index: x}
+	}
+	rk := &jsonRK{Kind: "constant", Index: INDEXK(x)}
+	if INDEXK(x) < len(f.Constants) {
+		k := exportConstant(f.Constants[INDEXK(x)])
+		rk.Constant = &k
+	}
+	return rk
+}
+
+/*
+常量 tag 与 JSON 中 type 字段的对应关系
+*/
+var constantTypes = map[byte]string{
+	TAG_NIL:       "nil",
+	TAG_BOOLEAN:   "boolean",
+	TAG_NUMBER:    "float",
+	TAG_INTEGER:   "integer",
+	TAG_SHORT_STR: "shortString",
+	TAG_LONG_STR:  "longString",
+}
+
+func exportConstant(k Constant) jsonConstant {
+	jk := jsonConstant{Type: constantTypes[k.Tag], Value: k.Value}
+	switch x := k.Value.(type) {
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			jk.Value = formatFloat(x)
+		}
+	case string:
+		if !utf8.ValidString(x) {
+			jk.Value = nil
+			jk.Bytes = base64.StdEncoding.EncodeToString([]byte(x))
+		}
+	}
+	return jk
+}
+
+/*
+从 ExportJSON 导出的 JSON 中重建主函数的 Prototype；
+指令与 raw 不一致时根据 name 和操作数重新编码，因此直接修改 JSON 中的操作数即可修改字节码，
+操作数的范围会根据指令模式进行检查
+*/
+func ImportJSON(r io.Reader) (*Prototype, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var chunk jsonChunk
+	if err := dec.Decode(&chunk); err != nil {
+		return nil, err
+	}
+	if err := checkJSONHeader(chunk.Header); err != nil {
+		return nil, err
+	}
+	if chunk.Main == nil {
+		return nil, fmt.Errorf("json: missing main function")
+	}
+	return importProto(chunk.Main)
+}
+
+/*
+只支持与 checkHeader 中相同的格式
+*/
+func checkJSONHeader(h jsonHeader) error {
+	expected := jsonHeader{hex.EncodeToString([]byte(LUA_SIGNATURE)), LUAC_VERSION, LUAC_FORMAT,
+		hex.EncodeToString([]byte(LUAC_DATA)),
+		CINT_SIZE, CSIZET_SIZE, INSTRUCTION_SIZE, LUA_INTEGER_SIZE, LUA_NUMBER_SIZE,
+		LUAC_INT, LUAC_NUM}
+	if h != expected {
+		return fmt.Errorf("json: unsupported header %+v", h)
+	}
+	return nil
+}
+
+func importProto(jp *jsonProto) (*Prototype, error) {
+	f := &Prototype{
+		Source:          jp.Source,
+		LineDefined:     jp.LineDefined,
+		LastLineDefined: jp.LastLineDefined,
+		NumParams:       jp.NumParams,
+		MaxStackSize:    jp.MaxStackSize,
+		Code:            make([]uint32, len(jp.Code)),
+		Constants:       make([]Constant, len(jp.Constants)),
+		Upvalues:        make([]Upvalue, len(jp.Upvalues)),
+		Protos:          make([]*Prototype, len(jp.Protos)),
+		LineInfo:        jp.LineInfo,
+		LocVars:         make([]LocVar, len(jp.LocVars)),
+	}
+	if jp.IsVararg {
+		f.IsVararg = 1
+	}
+
+	for pc, ji := range jp.Code {
+		code, err := importInstruction(ji)
+		if err != nil {
+			return nil, fmt.Errorf("json: function <%s:%d> pc %d: %v", jp.Source, jp.LineDefined, pc, err)
+		}
+		f.Code[pc] = code
+	}
+	for i, jk := range jp.Constants {
+		k, err := importConstant(jk)
+		if err != nil {
+			return nil, fmt.Errorf("json: function <%s:%d> constant %d: %v", jp.Source, jp.LineDefined, i, err)
+		}
+		f.Constants[i] = k
+	}
+	hasNames := false
+	for i, ju := range jp.Upvalues {
+		f.Upvalues[i] = Upvalue{Idx: ju.Idx}
+		if ju.Instack {
+			f.Upvalues[i].Instack = 1
+		}
+		hasNames = hasNames || ju.Name != ""
+	}
+	// 被剔除了调试信息的函数没有 Upvalue 名表
+	if hasNames {
+		f.UpvalueNames = make([]string, len(jp.Upvalues))
+		for i, ju := range jp.Upvalues {
+			f.UpvalueNames[i] = ju.Name
+		}
+	}
+	for i, jl := range jp.LocVars {
+		f.LocVars[i] = LocVar{jl.Name, jl.StartPc, jl.EndPc}
+	}
+	for i, jsub := range jp.Protos {
+		p, err := importProto(jsub)
+		if err != nil {
+			return nil, err
+		}
+		f.Protos[i] = p
+	}
+	return f, nil
+}
+
+/*
+返回操作数的值，缺失时返回 0；超出 [min, max] 时返回错误
+*/
+func operand(name string, x *int, min, max int) (int, error) {
+	if x == nil {
+		return 0, nil
+	}
+	if *x < min || *x > max {
+		return 0, fmt.Errorf("operand %s = %d out of range [%d, %d]", name, *x, min, max)
+	}
+	return *x, nil
+}
+
+func importInstruction(ji jsonInstruction) (uint32, error) {
+	if ji.Name == "" {
+		return ji.Raw, nil
+	}
+	op, ok := opcodeByName(ji.Name)
+	if !ok {
+		return 0, fmt.Errorf("unknown opcode %q", ji.Name)
+	}
+	if rawMatches(ji, op) {
+		return ji.Raw, nil
+	}
+
+	a, err := operand("a", ji.A, 0, MAXARG_A)
+	if err != nil {
+		return 0, err
+	}
+	switch Instruction(op).OpMode() {
+	case IABC:
+		b, err := operand("b", ji.B, 0, MAXARG_B)
+		if err != nil {
+			return 0, err
+		}
+		c, err := operand("c", ji.C, 0, MAXARG_C)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(CreateABC(op, a, b, c)), nil
+	case IABx:
+		bx, err := operand("bx", ji.Bx, 0, MAXARG_Bx)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(CreateABx(op, a, bx)), nil
+	case IAsBx:
+		sbx, err := operand("sbx", ji.SBx, -MAXARG_sBx, MAXARG_Bx-MAXARG_sBx)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(CreateAsBx(op, a, sbx)), nil
+	default:
+		ax, err := operand("ax", ji.Ax, 0, MAXARG_Ax)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(CreateAx(op, ax)), nil
+	}
+}
+
+/*
+判断 raw 的操作码以及 JSON 中给出的各个操作数是否都与 name 和操作数一致，缺失的操作数不参与比较
+*/
+func rawMatches(ji jsonInstruction, op int) bool {
+	same := func(x *int, v int) bool { return x == nil || *x == v }
+	raw := Instruction(ji.Raw)
+	if raw.Opcode() != op {
+		return false
+	}
+	switch raw.OpMode() {
+	case IABC:
+		a, b, c := raw.ABC()
+		return same(ji.A, a) && same(ji.B, b) && same(ji.C, c)
+	case IABx:
+		a, bx := raw.ABx()
+		return same(ji.A, a) && same(ji.Bx, bx)
+	case IAsBx:
+		a, sbx := raw.AsBx()
+		return same(ji.A, a) && same(ji.SBx, sbx)
+	default:
+		return same(ji.Ax, raw.Ax())
+	}
+}
+
+/*
+根据指令名查找操作码
+*/
+func opcodeByName(name string) (int, bool) {
+	for op := 0; op < NUM_OPCODES; op++ {
+		if Instruction(op).OpName() == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func importConstant(jk jsonConstant) (Constant, error) {
+	switch jk.Type {
+	case "nil":
+		return Constant{TAG_NIL, nil}, nil
+	case "boolean":
+		b, ok := jk.Value.(bool)
+		if !ok && jk.Value != nil {
+			return Constant{}, fmt.Errorf("bad boolean %v", jk.Value)
+		}
+		return Constant{TAG_BOOLEAN, b}, nil
+	case "integer":
+		n, ok := jk.Value.(json.Number)
+		if !ok {
+			return Constant{TAG_INTEGER, int64(0)}, nil
+		}
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		if err != nil {
+			return Constant{}, err
+		}
+		return Constant{TAG_INTEGER, i}, nil
+	case "float":
+		switch x := jk.Value.(type) {
+		case nil:
+			return Constant{TAG_NUMBER, 0.0}, nil
+		case json.Number:
+			f, err := strconv.ParseFloat(string(x), 64)
+			if err != nil {
+				return Constant{}, err
+			}
+			return Constant{TAG_NUMBER, f}, nil
+		case string:
+			switch x {
+			case "inf":
+				return Constant{TAG_NUMBER, math.Inf(1)}, nil
+			case "-inf":
+				return Constant{TAG_NUMBER, math.Inf(-1)}, nil
+			case "nan":
+				return Constant{TAG_NUMBER, math.NaN()}, nil
+			}
+		}
+		return Constant{}, fmt.Errorf("bad float %v", jk.Value)
+	case "shortString", "longString":
+		tag := byte(TAG_SHORT_STR)
+		if jk.Type == "longString" {
+			tag = TAG_LONG_STR
+		}
+		if jk.Bytes != "" {
+			bytes, err := base64.StdEncoding.DecodeString(jk.Bytes)
+			if err != nil {
+				return Constant{}, err
+			}
+			return Constant{tag, string(bytes)}, nil
+		}
+		s, ok := jk.Value.(string)
+		if !ok && jk.Value != nil {
+			return Constant{}, fmt.Errorf("bad string %v", jk.Value)
+		}
+		return Constant{tag, s}, nil
+	default:
+		return Constant{}, fmt.Errorf("unknown constant type %q", jk.Type)
+	}
+}
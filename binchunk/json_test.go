@@ -0,0 +1,92 @@
+package binchunk
+
+import (
+	"bytes"
+	"encoding/json"
+	. "lua-vm/vm"
+	"testing"
+)
+
+func TestJSONRoundTripInvalidOpcode(t *testing.T) {
+	// 操作码 50 之外的位也要原样保留
+	invalid := uint32(50 | 0x3ffffff<<6)
+	proto := &Prototype{
+		Source:       "@test.lua",
+		IsVararg:     1,
+		MaxStackSize: 2,
+		Code: []uint32{
+			uint32(CreateABx(OP_LOADK, 0, 0)),
+			invalid,
+			uint32(CreateABC(OP_RETURN, 0, 1, 0)),
+		},
+		Constants: []Constant{NewConstant("x")},
+		Upvalues:  []Upvalue{{Instack: 1, Idx: 0}},
+	}
+
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf, proto); err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	var chunk jsonChunk
+	if err := json.Unmarshal(buf.Bytes(), &chunk); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if name := chunk.Main.Code[1].Name; name != "" {
+		t.Fatalf("invalid opcode exported with name %q", name)
+	}
+
+	got, err := ImportJSON(&buf)
+	if err != nil {
+		t.Fatalf("ImportJSON: %v", err)
+	}
+	if len(got.Code) != len(proto.Code) {
+		t.Fatalf("got %d instructions, want %d", len(got.Code), len(proto.Code))
+	}
+	for pc, code := range proto.Code {
+		if got.Code[pc] != code {
+			t.Errorf("pc %d: got %#08x, want %#08x", pc, got.Code[pc], code)
+		}
+	}
+}
+
+func TestJSONRoundTripUnusedOperands(t *testing.T) {
+	// MOVE 不使用 C，RETURN 0 1 的 C 中也可以有多余的位
+	move := uint32(CreateABC(OP_MOVE, 1, 0, 0)) | 0x1ff<<14
+	ret := uint32(CreateABC(OP_RETURN, 0, 1, 0)) | 5<<14
+	proto := &Prototype{
+		Source:       "@test.lua",
+		IsVararg:     1,
+		MaxStackSize: 2,
+		Code:         []uint32{move, ret},
+		Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+	}
+
+	var buf bytes.Buffer
+	if err := ExportJSON(&buf, proto); err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	var chunk jsonChunk
+	if err := json.Unmarshal(buf.Bytes(), &chunk); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	got, err := importProto(chunk.Main)
+	if err != nil {
+		t.Fatalf("importProto: %v", err)
+	}
+	for pc, code := range proto.Code {
+		if got.Code[pc] != code {
+			t.Errorf("pc %d: got %#08x, want %#08x", pc, got.Code[pc], code)
+		}
+	}
+
+	// 修改了操作数之后以操作数为准重新编码
+	b := 1
+	chunk.Main.Code[0].B = &b
+	got, err = importProto(chunk.Main)
+	if err != nil {
+		t.Fatalf("importProto: %v", err)
+	}
+	if want := uint32(CreateABC(OP_MOVE, 1, 1, 0)); got.Code[0] != want {
+		t.Errorf("edited MOVE: got %#08x, want %#08x", got.Code[0], want)
+	}
+}
@@ -0,0 +1,12 @@
+package binchunk
+
+import "os"
+
+/*
+递归输出 Prototype 结构体中的内容，格式等同于 `luac -l -l` 的反编译输出
+*/
+func List(f *Prototype) {
+	if err := Disassemble(os.Stdout, f, DisasmOptions{Full: true}); err != nil {
+		panic(err)
+	}
+}
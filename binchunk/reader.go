@@ -1,16 +1,89 @@
 package binchunk
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"math"
 )
 
+/*
+UndumpReader 的资源限制，为 0 的字段表示不做限制
+*/
+type Limits struct {
+	// 整个 chunk 的最大字节数，包括头部
+	MaxBytes int64
+	// 单个函数的最大指令数
+	MaxCode int
+	// 单个函数的最大常量数
+	MaxConstants int
+	// 函数的最大嵌套深度，主函数的深度为 1
+	MaxDepth int
+	// 单个字符串（常量、源文件名、局部变量名等）的最大字节数
+	MaxString int
+}
+
+/*
+适用于不可信输入的缺省限制
+*/
+var DefaultLimits = Limits{
+	MaxBytes:     64 << 20,
+	MaxCode:      1 << 22,
+	MaxConstants: 1 << 22,
+	MaxDepth:     200,
+	MaxString:    16 << 20,
+}
+
+/*
+从 r 中逐步读取并解析二进制 chunk，返回主函数的 Prototype；
+只读取 chunk 本身所需的字节，r 中之后的数据保持不动，读取文件时可以用 bufio.Reader 包装以减少系统调用。
+任何超出 limits 的表长度或字符串长度都会在分配内存之前被发现并以 error 的形式返回，
+格式错误以及数据不完整同样返回 error
+*/
+func UndumpReader(r io.Reader, limits Limits) (proto *Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(undumpError); ok {
+				proto, err = nil, e
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	rd := &reader{r: r, limits: limits}
+	rd.checkHeader()
+	// 跳过主函数的 Upvalue 数量，因为这个值从 Prototype 中也可以拿到
+	rd.readByte()
+	return rd.readProto(""), nil
+}
+
+/*
+解析失败时抛出的错误，由 UndumpReader 捕获后返回
+*/
+type undumpError struct {
+	msg string
+}
+
+func (self undumpError) Error() string {
+	return self.msg
+}
+
 /*
 用于读取并分析 BinChunk 中的字节流
 */
 type reader struct {
-	// 内部记录将要被解析的字节流
-	data []byte
+	r      io.Reader
+	limits Limits
+	// 已经读取的字节数以及当前函数的嵌套深度
+	read  int64
+	depth int
+	buf   [8]byte
+}
+
+func (self *reader) errorf(format string, a ...interface{}) {
+	panic(undumpError{fmt.Sprintf(format, a...)})
 }
 
 /*
@@ -19,27 +92,27 @@ type reader struct {
 */
 func (self *reader) checkHeader() {
 	if string(self.readBytes(4)) != LUA_SIGNATURE {
-		panic("Signature Error")
+		self.errorf("Signature Error")
 	} else if self.readByte() != LUAC_VERSION {
-		panic("Luac Version Error")
+		self.errorf("Luac Version Error")
 	} else if self.readByte() != LUAC_FORMAT {
-		panic("Luac Format Error")
+		self.errorf("Luac Format Error")
 	} else if string(self.readBytes(6)) != LUAC_DATA {
-		panic("Luac Data Error")
+		self.errorf("Luac Data Error")
 	} else if self.readByte() != CINT_SIZE {
-		panic("Cint Size Error")
+		self.errorf("Cint Size Error")
 	} else if self.readByte() != CSIZET_SIZE {
-		panic("Size_t Size Error")
+		self.errorf("Size_t Size Error")
 	} else if self.readByte() != INSTRUCTION_SIZE {
-		panic("Instruction Size Error")
+		self.errorf("Instruction Size Error")
 	} else if self.readByte() != LUA_INTEGER_SIZE {
-		panic("Lua Integer Size Error")
+		self.errorf("Lua Integer Size Error")
 	} else if self.readByte() != LUA_NUMBER_SIZE {
-		panic("Lua Number Size Error")
+		self.errorf("Lua Number Size Error")
 	} else if self.readLuaInteger() != LUAC_INT {
-		panic("Lua Integer Format Error")
+		self.errorf("Lua Integer Format Error")
 	} else if self.readLuaNumber() != LUAC_NUM {
-		panic("Lua Number Format Error")
+		self.errorf("Lua Number Format Error")
 	}
 }
 
@@ -47,6 +120,12 @@ func (self *reader) checkHeader() {
 递归读取函数 Prototype 并返回主函数
 */
 func (self *reader) readProto(parentSource string) *Prototype {
+	self.depth++
+	if max := self.limits.MaxDepth; max > 0 && self.depth > max {
+		self.errorf("functions nested deeper than %d", max)
+	}
+	defer func() { self.depth-- }()
+
 	source := self.readString()
 	// 只有最顶层的 Prototype 才会获得 Source
 	// 子 Prototype 可以继承父 Prototype 的值
@@ -70,47 +149,73 @@ func (self *reader) readProto(parentSource string) *Prototype {
 	}
 }
 
+/*
+读取表的长度，检查它是否超过限制，以及剩余的字节数是否足以容纳这么多项（每项至少 minSize 个字节），
+这样在分配内存之前就能拒绝伪造的长度
+*/
+func (self *reader) readCount(what string, max int, minSize int64) int {
+	n := self.readUint32()
+	if max > 0 && int64(n) > int64(max) {
+		self.errorf("%d %s exceed limit %d", n, what, max)
+	}
+	if limit := self.limits.MaxBytes; limit > 0 && int64(n)*minSize > limit-self.read {
+		self.errorf("%d %s exceed chunk size limit %d", n, what, limit)
+	}
+	return int(n)
+}
+
+/*
+为 n 项的表预留空间，没有字节数限制时最多预留 4096 项，其余的随读取逐步增长
+*/
+func capacity(n int) int {
+	if n > 4096 {
+		return 4096
+	}
+	return n
+}
+
 /*
 读取所有的指令
 */
 func (self *reader) readCode() []uint32 {
-	code := make([]uint32, self.readUint32())
-	for i := range code {
-		code[i] = self.readUint32()
+	n := self.readCount("instructions", self.limits.MaxCode, INSTRUCTION_SIZE)
+	code := make([]uint32, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		code = append(code, self.readUint32())
 	}
 	return code
 }
 
 /*
-读取一个常量
+读取一个常量，保留其原本的 tag
 */
-func (self *reader) readConstant() interface{} {
+func (self *reader) readConstant() Constant {
 	tag := self.readByte()
 	switch tag {
 	case TAG_NIL:
-		return nil
+		return Constant{tag, nil}
 	case TAG_BOOLEAN:
-		return self.readByte()
+		return Constant{tag, self.readByte() != 0}
 	case TAG_NUMBER:
-		return self.readLuaNumber()
+		return Constant{tag, self.readLuaNumber()}
 	case TAG_INTEGER:
-		return self.readLuaInteger()
-	case TAG_SHORT_STR:
-		return self.readString()
-	case TAG_LONG_STR:
-		return self.readString()
+		return Constant{tag, self.readLuaInteger()}
+	case TAG_SHORT_STR, TAG_LONG_STR:
+		return Constant{tag, self.readString()}
 	default:
-		panic("Tag Error")
+		self.errorf("Tag Error")
+		return Constant{}
 	}
 }
 
 /*
 读取所有的常量
 */
-func (self *reader) readConstants() []interface{} {
-	constants := make([]interface{}, self.readUint32())
-	for i := range constants {
-		constants[i] = self.readConstant()
+func (self *reader) readConstants() []Constant {
+	n := self.readCount("constants", self.limits.MaxConstants, 1)
+	constants := make([]Constant, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		constants = append(constants, self.readConstant())
 	}
 	return constants
 }
@@ -119,12 +224,13 @@ func (self *reader) readConstants() []interface{} {
 读取所有的 Upvalue
 */
 func (self *reader) readUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, self.readUint32())
-	for i := range upvalues {
-		upvalues[i] = Upvalue{
+	n := self.readCount("upvalues", 0, 2)
+	upvalues := make([]Upvalue, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		upvalues = append(upvalues, Upvalue{
 			Instack: self.readByte(),
 			Idx:     self.readByte(),
-		}
+		})
 	}
 	return upvalues
 }
@@ -133,9 +239,11 @@ func (self *reader) readUpvalues() []Upvalue {
 读取所有的子 Prototype
 */
 func (self *reader) readProtos(source string) []*Prototype {
-	protos := make([]*Prototype, self.readUint32())
-	for i := range protos {
-		protos[i] = self.readProto(source)
+	// 一个函数原型至少包含 1 字节的源文件名、8 字节的行号、3 字节的参数信息以及 7 个表的长度
+	n := self.readCount("functions", 0, 40)
+	protos := make([]*Prototype, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		protos = append(protos, self.readProto(source))
 	}
 	return protos
 }
@@ -144,9 +252,10 @@ func (self *reader) readProtos(source string) []*Prototype {
 读取行号表
 */
 func (self *reader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, self.readUint32())
-	for i := range lineInfo {
-		lineInfo[i] = self.readUint32()
+	n := self.readCount("line entries", self.limits.MaxCode, 4)
+	lineInfo := make([]uint32, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		lineInfo = append(lineInfo, self.readUint32())
 	}
 	return lineInfo
 }
@@ -155,13 +264,14 @@ func (self *reader) readLineInfo() []uint32 {
 读取局部变量表
 */
 func (self *reader) readLocVars() []LocVar {
-	locVars := make([]LocVar, self.readUint32())
-	for i := range locVars {
-		locVars[i] = LocVar{
+	n := self.readCount("local variables", 0, 9)
+	locVars := make([]LocVar, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		locVars = append(locVars, LocVar{
 			VarName: self.readString(),
 			StartPc: self.readUint32(),
 			EndPc:   self.readUint32(),
-		}
+		})
 	}
 	return locVars
 }
@@ -170,9 +280,10 @@ func (self *reader) readLocVars() []LocVar {
 读取 Upvalue 名表
 */
 func (self *reader) readUpvalueNames() []string {
-	upValueNames := make([]string, self.readUint32())
-	for i := range upValueNames {
-		upValueNames[i] = self.readString()
+	n := self.readCount("upvalue names", 0, 1)
+	upValueNames := make([]string, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		upValueNames = append(upValueNames, self.readString())
 	}
 	return upValueNames
 }
@@ -181,36 +292,65 @@ func (self *reader) readUpvalueNames() []string {
 从当前数据中读取一个 byte 出来
 */
 func (self *reader) readByte() byte {
-	b := self.data[0]
-	self.data = self.data[1:]
-	return b
+	self.readFull(self.buf[:1])
+	return self.buf[0]
 }
 
 /*
-从当前数据中读取 n 个 byte 出来
+从当前数据中读取 n 个 byte 出来，超出字节数限制时在分配内存之前报错；
+没有字节数限制时，较长的数据随读取逐步分配，伪造的长度只会导致数据不完整的错误
 */
-func (self *reader) readBytes(n uint) []byte {
-	bytes := self.data[:n]
-	self.data = self.data[n:]
-	return bytes
+func (self *reader) readBytes(n uint64) []byte {
+	limit := self.limits.MaxBytes
+	if limit > 0 && n > uint64(limit-self.read) {
+		self.errorf("chunk exceeds size limit %d", limit)
+	}
+	if limit > 0 || n <= 4096 {
+		data := make([]byte, n)
+		self.readFull(data)
+		return data
+	}
+	if n > math.MaxInt64 {
+		self.errorf("bad string size")
+	}
+	var buf bytes.Buffer
+	copied, err := io.CopyN(&buf, self.r, int64(n))
+	self.read += copied
+	if err == io.EOF {
+		self.errorf("truncated chunk")
+	} else if err != nil {
+		panic(undumpError{err.Error()})
+	}
+	return buf.Bytes()
+}
+
+func (self *reader) readFull(p []byte) {
+	if limit := self.limits.MaxBytes; limit > 0 && int64(len(p)) > limit-self.read {
+		self.errorf("chunk exceeds size limit %d", limit)
+	}
+	n, err := io.ReadFull(self.r, p)
+	self.read += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		self.errorf("truncated chunk")
+	} else if err != nil {
+		panic(undumpError{err.Error()})
+	}
 }
 
 /*
 从当前数据中读取一个 cint 出来
 */
 func (self *reader) readUint32() uint32 {
-	i := binary.LittleEndian.Uint32(self.data)
-	self.data = self.data[4:]
-	return i
+	self.readFull(self.buf[:4])
+	return binary.LittleEndian.Uint32(self.buf[:4])
 }
 
 /*
 从当前数据中读取一个 size_t 出来
 */
 func (self *reader) readUint64() uint64 {
-	i := binary.LittleEndian.Uint64(self.data)
-	self.data = self.data[8:]
-	return i
+	self.readFull(self.buf[:8])
+	return binary.LittleEndian.Uint64(self.buf[:8])
 }
 
 /*
@@ -235,12 +375,18 @@ func (self *reader) readLuaNumber() float64 {
   对于长度大于等于 254 的字符串，第一个字节是 0xFF，后面跟着 size_t 来记录长度+1，再跟着字节数组
 */
 func (self *reader) readString() string {
-	size := uint(self.readByte())
+	size := uint64(self.readByte())
 	if size == 0 {
 		return ""
 	}
 	if size == 0xFF {
-		size = uint(self.readUint64())
+		size = self.readUint64()
+		if size == 0 {
+			self.errorf("bad string size")
+		}
+	}
+	if max := self.limits.MaxString; max > 0 && size-1 > uint64(max) {
+		self.errorf("string of %d bytes exceeds limit %d", size-1, max)
 	}
 	bytes := self.readBytes(size - 1)
 	return string(bytes)
@@ -0,0 +1,231 @@
+package binchunk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// 带签名的 chunk 的文件头，不能以 "\x1bLuaS" 开头，否则会与 5.3 的 chunk 混淆
+	SIGNED_SIGNATURE = "\x1bLsig"
+	SIGNED_FORMAT    = 1
+	// 签名算法
+	SIG_HMAC_SHA256 = 1
+	SIG_ED25519     = 2
+)
+
+/*
+带签名的 chunk 的格式如下，签名覆盖文件头（SIGNED_SIGNATURE、格式和算法）以及整个 luac chunk，
+因此算法字段同样不能被篡改：
+
+	SIGNED_SIGNATURE  5 字节
+	SIGNED_FORMAT     1 字节
+	算法              1 字节
+	签名长度          2 字节，小端序
+	签名
+	luac 5.3 chunk
+*/
+const signedHeaderSize = len(SIGNED_SIGNATURE) + 2
+
+/*
+签名所用的本地密钥
+*/
+type Signer interface {
+	Algorithm() byte
+	Sign(message []byte) []byte
+}
+
+/*
+验证签名所用的密钥，对于 HMAC 与签名所用的密钥相同，对于 Ed25519 是公钥
+*/
+type Verifier interface {
+	Algorithm() byte
+	Verify(message, sig []byte) bool
+}
+
+/*
+HMAC-SHA256 密钥，既可以签名也可以验证，不能为空，应当通过 NewHMACKey 创建
+*/
+type HMACKey []byte
+
+/*
+根据密钥创建 HMACKey，密钥为空时返回错误：空密钥的 HMAC 任何人都可以计算
+*/
+func NewHMACKey(key []byte) (HMACKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty HMAC key")
+	}
+	return HMACKey(key), nil
+}
+
+func (self HMACKey) Algorithm() byte {
+	return SIG_HMAC_SHA256
+}
+
+func (self HMACKey) Sign(message []byte) []byte {
+	mac := hmac.New(sha256.New, self)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (self HMACKey) Verify(message, sig []byte) bool {
+	return len(self) > 0 && hmac.Equal(self.Sign(message), sig)
+}
+
+/*
+Ed25519 私钥，用于签名，长度必须为 ed25519.PrivateKeySize，应当通过 NewEd25519Signer 创建
+*/
+type Ed25519Signer ed25519.PrivateKey
+
+/*
+根据私钥创建 Ed25519Signer，长度不正确时返回错误，而不是等到签名时才 panic
+*/
+func NewEd25519Signer(key []byte) (Ed25519Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("bad Ed25519 private key size %d (want %d)", len(key), ed25519.PrivateKeySize)
+	}
+	return Ed25519Signer(key), nil
+}
+
+func (self Ed25519Signer) Algorithm() byte {
+	return SIG_ED25519
+}
+
+func (self Ed25519Signer) Sign(message []byte) []byte {
+	return ed25519.Sign(ed25519.PrivateKey(self), message)
+}
+
+/*
+Ed25519 公钥，用于验证
+*/
+type Ed25519Verifier ed25519.PublicKey
+
+func (self Ed25519Verifier) Algorithm() byte {
+	return SIG_ED25519
+}
+
+func (self Ed25519Verifier) Verify(message, sig []byte) bool {
+	return len(self) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(self), message, sig)
+}
+
+/*
+判断 data 是否是带签名的 chunk
+*/
+func IsSigned(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(SIGNED_SIGNATURE))
+}
+
+/*
+给 luac 5.3 格式的 chunk 加上签名，返回带签名的 chunk
+*/
+func SignChunk(chunk []byte, s Signer) ([]byte, error) {
+	if !bytes.HasPrefix(chunk, []byte(LUA_SIGNATURE)) {
+		return nil, errors.New("not a binary chunk")
+	}
+	// 直接转换得到的 HMACKey 和 Ed25519Signer 没有经过检查
+	switch k := s.(type) {
+	case HMACKey:
+		if _, err := NewHMACKey(k); err != nil {
+			return nil, err
+		}
+	case Ed25519Signer:
+		if _, err := NewEd25519Signer(k); err != nil {
+			return nil, err
+		}
+	}
+	header := []byte(SIGNED_SIGNATURE)
+	header = append(header, SIGNED_FORMAT, s.Algorithm())
+	sig := s.Sign(signedMessage(header, chunk))
+	if len(sig) > 0xFFFF {
+		return nil, errors.New("signature too long")
+	}
+
+	data := append([]byte(nil), header...)
+	var size [2]byte
+	binary.LittleEndian.PutUint16(size[:], uint16(len(sig)))
+	data = append(data, size[:]...)
+	data = append(data, sig...)
+	return append(data, chunk...), nil
+}
+
+/*
+验证带签名的 chunk，返回其中的 luac chunk；
+没有签名、算法与 v 不一致或者签名无效时返回 error，此时 chunk 中的任何内容都还没有被解析
+*/
+func OpenSignedChunk(data []byte, v Verifier) ([]byte, error) {
+	if k, ok := v.(HMACKey); ok {
+		if _, err := NewHMACKey(k); err != nil {
+			return nil, err
+		}
+	}
+	header, sig, chunk, err := splitSigned(data)
+	if err != nil {
+		return nil, err
+	}
+	if alg := header[len(SIGNED_SIGNATURE)+1]; alg != v.Algorithm() {
+		return nil, fmt.Errorf("chunk is signed with algorithm %d, expected %d", alg, v.Algorithm())
+	}
+	if !v.Verify(signedMessage(header, chunk), sig) {
+		return nil, errors.New("invalid chunk signature")
+	}
+	return chunk, nil
+}
+
+/*
+把带签名的 chunk 拆分成文件头、签名以及 luac chunk
+*/
+func splitSigned(data []byte) (header, sig, chunk []byte, err error) {
+	if !IsSigned(data) {
+		return nil, nil, nil, errors.New("chunk is not signed")
+	}
+	if len(data) < signedHeaderSize+2 {
+		return nil, nil, nil, errors.New("truncated signed chunk")
+	}
+	header = data[:signedHeaderSize]
+	if format := header[len(SIGNED_SIGNATURE)]; format != SIGNED_FORMAT {
+		return nil, nil, nil, fmt.Errorf("unknown signed chunk format %d", format)
+	}
+	size := int(binary.LittleEndian.Uint16(data[signedHeaderSize:]))
+	rest := data[signedHeaderSize+2:]
+	if len(rest) < size {
+		return nil, nil, nil, errors.New("truncated signed chunk")
+	}
+	return header, rest[:size], rest[size:], nil
+}
+
+func signedMessage(header, chunk []byte) []byte {
+	message := append([]byte(nil), header...)
+	return append(message, chunk...)
+}
+
+/*
+验证签名之后再解析 chunk，拒绝没有签名或者签名无效的 chunk；
+v 为 nil 时不要求签名，带签名的 chunk 去掉签名之后直接解析，用于开发环境
+*/
+func UndumpSigned(data []byte, v Verifier, limits Limits) (*Prototype, error) {
+	chunk := data
+	var err error
+	if v != nil {
+		chunk, err = OpenSignedChunk(data, v)
+	} else if IsSigned(data) {
+		_, _, chunk, err = splitSigned(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(chunk)
+	proto, err := UndumpReader(r, limits)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("Undump Error")
+	}
+	return proto, nil
+}
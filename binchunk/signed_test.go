@@ -0,0 +1,62 @@
+package binchunk
+
+import (
+	. "lua-vm/vm"
+	"strings"
+	"testing"
+)
+
+func testChunk() []byte {
+	return Dump(&Prototype{
+		Source:       "@test.lua",
+		IsVararg:     1,
+		MaxStackSize: 2,
+		Code:         []uint32{uint32(CreateABC(OP_RETURN, 0, 1, 0))},
+		Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+	})
+}
+
+func TestSignedHMAC(t *testing.T) {
+	key, err := NewHMACKey([]byte("secret"))
+	if err != nil {
+		t.Fatalf("NewHMACKey: %v", err)
+	}
+	data, err := SignChunk(testChunk(), key)
+	if err != nil {
+		t.Fatalf("SignChunk: %v", err)
+	}
+	if _, err := UndumpSigned(data, key, DefaultLimits); err != nil {
+		t.Fatalf("UndumpSigned: %v", err)
+	}
+	_, err = UndumpSigned(data, HMACKey("other"), DefaultLimits)
+	if err == nil || err.Error() != "invalid chunk signature" {
+		t.Fatalf("wrong key: got error %v", err)
+	}
+}
+
+func TestSignedEmptyHMACKey(t *testing.T) {
+	if _, err := NewHMACKey(nil); err == nil {
+		t.Errorf("NewHMACKey accepted an empty key")
+	}
+	if _, err := SignChunk(testChunk(), HMACKey{}); err == nil || !strings.Contains(err.Error(), "empty HMAC key") {
+		t.Errorf("SignChunk with an empty key: got error %v", err)
+	}
+
+	// 即使签名本身是用空密钥算出来的，也不能通过验证
+	data, err := SignChunk(testChunk(), HMACKey("secret"))
+	if err != nil {
+		t.Fatalf("SignChunk: %v", err)
+	}
+	header, _, chunk, _ := splitSigned(data)
+	forged := append([]byte(nil), header...)
+	sig := HMACKey{}.Sign(signedMessage(header, chunk))
+	forged = append(forged, byte(len(sig)), byte(len(sig)>>8))
+	forged = append(forged, sig...)
+	forged = append(forged, chunk...)
+	if _, err := OpenSignedChunk(forged, HMACKey{}); err == nil || !strings.Contains(err.Error(), "empty HMAC key") {
+		t.Errorf("OpenSignedChunk with an empty key: got error %v", err)
+	}
+	if _, err := UndumpSigned(forged, HMACKey(nil), DefaultLimits); err == nil {
+		t.Errorf("UndumpSigned accepted a chunk signed with an empty key")
+	}
+}
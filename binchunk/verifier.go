@@ -0,0 +1,353 @@
+package binchunk
+
+import (
+	"fmt"
+	. "lua-vm/vm"
+)
+
+/*
+对 Prototype 进行静态检查，在执行不可信的字节码之前使用；
+检查内容包括寄存器、常量、Upvalue 以及子函数的索引是否越界，
+跳转目标是否落在指令表内，以及指令之间的搭配（test 后接 JMP，LOADKX 后接 EXTRAARG 等）是否正确；
+子函数会被递归检查，发现的第一个问题以 error 的形式返回
+*/
+func Verify(proto *Prototype) error {
+	return verifyProto(proto, nil)
+}
+
+/*
+检查单个函数原型，parent 为其外层函数，主函数的 parent 为 nil
+*/
+func verifyProto(f, parent *Prototype) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(verifyError); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	v := &verifier{f: f}
+	v.checkHeader(parent)
+	for pc := range f.Code {
+		v.checkInstruction(pc)
+	}
+
+	for _, p := range f.Protos {
+		if err := verifyProto(p, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+校验失败时抛出的错误，由 verifyProto 捕获后返回
+*/
+type verifyError struct {
+	msg string
+}
+
+func (self verifyError) Error() string {
+	return self.msg
+}
+
+/*
+用于检查一个函数原型的内部状态
+*/
+type verifier struct {
+	f *Prototype
+}
+
+/*
+抛出一个带有函数位置信息的错误
+*/
+func (self *verifier) errorf(format string, a ...interface{}) {
+	msg := fmt.Sprintf("function <%s:%d>: %s",
+		self.f.Source, self.f.LineDefined, fmt.Sprintf(format, a...))
+	panic(verifyError{msg})
+}
+
+/*
+检查与指令无关的部分，包括各个表的长度是否相互匹配，以及 Upvalue 描述是否指向外层函数中存在的位置
+*/
+func (self *verifier) checkHeader(parent *Prototype) {
+	f := self.f
+	if len(f.Code) == 0 {
+		self.errorf("empty code")
+	}
+	// 函数的最后一条指令必须是 RETURN，否则执行时会越过指令表的末尾
+	if Instruction(f.Code[len(f.Code)-1]).Opcode() != OP_RETURN {
+		self.errorf("code does not end with RETURN")
+	}
+	if f.IsVararg > 1 {
+		self.errorf("bad vararg flag %d", f.IsVararg)
+	}
+	if int(f.NumParams) > int(f.MaxStackSize) {
+		self.errorf("%d params exceed %d slots", f.NumParams, f.MaxStackSize)
+	}
+	if len(f.LineInfo) != 0 && len(f.LineInfo) != len(f.Code) {
+		self.errorf("%d line entries for %d instructions", len(f.LineInfo), len(f.Code))
+	}
+	if len(f.UpvalueNames) != 0 && len(f.UpvalueNames) != len(f.Upvalues) {
+		self.errorf("%d upvalue names for %d upvalues", len(f.UpvalueNames), len(f.Upvalues))
+	}
+	for i, locVar := range f.LocVars {
+		if locVar.StartPc > locVar.EndPc || int(locVar.EndPc) > len(f.Code) {
+			self.errorf("local %d has bad pc range [%d, %d)", i, locVar.StartPc, locVar.EndPc)
+		}
+	}
+
+	// 主函数的 Upvalue 由加载器负责设置，无需检查
+	if parent == nil {
+		return
+	}
+	for i, upval := range f.Upvalues {
+		if upval.Instack == 1 {
+			if upval.Idx >= parent.MaxStackSize {
+				self.errorf("upvalue %d refers to register %d of enclosing function", i, upval.Idx)
+			}
+		} else if upval.Instack == 0 {
+			if int(upval.Idx) >= len(parent.Upvalues) {
+				self.errorf("upvalue %d refers to upvalue %d of enclosing function", i, upval.Idx)
+			}
+		} else {
+			self.errorf("upvalue %d has bad instack flag %d", i, upval.Instack)
+		}
+	}
+}
+
+/*
+获取 pc 处的指令，pc 越界时报错
+*/
+func (self *verifier) instruction(pc int) Instruction {
+	if pc < 0 || pc >= len(self.f.Code) {
+		self.errorf("pc %d out of code", pc)
+	}
+	return Instruction(self.f.Code[pc])
+}
+
+/*
+检查寄存器索引是否小于 MaxStackSize
+*/
+func (self *verifier) checkReg(pc, reg int) {
+	if reg < 0 || reg >= int(self.f.MaxStackSize) {
+		self.errorf("pc %d: register %d out of range (%d slots)", pc+1, reg, self.f.MaxStackSize)
+	}
+}
+
+/*
+检查寄存器区间 [from, from+n) 是否都小于 MaxStackSize
+*/
+func (self *verifier) checkRegRange(pc, from, n int) {
+	if n > 0 {
+		self.checkReg(pc, from+n-1)
+	}
+}
+
+/*
+检查常量表索引是否越界
+*/
+func (self *verifier) checkConst(pc, idx int) {
+	if idx < 0 || idx >= len(self.f.Constants) {
+		self.errorf("pc %d: constant %d out of range (%d constants)", pc+1, idx, len(self.f.Constants))
+	}
+}
+
+/*
+检查 RK 操作数，第 9 位为 1 表示常量表索引，否则表示寄存器索引
+*/
+func (self *verifier) checkRK(pc, x int) {
+	if ISK(x) {
+		self.checkConst(pc, INDEXK(x))
+	} else {
+		self.checkReg(pc, x)
+	}
+}
+
+/*
+检查 Upvalue 索引是否越界
+*/
+func (self *verifier) checkUpval(pc, idx int) {
+	if idx >= len(self.f.Upvalues) {
+		self.errorf("pc %d: upvalue %d out of range (%d upvalues)", pc+1, idx, len(self.f.Upvalues))
+	}
+}
+
+/*
+检查跳转目标是否落在指令表内，返回跳转目标
+*/
+func (self *verifier) checkJump(pc, sbx int) int {
+	dest := pc + 1 + sbx
+	if dest < 0 || dest >= len(self.f.Code) {
+		self.errorf("pc %d: jump to %d out of code", pc+1, dest+1)
+	}
+	return dest
+}
+
+/*
+检查 pc 处的指令必须是 op
+*/
+func (self *verifier) checkNext(pc, op int, what string) {
+	if pc >= len(self.f.Code) || self.instruction(pc).Opcode() != op {
+		self.errorf("pc %d: %s must be followed by %s", pc, what, Instruction(op).OpName())
+	}
+}
+
+/*
+根据指令的模式和各个操作数的类型检查单条指令
+*/
+func (self *verifier) checkInstruction(pc int) {
+	f := self.f
+	i := self.instruction(pc)
+	if !i.IsValid() {
+		self.errorf("pc %d: invalid opcode %d", pc+1, i.Opcode())
+	}
+
+	if i.IsTest() {
+		self.checkNext(pc+1, OP_JMP, i.OpName())
+	}
+
+	op := i.Opcode()
+	switch i.OpMode() {
+	case IABC:
+		a, b, c := i.ABC()
+		self.checkABC(pc, op, a, b, c)
+
+	case IABx:
+		a, bx := i.ABx()
+		self.checkReg(pc, a)
+		switch op {
+		case OP_LOADK:
+			self.checkConst(pc, bx)
+		case OP_LOADKX:
+			self.checkNext(pc+1, OP_EXTRAARG, "LOADKX")
+			self.checkConst(pc, self.instruction(pc+1).Ax())
+		case OP_CLOSURE:
+			if bx >= len(f.Protos) {
+				self.errorf("pc %d: function %d out of range (%d functions)", pc+1, bx, len(f.Protos))
+			}
+		}
+
+	case IAsBx:
+		a, sbx := i.AsBx()
+		dest := self.checkJump(pc, sbx)
+		switch op {
+		case OP_JMP:
+			// A 不为 0 时表示需要关闭 >= R(A-1) 的 Upvalue
+			if a > 0 {
+				self.checkReg(pc, a-1)
+			}
+		case OP_FORPREP:
+			self.checkRegRange(pc, a, 4)
+			if Instruction(f.Code[dest]).Opcode() != OP_FORLOOP {
+				self.errorf("pc %d: FORPREP must jump to FORLOOP", pc+1)
+			}
+		case OP_FORLOOP:
+			self.checkRegRange(pc, a, 4)
+		case OP_TFORLOOP:
+			self.checkRegRange(pc, a, 2)
+		}
+
+	case IAx:
+		// EXTRAARG 只能作为 LOADKX 或 SETLIST 的附加参数出现
+		if pc == 0 {
+			self.errorf("pc %d: unexpected EXTRAARG", pc+1)
+		}
+		prev := self.instruction(pc - 1)
+		if prev.Opcode() == OP_LOADKX {
+			return
+		}
+		if prev.Opcode() == OP_SETLIST {
+			if _, _, c := prev.ABC(); c == 0 {
+				return
+			}
+		}
+		self.errorf("pc %d: unexpected EXTRAARG", pc+1)
+	}
+}
+
+/*
+检查 iABC 模式的指令，B 和 C 操作数先根据其类型进行通用检查，再对各条指令的特殊用法进行检查
+*/
+func (self *verifier) checkABC(pc, op, a, b, c int) {
+	f := self.f
+
+	switch op {
+	case OP_SETTABUP:
+		self.checkUpval(pc, a)
+	case OP_EQ, OP_LT, OP_LE:
+		// A 是与比较结果对照的布尔值，不是寄存器
+		if a > 1 {
+			self.errorf("pc %d: bad %s flag %d", pc+1, Instruction(op).OpName(), a)
+		}
+	default:
+		self.checkReg(pc, a)
+	}
+
+	i := Instruction(f.Code[pc])
+	switch i.BMode() {
+	case OpArgR:
+		self.checkReg(pc, b)
+	case OpArgK:
+		self.checkRK(pc, b)
+	}
+	switch i.CMode() {
+	case OpArgR:
+		self.checkReg(pc, c)
+	case OpArgK:
+		self.checkRK(pc, c)
+	}
+
+	switch op {
+	case OP_LOADBOOL:
+		if c != 0 {
+			self.checkJump(pc, 1)
+		}
+	case OP_LOADNIL:
+		self.checkRegRange(pc, a, b+1)
+	case OP_GETUPVAL, OP_SETUPVAL, OP_GETTABUP:
+		self.checkUpval(pc, b)
+	case OP_SELF:
+		self.checkReg(pc, a+1)
+	case OP_CONCAT:
+		if b >= c {
+			self.errorf("pc %d: bad CONCAT range %d..%d", pc+1, b, c)
+		}
+	case OP_CALL, OP_TAILCALL:
+		if b > 0 {
+			self.checkRegRange(pc, a, b)
+		}
+		if c > 1 {
+			self.checkRegRange(pc, a, c-1)
+		}
+	case OP_RETURN:
+		if b > 0 {
+			self.checkRegRange(pc, a, b-1)
+		}
+	case OP_TFORCALL:
+		// 调用之前会把 R(A)..R(A+2) 复制到 R(A+3)..R(A+5)，C 小于 3 时也要用到 R(A+5)
+		n := 3 + c
+		if n < 6 {
+			n = 6
+		}
+		self.checkRegRange(pc, a, n)
+		self.checkNext(pc+1, OP_TFORLOOP, "TFORCALL")
+	case OP_SETLIST:
+		if b > 0 {
+			self.checkRegRange(pc, a, b+1)
+		}
+		if c == 0 {
+			self.checkNext(pc+1, OP_EXTRAARG, "SETLIST")
+		}
+	case OP_VARARG:
+		if f.IsVararg == 0 {
+			self.errorf("pc %d: VARARG in non-vararg function", pc+1)
+		}
+		if b > 1 {
+			self.checkRegRange(pc, a, b-1)
+		}
+	}
+}
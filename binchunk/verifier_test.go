@@ -0,0 +1,72 @@
+package binchunk
+
+import (
+	. "lua-vm/vm"
+	"strings"
+	"testing"
+)
+
+/*
+只有一个循环变量的泛型 for 循环：for k in f, s, c do end，R(0)..R(2) 为 f、s、c，R(3) 为 k
+*/
+func genericForProto(maxStack byte) *Prototype {
+	return &Prototype{
+		Source:       "@test.lua",
+		IsVararg:     1,
+		MaxStackSize: maxStack,
+		Code: []uint32{
+			uint32(CreateAsBx(OP_JMP, 0, 0)),
+			uint32(CreateABC(OP_TFORCALL, 0, 0, 1)),
+			uint32(CreateAsBx(OP_TFORLOOP, 2, -2)),
+			uint32(CreateABC(OP_RETURN, 0, 1, 0)),
+		},
+	}
+}
+
+func TestVerifyTForCallStack(t *testing.T) {
+	// TFORCALL 会用到 R(A+3)..R(A+5)，即使只有一个循环变量
+	if err := Verify(genericForProto(6)); err != nil {
+		t.Fatalf("Verify with 6 slots: %v", err)
+	}
+	for _, size := range []byte{4, 5} {
+		err := Verify(genericForProto(size))
+		if err == nil {
+			t.Fatalf("Verify with %d slots: expected an error", size)
+		}
+		if !strings.Contains(err.Error(), "register 5 out of range") {
+			t.Fatalf("Verify with %d slots: unexpected error %q", size, err)
+		}
+	}
+}
+
+/*
+if K(0) < K(1) 的比较，a 为比较指令的 A，函数只有一个寄存器
+*/
+func compareProto(op, a int) *Prototype {
+	return &Prototype{
+		Source:       "@test.lua",
+		IsVararg:     1,
+		MaxStackSize: 1,
+		Code: []uint32{
+			uint32(CreateABC(op, a, BITRK|0, BITRK|1)),
+			uint32(CreateAsBx(OP_JMP, 0, 0)),
+			uint32(CreateABC(OP_RETURN, 0, 1, 0)),
+		},
+		Constants: []Constant{NewConstant(int64(1)), NewConstant(int64(2))},
+	}
+}
+
+func TestVerifyCompareFlag(t *testing.T) {
+	// A 是布尔值而不是寄存器，为 1 时也不受寄存器数量的限制
+	for _, op := range []int{OP_EQ, OP_LT, OP_LE} {
+		for _, a := range []int{0, 1} {
+			if err := Verify(compareProto(op, a)); err != nil {
+				t.Errorf("%s A=%d: %v", Instruction(op).OpName(), a, err)
+			}
+		}
+		err := Verify(compareProto(op, 2))
+		if err == nil || !strings.Contains(err.Error(), "flag 2") {
+			t.Errorf("%s A=2: got error %v, want bad flag", Instruction(op).OpName(), err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/*
+用于把 Prototype 序列化成 BinChunk 字节流，是 reader 的逆过程
+*/
+type writer struct {
+	// 内部记录已经写出的字节流
+	data []byte
+}
+
+/*
+把主函数的 Prototype 序列化成 luac 5.3 格式的二进制 chunk
+*/
+func Dump(proto *Prototype) []byte {
+	w := &writer{}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues)))
+	w.writeProto(proto, "")
+	return w.data
+}
+
+/*
+写入头部信息，内容与 reader.checkHeader 中检查的一致
+*/
+func (self *writer) writeHeader() {
+	self.writeBytes([]byte(LUA_SIGNATURE))
+	self.writeByte(LUAC_VERSION)
+	self.writeByte(LUAC_FORMAT)
+	self.writeBytes([]byte(LUAC_DATA))
+	self.writeByte(CINT_SIZE)
+	self.writeByte(CSIZET_SIZE)
+	self.writeByte(INSTRUCTION_SIZE)
+	self.writeByte(LUA_INTEGER_SIZE)
+	self.writeByte(LUA_NUMBER_SIZE)
+	self.writeLuaInteger(LUAC_INT)
+	self.writeLuaNumber(LUAC_NUM)
+}
+
+/*
+递归写入函数 Prototype，
+与 luac 一样，子函数的 Source 如果和父函数相同，那么写入 NULL 字符串
+*/
+func (self *writer) writeProto(f *Prototype, parentSource string) {
+	if f.Source == parentSource {
+		self.writeString("")
+	} else {
+		self.writeString(f.Source)
+	}
+	self.writeUint32(f.LineDefined)
+	self.writeUint32(f.LastLineDefined)
+	self.writeByte(f.NumParams)
+	self.writeByte(f.IsVararg)
+	self.writeByte(f.MaxStackSize)
+
+	self.writeUint32(uint32(len(f.Code)))
+	for _, c := range f.Code {
+		self.writeUint32(c)
+	}
+
+	self.writeUint32(uint32(len(f.Constants)))
+	for _, k := range f.Constants {
+		self.writeConstant(k)
+	}
+
+	self.writeUint32(uint32(len(f.Upvalues)))
+	for _, upval := range f.Upvalues {
+		self.writeByte(upval.Instack)
+		self.writeByte(upval.Idx)
+	}
+
+	self.writeUint32(uint32(len(f.Protos)))
+	for _, p := range f.Protos {
+		self.writeProto(p, f.Source)
+	}
+
+	self.writeUint32(uint32(len(f.LineInfo)))
+	for _, line := range f.LineInfo {
+		self.writeUint32(line)
+	}
+
+	self.writeUint32(uint32(len(f.LocVars)))
+	for _, locVar := range f.LocVars {
+		self.writeString(locVar.VarName)
+		self.writeUint32(locVar.StartPc)
+		self.writeUint32(locVar.EndPc)
+	}
+
+	self.writeUint32(uint32(len(f.UpvalueNames)))
+	for _, name := range f.UpvalueNames {
+		self.writeString(name)
+	}
+}
+
+/*
+写入一个常量，tag 保持不变
+*/
+func (self *writer) writeConstant(k Constant) {
+	self.writeByte(k.Tag)
+	switch k.Tag {
+	case TAG_NIL:
+	case TAG_BOOLEAN:
+		if k.Value.(bool) {
+			self.writeByte(1)
+		} else {
+			self.writeByte(0)
+		}
+	case TAG_NUMBER:
+		self.writeLuaNumber(k.Value.(float64))
+	case TAG_INTEGER:
+		self.writeLuaInteger(k.Value.(int64))
+	case TAG_SHORT_STR, TAG_LONG_STR:
+		// 常量中的空字符串并不是 NULL 字符串，需要写出长度 1
+		self.writeLString(k.Value.(string))
+	default:
+		panic("Tag Error")
+	}
+}
+
+/*
+写入一个 byte
+*/
+func (self *writer) writeByte(b byte) {
+	self.data = append(self.data, b)
+}
+
+/*
+写入一组 byte
+*/
+func (self *writer) writeBytes(bytes []byte) {
+	self.data = append(self.data, bytes...)
+}
+
+/*
+写入一个 cint
+*/
+func (self *writer) writeUint32(i uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], i)
+	self.writeBytes(buf[:])
+}
+
+/*
+写入一个 size_t
+*/
+func (self *writer) writeUint64(i uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], i)
+	self.writeBytes(buf[:])
+}
+
+/*
+写入一个 Lua 整数
+*/
+func (self *writer) writeLuaInteger(i int64) {
+	self.writeUint64(uint64(i))
+}
+
+/*
+写入一个 Lua 浮点数
+*/
+func (self *writer) writeLuaNumber(n float64) {
+	self.writeUint64(math.Float64bits(n))
+}
+
+/*
+写入一个 string，格式详见 reader.readString；
+空字符串写成 NULL 字符串，即一个 0x00
+*/
+func (self *writer) writeString(s string) {
+	if s == "" {
+		self.writeByte(0)
+		return
+	}
+	self.writeLString(s)
+}
+
+/*
+写入一个非 NULL 的 string，先写入长度+1，再写入字节数组
+*/
+func (self *writer) writeLString(s string) {
+	size := len(s) + 1
+	if size < 0xFF {
+		self.writeByte(byte(size))
+	} else {
+		self.writeByte(0xFF)
+		self.writeUint64(uint64(size))
+	}
+	self.writeBytes([]byte(s))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/asm"
+	"lua-vm/binchunk"
+	"os"
+	"path/filepath"
+)
+
+/*
+把文本形式的汇编代码（可以是 luac -l -l 或 luac -l 的输出）汇编成二进制 chunk；
+luac -l 的输出中没有常量表和 Upvalue 表，常量从指令的注释中恢复，子函数的 Upvalue 需要手动补上
+用法：luaasm [-o luac.out] [-noverify] file.lasm
+*/
+func main() {
+	output := flag.String("o", "luac.out", "output to file")
+	noVerify := flag.Bool("noverify", false, "do not verify the assembled chunk")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: luaasm [-o luac.out] [-noverify] file.lasm")
+		fmt.Fprintln(os.Stderr, "file.lasm may be the output of luac -l -l, or of luac -l with .upvalue lines added to nested functions")
+		os.Exit(1)
+	}
+
+	if err := assembleFile(flag.Arg(0), *output, !*noVerify); err != nil {
+		fmt.Fprintf(os.Stderr, "luaasm: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func assembleFile(name, output string, verify bool) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	proto, err := asm.Assemble(file, filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	if verify {
+		if err := binchunk.Verify(proto); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(output, binchunk.Dump(proto), 0644)
+}
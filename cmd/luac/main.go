@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"lua-vm/compiler"
+	"lua-vm/compiler/lexer"
+	"os"
+	"strconv"
+)
+
+/*
+把 Lua 源代码编译成二进制 chunk，选项与 luac 一致；文件名为 - 时从标准输入读取。
+-e 在编译错误之后给出出错的源代码行，缺省的错误信息与 luac 一致；
+与 luac 一样，-l 给出指令列表，-l -l 还会给出常量表、局部变量表和 Upvalue 表
+用法：luac [-e] [-l [-l]] [-p] [-s] [-o luac.out] file.lua
+*/
+func main() {
+	var list counter
+	flag.Var(&list, "l", "list (use -l -l for full listing)")
+	parseOnly := flag.Bool("p", false, "parse only")
+	strip := flag.Bool("s", false, "strip debug information")
+	output := flag.String("o", "luac.out", "output to file")
+	excerpt := flag.Bool("e", false, "show source excerpts for compile errors")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: luac [-e] [-l [-l]] [-p] [-s] [-o luac.out] file.lua")
+		os.Exit(1)
+	}
+
+	proto, src, err := compileFile(flag.Arg(0))
+	if err != nil {
+		if e, ok := err.(*lexer.Error); ok && *excerpt {
+			fmt.Fprintf(os.Stderr, "luac: %s", e.Render(src))
+		} else {
+			fmt.Fprintf(os.Stderr, "luac: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if *strip {
+		stripDebug(proto)
+	}
+	if list > 0 {
+		binchunk.Disassemble(os.Stdout, proto, binchunk.DisasmOptions{Full: list > 1})
+	}
+	if !*parseOnly {
+		if err := ioutil.WriteFile(*output, binchunk.Dump(proto), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "luac: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+/*
+记录选项出现的次数，不需要参数，用于 -l -l；与布尔选项一样，-l=false 会清零
+*/
+type counter int
+
+func (self *counter) String() string {
+	return strconv.Itoa(int(*self))
+}
+
+func (self *counter) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if on {
+		*self++
+	} else {
+		*self = 0
+	}
+	return nil
+}
+
+func (self *counter) IsBoolFlag() bool {
+	return true
+}
+
+/*
+与 luac 一样，源文件的 Source 为 "@文件名"，标准输入为 "=stdin"；同时返回读到的源代码
+*/
+func compileFile(name string) (*binchunk.Prototype, string, error) {
+	var data []byte
+	var err error
+	source := "@" + name
+	if name == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+		source = "=stdin"
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	proto, err := compiler.Compile(string(data), source)
+	return proto, string(data), err
+}
+
+/*
+去掉所有函数的调试信息，相当于 luac -s
+*/
+func stripDebug(f *binchunk.Prototype) {
+	f.Source = ""
+	f.LineInfo = nil
+	f.LocVars = nil
+	f.UpvalueNames = nil
+	for _, p := range f.Protos {
+		stripDebug(p)
+	}
+}
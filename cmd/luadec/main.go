@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"lua-vm/decompiler"
+	"os"
+)
+
+/*
+把 luac 编译得到的二进制 chunk 反编译成 Lua 源代码并输出到标准输出
+用法：luadec file.luac...
+*/
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: luadec file.luac...")
+		os.Exit(1)
+	}
+
+	status := 0
+	for _, name := range os.Args[1:] {
+		if err := decompileFile(name); err != nil {
+			fmt.Fprintf(os.Stderr, "luadec: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func decompileFile(name string) (err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	proto := binchunk.Undump(data)
+	return decompiler.Decompile(os.Stdout, proto)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"lua-vm/diff"
+	"os"
+)
+
+/*
+比较两个二进制 chunk，以 unified diff 的格式输出字节码的差异；
+与 diff 命令一样，没有差异时退出码为 0，有差异时为 1，出错时为 2
+用法：luadiff old.luac new.luac
+*/
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: luadiff old.luac new.luac")
+		os.Exit(2)
+	}
+
+	result, err := diffFiles(os.Args[1], os.Args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "luadiff: %v\n", err)
+		os.Exit(2)
+	}
+	if err := result.WriteUnified(os.Stdout, os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "luadiff: %v\n", err)
+		os.Exit(2)
+	}
+	if !result.Equal() {
+		os.Exit(1)
+	}
+}
+
+func diffFiles(oldName, newName string) (*diff.Result, error) {
+	old, err := undumpFile(oldName)
+	if err != nil {
+		return nil, err
+	}
+	new, err := undumpFile(newName)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Compare(old, new), nil
+}
+
+func undumpFile(name string) (*binchunk.Prototype, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := binchunk.UndumpSigned(data, nil, binchunk.DefaultLimits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return proto, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/compiler/format"
+	"lua-vm/diff"
+	"os"
+)
+
+/*
+格式化 Lua 源代码，选项与 gofmt 一致：缺省把结果写到标准输出，-w 写回源文件，
+-d 输出格式化前后的 unified diff，-l 列出格式与要求不一致的文件；没有给出文件时从标准输入读取
+用法：luafmt [-d] [-l] [-w] [file.lua ...]
+*/
+func main() {
+	write := flag.Bool("w", false, "write result to source file instead of stdout")
+	showDiff := flag.Bool("d", false, "display diffs instead of rewriting files")
+	list := flag.Bool("l", false, "list files whose formatting differs")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "luafmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := processFile("-", *write, *showDiff, *list); err != nil {
+			fmt.Fprintf(os.Stderr, "luafmt: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	status := 0
+	for _, name := range flag.Args() {
+		if err := processFile(name, *write, *showDiff, *list); err != nil {
+			fmt.Fprintf(os.Stderr, "luafmt: %v\n", err)
+			status = 2
+		}
+	}
+	os.Exit(status)
+}
+
+/*
+与 luac 一样，源文件的 Source 为 "@文件名"，标准输入为 "=stdin"
+*/
+func processFile(name string, write, showDiff, list bool) error {
+	var src []byte
+	var err error
+	source := "@" + name
+	if name == "-" {
+		src, err = ioutil.ReadAll(os.Stdin)
+		source = "=stdin"
+	} else {
+		src, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := format.Source(string(src), source)
+	if err != nil {
+		return err
+	}
+	changed := !bytes.Equal(src, []byte(res))
+
+	if list && changed {
+		fmt.Println(name)
+	}
+	if write && changed {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(name, []byte(res), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if showDiff {
+		return diff.WriteText(os.Stdout, name+".orig", name, string(src), res)
+	}
+	if !list && !write {
+		_, err = os.Stdout.WriteString(res)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"lua-vm/analysis"
+	"lua-vm/binchunk"
+	"os"
+)
+
+/*
+列出二进制 chunk 中对全局变量的所有读写，无法确定的访问以 "?" 标出并说明原因
+用法：luaglobals file.luac...
+输出的每一行为：源文件:行号: read|write 变量名 in 所在函数
+*/
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: luaglobals file.luac...")
+		os.Exit(1)
+	}
+
+	status := 0
+	for _, name := range os.Args[1:] {
+		if err := listGlobals(name); err != nil {
+			fmt.Fprintf(os.Stderr, "luaglobals: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func listGlobals(name string) (err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	proto := binchunk.Undump(data)
+	for _, access := range analysis.FindGlobals(proto) {
+		fmt.Println(formatAccess(access))
+	}
+	return nil
+}
+
+func formatAccess(access analysis.GlobalAccess) string {
+	f := access.Func
+	line := "-"
+	if access.Line > 0 {
+		line = fmt.Sprintf("%d", access.Line)
+	}
+	kind := "read"
+	if access.Write {
+		kind = "write"
+	}
+	name, reason := access.Name, ""
+	if access.Unresolved != "" {
+		name += "?"
+		reason = fmt.Sprintf(" (%s)", access.Unresolved)
+	}
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+	source := binchunk.SourceName(f.Source)
+	return fmt.Sprintf("%s:%s: %s %s in %s <%s:%d,%d>%s",
+		source, line, kind, name, funcType, source, f.LineDefined, f.LastLineDefined, reason)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"lua-vm/lint"
+	"os"
+)
+
+/*
+检查二进制 chunk 并以 "文件:行号: 信息" 的格式输出警告，有警告或者出错时退出码为 1，可以直接用在 pre-commit 钩子中
+用法：lualint [-maxregs 200] [-maxupvals 60] file.luac...
+*/
+func main() {
+	var opts lint.Options
+	flag.IntVar(&opts.MaxRegisters, "maxregs", 200, "warn about functions using more registers")
+	flag.IntVar(&opts.MaxUpvalues, "maxupvals", 60, "warn about functions using more upvalues")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: lualint [-maxregs 200] [-maxupvals 60] file.luac...")
+		os.Exit(1)
+	}
+
+	status := 0
+	for _, name := range flag.Args() {
+		warnings, err := lintFile(name, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lualint: %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		for _, w := range warnings {
+			fmt.Println(w)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func lintFile(name string, opts lint.Options) (warnings []lint.Warning, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	proto := binchunk.Undump(data)
+	if err := binchunk.Verify(proto); err != nil {
+		return nil, err
+	}
+	return lint.Lint(proto, opts), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"lua-vm/optimizer"
+	"os"
+)
+
+/*
+对 luac 编译得到的二进制 chunk 进行窥孔优化和死代码消除，输出优化之后的 chunk
+用法：luaopt [-o luac.out] file.luac
+*/
+func main() {
+	output := flag.String("o", "luac.out", "output to file")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: luaopt [-o luac.out] file.luac")
+		os.Exit(1)
+	}
+
+	if err := optimizeFile(flag.Arg(0), *output); err != nil {
+		fmt.Fprintf(os.Stderr, "luaopt: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func optimizeFile(name, output string) (err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	proto, err := optimizer.Optimize(binchunk.Undump(data))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output, binchunk.Dump(proto), 0644)
+}
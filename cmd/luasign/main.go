@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"lua-vm/binchunk"
+	"os"
+)
+
+/*
+给二进制 chunk 签名或者验证签名，密钥文件保存原始字节：
+HMAC 密钥文件的内容就是密钥本身，Ed25519 的私钥为 64 字节，公钥为 32 字节
+用法：
+
+	luasign -genkey name                              生成 name.key 和 name.pub 两个 Ed25519 密钥文件
+	luasign -key name.key [-hmac] [-o out] file.luac  签名
+	luasign -verify -key name.pub [-hmac] file        验证签名并检查 chunk 能否被解析
+*/
+func main() {
+	genKey := flag.String("genkey", "", "generate an Ed25519 key pair with this name")
+	keyFile := flag.String("key", "", "key file")
+	useHMAC := flag.Bool("hmac", false, "use HMAC-SHA256 instead of Ed25519")
+	verify := flag.Bool("verify", false, "verify instead of sign")
+	output := flag.String("o", "luac.signed", "output to file")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *genKey != "":
+		err = generateKey(*genKey)
+	case *keyFile == "" || flag.NArg() != 1:
+		fmt.Fprintln(os.Stderr, "usage: luasign -genkey name | luasign [-verify] -key file [-hmac] [-o out] file")
+		os.Exit(1)
+	case *verify:
+		err = verifyFile(flag.Arg(0), *keyFile, *useHMAC)
+	default:
+		err = signFile(flag.Arg(0), *output, *keyFile, *useHMAC)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "luasign: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generateKey(name string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".key", priv, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+".pub", pub, 0644)
+}
+
+func signFile(name, output, keyFile string, useHMAC bool) error {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	var signer binchunk.Signer
+	if useHMAC {
+		signer, err = binchunk.NewHMACKey(key)
+	} else {
+		signer, err = binchunk.NewEd25519Signer(key)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", keyFile, err)
+	}
+
+	chunk, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	data, err := binchunk.SignChunk(chunk, signer)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return ioutil.WriteFile(output, data, 0644)
+}
+
+func verifyFile(name, keyFile string, useHMAC bool) error {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	var verifier binchunk.Verifier
+	if useHMAC {
+		if verifier, err = binchunk.NewHMACKey(key); err != nil {
+			return fmt.Errorf("%s: %v", keyFile, err)
+		}
+	} else {
+		if len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("%s: not an Ed25519 public key", keyFile)
+		}
+		verifier = binchunk.Ed25519Verifier(key)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := binchunk.UndumpSigned(data, verifier, binchunk.DefaultLimits); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	fmt.Printf("%s: OK\n", name)
+	return nil
+}
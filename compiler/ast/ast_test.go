@@ -0,0 +1,159 @@
+package ast_test
+
+import (
+	"fmt"
+	. "lua-vm/compiler/ast"
+	"lua-vm/compiler/format"
+	. "lua-vm/compiler/lexer"
+	"lua-vm/compiler/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, src string) *File {
+	t.Helper()
+	file, err := parser.ParseFile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return file
+}
+
+/*
+按照 Walk 访问的顺序返回所有名字表达式的名字
+*/
+func names(node Node) string {
+	var list []string
+	Inspect(node, func(n Node) bool {
+		if name, ok := n.(*NameExp); ok {
+			list = append(list, name.Name)
+		}
+		return true
+	})
+	return strings.Join(list, " ")
+}
+
+func TestInspectOrder(t *testing.T) {
+	file := parse(t, "local a = b + c(d, {e = f})\nfor i = g, h do j[k] = l end\nrepeat m() until n\n")
+	if got := names(file.Block); got != "b c d f g h j k l m n" {
+		t.Errorf("got names %q", got)
+	}
+
+	// 每个节点访问完子节点之后都会调用一次 f(nil)
+	depth, max := 0, 0
+	Inspect(file.Block, func(n Node) bool {
+		if n == nil {
+			depth--
+		} else if depth++; depth > max {
+			max = depth
+		}
+		return true
+	})
+	if depth != 0 || max < 4 {
+		t.Errorf("unbalanced Inspect: depth %d, max %d", depth, max)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	file := parse(t, "local x = 1 + a * 2\nprint(x, 1)\n")
+	root := Rewrite(file.Block, func(n Node) Node {
+		switch exp := n.(type) {
+		case *IntegerExp:
+			if exp.Val == 1 {
+				return &IntegerExp{Val: 10}
+			}
+		case *NameExp:
+			if exp.Name == "a" {
+				// 替换成加法之后需要加上括号才能保持优先级
+				return &BinopExp{Op: TOKEN_OP_ADD, Exp1: &NameExp{Name: "b"}, Exp2: &NameExp{Name: "c"}}
+			}
+		}
+		return n
+	})
+	var b strings.Builder
+	if err := format.Fprint(&b, root); err != nil {
+		t.Fatalf("Fprint: %v", err)
+	}
+	if want := "local x = 10 + (b + c) * 2\nprint(x, 10)\n"; b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestParentMap(t *testing.T) {
+	file := parse(t, "if x then\n  y = z.w\nend\n")
+	var w *StringExp
+	Inspect(file.Block, func(n Node) bool {
+		if s, ok := n.(*StringExp); ok {
+			w = s
+		}
+		return true
+	})
+	parents := NewParentMap(file.Block)
+	var kinds []string
+	for _, p := range parents.Ancestors(w) {
+		kinds = append(kinds, strings.TrimPrefix(fmt.Sprintf("%T", p), "*ast."))
+	}
+	if got := strings.Join(kinds, " "); got != "TableAccessExp AssignStat Block IfClause IfStat Block" {
+		t.Errorf("ancestors of w: %s", got)
+	}
+	if parents.Parent(file.Block) != nil {
+		t.Errorf("root has a parent")
+	}
+}
+
+func TestNodesAt(t *testing.T) {
+	file := parse(t, "local t = {\n  f(a + b)\n}\n")
+	path := file.NodesAt(Pos{Line: 2, Column: 9})
+	last, ok := path[len(path)-1].(*NameExp)
+	if !ok || last.Name != "b" {
+		t.Fatalf("innermost node at 2:9 is %v", path[len(path)-1])
+	}
+	if _, ok := path[0].(*Block); !ok {
+		t.Errorf("outermost node at 2:9 is %T", path[0])
+	}
+}
+
+func TestResolve(t *testing.T) {
+	src := `local a = 1
+local function f(p)
+  local function g() return a, p, f end
+  return g, q
+end
+repeat local r = a until r
+local _ENV = {}
+s = a
+`
+	file := parse(t, src)
+	scopes := Resolve(file.Block)
+	kinds := map[string][]string{}
+	Inspect(file.Block, func(n Node) bool {
+		if name, ok := n.(*NameExp); ok {
+			kinds[name.Name] = append(kinds[name.Name], scopes.Bindings[name].Kind.String())
+		}
+		return true
+	})
+	want := map[string]string{
+		"a":    "upvalue local local",
+		"p":    "upvalue",
+		"f":    "upvalue",
+		"g":    "local",
+		"q":    "global",
+		"r":    "local",
+		"s":    "global",
+		"_ENV": "",
+	}
+	for name, w := range want {
+		if got := strings.Join(kinds[name], " "); got != w {
+			t.Errorf("%s: got %q, want %q", name, got, w)
+		}
+	}
+	// 局部变量 _ENV 之后的全局变量不属于 chunk 的 _ENV
+	if len(scopes.Globals["q"]) != 1 || len(scopes.Globals["s"]) != 0 {
+		t.Errorf("globals: %v", scopes.Globals)
+	}
+	for _, v := range scopes.Locals {
+		if v.Name == "a" && len(v.Refs) != 3 {
+			t.Errorf("a has %d references, want 3", len(v.Refs))
+		}
+	}
+}
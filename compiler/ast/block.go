@@ -0,0 +1,11 @@
+package ast
+
+/*
+语法树中的代码块，也是整个 chunk 的根节点。
+return 语句如果存在，一定是 Stats 中的最后一条语句；
+LastLine 为紧跟在代码块之后的词法单元（end、else、until 或文件结束）所在的行
+*/
+type Block struct {
+	Stats    []Stat
+	LastLine int
+}
@@ -0,0 +1,132 @@
+package ast
+
+/*
+表达式；Line 为表达式第一个词法单元所在的行（运算符表达式为运算符所在的行），
+LastLine 为最后一个词法单元所在的行
+*/
+type Exp interface{}
+
+type NilExp struct {
+	Line int
+}
+
+type TrueExp struct {
+	Line int
+}
+
+type FalseExp struct {
+	Line int
+}
+
+type VarargExp struct {
+	Line int
+}
+
+/*
+数字和字符串字面量，Text 为字面量在源代码中的原文（如 0xFF、'abc'、[[abc]]）；
+由名字转换得到的 StringExp（如 a.b 中的 b 和 {b = 1} 中的 b）的 Text 为空
+*/
+type IntegerExp struct {
+	Line int
+	Val  int64
+	Text string
+}
+
+type FloatExp struct {
+	Line int
+	Val  float64
+	Text string
+}
+
+type StringExp struct {
+	Line int
+	Str  string
+	Text string
+}
+
+/*
+局部变量、Upvalue 或全局变量的名字
+*/
+type NameExp struct {
+	Line int
+	Name string
+}
+
+/*
+一元运算，Op 为 lexer 中的 TOKEN_OP_UNM、TOKEN_OP_NOT、TOKEN_OP_LEN 或 TOKEN_OP_BNOT
+*/
+type UnopExp struct {
+	Line int
+	Op   int
+	Exp  Exp
+}
+
+/*
+二元运算，Op 为 lexer 中对应的 TOKEN_OP_*，包括 and、or 和 ..；
+.. 和 ^ 是右结合的，a .. b .. c 表示为 a .. (b .. c)
+*/
+type BinopExp struct {
+	Line int
+	Op   int
+	Exp1 Exp
+	Exp2 Exp
+}
+
+/*
+表构造器，Line 和 LastLine 分别为 { 和 } 所在的行
+*/
+type TableConstructorExp struct {
+	Line     int
+	LastLine int
+	Fields   []*TableField
+}
+
+/*
+表构造器中的一项，Key 为 nil 时表示数组部分；Name = exp 形式的 Key 为 StringExp
+*/
+type TableField struct {
+	Key   Exp
+	Value Exp
+}
+
+/*
+函数构造器，Line 为函数开始的行，与 luac 的 linedefined 一致：function a.b() 形式的定义为 function 所在的行，
+其余为 ( 所在的行；LastLine 为 end 所在的行
+*/
+type FuncDefExp struct {
+	Line     int
+	LastLine int
+	ParList  []string
+	IsVararg bool
+	Block    *Block
+}
+
+/*
+带括号的表达式，括号会把多个返回值截断成一个；Line 和 LastLine 分别为 ( 和 ) 所在的行
+*/
+type ParensExp struct {
+	Line     int
+	LastLine int
+	Exp      Exp
+}
+
+/*
+prefixexp[exp] 或 prefixexp.Name，后者的 KeyExp 为 StringExp；LastLine 为 ] 或 Name 所在的行
+*/
+type TableAccessExp struct {
+	LastLine  int
+	PrefixExp Exp
+	KeyExp    Exp
+}
+
+/*
+函数调用 prefixexp args 或方法调用 prefixexp:Name args，NameExp 不为 nil 时为方法调用。
+Line 为 prefixexp 第一个词法单元所在的行，LastLine 为参数列表最后一个词法单元所在的行
+*/
+type FuncCallExp struct {
+	Line      int
+	LastLine  int
+	PrefixExp Exp
+	NameExp   *StringExp
+	Args      []Exp
+}
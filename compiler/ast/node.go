@@ -0,0 +1,82 @@
+package ast
+
+import "fmt"
+
+/*
+语法树的节点：*Block、Stat、Exp、*TableField 或 *IfClause
+*/
+type Node interface{}
+
+/*
+源代码中的位置，行号和列号都从 1 开始，列号以字节为单位
+*/
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (self Pos) String() string {
+	return fmt.Sprintf("%d:%d", self.Line, self.Column)
+}
+
+/*
+判断 self 是否在 other 之前
+*/
+func (self Pos) Before(other Pos) bool {
+	return self.Line < other.Line || self.Line == other.Line && self.Column < other.Column
+}
+
+/*
+节点在源代码中的范围，Start 为第一个词法单元开始的位置，End 为最后一个词法单元结束之后的位置；
+空的代码块 Start 和 End 相同，为代码块之后的词法单元开始的位置
+*/
+type Range struct {
+	Start Pos
+	End   Pos
+}
+
+func (self Range) String() string {
+	return fmt.Sprintf("%v-%v", self.Start, self.End)
+}
+
+/*
+判断位置 pos 是否在范围之内
+*/
+func (self Range) Contains(pos Pos) bool {
+	return !pos.Before(self.Start) && pos.Before(self.End)
+}
+
+/*
+带有位置信息的语法树，由 parser.ParseFile 生成。
+Ranges 记录了解析得到的每个节点的范围，之后创建的节点没有范围
+*/
+type File struct {
+	Block  *Block
+	Ranges map[Node]Range
+}
+
+/*
+返回节点的范围，ok 为 false 表示节点不是解析得到的
+*/
+func (self *File) Range(node Node) (r Range, ok bool) {
+	r, ok = self.Ranges[node]
+	return
+}
+
+/*
+返回包含位置 pos 的所有节点，从最外层的代码块开始，到最内层的节点为止
+*/
+func (self *File) NodesAt(pos Pos) []Node {
+	var path []Node
+	Inspect(self.Block, func(node Node) bool {
+		if node == nil {
+			return false
+		}
+		if r, ok := self.Ranges[node]; ok && r.Contains(pos) {
+			path = append(path, node)
+			return true
+		}
+		return false
+	})
+	return path
+}
@@ -0,0 +1,242 @@
+package ast
+
+/*
+名字表达式引用的变量的种类
+*/
+type VarKind int
+
+const (
+	// 全局变量，即 _ENV.Name
+	GlobalVar VarKind = iota
+	// 当前函数中的局部变量
+	LocalVar
+	// 外层函数中的局部变量
+	UpvalueVar
+)
+
+func (self VarKind) String() string {
+	switch self {
+	case LocalVar:
+		return "local"
+	case UpvalueVar:
+		return "upvalue"
+	default:
+		return "global"
+	}
+}
+
+/*
+局部变量的声明。Decl 为声明它的节点：*LocalVarDeclStat、*LocalFuncDefStat、*ForNumStat、*ForInStat
+或 *FuncDefExp（参数）；Index 为变量在 NameList 或 ParList 中的位置，方法隐含的 self 参数为 -1。
+Func 为声明变量的函数，主函数中的变量为 nil；Refs 为引用这个变量的所有名字表达式
+*/
+type Variable struct {
+	Name  string
+	Decl  Node
+	Index int
+	Func  *FuncDefExp
+	Refs  []*NameExp
+}
+
+/*
+名字表达式的解析结果。Kind 为 GlobalVar 时 Var 为 nil，
+Env 为此处可见的名为 _ENV 的局部变量，为 nil 时表示 chunk 的 _ENV，即真正的全局变量
+*/
+type Binding struct {
+	Kind VarKind
+	Var  *Variable
+	Env  *Variable
+}
+
+/*
+作用域分析的结果
+*/
+type Scopes struct {
+	// 每个名字表达式（包括赋值的目标和 function a.b() 中的 a）引用的变量
+	Bindings map[*NameExp]Binding
+	// 按照声明的顺序排列的所有局部变量
+	Locals []*Variable
+	// 引用 chunk 的 _ENV 中的全局变量的名字表达式，按照在源代码中出现的顺序排列
+	Globals map[string][]*NameExp
+}
+
+/*
+区分 chunk 中每个名字引用的是局部变量、Upvalue 还是全局变量，作用域规则与编译器一致：
+局部变量在声明它的语句之后才开始有效，local function 的名字在函数体中有效，
+repeat 语句的条件表达式可以访问循环体中的局部变量
+*/
+func Resolve(chunk *Block) *Scopes {
+	r := &resolver{scopes: &Scopes{
+		Bindings: map[*NameExp]Binding{},
+		Globals:  map[string][]*NameExp{},
+	}}
+	r.block(chunk)
+	return r.scopes
+}
+
+type resolver struct {
+	scopes *Scopes
+	// 当前可见的局部变量，后声明的在后面
+	active []*Variable
+	fn     *FuncDefExp
+}
+
+func (self *resolver) declare(name string, decl Node, index int) {
+	v := &Variable{Name: name, Decl: decl, Index: index, Func: self.fn}
+	self.scopes.Locals = append(self.scopes.Locals, v)
+	self.active = append(self.active, v)
+}
+
+func (self *resolver) lookup(name string) *Variable {
+	for i := len(self.active) - 1; i >= 0; i-- {
+		if self.active[i].Name == name {
+			return self.active[i]
+		}
+	}
+	return nil
+}
+
+/*
+在新的作用域中处理代码块
+*/
+func (self *resolver) block(block *Block) {
+	level := len(self.active)
+	self.stats(block.Stats)
+	self.active = self.active[:level]
+}
+
+func (self *resolver) stats(stats []Stat) {
+	for _, stat := range stats {
+		self.stat(stat)
+	}
+}
+
+func (self *resolver) stat(node Stat) {
+	switch stat := node.(type) {
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+	case *DoStat:
+		self.block(stat.Block)
+	case *WhileStat:
+		self.exp(stat.Cond)
+		self.block(stat.Block)
+	case *RepeatStat:
+		level := len(self.active)
+		self.stats(stat.Block.Stats)
+		self.exp(stat.Cond)
+		self.active = self.active[:level]
+	case *IfStat:
+		for _, clause := range stat.Clauses {
+			self.exp(clause.Cond)
+			self.block(clause.Block)
+		}
+		if stat.Else != nil {
+			self.block(stat.Else)
+		}
+	case *ForNumStat:
+		self.exp(stat.Init)
+		self.exp(stat.Limit)
+		if stat.Step != nil {
+			self.exp(stat.Step)
+		}
+		level := len(self.active)
+		self.declare(stat.VarName, stat, 0)
+		self.block(stat.Block)
+		self.active = self.active[:level]
+	case *ForInStat:
+		self.expList(stat.ExpList)
+		level := len(self.active)
+		for i, name := range stat.NameList {
+			self.declare(name, stat, i)
+		}
+		self.block(stat.Block)
+		self.active = self.active[:level]
+	case *LocalVarDeclStat:
+		self.expList(stat.ExpList)
+		for i, name := range stat.NameList {
+			self.declare(name, stat, i)
+		}
+	case *AssignStat:
+		self.expList(stat.VarList)
+		self.expList(stat.ExpList)
+	case *LocalFuncDefStat:
+		self.declare(stat.Name, stat, 0)
+		self.funcBody(stat.Func, false)
+	case *FuncDefStat:
+		self.exp(stat.Name)
+		self.funcBody(stat.Func, stat.IsMethod)
+	case *ReturnStat:
+		self.expList(stat.ExpList)
+	case *FuncCallStat:
+		self.exp(stat)
+	default:
+		panic("unreachable")
+	}
+}
+
+func (self *resolver) funcBody(exp *FuncDefExp, isMethod bool) {
+	fn, level := self.fn, len(self.active)
+	self.fn = exp
+	if isMethod {
+		self.declare("self", exp, -1)
+	}
+	for i, name := range exp.ParList {
+		self.declare(name, exp, i)
+	}
+	self.block(exp.Block)
+	self.fn, self.active = fn, self.active[:level]
+}
+
+func (self *resolver) expList(exps []Exp) {
+	for _, exp := range exps {
+		self.exp(exp)
+	}
+}
+
+func (self *resolver) exp(node Exp) {
+	switch exp := node.(type) {
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp, *IntegerExp, *FloatExp, *StringExp:
+	case *NameExp:
+		self.name(exp)
+	case *UnopExp:
+		self.exp(exp.Exp)
+	case *BinopExp:
+		self.exp(exp.Exp1)
+		self.exp(exp.Exp2)
+	case *TableConstructorExp:
+		for _, field := range exp.Fields {
+			if field.Key != nil {
+				self.exp(field.Key)
+			}
+			self.exp(field.Value)
+		}
+	case *FuncDefExp:
+		self.funcBody(exp, false)
+	case *ParensExp:
+		self.exp(exp.Exp)
+	case *TableAccessExp:
+		self.exp(exp.PrefixExp)
+		self.exp(exp.KeyExp)
+	case *FuncCallExp:
+		self.exp(exp.PrefixExp)
+		self.expList(exp.Args)
+	default:
+		panic("unreachable")
+	}
+}
+
+func (self *resolver) name(exp *NameExp) {
+	var b Binding
+	if v := self.lookup(exp.Name); v != nil {
+		b.Kind, b.Var = LocalVar, v
+		if v.Func != self.fn {
+			b.Kind = UpvalueVar
+		}
+		v.Refs = append(v.Refs, exp)
+	} else {
+		b.Env = self.lookup("_ENV")
+		if b.Env == nil {
+			self.scopes.Globals[exp.Name] = append(self.scopes.Globals[exp.Name], exp)
+		}
+	}
+	self.scopes.Bindings[exp] = b
+}
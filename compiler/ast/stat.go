@@ -0,0 +1,172 @@
+package ast
+
+/*
+语句；行号与 luac 一致，取词法单元结束时所在的行。
+Line 为语句第一个词法单元所在的行，LastLine 为最后一个词法单元所在的行，
+其余的 XxxLine 为语句中对应关键字所在的行
+*/
+type Stat interface{}
+
+/*
+;
+*/
+type EmptyStat struct {
+	Line int
+}
+
+/*
+break
+*/
+type BreakStat struct {
+	Line int
+}
+
+/*
+::Name::
+*/
+type LabelStat struct {
+	Line int
+	Name string
+}
+
+/*
+goto Name
+*/
+type GotoStat struct {
+	Line int
+	Name string
+}
+
+/*
+do block end
+*/
+type DoStat struct {
+	Line     int
+	LastLine int
+	Block    *Block
+}
+
+/*
+functioncall，作为语句时丢弃所有返回值
+*/
+type FuncCallStat = FuncCallExp
+
+/*
+while exp do block end
+*/
+type WhileStat struct {
+	Line     int
+	DoLine   int
+	LastLine int
+	Cond     Exp
+	Block    *Block
+}
+
+/*
+repeat block until exp
+*/
+type RepeatStat struct {
+	Line      int
+	UntilLine int
+	Block     *Block
+	Cond      Exp
+}
+
+/*
+if exp then block {elseif exp then block} [else block] end，
+Else 为 nil 表示没有 else 部分
+*/
+type IfStat struct {
+	Line     int
+	ElseLine int
+	LastLine int
+	Clauses  []*IfClause
+	Else     *Block
+}
+
+/*
+if 语句中的 if 或 elseif 部分，Line 为 if 或 elseif 所在的行
+*/
+type IfClause struct {
+	Line     int
+	ThenLine int
+	Cond     Exp
+	Block    *Block
+}
+
+/*
+for Name = exp, exp [, exp] do block end，Step 为 nil 表示省略了步长
+*/
+type ForNumStat struct {
+	Line     int
+	DoLine   int
+	LastLine int
+	VarName  string
+	Init     Exp
+	Limit    Exp
+	Step     Exp
+	Block    *Block
+}
+
+/*
+for namelist in explist do block end
+*/
+type ForInStat struct {
+	Line     int
+	DoLine   int
+	LastLine int
+	NameList []string
+	ExpList  []Exp
+	Block    *Block
+}
+
+/*
+local attnamelist [= explist]，attnamelist ::= Name attrib {',' Name attrib}。
+AttribList 与 NameList 一一对应，为 Lua 5.4 的属性 const 或 close，没有属性的变量为空字符串
+*/
+type LocalVarDeclStat struct {
+	Line       int
+	LastLine   int
+	NameList   []string
+	AttribList []string
+	ExpList    []Exp
+}
+
+/*
+varlist = explist，VarList 中的元素为 NameExp 或 TableAccessExp
+*/
+type AssignStat struct {
+	Line     int
+	LastLine int
+	VarList  []Exp
+	ExpList  []Exp
+}
+
+/*
+local function Name funcbody
+*/
+type LocalFuncDefStat struct {
+	Line int
+	Name string
+	Func *FuncDefExp
+}
+
+/*
+function funcname funcbody。Name 为 NameExp 或者由 TableAccessExp 组成的 a.b.c 形式的链，
+IsMethod 为 true 时表示 a.b:c 形式的定义，函数有一个隐含的第一个参数 self，它不出现在 Func.ParList 中
+*/
+type FuncDefStat struct {
+	Line     int
+	Name     Exp
+	IsMethod bool
+	Func     *FuncDefExp
+}
+
+/*
+return [explist]，只能作为代码块的最后一条语句
+*/
+type ReturnStat struct {
+	Line     int
+	LastLine int
+	ExpList  []Exp
+}
@@ -0,0 +1,274 @@
+package ast
+
+/*
+遍历语法树的访问者，与 go/ast 的 Visitor 一致：
+Walk 对每个节点调用 Visit，返回的 w 不为 nil 时用 w 访问该节点的子节点，最后调用 w.Visit(nil)
+*/
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+/*
+按照在源代码中出现的顺序深度优先地遍历 node 及其所有子节点。
+名字形式的声明（如局部变量名和参数）是字符串而不是节点，不会被访问
+*/
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *Block:
+		for _, stat := range n.Stats {
+			Walk(v, stat)
+		}
+
+	/* 语句 */
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+	case *DoStat:
+		Walk(v, n.Block)
+	case *WhileStat:
+		Walk(v, n.Cond)
+		Walk(v, n.Block)
+	case *RepeatStat:
+		Walk(v, n.Block)
+		Walk(v, n.Cond)
+	case *IfStat:
+		for _, clause := range n.Clauses {
+			Walk(v, clause)
+		}
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *IfClause:
+		Walk(v, n.Cond)
+		Walk(v, n.Block)
+	case *ForNumStat:
+		Walk(v, n.Init)
+		Walk(v, n.Limit)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		Walk(v, n.Block)
+	case *ForInStat:
+		walkList(v, n.ExpList)
+		Walk(v, n.Block)
+	case *LocalVarDeclStat:
+		walkList(v, n.ExpList)
+	case *AssignStat:
+		walkList(v, n.VarList)
+		walkList(v, n.ExpList)
+	case *LocalFuncDefStat:
+		Walk(v, n.Func)
+	case *FuncDefStat:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+	case *ReturnStat:
+		walkList(v, n.ExpList)
+
+	/* 表达式 */
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp, *IntegerExp, *FloatExp, *StringExp, *NameExp:
+	case *UnopExp:
+		Walk(v, n.Exp)
+	case *BinopExp:
+		Walk(v, n.Exp1)
+		Walk(v, n.Exp2)
+	case *TableConstructorExp:
+		for _, field := range n.Fields {
+			Walk(v, field)
+		}
+	case *TableField:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		Walk(v, n.Value)
+	case *FuncDefExp:
+		Walk(v, n.Block)
+	case *ParensExp:
+		Walk(v, n.Exp)
+	case *TableAccessExp:
+		Walk(v, n.PrefixExp)
+		Walk(v, n.KeyExp)
+	case *FuncCallExp:
+		Walk(v, n.PrefixExp)
+		if n.NameExp != nil {
+			Walk(v, n.NameExp)
+		}
+		walkList(v, n.Args)
+	default:
+		panic("unreachable")
+	}
+	v.Visit(nil)
+}
+
+func walkList(v Visitor, exps []Exp) {
+	for _, exp := range exps {
+		Walk(v, exp)
+	}
+}
+
+type inspector func(Node) bool
+
+func (self inspector) Visit(node Node) Visitor {
+	if self(node) {
+		return self
+	}
+	return nil
+}
+
+/*
+用函数遍历语法树：对每个节点调用 f(node)，返回 true 时继续访问它的子节点，之后调用 f(nil)
+*/
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+/*
+以后序遍历的方式改写语法树：先改写子节点，再用 f 的返回值替换节点本身，返回改写之后的根节点。
+f 不需要改写时返回原来的节点；*Block、*TableField 和 *IfClause 只能被替换成同一类型的节点，
+FuncCallExp 的 NameExp 和 FuncDefStat 的 Name 链中的 StringExp 必须仍然是 *StringExp
+*/
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Block:
+		for i, stat := range n.Stats {
+			n.Stats[i] = Rewrite(stat, f)
+		}
+
+	/* 语句 */
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+	case *DoStat:
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *WhileStat:
+		n.Cond = Rewrite(n.Cond, f)
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *RepeatStat:
+		n.Block = Rewrite(n.Block, f).(*Block)
+		n.Cond = Rewrite(n.Cond, f)
+	case *IfStat:
+		for i, clause := range n.Clauses {
+			n.Clauses[i] = Rewrite(clause, f).(*IfClause)
+		}
+		if n.Else != nil {
+			n.Else = Rewrite(n.Else, f).(*Block)
+		}
+	case *IfClause:
+		n.Cond = Rewrite(n.Cond, f)
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *ForNumStat:
+		n.Init = Rewrite(n.Init, f)
+		n.Limit = Rewrite(n.Limit, f)
+		if n.Step != nil {
+			n.Step = Rewrite(n.Step, f)
+		}
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *ForInStat:
+		rewriteList(n.ExpList, f)
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *LocalVarDeclStat:
+		rewriteList(n.ExpList, f)
+	case *AssignStat:
+		rewriteList(n.VarList, f)
+		rewriteList(n.ExpList, f)
+	case *LocalFuncDefStat:
+		n.Func = Rewrite(n.Func, f).(*FuncDefExp)
+	case *FuncDefStat:
+		n.Name = Rewrite(n.Name, f)
+		n.Func = Rewrite(n.Func, f).(*FuncDefExp)
+	case *ReturnStat:
+		rewriteList(n.ExpList, f)
+
+	/* 表达式 */
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp, *IntegerExp, *FloatExp, *StringExp, *NameExp:
+	case *UnopExp:
+		n.Exp = Rewrite(n.Exp, f)
+	case *BinopExp:
+		n.Exp1 = Rewrite(n.Exp1, f)
+		n.Exp2 = Rewrite(n.Exp2, f)
+	case *TableConstructorExp:
+		for i, field := range n.Fields {
+			n.Fields[i] = Rewrite(field, f).(*TableField)
+		}
+	case *TableField:
+		if n.Key != nil {
+			n.Key = Rewrite(n.Key, f)
+		}
+		n.Value = Rewrite(n.Value, f)
+	case *FuncDefExp:
+		n.Block = Rewrite(n.Block, f).(*Block)
+	case *ParensExp:
+		n.Exp = Rewrite(n.Exp, f)
+	case *TableAccessExp:
+		n.PrefixExp = Rewrite(n.PrefixExp, f)
+		n.KeyExp = Rewrite(n.KeyExp, f)
+	case *FuncCallExp:
+		n.PrefixExp = Rewrite(n.PrefixExp, f)
+		if n.NameExp != nil {
+			n.NameExp = Rewrite(n.NameExp, f).(*StringExp)
+		}
+		rewriteList(n.Args, f)
+	default:
+		panic("unreachable")
+	}
+	return f(node)
+}
+
+func rewriteList(exps []Exp, f func(Node) Node) {
+	for i, exp := range exps {
+		exps[i] = Rewrite(exp, f)
+	}
+}
+
+/*
+节点到其父节点的映射，根节点没有父节点
+*/
+type ParentMap map[Node]Node
+
+/*
+记录以 root 为根的语法树中每个节点的父节点；改写语法树之后需要重新生成
+*/
+func NewParentMap(root Node) ParentMap {
+	parents := ParentMap{}
+	var stack []Node
+	Inspect(root, func(node Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		if len(stack) > 0 {
+			parents[node] = stack[len(stack)-1]
+		}
+		stack = append(stack, node)
+		return true
+	})
+	return parents
+}
+
+/*
+返回节点的父节点，根节点和不在语法树中的节点返回 nil
+*/
+func (self ParentMap) Parent(node Node) Node {
+	return self[node]
+}
+
+/*
+返回节点的所有祖先，从父节点开始，到根节点为止
+*/
+func (self ParentMap) Ancestors(node Node) []Node {
+	var path []Node
+	for p := self[node]; p != nil; p = self[p] {
+		path = append(path, p)
+	}
+	return path
+}
+
+/*
+返回包含节点的最内层的函数构造器，节点在主函数中时返回 nil
+*/
+func (self ParentMap) EnclosingFunc(node Node) *FuncDefExp {
+	for p := self[node]; p != nil; p = self[p] {
+		if f, ok := p.(*FuncDefExp); ok {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package codegen
+
+import (
+	"fmt"
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+)
+
+/*
+Lua 5.4 的 close 变量。Lua 5.3 的字节码没有 TBC 指令，因此把
+
+	local x <close> = e
+	rest
+
+编译成与下面的代码等价的指令：
+
+	local x <const> = e
+	if x then
+	  local (close mt) = getmetatable(x)
+	  if not ((close mt) and (close mt).__close) then error("variable 'x' got a non-closable value") end
+	end
+	local (close status), (close tag), (close values) = pcall(function(...) rest end, ...)
+	if x then getmetatable(x).__close(x, not (close status) and (close tag) or nil) end
+	if not (close status) then error((close tag), 0) end
+	if (close tag) == 1 then return table.unpack((close values), 1, (close values).n) end
+	if (close tag) == 2 then break end
+	if (close tag) == 3 then goto label end
+
+rest 中的 return 返回 1 和用 table.pack 打包的返回值，跳出 rest 的 break 和 goto 返回各自的编号，
+在关闭变量之后由外层函数执行；出错时用错误对象调用 __close 之后重新抛出错误。
+标准库函数总是通过 _ENV 访问，不会被同名的局部变量遮蔽
+*/
+
+// rest 中 return 语句的编号，跳出 rest 的 break 和 goto 从 closeGoto 开始编号
+const (
+	closeReturn = 1
+	closeGoto   = 2
+)
+
+/*
+由 rest 编译成的函数的状态
+*/
+type closeScope struct {
+	returns bool
+	// 跳出 rest 的 break 和 goto，同名的只记录第一个
+	exits []labelDesc
+}
+
+/*
+返回跳出 rest 的 goto 的编号
+*/
+func (self *closeScope) exit(gt *labelDesc) int {
+	for i, e := range self.exits {
+		if e.name == gt.name {
+			return closeGoto + i
+		}
+	}
+	self.exits = append(self.exits, *gt)
+	return closeGoto + len(self.exits) - 1
+}
+
+/*
+声明 close 变量的 local 语句，rest 为代码块中剩余的语句；
+rest 末尾的标签留在外层函数中，使跳转到代码块末尾的 goto 仍然有效
+*/
+func (self *funcState) closeStat(stat *LocalVarDeclStat, rest []Stat) {
+	self.localVarDeclStat(stat)
+	line := stat.LastLine
+	var x Exp
+	for i, attrib := range stat.AttribList {
+		if attrib == "close" {
+			x = &NameExp{Line: line, Name: stat.NameList[i]}
+			msg := fmt.Sprintf("variable '%s' got a non-closable value", stat.NameList[i])
+			mt := &NameExp{Line: line, Name: "(close mt)"}
+			self.statement(ifThen(line, x,
+				&LocalVarDeclStat{Line: line, LastLine: line, NameList: []string{mt.Name}, AttribList: []string{""},
+					ExpList: []Exp{envCall(line, []string{"getmetatable"}, x)}},
+				ifThen(line, &UnopExp{Line: line, Op: TOKEN_OP_NOT, Exp: &ParensExp{Line: line, LastLine: line,
+					Exp: &BinopExp{Line: line, Op: TOKEN_OP_AND, Exp1: mt, Exp2: field(line, mt, "__close")}}},
+					envCall(line, []string{"error"}, &StringExp{Line: line, Str: msg}))))
+		}
+	}
+
+	n := len(rest)
+	for n > 0 && onlyNoOps(rest[n-1:]) {
+		n--
+	}
+	body := &FuncDefExp{Line: line, LastLine: line, IsVararg: self.f.IsVararg != 0,
+		Block: &Block{Stats: rest[:n], LastLine: line}}
+	scope := &closeScope{}
+	self.closeBodies[body] = scope
+	args := []Exp{body}
+	if body.IsVararg {
+		args = append(args, &VarargExp{Line: line})
+	}
+	status := &NameExp{Line: line, Name: "(close status)"}
+	tag := &NameExp{Line: line, Name: "(close tag)"}
+	values := &NameExp{Line: line, Name: "(close values)"}
+	self.statement(&LocalVarDeclStat{Line: line, LastLine: line,
+		NameList:   []string{status.Name, tag.Name, values.Name},
+		AttribList: []string{"", "", ""},
+		ExpList:    []Exp{envCall(line, []string{"pcall"}, args...)}})
+
+	errObj := &BinopExp{Line: line, Op: TOKEN_OP_OR,
+		Exp1: &BinopExp{Line: line, Op: TOKEN_OP_AND, Exp1: &UnopExp{Line: line, Op: TOKEN_OP_NOT, Exp: status}, Exp2: tag},
+		Exp2: &NilExp{Line: line}}
+	closer := field(line, envCall(line, []string{"getmetatable"}, x), "__close")
+	self.statement(ifThen(line, x, &FuncCallExp{Line: line, LastLine: line, PrefixExp: closer, Args: []Exp{x, errObj}}))
+	self.statement(ifThen(line, &UnopExp{Line: line, Op: TOKEN_OP_NOT, Exp: status},
+		envCall(line, []string{"error"}, tag, &IntegerExp{Line: line, Val: 0})))
+	if scope.returns {
+		unpack := envCall(line, []string{"table", "unpack"}, values, &IntegerExp{Line: line, Val: 1}, field(line, values, "n"))
+		self.statement(self.closeDispatch(line, tag, closeReturn,
+			&ReturnStat{Line: line, LastLine: line, ExpList: []Exp{unpack}}))
+	}
+	for i, gt := range scope.exits {
+		var jump Stat = &GotoStat{Line: gt.line, Name: gt.name}
+		if gt.name == "break" {
+			jump = &BreakStat{Line: gt.line}
+		}
+		self.statement(self.closeDispatch(line, tag, closeGoto+i, jump))
+	}
+	self.statList(rest[n:], false)
+}
+
+/*
+if (close tag) == n then stat end
+*/
+func (self *funcState) closeDispatch(line int, tag Exp, n int, stat Stat) *IfStat {
+	cond := &BinopExp{Line: line, Op: TOKEN_OP_EQ, Exp1: tag, Exp2: &IntegerExp{Line: line, Val: int64(n)}}
+	return ifThen(line, cond, stat)
+}
+
+/*
+rest 中的 return 语句，返回编号和打包之后的返回值
+*/
+func (self *funcState) closeReturnStat(stat *ReturnStat) *ReturnStat {
+	self.closing.returns = true
+	tag := &IntegerExp{Line: stat.Line, Val: closeReturn}
+	pack := envCall(stat.Line, []string{"table", "pack"}, stat.ExpList...)
+	return &ReturnStat{Line: stat.Line, LastLine: stat.LastLine, ExpList: []Exp{tag, pack}}
+}
+
+/*
+rest 结束时仍未找到标签的 goto 和 break 跳出了 rest，让它们返回各自的编号
+*/
+func (self *funcState) closeGotos(bl *blockCnt) {
+	for i := bl.firstGoto; i < len(self.gotos); i++ {
+		gt := &self.gotos[i]
+		self.patchToHere(gt.pc)
+		self.codeK(0, self.intK(int64(self.closing.exit(gt))))
+		self.ret(0, 1)
+	}
+	self.gotos = self.gotos[:bl.firstGoto]
+}
+
+/*
+判断语句是否是声明了 close 变量的 local 语句
+*/
+func isCloseStat(stat Stat) bool {
+	if decl, ok := stat.(*LocalVarDeclStat); ok {
+		for _, attrib := range decl.AttribList {
+			if attrib == "close" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ifThen(line int, cond Exp, stats ...Stat) *IfStat {
+	clause := &IfClause{Line: line, ThenLine: line, Cond: cond, Block: &Block{Stats: stats, LastLine: line}}
+	return &IfStat{Line: line, LastLine: line, Clauses: []*IfClause{clause}}
+}
+
+func field(line int, obj Exp, name string) Exp {
+	return &TableAccessExp{LastLine: line, PrefixExp: obj, KeyExp: &StringExp{Line: line, Str: name}}
+}
+
+/*
+调用 _ENV 中的标准库函数，path 为 _ENV 之后的各级字段
+*/
+func envCall(line int, path []string, args ...Exp) *FuncCallExp {
+	var fn Exp = &NameExp{Line: line, Name: "_ENV"}
+	for _, name := range path {
+		fn = field(line, fn, name)
+	}
+	return &FuncCallExp{Line: line, LastLine: line, PrefixExp: fn, Args: args}
+}
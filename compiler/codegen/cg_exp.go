@@ -0,0 +1,319 @@
+package codegen
+
+import (
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+	. "lua-vm/vm"
+)
+
+/*
+本文件中的函数与 lparser.c 中处理表达式的函数一一对应；
+语法树中没有记录的词法单元（如逗号和括号）所在的行用前一个词法单元所在的行代替
+*/
+
+/*
+生成表达式，返回它的描述
+*/
+func (self *funcState) exp(node Exp) expDesc {
+	switch exp := node.(type) {
+	case *NilExp:
+		self.setLine(exp.Line)
+		return newExp(expNil, 0)
+	case *TrueExp:
+		self.setLine(exp.Line)
+		return newExp(expTrue, 0)
+	case *FalseExp:
+		self.setLine(exp.Line)
+		return newExp(expFalse, 0)
+	case *IntegerExp:
+		self.setLine(exp.Line)
+		e := newExp(expKInt, 0)
+		e.ival = exp.Val
+		return e
+	case *FloatExp:
+		self.setLine(exp.Line)
+		e := newExp(expKFlt, 0)
+		e.nval = exp.Val
+		return e
+	case *StringExp:
+		self.setLine(exp.Line)
+		return self.stringExp(exp.Str)
+	case *VarargExp:
+		// VARARG 在读取 ... 之前生成
+		e := newExp(expVararg, self.codeABC(OP_VARARG, 0, 1, 0))
+		self.setLine(exp.Line)
+		return e
+	case *NameExp:
+		self.setLine(exp.Line)
+		return self.singleVar(exp.Name)
+	case *ParensExp:
+		self.setLine(exp.Line)
+		e := self.exp(exp.Exp)
+		self.setLine(exp.LastLine)
+		self.dischargeVars(&e)
+		return e
+	case *TableAccessExp:
+		return self.tableAccessExp(exp)
+	case *FuncCallExp:
+		return self.funcCallExp(exp)
+	case *UnopExp:
+		self.setLine(exp.Line)
+		e := self.exp(exp.Exp)
+		self.prefix(unops[exp.Op], &e, exp.Line)
+		return e
+	case *BinopExp:
+		if s, line, ok := concatLiterals(exp); ok {
+			self.setLine(line)
+			return self.stringExp(s)
+		}
+		op := binops[exp.Op]
+		e1 := self.exp(exp.Exp1)
+		self.setLine(exp.Line)
+		self.infix(op, &e1)
+		e2 := self.exp(exp.Exp2)
+		self.posfix(op, &e1, &e2, exp.Line)
+		return e1
+	case *TableConstructorExp:
+		return self.tableConstructorExp(exp)
+	case *FuncDefExp:
+		return self.funcBody(exp, false)
+	default:
+		panic("unreachable")
+	}
+}
+
+/*
+只由字符串字面量组成的连接运算在编译期求值，返回连接的结果和最后一个字面量所在的行；
+.. 是右结合的，所以 x .. "a" .. "b" 中的 "a" .. "b" 也会被折叠
+*/
+func concatLiterals(node Exp) (string, int, bool) {
+	switch exp := node.(type) {
+	case *StringExp:
+		return exp.Str, exp.Line, true
+	case *BinopExp:
+		if exp.Op != TOKEN_OP_CONCAT {
+			break
+		}
+		if s1, _, ok := concatLiterals(exp.Exp1); ok {
+			if s2, line, ok := concatLiterals(exp.Exp2); ok {
+				return s1 + s2, line, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+/*
+explist ::= exp {',' exp}，除最后一个表达式之外都放入下一个寄存器，返回表达式的个数和最后一个表达式
+*/
+func (self *funcState) expList(exps []Exp) (int, expDesc) {
+	e := self.exp(exps[0])
+	for _, exp := range exps[1:] {
+		self.exp2NextReg(&e)
+		e = self.exp(exp)
+	}
+	return len(exps), e
+}
+
+/*
+prefixexp.Name 或 prefixexp[exp]，与 fieldsel 和 yindex 一致
+*/
+func (self *funcState) tableAccessExp(exp *TableAccessExp) expDesc {
+	e := self.exp(exp.PrefixExp)
+	self.exp2AnyRegUp(&e)
+	key := self.exp(exp.KeyExp)
+	self.exp2Val(&key)
+	self.setLine(exp.LastLine)
+	self.indexed(&e, &key)
+	return e
+}
+
+/*
+函数调用，与 suffixedexp 和 funcargs 一致
+*/
+func (self *funcState) funcCallExp(exp *FuncCallExp) expDesc {
+	e := self.exp(exp.PrefixExp)
+	if exp.NameExp != nil {
+		self.setLine(exp.NameExp.Line)
+		key := self.stringExp(exp.NameExp.Str)
+		self.codeSelf(&e, &key)
+	} else {
+		self.exp2NextReg(&e)
+	}
+
+	base := e.info
+	args := newExp(expVoid, 0)
+	if len(exp.Args) > 0 {
+		_, args = self.expList(exp.Args)
+		if args.hasMultRet() {
+			self.setMultRet(&args)
+		}
+	}
+	self.setLine(exp.LastLine)
+	nparams := multRet
+	if !args.hasMultRet() {
+		if args.k != expVoid {
+			self.exp2NextReg(&args)
+		}
+		nparams = self.freeReg - (base + 1)
+	}
+	e = newExp(expCall, self.codeABC(OP_CALL, base, nparams+1, 2))
+	self.fixLine(exp.Line)
+	// 调用之后只剩下一个返回值
+	self.freeReg = base + 1
+	return e
+}
+
+/*
+表构造器的状态，与 ConsControl 一致
+*/
+type consControl struct {
+	v       expDesc  // 最后读到的数组元素
+	t       *expDesc // 表
+	nh      int      // 哈希部分元素的个数
+	na      int      // 数组部分元素的个数
+	toStore int      // 等待 SETLIST 的数组元素个数
+}
+
+func (self *funcState) tableConstructorExp(exp *TableConstructorExp) expDesc {
+	// NEWTABLE 在读取 { 之前生成，数组和哈希部分的大小在最后填上
+	pc := self.codeABC(OP_NEWTABLE, 0, 0, 0)
+	t := newExp(expRelocable, pc)
+	cc := consControl{v: newExp(expVoid, 0), t: &t}
+	self.exp2NextReg(&t)
+	self.setLine(exp.Line)
+	for _, field := range exp.Fields {
+		self.closeListField(&cc)
+		if field.Key == nil {
+			cc.v = self.exp(field.Value)
+			cc.na++
+			cc.toStore++
+		} else {
+			self.recField(&cc, field)
+		}
+	}
+	self.setLine(exp.LastLine)
+	self.lastListField(&cc)
+	setArg(&self.f.Code[pc], posB, 9, int2fb(cc.na))
+	setArg(&self.f.Code[pc], posC, 9, int2fb(cc.nh))
+	return t
+}
+
+/*
+把上一个数组元素放入寄存器，积累满 fieldsPerFlush 个时生成 SETLIST
+*/
+func (self *funcState) closeListField(cc *consControl) {
+	if cc.v.k == expVoid {
+		return
+	}
+	self.exp2NextReg(&cc.v)
+	cc.v.k = expVoid
+	if cc.toStore == fieldsPerFlush {
+		self.setList(cc.t.info, cc.na, cc.toStore)
+		cc.toStore = 0
+	}
+}
+
+func (self *funcState) lastListField(cc *consControl) {
+	if cc.toStore == 0 {
+		return
+	}
+	if cc.v.hasMultRet() {
+		self.setMultRet(&cc.v)
+		self.setList(cc.t.info, cc.na, multRet)
+		// 最后一个元素的个数不确定，不计入数组部分的大小
+		cc.na--
+	} else {
+		if cc.v.k != expVoid {
+			self.exp2NextReg(&cc.v)
+		}
+		self.setList(cc.t.info, cc.na, cc.toStore)
+	}
+}
+
+/*
+Name = exp 或 [exp] = exp
+*/
+func (self *funcState) recField(cc *consControl, field *TableField) {
+	reg := self.freeReg
+	key := self.exp(field.Key)
+	self.exp2Val(&key)
+	cc.nh++
+	rkKey := self.exp2RK(&key)
+	val := self.exp(field.Value)
+	self.codeABC(OP_SETTABLE, cc.t.info, rkKey, self.exp2RK(&val))
+	self.freeReg = reg
+}
+
+/*
+函数构造器，与 body 一致；函数原型在开始时就加入父函数，CLOSURE 在读取 end 之后生成
+*/
+func (self *funcState) funcBody(exp *FuncDefExp, isMethod bool) expDesc {
+	var bl blockCnt
+	fs := self.openFunc(&bl)
+	fs.closing = self.closeBodies[exp]
+	self.f.Protos = append(self.f.Protos, fs.f)
+	fs.f.LineDefined = uint32(exp.Line)
+	self.setLine(exp.Line)
+	if isMethod {
+		fs.newLocalVar("self")
+		fs.adjustLocalVars(1)
+	}
+	for _, name := range exp.ParList {
+		fs.newLocalVar(name)
+	}
+	fs.adjustLocalVars(len(exp.ParList))
+	fs.f.NumParams = byte(fs.nactvar)
+	if exp.IsVararg {
+		fs.f.IsVararg = 1
+	}
+	fs.reserveRegs(fs.nactvar)
+	fs.statList(exp.Block.Stats, false)
+	fs.f.LastLineDefined = uint32(exp.LastLine)
+	self.setLine(exp.LastLine)
+
+	e := newExp(expRelocable, self.codeABx(OP_CLOSURE, 0, len(self.f.Protos)-1))
+	self.exp2NextReg(&e)
+	self.closeFunc(exp.LastLine)
+	return e
+}
+
+/*
+表达式第一个词法单元所在的行
+*/
+func firstLine(node Exp) int {
+	switch exp := node.(type) {
+	case *TableAccessExp:
+		return firstLine(exp.PrefixExp)
+	case *BinopExp:
+		return firstLine(exp.Exp1)
+	case *NilExp:
+		return exp.Line
+	case *TrueExp:
+		return exp.Line
+	case *FalseExp:
+		return exp.Line
+	case *VarargExp:
+		return exp.Line
+	case *IntegerExp:
+		return exp.Line
+	case *FloatExp:
+		return exp.Line
+	case *StringExp:
+		return exp.Line
+	case *NameExp:
+		return exp.Line
+	case *UnopExp:
+		return exp.Line
+	case *ParensExp:
+		return exp.Line
+	case *TableConstructorExp:
+		return exp.Line
+	case *FuncDefExp:
+		return exp.Line
+	case *FuncCallExp:
+		return exp.Line
+	default:
+		panic("unreachable")
+	}
+}
@@ -0,0 +1,499 @@
+package codegen
+
+import (
+	. "lua-vm/compiler/ast"
+	. "lua-vm/vm"
+)
+
+/*
+本文件中的函数与 lparser.c 中处理语句的函数一一对应
+*/
+
+/*
+语句列表，withUntil 表示代码块以 until 结束，此时代码块中的局部变量在条件表达式中仍然有效
+*/
+func (self *funcState) statList(stats []Stat, withUntil bool) {
+	for i, stat := range stats {
+		if isCloseStat(stat) {
+			self.closeStat(stat.(*LocalVarDeclStat), stats[i+1:])
+			return
+		}
+		if label, ok := stat.(*LabelStat); ok {
+			self.labelStat(label, !withUntil && onlyNoOps(stats[i+1:]))
+			continue
+		}
+		self.statement(stat)
+	}
+}
+
+/*
+语句是否都是空语句或标签，与 skipnoopstat 一致
+*/
+func onlyNoOps(stats []Stat) bool {
+	for _, stat := range stats {
+		switch stat.(type) {
+		case *EmptyStat, *LabelStat:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+/*
+block ::= {stat} [retstat]，在新的代码块中生成
+*/
+func (self *funcState) block(block *Block) {
+	var bl blockCnt
+	self.enterBlock(&bl, false)
+	self.statList(block.Stats, false)
+	self.leaveBlock()
+}
+
+func (self *funcState) statement(node Stat) {
+	switch stat := node.(type) {
+	case *EmptyStat:
+		self.setLine(stat.Line)
+	case *IfStat:
+		self.ifStat(stat)
+	case *WhileStat:
+		self.whileStat(stat)
+	case *DoStat:
+		self.setLine(stat.Line)
+		self.block(stat.Block)
+		self.setLine(stat.LastLine)
+	case *ForNumStat:
+		self.forNumStat(stat)
+	case *ForInStat:
+		self.forInStat(stat)
+	case *RepeatStat:
+		self.repeatStat(stat)
+	case *FuncDefStat:
+		self.funcDefStat(stat)
+	case *LocalFuncDefStat:
+		self.localFuncDefStat(stat)
+	case *LocalVarDeclStat:
+		self.localVarDeclStat(stat)
+	case *GotoStat, *BreakStat:
+		self.gotoStat(stat, self.jump())
+	case *ReturnStat:
+		self.retStat(stat)
+	case *FuncCallStat:
+		e := self.funcCallExp(stat)
+		// 作为语句的函数调用不需要返回值
+		setArg(self.instruction(&e), posC, 9, 1)
+	case *AssignStat:
+		self.assignStat(stat)
+	default:
+		panic("unreachable")
+	}
+	self.freeReg = self.nactvar
+}
+
+/*
+goto Name 或 break，与 gotostat 一致；break 语句是跳转到 "break" 标签的 goto，pc 为对应的 JMP 指令。
+标签已经定义时立即连接，否则等到定义标签或离开代码块时再处理
+*/
+func (self *funcState) gotoStat(node Stat, pc int) {
+	name, line := "break", 0
+	switch stat := node.(type) {
+	case *GotoStat:
+		name, line = stat.Name, stat.Line
+	case *BreakStat:
+		line = stat.Line
+	}
+	self.setLine(line)
+	g := self.newLabelEntry(&self.gotos, name, line, pc)
+	self.findLabel(g)
+}
+
+/*
+::Name::，与 labelstat 一致；atEnd 表示标签之后直到代码块结束只有空语句和标签，
+此时认为代码块中的局部变量已经失效，跳转到这个标签的 goto 不会进入局部变量的作用域
+*/
+func (self *funcState) labelStat(stat *LabelStat, atEnd bool) {
+	self.setLine(stat.Line)
+	self.checkRepeated(stat.Name)
+	l := self.newLabelEntry(&self.labels, stat.Name, stat.Line, self.getLabel())
+	if atEnd {
+		self.labels[l].nactvar = self.bl.nactvar
+	}
+	self.findGotos(&self.labels[l])
+}
+
+/*
+把 nexps 个表达式调整为 nvars 个值，e 为最后一个表达式，与 adjust_assign 一致
+*/
+func (self *funcState) adjustAssign(nvars, nexps int, e *expDesc) {
+	extra := nvars - nexps
+	if e.hasMultRet() {
+		extra++
+		if extra < 0 {
+			extra = 0
+		}
+		self.setReturns(e, extra)
+		if extra > 1 {
+			self.reserveRegs(extra - 1)
+		}
+	} else {
+		if e.k != expVoid {
+			self.exp2NextReg(e)
+		}
+		if extra > 0 {
+			reg := self.freeReg
+			self.reserveRegs(extra)
+			self.codeNil(reg, extra)
+		}
+	}
+	if nexps > nvars {
+		self.freeReg -= nexps - nvars
+	}
+}
+
+/*
+条件表达式，返回值为假时的跳转链表
+*/
+func (self *funcState) cond(exp Exp) int {
+	e := self.exp(exp)
+	if e.k == expNil {
+		// nil 和 false 一样处理
+		e.k = expFalse
+	}
+	self.goIfTrue(&e)
+	return e.f
+}
+
+/*
+if 语句的一个分支，与 test_then_block 一致；escapeList 为各分支结束后跳转到 end 的链表
+*/
+func (self *funcState) testThenBlock(clause *IfClause, escapeList *int, hasMore bool) {
+	var bl blockCnt
+	var jf int
+	self.setLine(clause.Line)
+	e := self.exp(clause.Cond)
+	self.setLine(clause.ThenLine)
+	stats := clause.Block.Stats
+	if len(stats) > 0 && isGoto(stats[0]) {
+		// if cond then break 或 if cond then goto：条件为真时直接跳转
+		self.goIfFalse(&e)
+		self.enterBlock(&bl, false)
+		self.gotoStat(stats[0], e.t)
+		stats = stats[1:]
+		for len(stats) > 0 {
+			if empty, ok := stats[0].(*EmptyStat); ok {
+				self.setLine(empty.Line)
+				stats = stats[1:]
+			} else {
+				break
+			}
+		}
+		if len(stats) == 0 {
+			self.leaveBlock()
+			return
+		}
+		jf = self.jump()
+	} else {
+		self.goIfTrue(&e)
+		self.enterBlock(&bl, false)
+		jf = e.f
+	}
+	self.statList(stats, false)
+	self.leaveBlock()
+	if hasMore {
+		self.concat(escapeList, self.jump())
+	}
+	self.patchToHere(jf)
+}
+
+func isGoto(stat Stat) bool {
+	switch stat.(type) {
+	case *GotoStat, *BreakStat:
+		return true
+	}
+	return false
+}
+
+/*
+if exp then block {elseif exp then block} [else block] end
+*/
+func (self *funcState) ifStat(stat *IfStat) {
+	escapeList := noJump
+	for i, clause := range stat.Clauses {
+		hasMore := i < len(stat.Clauses)-1 || stat.Else != nil
+		self.testThenBlock(clause, &escapeList, hasMore)
+	}
+	if stat.Else != nil {
+		self.setLine(stat.ElseLine)
+		self.block(stat.Else)
+	}
+	self.setLine(stat.LastLine)
+	self.patchToHere(escapeList)
+}
+
+/*
+while exp do block end
+*/
+func (self *funcState) whileStat(stat *WhileStat) {
+	var bl blockCnt
+	self.setLine(stat.Line)
+	whileInit := self.getLabel()
+	condExit := self.cond(stat.Cond)
+	self.enterBlock(&bl, true)
+	self.setLine(stat.DoLine)
+	self.block(stat.Block)
+	self.patchList(self.jump(), whileInit)
+	self.setLine(stat.LastLine)
+	self.leaveBlock()
+	self.patchToHere(condExit)
+}
+
+/*
+repeat block until exp，条件表达式可以访问循环体中的局部变量；
+循环体中有 close 变量时条件表达式也要在变量关闭之前求值，此时改写成 repeat block if exp then break end until false
+*/
+func (self *funcState) repeatStat(stat *RepeatStat) {
+	var bl1, bl2 blockCnt
+	stats, cond := stat.Block.Stats, stat.Cond
+	for _, s := range stats {
+		if isCloseStat(s) {
+			exit := ifThen(stat.UntilLine, cond, &BreakStat{Line: stat.UntilLine})
+			stats = append(stats[:len(stats):len(stats)], exit)
+			cond = &FalseExp{Line: stat.UntilLine}
+			break
+		}
+	}
+	repeatInit := self.getLabel()
+	self.enterBlock(&bl1, true)
+	self.enterBlock(&bl2, false)
+	self.setLine(stat.Line)
+	self.statList(stats, true)
+	self.setLine(stat.UntilLine)
+	condExit := self.cond(cond)
+	if bl2.upval {
+		self.patchClose(condExit, bl2.nactvar)
+	}
+	self.leaveBlock()
+	self.patchList(condExit, repeatInit)
+	self.leaveBlock()
+}
+
+/*
+for 循环的循环体，与 forbody 一致；base 为控制变量所在的第一个寄存器，nvars 为循环体中声明的变量个数
+*/
+func (self *funcState) forBody(base, line, nvars int, isNum bool, doLine int, block *Block) {
+	var bl blockCnt
+	self.adjustLocalVars(3)
+	self.setLine(doLine)
+	var prep int
+	if isNum {
+		prep = self.codeAsBx(OP_FORPREP, base, noJump)
+	} else {
+		prep = self.jump()
+	}
+	self.enterBlock(&bl, false)
+	self.adjustLocalVars(nvars)
+	self.reserveRegs(nvars)
+	self.block(block)
+	self.leaveBlock()
+	self.patchToHere(prep)
+	var endFor int
+	if isNum {
+		endFor = self.codeAsBx(OP_FORLOOP, base, noJump)
+	} else {
+		self.codeABC(OP_TFORCALL, base, 0, nvars)
+		self.fixLine(line)
+		endFor = self.codeAsBx(OP_TFORLOOP, base+2, noJump)
+	}
+	self.patchList(endFor, prep+1)
+	self.fixLine(line)
+}
+
+/*
+for Name = exp, exp [, exp] do block end
+*/
+func (self *funcState) forNumStat(stat *ForNumStat) {
+	var bl blockCnt
+	self.enterBlock(&bl, true)
+	self.setLine(stat.Line)
+	base := self.freeReg
+	self.newLocalVar("(for index)")
+	self.newLocalVar("(for limit)")
+	self.newLocalVar("(for step)")
+	self.newLocalVar(stat.VarName)
+	self.exp1(stat.Init)
+	self.exp1(stat.Limit)
+	if stat.Step != nil {
+		self.exp1(stat.Step)
+	} else {
+		self.codeK(self.freeReg, self.intK(1))
+		self.reserveRegs(1)
+	}
+	self.forBody(base, stat.Line, 1, true, stat.DoLine, stat.Block)
+	self.setLine(stat.LastLine)
+	self.leaveBlock()
+}
+
+func (self *funcState) exp1(exp Exp) {
+	e := self.exp(exp)
+	self.exp2NextReg(&e)
+}
+
+/*
+for namelist in explist do block end
+*/
+func (self *funcState) forInStat(stat *ForInStat) {
+	var bl blockCnt
+	self.enterBlock(&bl, true)
+	self.setLine(stat.Line)
+	base := self.freeReg
+	self.newLocalVar("(for generator)")
+	self.newLocalVar("(for state)")
+	self.newLocalVar("(for control)")
+	for _, name := range stat.NameList {
+		self.newLocalVar(name)
+	}
+	line := firstLine(stat.ExpList[0])
+	nexps, e := self.expList(stat.ExpList)
+	self.adjustAssign(3, nexps, &e)
+	// 调用迭代器时需要额外的空间
+	self.checkStack(3)
+	self.forBody(base, line, len(stat.NameList), false, stat.DoLine, stat.Block)
+	self.setLine(stat.LastLine)
+	self.leaveBlock()
+}
+
+/*
+function funcname funcbody，定义“发生”在 function 所在的行
+*/
+func (self *funcState) funcDefStat(stat *FuncDefStat) {
+	self.setLine(stat.Line)
+	v := self.exp(stat.Name)
+	self.checkReadOnly(&v)
+	b := self.funcBody(stat.Func, stat.IsMethod)
+	self.storeVar(&v, &b)
+	self.fixLine(stat.Line)
+}
+
+/*
+local function Name funcbody，函数体中可以访问这个局部变量
+*/
+func (self *funcState) localFuncDefStat(stat *LocalFuncDefStat) {
+	self.setLine(stat.Line)
+	self.newLocalVar(stat.Name)
+	self.adjustLocalVars(1)
+	b := self.funcBody(stat.Func, false)
+	// 调试信息中的局部变量从 CLOSURE 之后开始有效
+	self.getLocVar(b.info).StartPc = uint32(self.pc())
+}
+
+/*
+local attnamelist ['=' explist]，const 变量与普通的局部变量一样占用寄存器，只是不能被赋值；
+close 变量也不能被赋值，关闭变量的代码由 closeStat 生成
+*/
+func (self *funcState) localVarDeclStat(stat *LocalVarDeclStat) {
+	self.setLine(stat.Line)
+	for i, name := range stat.NameList {
+		switch stat.AttribList[i] {
+		case "const", "close":
+			self.newConstVar(name)
+		default:
+			self.newLocalVar(name)
+		}
+	}
+	nexps, e := 0, newExp(expVoid, 0)
+	if len(stat.ExpList) > 0 {
+		nexps, e = self.expList(stat.ExpList)
+	}
+	self.setLine(stat.LastLine)
+	self.adjustAssign(len(stat.NameList), nexps, &e)
+	self.adjustLocalVars(len(stat.NameList))
+}
+
+/*
+varlist '=' explist，与 restassign 一致：先计算所有的值，再从右向左赋值
+*/
+func (self *funcState) assignStat(stat *AssignStat) {
+	vars := make([]expDesc, len(stat.VarList))
+	for i, exp := range stat.VarList {
+		vars[i] = self.exp(exp)
+		self.checkReadOnly(&vars[i])
+		if i > 0 && vars[i].k != expIndexed {
+			self.checkConflict(vars[:i], &vars[i])
+		}
+	}
+	nvars := len(vars)
+	nexps, e := self.expList(stat.ExpList)
+	if nexps == nvars {
+		self.setOneRet(&e)
+		self.storeVar(&vars[nvars-1], &e)
+	} else {
+		self.adjustAssign(nvars, nexps, &e)
+		e = newExp(expNonReloc, self.freeReg-1)
+		self.storeVar(&vars[nvars-1], &e)
+	}
+	for i := nvars - 2; i >= 0; i-- {
+		e = newExp(expNonReloc, self.freeReg-1)
+		self.storeVar(&vars[i], &e)
+	}
+}
+
+/*
+如果前面的赋值目标 t[k] 中的 t 或 k 就是当前被赋值的变量 v，先把 v 的值复制到一个临时寄存器中
+*/
+func (self *funcState) checkConflict(prev []expDesc, v *expDesc) {
+	extra := self.freeReg
+	conflict := false
+	for i := range prev {
+		lh := &prev[i]
+		if lh.k != expIndexed {
+			continue
+		}
+		if lh.indVt == v.k && lh.indT == v.info {
+			conflict = true
+			lh.indVt = expLocal
+			lh.indT = extra
+		}
+		if v.k == expLocal && lh.indIdx == v.info {
+			conflict = true
+			lh.indIdx = extra
+		}
+	}
+	if conflict {
+		op := OP_GETUPVAL
+		if v.k == expLocal {
+			op = OP_MOVE
+		}
+		self.codeABC(op, extra, v.info, 0)
+		self.reserveRegs(1)
+	}
+}
+
+/*
+return [explist] [';']，唯一的返回值是函数调用时生成尾调用
+*/
+func (self *funcState) retStat(stat *ReturnStat) {
+	if self.closing != nil {
+		stat = self.closeReturnStat(stat)
+	}
+	self.setLine(stat.Line)
+	first, nret := 0, 0
+	if len(stat.ExpList) > 0 {
+		var e expDesc
+		nret, e = self.expList(stat.ExpList)
+		if e.hasMultRet() {
+			self.setMultRet(&e)
+			if e.k == expCall && nret == 1 {
+				setArg(self.instruction(&e), 0, 6, OP_TAILCALL)
+			}
+			first = self.nactvar
+			nret = multRet
+		} else if nret == 1 {
+			first = self.exp2AnyReg(&e)
+		} else {
+			self.exp2NextReg(&e)
+			first = self.nactvar
+		}
+	}
+	self.ret(first, nret)
+	self.setLine(stat.LastLine)
+}
@@ -0,0 +1,832 @@
+package codegen
+
+import (
+	. "lua-vm/binchunk"
+	"lua-vm/number"
+	. "lua-vm/vm"
+	"math"
+)
+
+// 一条 SETLIST 指令最多设置的数组元素个数，与 LFIELDS_PER_FLUSH 一致
+const fieldsPerFlush = 50
+
+// 没有寄存器，用于 TESTSET 的 A 操作数
+const noReg = MAXARG_A
+
+// 可变数量的返回值，与 LUA_MULTRET 一致
+const multRet = -1
+
+/*
+本文件中的函数与 lcode.c 中的同名函数一一对应
+*/
+
+/* 指令的读写 */
+
+const (
+	posA  = 6
+	posC  = 14
+	posB  = 23
+	posBx = 14
+)
+
+func getArg(i uint32, pos, size uint) int {
+	return int(i >> pos & (1<<size - 1))
+}
+
+func setArg(i *uint32, pos, size uint, v int) {
+	mask := uint32(1<<size-1) << pos
+	*i = *i&^mask | uint32(v)<<pos&mask
+}
+
+func getArgSBx(i uint32) int {
+	return getArg(i, posBx, 18) - MAXARG_sBx
+}
+
+func (self *funcState) instruction(e *expDesc) *uint32 {
+	return &self.f.Code[e.info]
+}
+
+/*
+生成一条指令，行号为上一个词法单元所在的行
+*/
+func (self *funcState) code(i Instruction) int {
+	self.dischargeJpc()
+	self.f.Code = append(self.f.Code, uint32(i))
+	self.f.LineInfo = append(self.f.LineInfo, uint32(self.lastLine))
+	return self.pc() - 1
+}
+
+func (self *funcState) codeABC(op, a, b, c int) int {
+	return self.code(CreateABC(op, a, b, c))
+}
+
+func (self *funcState) codeABx(op, a, bx int) int {
+	return self.code(CreateABx(op, a, bx))
+}
+
+func (self *funcState) codeAsBx(op, a, sbx int) int {
+	return self.code(CreateAsBx(op, a, sbx))
+}
+
+func (self *funcState) codeExtraArg(a int) int {
+	return self.code(CreateAx(OP_EXTRAARG, a))
+}
+
+/*
+把上一条指令的行号改成 line
+*/
+func (self *funcState) fixLine(line int) {
+	self.f.LineInfo[self.pc()-1] = uint32(line)
+}
+
+func (self *funcState) codeK(reg, k int) int {
+	if k <= MAXARG_Bx {
+		return self.codeABx(OP_LOADK, reg, k)
+	}
+	p := self.codeABx(OP_LOADKX, reg, 0)
+	self.codeExtraArg(k)
+	return p
+}
+
+/*
+把寄存器 from 开始的 n 个寄存器置为 nil，尽量与上一条 LOADNIL 合并
+*/
+func (self *funcState) codeNil(from, n int) {
+	l := from + n - 1
+	if self.pc() > self.lastTarget && self.pc() > 0 {
+		previous := &self.f.Code[self.pc()-1]
+		if Instruction(*previous).Opcode() == OP_LOADNIL {
+			pfrom := getArg(*previous, posA, 8)
+			pl := pfrom + getArg(*previous, posB, 9)
+			if pfrom <= from && from <= pl+1 || from <= pfrom && pfrom <= l+1 {
+				if pfrom < from {
+					from = pfrom
+				}
+				if pl > l {
+					l = pl
+				}
+				setArg(previous, posA, 8, from)
+				setArg(previous, posB, 9, l-from)
+				return
+			}
+		}
+	}
+	self.codeABC(OP_LOADNIL, from, n-1, 0)
+}
+
+func (self *funcState) ret(first, nret int) {
+	self.codeABC(OP_RETURN, first, nret+1, 0)
+}
+
+/* 跳转链表 */
+
+func (self *funcState) getJump(pc int) int {
+	offset := getArgSBx(self.f.Code[pc])
+	if offset == noJump {
+		return noJump
+	}
+	return pc + 1 + offset
+}
+
+func (self *funcState) fixJump(pc, dest int) {
+	offset := dest - (pc + 1)
+	if offset < -MAXARG_sBx || offset > MAXARG_sBx {
+		self.syntaxError("control structure too long")
+	}
+	setArg(&self.f.Code[pc], posBx, 18, offset+MAXARG_sBx)
+}
+
+/*
+把链表 l2 连接到链表 l1 的末尾
+*/
+func (self *funcState) concat(l1 *int, l2 int) {
+	if l2 == noJump {
+		return
+	}
+	if *l1 == noJump {
+		*l1 = l2
+		return
+	}
+	list := *l1
+	for next := self.getJump(list); next != noJump; next = self.getJump(list) {
+		list = next
+	}
+	self.fixJump(list, l2)
+}
+
+func (self *funcState) jump() int {
+	jpc := self.jpc
+	self.jpc = noJump
+	j := self.codeAsBx(OP_JMP, 0, noJump)
+	self.concat(&j, jpc)
+	return j
+}
+
+func (self *funcState) condJump(op, a, b, c int) int {
+	self.codeABC(op, a, b, c)
+	return self.jump()
+}
+
+/*
+返回当前位置并把它标记为跳转目标
+*/
+func (self *funcState) getLabel() int {
+	self.lastTarget = self.pc()
+	return self.pc()
+}
+
+/*
+跳转指令的控制指令：如果 JMP 之前是一条测试指令，返回该测试指令
+*/
+func (self *funcState) getJumpControl(pc int) *uint32 {
+	if pc >= 1 && Instruction(self.f.Code[pc-1]).IsTest() {
+		return &self.f.Code[pc-1]
+	}
+	return &self.f.Code[pc]
+}
+
+/*
+修改 TESTSET 的目标寄存器，没有目标寄存器或者目标与源相同时改为 TEST；不是 TESTSET 时返回 false
+*/
+func (self *funcState) patchTestReg(node, reg int) bool {
+	i := self.getJumpControl(node)
+	if Instruction(*i).Opcode() != OP_TESTSET {
+		return false
+	}
+	if b := getArg(*i, posB, 9); reg != noReg && reg != b {
+		setArg(i, posA, 8, reg)
+	} else {
+		*i = uint32(CreateABC(OP_TEST, b, 0, getArg(*i, posC, 9)))
+	}
+	return true
+}
+
+func (self *funcState) removeValues(list int) {
+	for ; list != noJump; list = self.getJump(list) {
+		self.patchTestReg(list, noReg)
+	}
+}
+
+func (self *funcState) patchListAux(list, vtarget, reg, dtarget int) {
+	for list != noJump {
+		next := self.getJump(list)
+		if self.patchTestReg(list, reg) {
+			self.fixJump(list, vtarget)
+		} else {
+			self.fixJump(list, dtarget)
+		}
+		list = next
+	}
+}
+
+func (self *funcState) dischargeJpc() {
+	self.patchListAux(self.jpc, self.pc(), noReg, self.pc())
+	self.jpc = noJump
+}
+
+func (self *funcState) patchToHere(list int) {
+	self.getLabel()
+	self.concat(&self.jpc, list)
+}
+
+func (self *funcState) patchList(list, target int) {
+	if target == self.pc() {
+		self.patchToHere(list)
+	} else {
+		self.patchListAux(list, target, noReg, target)
+	}
+}
+
+/*
+让链表中的 JMP 指令在跳转时关闭寄存器 level 及以上的 Upvalue
+*/
+func (self *funcState) patchClose(list, level int) {
+	for ; list != noJump; list = self.getJump(list) {
+		setArg(&self.f.Code[list], posA, 8, level+1)
+	}
+}
+
+/* 常量表 */
+
+/*
+常量查找表的键，与 luac 一样，整数和浮点数使用不同的键，这样 1 和 1.0 是两个不同的常量
+*/
+type constKey struct {
+	kind byte
+	s    string
+	i    int64
+	f    float64
+	b    bool
+}
+
+func (self *funcState) addK(key constKey, val interface{}) int {
+	k := NewConstant(val)
+	if idx, ok := self.h[key]; ok && idx < len(self.f.Constants) && self.f.Constants[idx] == k {
+		return idx
+	}
+	self.f.Constants = append(self.f.Constants, k)
+	idx := len(self.f.Constants) - 1
+	self.h[key] = idx
+	return idx
+}
+
+func (self *funcState) stringK(s string) int {
+	return self.addK(constKey{kind: 's', s: s}, s)
+}
+
+func (self *funcState) intK(n int64) int {
+	return self.addK(constKey{kind: 'i', i: n}, n)
+}
+
+func (self *funcState) numberK(r float64) int {
+	return self.addK(constKey{kind: 'f', f: r}, r)
+}
+
+func (self *funcState) boolK(b bool) int {
+	return self.addK(constKey{kind: 'b', b: b}, b)
+}
+
+func (self *funcState) nilK() int {
+	return self.addK(constKey{kind: 'n'}, nil)
+}
+
+func (self *funcState) stringExp(s string) expDesc {
+	return newExp(expK, self.stringK(s))
+}
+
+/* 表达式 */
+
+/*
+设置函数调用或 ... 的返回值个数
+*/
+func (self *funcState) setReturns(e *expDesc, nresults int) {
+	switch e.k {
+	case expCall:
+		setArg(self.instruction(e), posC, 9, nresults+1)
+	case expVararg:
+		pc := self.instruction(e)
+		setArg(pc, posB, 9, nresults+1)
+		setArg(pc, posA, 8, self.freeReg)
+		self.reserveRegs(1)
+	}
+}
+
+func (self *funcState) setMultRet(e *expDesc) {
+	self.setReturns(e, multRet)
+}
+
+func (self *funcState) setOneRet(e *expDesc) {
+	switch e.k {
+	case expCall:
+		e.k = expNonReloc
+		e.info = getArg(*self.instruction(e), posA, 8)
+	case expVararg:
+		setArg(self.instruction(e), posB, 9, 2)
+		e.k = expRelocable
+	}
+}
+
+/*
+生成读取变量的指令
+*/
+func (self *funcState) dischargeVars(e *expDesc) {
+	switch e.k {
+	case expLocal:
+		e.k = expNonReloc
+	case expUpval:
+		e.info = self.codeABC(OP_GETUPVAL, 0, e.info, 0)
+		e.k = expRelocable
+	case expIndexed:
+		op := OP_GETTABUP
+		self.freeRegister(e.indIdx)
+		if e.indVt == expLocal {
+			self.freeRegister(e.indT)
+			op = OP_GETTABLE
+		}
+		e.info = self.codeABC(op, 0, e.indT, e.indIdx)
+		e.k = expRelocable
+	case expVararg, expCall:
+		self.setOneRet(e)
+	}
+}
+
+func (self *funcState) discharge2Reg(e *expDesc, reg int) {
+	self.dischargeVars(e)
+	switch e.k {
+	case expNil:
+		self.codeNil(reg, 1)
+	case expFalse, expTrue:
+		b := 0
+		if e.k == expTrue {
+			b = 1
+		}
+		self.codeABC(OP_LOADBOOL, reg, b, 0)
+	case expK:
+		self.codeK(reg, e.info)
+	case expKFlt:
+		self.codeK(reg, self.numberK(e.nval))
+	case expKInt:
+		self.codeK(reg, self.intK(e.ival))
+	case expRelocable:
+		setArg(self.instruction(e), posA, 8, reg)
+	case expNonReloc:
+		if reg != e.info {
+			self.codeABC(OP_MOVE, reg, e.info, 0)
+		}
+	default:
+		// expJmp：没有需要做的
+		return
+	}
+	e.info = reg
+	e.k = expNonReloc
+}
+
+func (self *funcState) discharge2AnyReg(e *expDesc) {
+	if e.k != expNonReloc {
+		self.reserveRegs(1)
+		self.discharge2Reg(e, self.freeReg-1)
+	}
+}
+
+func (self *funcState) codeLoadBool(a, b, jump int) int {
+	self.getLabel()
+	return self.codeABC(OP_LOADBOOL, a, b, jump)
+}
+
+/*
+判断链表中是否有跳转需要产生值，即控制指令不是 TESTSET
+*/
+func (self *funcState) needValue(list int) bool {
+	for ; list != noJump; list = self.getJump(list) {
+		if Instruction(*self.getJumpControl(list)).Opcode() != OP_TESTSET {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *funcState) exp2Reg(e *expDesc, reg int) {
+	self.discharge2Reg(e, reg)
+	if e.k == expJmp {
+		self.concat(&e.t, e.info)
+	}
+	if e.hasJumps() {
+		pf, pt := noJump, noJump
+		if self.needValue(e.t) || self.needValue(e.f) {
+			fj := noJump
+			if e.k != expJmp {
+				fj = self.jump()
+			}
+			pf = self.codeLoadBool(reg, 0, 1)
+			pt = self.codeLoadBool(reg, 1, 0)
+			self.patchToHere(fj)
+		}
+		final := self.getLabel()
+		self.patchListAux(e.f, final, reg, pf)
+		self.patchListAux(e.t, final, reg, pt)
+	}
+	e.f, e.t = noJump, noJump
+	e.info = reg
+	e.k = expNonReloc
+}
+
+func (self *funcState) exp2NextReg(e *expDesc) {
+	self.dischargeVars(e)
+	self.freeExp(e)
+	self.reserveRegs(1)
+	self.exp2Reg(e, self.freeReg-1)
+}
+
+func (self *funcState) exp2AnyReg(e *expDesc) int {
+	self.dischargeVars(e)
+	if e.k == expNonReloc {
+		if !e.hasJumps() {
+			return e.info
+		}
+		if e.info >= self.nactvar {
+			self.exp2Reg(e, e.info)
+			return e.info
+		}
+	}
+	self.exp2NextReg(e)
+	return e.info
+}
+
+func (self *funcState) exp2AnyRegUp(e *expDesc) {
+	if e.k != expUpval || e.hasJumps() {
+		self.exp2AnyReg(e)
+	}
+}
+
+func (self *funcState) exp2Val(e *expDesc) {
+	if e.hasJumps() {
+		self.exp2AnyReg(e)
+	} else {
+		self.dischargeVars(e)
+	}
+}
+
+/*
+把表达式转换成 RK 操作数，常量表索引超出 MAXINDEXRK 时放入寄存器
+*/
+func (self *funcState) exp2RK(e *expDesc) int {
+	self.exp2Val(e)
+	isK := true
+	switch e.k {
+	case expTrue:
+		e.info = self.boolK(true)
+	case expFalse:
+		e.info = self.boolK(false)
+	case expNil:
+		e.info = self.nilK()
+	case expKInt:
+		e.info = self.intK(e.ival)
+	case expKFlt:
+		e.info = self.numberK(e.nval)
+	case expK:
+	default:
+		isK = false
+	}
+	if isK {
+		e.k = expK
+		if e.info <= MAXINDEXRK {
+			return e.info | BITRK
+		}
+	}
+	return self.exp2AnyReg(e)
+}
+
+func (self *funcState) storeVar(v, ex *expDesc) {
+	switch v.k {
+	case expLocal:
+		self.freeExp(ex)
+		self.exp2Reg(ex, v.info)
+		return
+	case expUpval:
+		e := self.exp2AnyReg(ex)
+		self.codeABC(OP_SETUPVAL, e, v.info, 0)
+	case expIndexed:
+		op := OP_SETTABUP
+		if v.indVt == expLocal {
+			op = OP_SETTABLE
+		}
+		e := self.exp2RK(ex)
+		self.codeABC(op, v.indT, v.indIdx, e)
+	}
+	self.freeExp(ex)
+}
+
+func (self *funcState) codeSelf(e, key *expDesc) {
+	self.exp2AnyReg(e)
+	ereg := e.info
+	self.freeExp(e)
+	e.info = self.freeReg
+	e.k = expNonReloc
+	self.reserveRegs(2)
+	self.codeABC(OP_SELF, e.info, ereg, self.exp2RK(key))
+	self.freeExp(key)
+}
+
+func (self *funcState) negateCondition(e *expDesc) {
+	pc := self.getJumpControl(e.info)
+	setArg(pc, posA, 8, 1-getArg(*pc, posA, 8))
+}
+
+func (self *funcState) jumpOnCond(e *expDesc, cond int) int {
+	if e.k == expRelocable {
+		ie := *self.instruction(e)
+		if Instruction(ie).Opcode() == OP_NOT {
+			// 去掉之前的 NOT，直接测试其操作数
+			self.f.Code = self.f.Code[:self.pc()-1]
+			self.f.LineInfo = self.f.LineInfo[:len(self.f.LineInfo)-1]
+			return self.condJump(OP_TEST, getArg(ie, posB, 9), 0, 1-cond)
+		}
+	}
+	self.discharge2AnyReg(e)
+	self.freeExp(e)
+	return self.condJump(OP_TESTSET, noReg, e.info, cond)
+}
+
+/*
+值为真时继续执行，为假时跳转
+*/
+func (self *funcState) goIfTrue(e *expDesc) {
+	self.dischargeVars(e)
+	var pc int
+	switch e.k {
+	case expJmp:
+		self.negateCondition(e)
+		pc = e.info
+	case expK, expKFlt, expKInt, expTrue:
+		pc = noJump
+	default:
+		pc = self.jumpOnCond(e, 0)
+	}
+	self.concat(&e.f, pc)
+	self.patchToHere(e.t)
+	e.t = noJump
+}
+
+/*
+值为假时继续执行，为真时跳转
+*/
+func (self *funcState) goIfFalse(e *expDesc) {
+	self.dischargeVars(e)
+	var pc int
+	switch e.k {
+	case expJmp:
+		pc = e.info
+	case expNil, expFalse:
+		pc = noJump
+	default:
+		pc = self.jumpOnCond(e, 1)
+	}
+	self.concat(&e.t, pc)
+	self.patchToHere(e.f)
+	e.f = noJump
+}
+
+func (self *funcState) codeNot(e *expDesc) {
+	self.dischargeVars(e)
+	switch e.k {
+	case expNil, expFalse:
+		e.k = expTrue
+	case expK, expKFlt, expKInt, expTrue:
+		e.k = expFalse
+	case expJmp:
+		self.negateCondition(e)
+	default:
+		self.discharge2AnyReg(e)
+		self.freeExp(e)
+		e.info = self.codeABC(OP_NOT, 0, e.info, 0)
+		e.k = expRelocable
+	}
+	e.f, e.t = e.t, e.f
+	self.removeValues(e.f)
+	self.removeValues(e.t)
+}
+
+/*
+把 t 变成 t[k]，t 必须位于寄存器或 Upvalue 中
+*/
+func (self *funcState) indexed(t, k *expDesc) {
+	t.indT = t.info
+	t.indIdx = self.exp2RK(k)
+	if t.k == expUpval {
+		t.indVt = expUpval
+	} else {
+		t.indVt = expLocal
+	}
+	t.k = expIndexed
+}
+
+func isNumeral(e *expDesc) bool {
+	return !e.hasJumps() && (e.k == expKInt || e.k == expKFlt)
+}
+
+/*
+数字字面量的值，与 tonumeral 一致
+*/
+func toNumeral(e *expDesc) (interface{}, bool) {
+	if e.hasJumps() {
+		return nil, false
+	}
+	switch e.k {
+	case expKInt:
+		return e.ival, true
+	case expKFlt:
+		return e.nval, true
+	}
+	return nil, false
+}
+
+/*
+常量折叠，与 constfolding 一致：两个操作数都是数字字面量时在编译期求值，结果保存在 e1 中。
+运行时会出错的运算（除数为 0、位运算的操作数不是整数）不折叠；
+结果为 NaN 或 0 的浮点数也不折叠，以免丢失 -0.0 这样的值
+*/
+func constFolding(op int, e1, e2 *expDesc) bool {
+	v1, ok1 := toNumeral(e1)
+	v2, ok2 := toNumeral(e2)
+	if !ok1 || !ok2 {
+		return false
+	}
+	switch op {
+	case number.LUA_OPDIV, number.LUA_OPIDIV, number.LUA_OPMOD:
+		if v2 == int64(0) || v2 == float64(0) {
+			return false
+		}
+	}
+	res, ok := number.Arith(op, v1, v2)
+	if !ok {
+		return false
+	}
+	switch x := res.(type) {
+	case int64:
+		e1.k = expKInt
+		e1.ival = x
+	case float64:
+		if math.IsNaN(x) || x == 0 {
+			return false
+		}
+		e1.k = expKFlt
+		e1.nval = x
+	}
+	return true
+}
+
+func (self *funcState) codeUnExpVal(op int, e *expDesc, line int) {
+	r := self.exp2AnyReg(e)
+	self.freeExp(e)
+	e.info = self.codeABC(op, 0, r, 0)
+	e.k = expRelocable
+	self.fixLine(line)
+}
+
+func (self *funcState) codeBinExpVal(op int, e1, e2 *expDesc, line int) {
+	rk2 := self.exp2RK(e2)
+	rk1 := self.exp2RK(e1)
+	self.freeExps(e1, e2)
+	e1.info = self.codeABC(op, 0, rk1, rk2)
+	e1.k = expRelocable
+	self.fixLine(line)
+}
+
+/*
+比较运算，~= 转换成 not ==，> 和 >= 转换成交换操作数的 < 和 <=
+*/
+func (self *funcState) codeComp(op int, e1, e2 *expDesc) {
+	rk1 := e1.info
+	if e1.k == expK {
+		rk1 |= BITRK
+	}
+	rk2 := self.exp2RK(e2)
+	self.freeExps(e1, e2)
+	switch op {
+	case oprNE:
+		e1.info = self.condJump(OP_EQ, 0, rk1, rk2)
+	case oprGT:
+		e1.info = self.condJump(OP_LT, 1, rk2, rk1)
+	case oprGE:
+		e1.info = self.condJump(OP_LE, 1, rk2, rk1)
+	default:
+		e1.info = self.condJump(OP_EQ+op-oprEQ, 1, rk1, rk2)
+	}
+	e1.k = expJmp
+}
+
+/*
+一元运算
+*/
+func (self *funcState) prefix(op int, e *expDesc, line int) {
+	// 与 luac 一样，一元运算使用整数 0 作为第二个操作数进行常量折叠
+	ef := newExp(expKInt, 0)
+	switch op {
+	case oprMinus:
+		if !constFolding(number.LUA_OPUNM, e, &ef) {
+			self.codeUnExpVal(OP_UNM, e, line)
+		}
+	case oprBNot:
+		if !constFolding(number.LUA_OPBNOT, e, &ef) {
+			self.codeUnExpVal(OP_BNOT, e, line)
+		}
+	case oprLen:
+		self.codeUnExpVal(OP_LEN, e, line)
+	case oprNot:
+		self.codeNot(e)
+	}
+}
+
+/*
+处理二元运算的第一个操作数，在读取第二个操作数之前调用
+*/
+func (self *funcState) infix(op int, v *expDesc) {
+	switch {
+	case op == oprAnd:
+		self.goIfTrue(v)
+	case op == oprOr:
+		self.goIfFalse(v)
+	case op == oprConcat:
+		self.exp2NextReg(v)
+	case isArith(op):
+		// 数字字面量保留下来，以便之后与第二个操作数一起处理
+		if !isNumeral(v) {
+			self.exp2RK(v)
+		}
+	default:
+		self.exp2RK(v)
+	}
+}
+
+/*
+生成二元运算，line 为运算符所在的行
+*/
+func (self *funcState) posfix(op int, e1, e2 *expDesc, line int) {
+	switch {
+	case op == oprAnd:
+		self.dischargeVars(e2)
+		self.concat(&e2.f, e1.f)
+		*e1 = *e2
+	case op == oprOr:
+		self.dischargeVars(e2)
+		self.concat(&e2.t, e1.t)
+		*e1 = *e2
+	case op == oprConcat:
+		self.exp2Val(e2)
+		if e2.k == expRelocable && Instruction(*self.instruction(e2)).Opcode() == OP_CONCAT {
+			// 与右边的 CONCAT 合并成一条指令
+			self.freeExp(e1)
+			setArg(self.instruction(e2), posB, 9, e1.info)
+			e1.k = expRelocable
+			e1.info = e2.info
+		} else {
+			self.exp2NextReg(e2)
+			self.codeBinExpVal(OP_CONCAT, e1, e2, line)
+		}
+	case isArith(op):
+		if !constFolding(number.LUA_OPADD+op-oprAdd, e1, e2) {
+			self.codeBinExpVal(OP_ADD+op-oprAdd, e1, e2, line)
+		}
+	default:
+		self.codeComp(op, e1, e2)
+	}
+}
+
+/*
+设置表构造器中数组部分的元素，与 luaK_setlist 一致
+*/
+func (self *funcState) setList(base, nelems, toStore int) {
+	c := (nelems-1)/fieldsPerFlush + 1
+	b := toStore
+	if toStore == multRet {
+		b = 0
+	}
+	if c <= MAXARG_C {
+		self.codeABC(OP_SETLIST, base, b, c)
+	} else if c <= MAXARG_Ax {
+		self.codeABC(OP_SETLIST, base, b, 0)
+		self.codeExtraArg(c)
+	} else {
+		self.syntaxError("constructor too long")
+	}
+	self.freeReg = base + 1
+}
+
+/*
+把整数转换成 NEWTABLE 中使用的“浮点字节”，与 luaO_int2fb 一致
+*/
+func int2fb(x int) int {
+	e := 0
+	if x < 8 {
+		return x
+	}
+	for x >= 8<<4 {
+		x = (x + 0xf) >> 4
+		e += 4
+	}
+	for x >= 8<<1 {
+		x = (x + 1) >> 1
+		e++
+	}
+	return (e+1)<<3 | (x - 8)
+}
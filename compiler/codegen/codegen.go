@@ -0,0 +1,42 @@
+package codegen
+
+import (
+	. "lua-vm/binchunk"
+	. "lua-vm/compiler/ast"
+	"lua-vm/compiler/lexer"
+)
+
+/*
+把语法树编译成主函数的原型，生成的指令、常量表和调试信息与 luac 一致。
+source 为函数原型的 Source（如 "@foo.lua"）；出错时返回 *lexer.Error
+*/
+func GenProto(block *Block, source string) (proto *Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*lexer.Error)
+			if !ok {
+				panic(r)
+			}
+			proto, err = nil, e
+		}
+	}()
+
+	cg := &codeGen{
+		chunkName: lexer.ChunkID(source),
+		source:    source,
+		lastLine:  1,
+		line:      1,
+		h:         map[constKey]int{},
+
+		closeBodies: map[*FuncDefExp]*closeScope{},
+	}
+	var bl blockCnt
+	fs := cg.openFunc(&bl)
+	// 主函数总是 vararg 函数，并且有唯一的 Upvalue _ENV
+	fs.f.IsVararg = 1
+	env := newExp(expLocal, 0)
+	fs.newUpvalue("_ENV", &env)
+	fs.statList(block.Stats, false)
+	cg.closeFunc(block.LastLine)
+	return fs.f, nil
+}
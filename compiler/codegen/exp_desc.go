@@ -0,0 +1,57 @@
+package codegen
+
+/*
+表达式的种类，与 lparser.h 中的 expkind 一致
+*/
+const (
+	expVoid      = iota // 空表达式列表，或者表达式列表的结尾
+	expNil              // nil
+	expTrue             // true
+	expFalse            // false
+	expK                // 常量，info 为常量表索引
+	expKFlt             // 浮点数字面量，值在 nval 中
+	expKInt             // 整数字面量，值在 ival 中
+	expNonReloc         // 值已经位于寄存器 info 中
+	expLocal            // 局部变量，info 为其寄存器
+	expUpval            // Upvalue，info 为其索引
+	expIndexed          // t[idx]，见 indT、indIdx 和 indVt
+	expJmp              // 比较表达式，info 为对应的 JMP 指令
+	expRelocable        // 结果可以放入任意寄存器的指令，info 为该指令
+	expCall             // 函数调用，info 为 CALL 指令
+	expVararg           // ...，info 为 VARARG 指令
+)
+
+// 跳转链表的结尾
+const noJump = -1
+
+/*
+表达式的描述，与 expdesc 一致；t 和 f 分别为值为真和为假时的跳转链表
+*/
+type expDesc struct {
+	k    int
+	info int
+	ival int64
+	nval float64
+	// expIndexed：表所在的寄存器或 Upvalue、RK 形式的键，以及表是寄存器（expLocal）还是 Upvalue（expUpval）
+	indT   int
+	indIdx int
+	indVt  int
+	t      int
+	f      int
+}
+
+func newExp(k, info int) expDesc {
+	return expDesc{k: k, info: info, t: noJump, f: noJump}
+}
+
+func (self *expDesc) hasJumps() bool {
+	return self.t != self.f
+}
+
+func (self *expDesc) hasMultRet() bool {
+	return self.k == expCall || self.k == expVararg
+}
+
+func (self *expDesc) isVar() bool {
+	return self.k >= expLocal && self.k <= expIndexed
+}
@@ -0,0 +1,492 @@
+package codegen
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	. "lua-vm/compiler/ast"
+	"lua-vm/compiler/lexer"
+	. "lua-vm/vm"
+)
+
+// 一个函数中同时有效的局部变量的最大数量，与 MAXVARS 一致
+const maxVars = 200
+
+// Upvalue 的最大数量，与 MAXUPVAL 一致
+const maxUpvalues = 255
+
+// 寄存器的最大数量，与 MAXREGS 一致
+const maxRegs = 255
+
+/*
+整个 chunk 共享的状态，对应 LexState 和 Dyndata
+*/
+type codeGen struct {
+	chunkName string
+	source    string
+	// 上一个被“读取”的词法单元所在的行，与 lastline 一致，新生成的指令使用这个行号
+	lastLine int
+	// 当前词法单元所在的行，与 linenumber 一致，用于错误信息
+	line int
+	fs   *funcState
+	// 所有函数中当前有效的局部变量
+	actVar []varDesc
+	gotos  []labelDesc
+	labels []labelDesc
+	// 常量查找表，与 luac 一样由所有函数共享，值为常量在最近一次加入它的函数中的索引
+	h map[constKey]int
+	// close 变量之后的语句编译成的函数
+	closeBodies map[*FuncDefExp]*closeScope
+}
+
+/*
+当前有效的局部变量，与 Vardesc 一致；locVar 为局部变量在其函数 LocVars 中的索引，
+readOnly 表示 Lua 5.4 中带有 const 属性的变量
+*/
+type varDesc struct {
+	locVar   int
+	readOnly bool
+}
+
+/*
+goto 语句或标签，与 Labeldesc 一致
+*/
+type labelDesc struct {
+	name    string
+	pc      int
+	line    int
+	nactvar int
+}
+
+/*
+代码块，与 BlockCnt 一致
+*/
+type blockCnt struct {
+	previous   *blockCnt
+	firstLabel int
+	firstGoto  int
+	nactvar    int
+	upval      bool
+	isLoop     bool
+}
+
+/*
+正在生成的函数，与 FuncState 一致
+*/
+type funcState struct {
+	*codeGen
+	prev       *funcState
+	f          *Prototype
+	bl         *blockCnt
+	lastTarget int
+	jpc        int
+	firstLocal int
+	nactvar    int
+	freeReg    int
+	// 与 f.Upvalues 一一对应，表示 Upvalue 引用的是否为 const 变量
+	readOnlyUpvals []bool
+	// 不为 nil 时表示函数由 close 变量之后的语句编译而成
+	closing *closeScope
+}
+
+func (self *codeGen) openFunc(bl *blockCnt) *funcState {
+	fs := &funcState{
+		codeGen:    self,
+		prev:       self.fs,
+		f:          &Prototype{Source: self.source, MaxStackSize: 2},
+		jpc:        noJump,
+		firstLocal: len(self.actVar),
+	}
+	self.fs = fs
+	fs.enterBlock(bl, false)
+	return fs
+}
+
+/*
+结束当前函数，line 为函数之后的词法单元所在的行
+*/
+func (self *codeGen) closeFunc(line int) {
+	fs := self.fs
+	self.line = line
+	fs.ret(0, 0)
+	fs.leaveBlock()
+	self.fs = fs.prev
+}
+
+/*
+抛出编译错误，与 semerror 一样不带 near 部分
+*/
+func (self *codeGen) semError(line int, msg string) {
+	panic(&lexer.Error{Chunk: self.chunkName, Line: line, Msg: msg})
+}
+
+func (self *codeGen) syntaxError(msg string) {
+	self.semError(self.line, msg)
+}
+
+/*
+“读取”一个位于 line 行的词法单元
+*/
+func (self *codeGen) setLine(line int) {
+	self.lastLine = line
+	self.line = line
+}
+
+/*
+与 errorlimit 一致，报告超出限制的错误
+*/
+func (self *funcState) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := self.f.LineDefined; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	self.syntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (self *funcState) checkLimit(v, limit int, what string) {
+	if v > limit {
+		self.errorLimit(limit, what)
+	}
+}
+
+func (self *funcState) pc() int {
+	return len(self.f.Code)
+}
+
+/* 局部变量 */
+
+func (self *funcState) registerLocalVar(name string) int {
+	self.f.LocVars = append(self.f.LocVars, LocVar{VarName: name})
+	return len(self.f.LocVars) - 1
+}
+
+/*
+声明一个局部变量，它在 adjustLocalVars 之后才开始有效
+*/
+func (self *funcState) newLocalVar(name string) {
+	reg := self.registerLocalVar(name)
+	self.checkLimit(len(self.actVar)+1-self.firstLocal, maxVars, "local variables")
+	self.actVar = append(self.actVar, varDesc{locVar: reg})
+}
+
+/*
+声明一个带有 const 属性的局部变量，它不能被赋值
+*/
+func (self *funcState) newConstVar(name string) {
+	self.newLocalVar(name)
+	self.actVar[len(self.actVar)-1].readOnly = true
+}
+
+func (self *funcState) getVarDesc(i int) *varDesc {
+	return &self.actVar[self.firstLocal+i]
+}
+
+func (self *funcState) getLocVar(i int) *LocVar {
+	return &self.f.LocVars[self.getVarDesc(i).locVar]
+}
+
+/*
+让最近声明的 n 个局部变量开始有效
+*/
+func (self *funcState) adjustLocalVars(n int) {
+	self.nactvar += n
+	for ; n > 0; n-- {
+		self.getLocVar(self.nactvar - n).StartPc = uint32(self.pc())
+	}
+}
+
+func (self *funcState) removeVars(toLevel int) {
+	n := self.nactvar - toLevel
+	for self.nactvar > toLevel {
+		self.nactvar--
+		self.getLocVar(self.nactvar).EndPc = uint32(self.pc())
+	}
+	self.actVar = self.actVar[:len(self.actVar)-n]
+}
+
+/* 变量查找 */
+
+func (self *funcState) searchUpvalue(name string) int {
+	for i, n := range self.f.UpvalueNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (self *funcState) newUpvalue(name string, v *expDesc) int {
+	self.checkLimit(len(self.f.Upvalues)+1, maxUpvalues, "upvalues")
+	instack := byte(0)
+	readOnly := false
+	if v.k == expLocal {
+		instack = 1
+		// 主函数的 _ENV 没有外层函数
+		readOnly = self.prev != nil && self.prev.getVarDesc(v.info).readOnly
+	} else {
+		readOnly = self.prev.readOnlyUpvals[v.info]
+	}
+	self.f.Upvalues = append(self.f.Upvalues, Upvalue{Instack: instack, Idx: byte(v.info)})
+	self.f.UpvalueNames = append(self.f.UpvalueNames, name)
+	self.readOnlyUpvals = append(self.readOnlyUpvals, readOnly)
+	return len(self.f.Upvalues) - 1
+}
+
+func (self *funcState) searchVar(name string) int {
+	for i := self.nactvar - 1; i >= 0; i-- {
+		if self.getLocVar(i).VarName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+被赋值的变量不能是 const 变量，与 check_readonly 一致
+*/
+func (self *funcState) checkReadOnly(e *expDesc) {
+	name := ""
+	switch e.k {
+	case expLocal:
+		if self.getVarDesc(e.info).readOnly {
+			name = self.getLocVar(e.info).VarName
+		}
+	case expUpval:
+		if self.readOnlyUpvals[e.info] {
+			name = self.f.UpvalueNames[e.info]
+		}
+	}
+	if name != "" {
+		self.syntaxError(fmt.Sprintf("attempt to assign to const variable '%s'", name))
+	}
+}
+
+/*
+标记寄存器 level 上的局部变量被用作 Upvalue，离开它所在的代码块时需要关闭 Upvalue
+*/
+func (self *funcState) markUpval(level int) {
+	bl := self.bl
+	for bl.nactvar > level {
+		bl = bl.previous
+	}
+	bl.upval = true
+}
+
+/*
+按照局部变量、已有的 Upvalue、外层函数的顺序查找变量，与 singlevaraux 一致
+*/
+func singleVarAux(fs *funcState, name string, base bool) expDesc {
+	if fs == nil {
+		return newExp(expVoid, 0)
+	}
+	if v := fs.searchVar(name); v >= 0 {
+		if !base {
+			fs.markUpval(v)
+		}
+		return newExp(expLocal, v)
+	}
+	idx := fs.searchUpvalue(name)
+	if idx < 0 {
+		v := singleVarAux(fs.prev, name, false)
+		if v.k == expVoid {
+			return v
+		}
+		idx = fs.newUpvalue(name, &v)
+	}
+	return newExp(expUpval, idx)
+}
+
+/*
+变量表达式，全局变量被转换成 _ENV.name
+*/
+func (self *funcState) singleVar(name string) expDesc {
+	v := singleVarAux(self, name, true)
+	if v.k == expVoid {
+		v = singleVarAux(self, "_ENV", true)
+		key := self.stringExp(name)
+		self.indexed(&v, &key)
+	}
+	return v
+}
+
+/* 代码块 */
+
+func (self *funcState) enterBlock(bl *blockCnt, isLoop bool) {
+	*bl = blockCnt{
+		previous:   self.bl,
+		firstLabel: len(self.labels),
+		firstGoto:  len(self.gotos),
+		nactvar:    self.nactvar,
+		isLoop:     isLoop,
+	}
+	self.bl = bl
+}
+
+func (self *funcState) leaveBlock() {
+	bl := self.bl
+	if bl.previous != nil && bl.upval {
+		// 跳转到下一条指令，用于关闭 Upvalue
+		j := self.jump()
+		self.patchClose(j, bl.nactvar)
+		self.patchToHere(j)
+	}
+	if bl.isLoop {
+		self.breakLabel()
+	}
+	self.bl = bl.previous
+	self.removeVars(bl.nactvar)
+	self.freeReg = self.nactvar
+	self.labels = self.labels[:bl.firstLabel]
+	if bl.previous != nil {
+		self.moveGotosOut(bl)
+	} else if self.closing != nil {
+		self.closeGotos(bl)
+	} else if bl.firstGoto < len(self.gotos) {
+		self.undefGoto(&self.gotos[bl.firstGoto])
+	}
+}
+
+/* goto 和标签 */
+
+func (self *funcState) newLabelEntry(list *[]labelDesc, name string, line, pc int) int {
+	*list = append(*list, labelDesc{name: name, line: line, nactvar: self.nactvar, pc: pc})
+	return len(*list) - 1
+}
+
+/*
+把第 g 个未决的 goto 连接到标签上
+*/
+func (self *funcState) closeGoto(g int, label *labelDesc) {
+	gt := self.gotos[g]
+	if gt.nactvar < label.nactvar {
+		name := self.getLocVar(gt.nactvar).VarName
+		self.semError(self.line, fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, name))
+	}
+	self.patchList(gt.pc, label.pc)
+	self.gotos = append(self.gotos[:g], self.gotos[g+1:]...)
+}
+
+/*
+在当前代码块中查找第 g 个 goto 的目标标签，找到时连接它们
+*/
+func (self *funcState) findLabel(g int) bool {
+	bl := self.bl
+	gt := &self.gotos[g]
+	for i := bl.firstLabel; i < len(self.labels); i++ {
+		lb := &self.labels[i]
+		if lb.name == gt.name {
+			if gt.nactvar > lb.nactvar && (bl.upval || len(self.labels) > bl.firstLabel) {
+				self.patchClose(gt.pc, lb.nactvar)
+			}
+			self.closeGoto(g, lb)
+			return true
+		}
+	}
+	return false
+}
+
+/*
+同一个代码块中不能有同名的标签，与 checkrepeated 一致
+*/
+func (self *funcState) checkRepeated(name string) {
+	for i := self.bl.firstLabel; i < len(self.labels); i++ {
+		if lb := &self.labels[i]; lb.name == name {
+			self.syntaxError(fmt.Sprintf("label '%s' already defined on line %d", name, lb.line))
+		}
+	}
+}
+
+/*
+把当前代码块中所有跳转到标签 lb 的 goto 连接到它
+*/
+func (self *funcState) findGotos(lb *labelDesc) {
+	for i := self.bl.firstGoto; i < len(self.gotos); {
+		if self.gotos[i].name == lb.name {
+			self.closeGoto(i, lb)
+		} else {
+			i++
+		}
+	}
+}
+
+/*
+离开代码块时，把其中未决的 goto 移到外层代码块，并尝试用外层可见的标签连接它们
+*/
+func (self *funcState) moveGotosOut(bl *blockCnt) {
+	for i := bl.firstGoto; i < len(self.gotos); {
+		gt := &self.gotos[i]
+		if gt.nactvar > bl.nactvar {
+			if bl.upval {
+				self.patchClose(gt.pc, bl.nactvar)
+			}
+			gt.nactvar = bl.nactvar
+		}
+		if !self.findLabel(i) {
+			i++
+		}
+	}
+}
+
+/*
+循环结束的位置，是所有 break 的目标
+*/
+func (self *funcState) breakLabel() {
+	l := self.newLabelEntry(&self.labels, "break", 0, self.pc())
+	self.findGotos(&self.labels[l])
+}
+
+func (self *funcState) undefGoto(gt *labelDesc) {
+	msg := fmt.Sprintf("no visible label '%s' for <goto> at line %d", gt.name, gt.line)
+	if gt.name == "break" {
+		msg = fmt.Sprintf("<%s> at line %d not inside a loop", gt.name, gt.line)
+	}
+	self.semError(self.line, msg)
+}
+
+/* 寄存器 */
+
+func (self *funcState) checkStack(n int) {
+	if newStack := self.freeReg + n; newStack > int(self.f.MaxStackSize) {
+		if newStack >= maxRegs {
+			self.syntaxError("function or expression needs too many registers")
+		}
+		self.f.MaxStackSize = byte(newStack)
+	}
+}
+
+func (self *funcState) reserveRegs(n int) {
+	self.checkStack(n)
+	self.freeReg += n
+}
+
+func (self *funcState) freeRegister(reg int) {
+	if reg >= 0 && !ISK(reg) && reg >= self.nactvar {
+		self.freeReg--
+	}
+}
+
+func (self *funcState) freeExp(e *expDesc) {
+	if e.k == expNonReloc {
+		self.freeRegister(e.info)
+	}
+}
+
+/*
+按照从高到低的顺序释放两个表达式占用的寄存器
+*/
+func (self *funcState) freeExps(e1, e2 *expDesc) {
+	r1, r2 := -1, -1
+	if e1.k == expNonReloc {
+		r1 = e1.info
+	}
+	if e2.k == expNonReloc {
+		r2 = e2.info
+	}
+	if r1 > r2 {
+		self.freeRegister(r1)
+		self.freeRegister(r2)
+	} else {
+		self.freeRegister(r2)
+		self.freeRegister(r1)
+	}
+}
@@ -0,0 +1,78 @@
+package codegen
+
+import . "lua-vm/compiler/lexer"
+
+/*
+二元运算符，与 lcode.h 中的 BinOpr 一致，算术和位运算的顺序与对应的操作码相同
+*/
+const (
+	oprAdd = iota
+	oprSub
+	oprMul
+	oprMod
+	oprPow
+	oprDiv
+	oprIDiv
+	oprBAnd
+	oprBOr
+	oprBXor
+	oprShl
+	oprShr
+	oprConcat
+	oprEQ
+	oprLT
+	oprLE
+	oprNE
+	oprGT
+	oprGE
+	oprAnd
+	oprOr
+)
+
+/*
+一元运算符，与 UnOpr 一致
+*/
+const (
+	oprMinus = iota
+	oprBNot
+	oprNot
+	oprLen
+)
+
+var binops = map[int]int{
+	TOKEN_OP_ADD:    oprAdd,
+	TOKEN_OP_SUB:    oprSub,
+	TOKEN_OP_MUL:    oprMul,
+	TOKEN_OP_MOD:    oprMod,
+	TOKEN_OP_POW:    oprPow,
+	TOKEN_OP_DIV:    oprDiv,
+	TOKEN_OP_IDIV:   oprIDiv,
+	TOKEN_OP_BAND:   oprBAnd,
+	TOKEN_OP_BOR:    oprBOr,
+	TOKEN_OP_BXOR:   oprBXor,
+	TOKEN_OP_SHL:    oprShl,
+	TOKEN_OP_SHR:    oprShr,
+	TOKEN_OP_CONCAT: oprConcat,
+	TOKEN_OP_EQ:     oprEQ,
+	TOKEN_OP_LT:     oprLT,
+	TOKEN_OP_LE:     oprLE,
+	TOKEN_OP_NE:     oprNE,
+	TOKEN_OP_GT:     oprGT,
+	TOKEN_OP_GE:     oprGE,
+	TOKEN_OP_AND:    oprAnd,
+	TOKEN_OP_OR:     oprOr,
+}
+
+var unops = map[int]int{
+	TOKEN_OP_UNM:  oprMinus,
+	TOKEN_OP_BNOT: oprBNot,
+	TOKEN_OP_NOT:  oprNot,
+	TOKEN_OP_LEN:  oprLen,
+}
+
+/*
+算术运算和位运算
+*/
+func isArith(op int) bool {
+	return op <= oprShr
+}
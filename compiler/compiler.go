@@ -0,0 +1,19 @@
+package compiler
+
+import (
+	. "lua-vm/binchunk"
+	"lua-vm/compiler/codegen"
+	"lua-vm/compiler/parser"
+)
+
+/*
+把 Lua 源代码编译成主函数的原型，与 luac 的输出一致；
+source 为函数原型的 Source（如 "@foo.lua"），出错时返回 *lexer.Error
+*/
+func Compile(chunk, source string) (*Prototype, error) {
+	block, err := parser.Parse(chunk, source)
+	if err != nil {
+		return nil, err
+	}
+	return codegen.GenProto(block, source)
+}
@@ -0,0 +1,340 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler/lexer"
+	. "lua-vm/vm"
+	"strings"
+	"testing"
+)
+
+func TestGoto(t *testing.T) {
+	// 跳出带有 Upvalue 的代码块时 JMP 的 A 关闭 x 所在的寄存器
+	checkCode(t, "do\n  local x = 1\n  f = function() return x end\n  goto out\nend\n::out::\n",
+		"LOADK 0 -1 ; 1",
+		"CLOSURE 1 0 ; function",
+		`SETTABUP 0 -2 1 ; _ENV "f"`,
+		"JMP 1 1 ; to 6",
+		"JMP 1 0 ; to 6",
+		"RETURN 0 1")
+	checkCode(t, "::top:: local x = 1 f = function() return x end goto top",
+		"LOADK 0 -1 ; 1",
+		"CLOSURE 1 0 ; function",
+		`SETTABUP 0 -2 1 ; _ENV "f"`,
+		"JMP 1 -4 ; to 1",
+		"RETURN 0 1")
+	// 代码块末尾的标签不在局部变量的作用域之内，与 luac 一样跳转到循环开始的位置
+	checkCode(t, "while true do goto continue local z = 1 ::continue:: end",
+		"JMP 0 -1 ; to 1",
+		"LOADK 0 -1 ; 1",
+		"JMP 0 -3 ; to 1",
+		"RETURN 0 1")
+	checkCode(t, "do goto l end local x ::l::",
+		"JMP 0 1 ; to 3",
+		"LOADNIL 0 0",
+		"RETURN 0 1")
+}
+
+func TestGotoErrors(t *testing.T) {
+	tests := []struct{ src, msg string }{
+		{"goto l local x ::l:: print(x)", "test.lua:1: <goto l> at line 1 jumps into the scope of local 'x'"},
+		{"repeat goto cont local a ::cont:: until a", "test.lua:1: <goto cont> at line 1 jumps into the scope of local 'a'"},
+		{"goto l do ::l:: end", "test.lua:1: no visible label 'l' for <goto> at line 1"},
+		{"::a:: ::a::", "test.lua:1: label 'a' already defined on line 1"},
+		{"::a:: do ::a:: end", ""},
+		{"for i = 1, 2 do ::l:: end ::l::", ""},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, "@test.lua")
+		if tt.msg == "" && err != nil {
+			t.Errorf("compile %q: %v", tt.src, err)
+		} else if tt.msg != "" && (err == nil || err.Error() != tt.msg) {
+			t.Errorf("compile %q: got error %v, want %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		src      string
+		column   int
+		token    string
+		expected string
+		opener   string
+		render   string
+	}{
+		{"local function f()\n  if x then\n    y = 1\n\n", 1, "<eof>", "'end'", "'if' 2",
+			"test.lua:5: 'end' expected (to close 'if' at line 2) near <eof>\n" +
+				"2 |   if x then\n" +
+				"  | ...\n" +
+				"5 |\n" +
+				"  | ^\n"},
+		{"\tx = 'abc\n", 6, "''abc'", "", " 0",
+			"test.lua:1: unfinished string near ''abc'\n" +
+				"1 | \tx = 'abc\n" +
+				"  | \t    ^~~~\n"},
+		{"for i = 1 do end", 11, "'do'", "','", " 0",
+			"test.lua:1: ',' expected near 'do'\n" +
+				"1 | for i = 1 do end\n" +
+				"  |           ^~\n"},
+		// 代码生成阶段的错误没有 near 部分，也没有列号
+		{"x = 1\nbreak\n", 0, "", "", " 0",
+			"test.lua:3: <break> at line 2 not inside a loop\n" +
+				"3 |\n"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, "@test.lua")
+		e, ok := err.(*lexer.Error)
+		if !ok {
+			t.Errorf("compile %q: got error %v (%T), want *lexer.Error", tt.src, err, err)
+			continue
+		}
+		opener := fmt.Sprintf("%s %d", e.Opener, e.OpenerLine)
+		if e.Column != tt.column || e.Token != tt.token || strings.Join(e.Expected, " ") != tt.expected || opener != tt.opener {
+			t.Errorf("compile %q: got %+v", tt.src, *e)
+		}
+		if r := e.Render(tt.src); r != tt.render {
+			t.Errorf("compile %q: got rendering\n%s\nwant\n%s", tt.src, r, tt.render)
+		}
+	}
+}
+
+func TestConstVariable(t *testing.T) {
+	// const 变量可以读取，也可以修改它引用的表，被同名的局部变量遮蔽之后可以赋值
+	ok := []string{
+		"local k <const> = {} k.x = 1 return k",
+		"local k <const> = 1 local function g() return k end",
+		"local k <const> = 1 do local k = 2 k = 3 end",
+		"local x <const>",
+	}
+	for _, src := range ok {
+		if _, err := Compile(src, "@test.lua"); err != nil {
+			t.Errorf("compile %q: %v", src, err)
+		}
+	}
+
+	errors := []string{
+		"local k <const> = 1 k = 2",
+		"local k <const> = 1 function f() k = 2 end",
+		"local a, k <const> = 1, 2 a, k = 3, 4",
+		"local k <const> = 1 for i = 1, 2 do k = i end",
+	}
+	for _, src := range errors {
+		_, err := Compile(src, "@test.lua")
+		if err == nil || err.Error() != "test.lua:1: attempt to assign to const variable 'k'" {
+			t.Errorf("compile %q: got error %v", src, err)
+		}
+	}
+}
+
+func TestCloseVariable(t *testing.T) {
+	src := `local function closer() return setmetatable({}, {__close = function() end}) end
+local function f(...)
+  local a, x <close>, b = 1, closer(), ...
+  for i = 1, 3 do
+    local y <close> = closer()
+    if i == 2 then break end
+    if i == 3 then goto done end
+  end
+  ::done::
+  return a, b
+end
+repeat local z <close> = closer() local stop = true until stop
+`
+	proto, err := Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if err := Verify(proto); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// f 中 close 变量之后的语句编译成传给 pcall 的函数，return 返回编号 1 和打包之后的返回值
+	f := proto.Protos[1]
+	if len(f.Protos) != 1 {
+		t.Fatalf("f has %d functions, want 1", len(f.Protos))
+	}
+	body := f.Protos[0]
+	if body.IsVararg == 0 {
+		t.Errorf("body of a vararg function is not vararg")
+	}
+	for _, name := range []string{"pcall", "getmetatable", "__close", "error"} {
+		if !hasString(f, name) {
+			t.Errorf("f does not use %q", name)
+		}
+	}
+	if !hasString(body, "pack") {
+		t.Errorf("return in the body does not pack its values")
+	}
+	// 循环中的 break 和 goto 跳出了 y 之后的语句，分别返回编号 2 和 3
+	loop := body.Protos[0]
+	for _, tag := range []int64{2, 3} {
+		found := false
+		for pc := 0; pc+1 < len(loop.Code); pc++ {
+			i, next := Instruction(loop.Code[pc]), Instruction(loop.Code[pc+1])
+			if _, bx := i.ABx(); i.Opcode() == OP_LOADK && loop.Constants[bx].Value == tag && next.Opcode() == OP_RETURN {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no return of tag %d in\n%v", tag, loop.Code)
+		}
+	}
+}
+
+func TestCloseVariableErrors(t *testing.T) {
+	tests := []struct{ src, msg string }{
+		{"local x <close> = nil\nx = 1\n", "test.lua:2: attempt to assign to const variable 'x'"},
+		{"local x <close>, y <close> = nil\n", "multiple to-be-closed variables in local list"},
+		{"do local x <close> = nil goto nowhere end\n", "no visible label 'nowhere' for <goto> at line 1"},
+		{"do local x <close> = nil break end\n", "<break> at line 1 not inside a loop"},
+		{"local function f() local x <close> = nil return ... end\n", "cannot use '...' outside a vararg function"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, "@test.lua")
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("compile %q: got error %v, want %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+/*
+编译 src 并返回主函数的指令，每条指令的各部分之间只有一个空格，子函数的地址为 function
+*/
+func compileCode(t *testing.T, src string) []string {
+	t.Helper()
+	proto, err := Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile %q: %v", src, err)
+	}
+	if err := Verify(proto); err != nil {
+		t.Fatalf("verify %q: %v", src, err)
+	}
+	code := make([]string, len(proto.Code))
+	opts := DisasmOptions{Address: func(*Prototype) string { return "function" }}
+	for pc := range proto.Code {
+		code[pc] = strings.Join(strings.Fields(FormatInstruction(proto, pc, opts)), " ")
+	}
+	return code
+}
+
+func checkCode(t *testing.T, src string, want ...string) {
+	t.Helper()
+	code := compileCode(t, src)
+	if strings.Join(code, "\n") != strings.Join(want, "\n") {
+		t.Errorf("compile %q:\ngot:\n%s\nwant:\n%s", src, strings.Join(code, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCodeGen(t *testing.T) {
+	// 与 luac 5.3 生成的指令相同
+	checkCode(t, "local a, b = 1 local c = a + b * 2 return c",
+		"LOADK 0 -1 ; 1",
+		"LOADNIL 1 0",
+		"MUL 2 1 -2 ; - 2",
+		"ADD 2 0 2",
+		"RETURN 2 2",
+		"RETURN 0 1")
+	checkCode(t, "local t = {} t.x = t.y return #t",
+		"NEWTABLE 0 0 0",
+		`GETTABLE 1 0 -2 ; "y"`,
+		`SETTABLE 0 -1 1 ; "x" -`,
+		"LEN 1 0",
+		"RETURN 1 2",
+		"RETURN 0 1")
+	checkCode(t, "local i = 0 while i < 10 do i = i + 1 end",
+		"LOADK 0 -1 ; 0",
+		"LT 0 0 -2 ; - 10",
+		"JMP 0 2 ; to 6",
+		"ADD 0 0 -3 ; - 1",
+		"JMP 0 -4 ; to 2",
+		"RETURN 0 1")
+	checkCode(t, "local a = {...} return a[1], select('#', ...)",
+		"NEWTABLE 0 0 0",
+		"VARARG 1 0",
+		"SETLIST 0 0 1 ; 1",
+		"GETTABLE 1 0 -1 ; 1",
+		`GETTABUP 2 0 -2 ; _ENV "select"`,
+		`LOADK 3 -3 ; "#"`,
+		"VARARG 4 0",
+		"CALL 2 0 0",
+		"RETURN 1 0",
+		"RETURN 0 1")
+}
+
+func TestCodeGenRoundTrip(t *testing.T) {
+	// Dump 之后再 Undump 得到相同的函数原型
+	src := "local function f(a, ...) local t = {a, ...} return #t end\nprint(f(1, 2.5, 'x'))\n"
+	proto, err := Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	data := Dump(proto)
+	again := Undump(data)
+	if !bytes.Equal(Dump(again), data) {
+		t.Errorf("chunk changed after Undump")
+	}
+}
+
+func TestCodeGenErrors(t *testing.T) {
+	tests := []struct{ src, msg string }{
+		{"break", "test.lua:1: <break> at line 1 not inside a loop"},
+		{"local function f() return ... end", "test.lua:1: cannot use '...' outside a vararg function near '...'"},
+		{"local a" + strings.Repeat(", a", 200), "test.lua:1: too many local variables (limit is 200) in main function"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, "@test.lua")
+		if err == nil || !strings.HasPrefix(err.Error(), tt.msg) {
+			t.Errorf("compile %.30q: got error %v, want %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	checkCode(t, "x = 1 + 2 * 3 - 4 / 2",
+		`SETTABUP 0 -1 -2 ; _ENV "x" 5.0`,
+		"RETURN 0 1")
+	// 浮点数与整数的位运算先转换成整数
+	checkCode(t, "local x = 2^53 + (1 << 4) | 3",
+		"LOADK 0 -1 ; 9007199254741011",
+		"RETURN 0 1")
+	checkCode(t, "x = -(-9223372036854775807 - 1) x = 3 % -2",
+		`SETTABUP 0 -1 -2 ; _ENV "x" -9223372036854775808`,
+		`SETTABUP 0 -1 -3 ; _ENV "x" -1`,
+		"RETURN 0 1")
+	// 除以 0 不折叠，操作数按照先右后左的顺序加入常量表
+	checkCode(t, "x = 1 // 0",
+		"IDIV 0 -3 -2 ; 1 0",
+		`SETTABUP 0 -1 0 ; _ENV "x"`,
+		"RETURN 0 1")
+	checkCode(t, "x = 0 / 0",
+		"DIV 0 -2 -2 ; 0 0",
+		`SETTABUP 0 -1 0 ; _ENV "x"`,
+		"RETURN 0 1")
+	// 运行时才能确定结果或者会出错的运算不折叠
+	checkCode(t, "x = 1.5 | 0",
+		"BOR 0 -3 -2 ; 1.5 0",
+		`SETTABUP 0 -1 0 ; _ENV "x"`,
+		"RETURN 0 1")
+	// 只有字符串字面量的连接才会折叠，数字转换成字符串的格式留给运行时
+	checkCode(t, "local s = 'a' .. 'b' .. 'c' local n = not 'x' local m = -'2' local u = 'd' .. 1",
+		`LOADK 0 -1 ; "abc"`,
+		"LOADBOOL 1 0 0",
+		`LOADK 2 -3 ; "2"`,
+		"UNM 2 2",
+		`LOADK 3 -4 ; "d"`,
+		"LOADK 4 -5 ; 1",
+		"CONCAT 3 3 4",
+		"RETURN 0 1")
+}
+
+func hasString(f *Prototype, s string) bool {
+	for _, k := range f.Constants {
+		if k.Value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package format
+
+import (
+	"lua-vm/compiler/lexer"
+	"lua-vm/compiler/parser"
+	"strings"
+)
+
+/*
+格式化 Lua 源代码：统一缩进（制表符）、运算符和逗号两边的空格、字符串的引号以及表构造器的布局，
+保留所有的注释和语句之间的单个空行。结果是幂等的，即再次格式化不会有任何变化。
+source 为函数原型的 Source（如 "@foo.lua"），用于生成错误信息；源代码有语法错误时返回 *lexer.Error
+*/
+func Source(chunk, source string) (string, error) {
+	// 与 luaL_loadfile 一样，以 # 开头的第一行（如 #!/usr/bin/lua）不是 Lua 代码，原样保留
+	header := ""
+	if strings.HasPrefix(chunk, "#") {
+		end := strings.IndexByte(chunk, '\n')
+		if end < 0 {
+			end = len(chunk)
+		}
+		header, chunk = chunk[:end]+"\n", chunk[end:]
+	}
+
+	block, err := parser.Parse(chunk, source)
+	if err != nil {
+		return "", err
+	}
+	comments := scanComments(chunk, source)
+
+	p := &printer{comments: comments, lineStart: true}
+	p.stats(block.Stats, maxLine)
+	p.commentsBefore(maxLine)
+	out := strings.TrimRight(p.b.String(), "\n")
+	if out != "" {
+		out += "\n"
+	}
+	return header + out, nil
+}
+
+/*
+读取源代码中的所有注释，源代码已经通过了语法检查，所以不会出错
+*/
+func scanComments(chunk, source string) []lexer.Comment {
+	lx := lexer.NewLexer(chunk, source)
+	for lx.NextToken().Kind != lexer.TOKEN_EOF {
+	}
+	return lx.Comments()
+}
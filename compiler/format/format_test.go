@@ -0,0 +1,115 @@
+package format
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+const messy = `#!/usr/bin/lua
+local   t={1,2,x=3;["y"]=4}  -- table
+
+
+
+function t.f(a,b)   if a==b then return a+b*2 else return {a,b} end end
+local s='it\'s'..[[long]]
+print( s , t [1] ,- -1, not not x, 2^-3)
+--[[ block
+ comment ]]
+while x do x=x-1 end
+`
+
+const formatted = `#!/usr/bin/lua
+local t = {1, 2, x = 3, ["y"] = 4} -- table
+
+function t.f(a, b)
+	if a == b then
+		return a + b * 2
+	else
+		return {a, b}
+	end
+end
+local s = "it's" .. [[long]]
+print(s, t[1], - -1, not not x, 2 ^ -3)
+--[[ block
+ comment ]]
+while x do
+	x = x - 1
+end
+`
+
+func TestSource(t *testing.T) {
+	out, err := Source(messy, "@test.lua")
+	if err != nil {
+		t.Fatalf("Source: %v", err)
+	}
+	if out != formatted {
+		t.Errorf("got\n%s\nwant\n%s", out, formatted)
+	}
+}
+
+/*
+不含行号的指令和常量，包括所有的子函数
+*/
+func code(t *testing.T, src string) string {
+	t.Helper()
+	// 与 luaL_loadfile 一样跳过 # 开头的第一行
+	if strings.HasPrefix(src, "#") {
+		src = "--" + src
+	}
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v\n%s", err, src)
+	}
+	var b strings.Builder
+	opts := DisasmOptions{Address: func(*Prototype) string { return "function" }}
+	var list func(f *Prototype)
+	list = func(f *Prototype) {
+		for pc := range f.Code {
+			fmt.Fprintln(&b, FormatInstruction(f, pc, opts))
+		}
+		for _, k := range f.Constants {
+			fmt.Fprintf(&b, "K %d %v\n", k.Tag, k.Value)
+		}
+		for _, p := range f.Protos {
+			list(p)
+		}
+	}
+	list(proto)
+	return b.String()
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+	// 格式化之后的代码与原来的代码编译得到相同的字节码，再次格式化不会有任何变化
+	tests := []string{
+		messy,
+		"local a,b=...\nif a then elseif b then;;else end\nreturn(a or b)and 1,#{...}\n",
+		"local t={[1]=1,'two',3.0,0x10,1e100,[[\nlong\n]],f(),g(...)}\nfor k,v in pairs(t)do print(k,v)end\n",
+		"local function f(...)local x<const> =1 return function(...)return x+...end end\n",
+		"do goto l end ::l:: repeat local y=1 until y\nx=-2^2 x=(-2)^2 x=2^-2 x=a..b..c x=(a..b)..c\n",
+		"-- lead\nlocal x = 1 -- trail\n\n\n--[==[ long\n]==] print(x)\n",
+	}
+	for _, src := range tests {
+		out, err := Source(src, "@test.lua")
+		if err != nil {
+			t.Errorf("Source(%q): %v", src, err)
+			continue
+		}
+		if want, got := code(t, src), code(t, out); want != got {
+			t.Errorf("code changed after formatting %q:\n%s\nwant:\n%s\ngot:\n%s", src, out, want, got)
+		}
+		again, err := Source(out, "@test.lua")
+		if err != nil || again != out {
+			t.Errorf("not idempotent for %q:\n%s\nthen:\n%s (%v)", src, out, again, err)
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("local x = \nif", "@test.lua")
+	if err == nil || err.Error() != "test.lua:2: unexpected symbol near 'if'" {
+		t.Errorf("got error %v", err)
+	}
+}
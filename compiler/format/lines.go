@@ -0,0 +1,214 @@
+package format
+
+import (
+	. "lua-vm/compiler/ast"
+	"strings"
+)
+
+/*
+语句第一个词法单元所在的行
+*/
+func statLine(node Stat) int {
+	switch stat := node.(type) {
+	case *FuncCallStat:
+		return firstExpLine(stat)
+	case *EmptyStat:
+		return stat.Line
+	case *BreakStat:
+		return stat.Line
+	case *LabelStat:
+		return stat.Line
+	case *GotoStat:
+		return stat.Line
+	case *DoStat:
+		return stat.Line
+	case *WhileStat:
+		return stat.Line
+	case *RepeatStat:
+		return stat.Line
+	case *IfStat:
+		return stat.Line
+	case *ForNumStat:
+		return stat.Line
+	case *ForInStat:
+		return stat.Line
+	case *LocalVarDeclStat:
+		return stat.Line
+	case *AssignStat:
+		return stat.Line
+	case *LocalFuncDefStat:
+		return stat.Line
+	case *FuncDefStat:
+		return stat.Line
+	case *ReturnStat:
+		return stat.Line
+	default:
+		panic("unreachable")
+	}
+}
+
+/*
+语句最后一个词法单元所在的行
+*/
+func lastStatLine(node Stat) int {
+	switch stat := node.(type) {
+	case *FuncCallStat:
+		return stat.LastLine
+	case *EmptyStat:
+		return stat.Line
+	case *BreakStat:
+		return stat.Line
+	case *LabelStat:
+		return stat.Line
+	case *GotoStat:
+		return stat.Line
+	case *DoStat:
+		return stat.LastLine
+	case *WhileStat:
+		return stat.LastLine
+	case *RepeatStat:
+		return lastExpLine(stat.Cond)
+	case *IfStat:
+		return stat.LastLine
+	case *ForNumStat:
+		return stat.LastLine
+	case *ForInStat:
+		return stat.LastLine
+	case *LocalVarDeclStat:
+		return stat.LastLine
+	case *AssignStat:
+		return stat.LastLine
+	case *LocalFuncDefStat:
+		return stat.Func.LastLine
+	case *FuncDefStat:
+		return stat.Func.LastLine
+	case *ReturnStat:
+		return stat.LastLine
+	default:
+		panic("unreachable")
+	}
+}
+
+/*
+表达式第一个词法单元所在的行
+*/
+func firstExpLine(node Exp) int {
+	switch exp := node.(type) {
+	case *TableAccessExp:
+		return firstExpLine(exp.PrefixExp)
+	case *BinopExp:
+		return firstExpLine(exp.Exp1)
+	case *FuncCallExp:
+		return firstExpLine(exp.PrefixExp)
+	default:
+		return lineOf(exp)
+	}
+}
+
+/*
+表达式最后一个词法单元所在的行
+*/
+func lastExpLine(node Exp) int {
+	switch exp := node.(type) {
+	case *UnopExp:
+		return lastExpLine(exp.Exp)
+	case *BinopExp:
+		return lastExpLine(exp.Exp2)
+	case *ParensExp:
+		return exp.LastLine
+	case *TableAccessExp:
+		return exp.LastLine
+	case *FuncCallExp:
+		return exp.LastLine
+	case *TableConstructorExp:
+		return exp.LastLine
+	case *FuncDefExp:
+		return exp.LastLine
+	default:
+		return lineOf(exp)
+	}
+}
+
+func lineOf(node Exp) int {
+	switch exp := node.(type) {
+	case *NilExp:
+		return exp.Line
+	case *TrueExp:
+		return exp.Line
+	case *FalseExp:
+		return exp.Line
+	case *VarargExp:
+		return exp.Line
+	case *IntegerExp:
+		return exp.Line
+	case *FloatExp:
+		return exp.Line
+	case *StringExp:
+		return exp.Line
+	case *NameExp:
+		return exp.Line
+	case *UnopExp:
+		return exp.Line
+	case *ParensExp:
+		return exp.Line
+	case *TableConstructorExp:
+		return exp.Line
+	case *FuncDefExp:
+		return exp.Line
+	default:
+		panic("unreachable")
+	}
+}
+
+/*
+统一短字符串的引号：默认使用双引号，字符串中的双引号比单引号多时使用单引号；
+\" 和 \' 先还原再按照新的引号重新转义，其余的转义序列原样保留。长字符串原样输出
+*/
+func quote(text string) string {
+	if text == "" || text[0] == '[' {
+		return text
+	}
+	body := text[1 : len(text)-1]
+
+	var raw []string // 转义序列保持为一个整体
+	dq, sq := 0, 0
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c == '\\' && i+1 < len(body) {
+			i++
+			switch body[i] {
+			case '"':
+				raw = append(raw, `"`)
+				dq++
+			case '\'':
+				raw = append(raw, "'")
+				sq++
+			default:
+				raw = append(raw, body[i-1:i+1])
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			dq++
+		case '\'':
+			sq++
+		}
+		raw = append(raw, string(c))
+	}
+
+	delim := `"`
+	if dq > sq {
+		delim = "'"
+	}
+	var b strings.Builder
+	b.WriteString(delim)
+	for _, s := range raw {
+		if s == delim {
+			b.WriteByte('\\')
+		}
+		b.WriteString(s)
+	}
+	b.WriteString(delim)
+	return b.String()
+}
@@ -0,0 +1,200 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+把语法树节点（*Block、语句、表达式或 *TableField）输出成源代码，用于在重构等工具改写语法树之后生成代码；
+语法树中没有注释，所以输出中也没有注释。
+工具创建的节点不需要填写行号；Text 为空的字面量按照 Val 或 Str 输出，修改了解析得到的字面量的值时需要清空 Text。
+语法树中没有的括号会在需要时自动加上，以保持运算的优先级和结合性
+*/
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{lineStart: true}
+	switch n := node.(type) {
+	case *Block:
+		p.stats(n.Stats, maxLine)
+	case *TableField:
+		p.field(n)
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat, *DoStat, *WhileStat, *RepeatStat, *IfStat,
+		*ForNumStat, *ForInStat, *LocalVarDeclStat, *AssignStat, *LocalFuncDefStat, *FuncDefStat, *ReturnStat:
+		p.stat(n)
+	case nil, *IfClause:
+		return fmt.Errorf("format: cannot print %T", node)
+	default:
+		p.exp(n)
+	}
+	_, err := io.WriteString(w, p.b.String())
+	return err
+}
+
+/* 字面量 */
+
+func intText(exp *IntegerExp) string {
+	switch {
+	case exp.Text != "":
+		return exp.Text
+	case exp.Val == math.MinInt64:
+		// 9223372036854775808 超出了整数的范围，会被当成浮点数
+		return "(-9223372036854775807 - 1)"
+	case exp.Val < 0:
+		return "(" + strconv.FormatInt(exp.Val, 10) + ")"
+	}
+	return strconv.FormatInt(exp.Val, 10)
+}
+
+func floatText(exp *FloatExp) string {
+	v := exp.Val
+	switch {
+	case exp.Text != "":
+		return exp.Text
+	case math.IsNaN(v):
+		return "(0 / 0)"
+	case math.IsInf(v, 1):
+		return "1e9999"
+	case math.IsInf(v, -1):
+		return "(-1e9999)"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	// 没有小数点和指数的数字会被当成整数
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	if math.Signbit(v) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func stringText(exp *StringExp) string {
+	if exp.Text != "" {
+		return quote(exp.Text)
+	}
+	return quoteString(exp.Str)
+}
+
+/*
+把字符串的内容写成短字符串，引号的选择与 quote 一致；控制字符写成 \ddd 形式，其余字节原样输出
+*/
+func quoteString(s string) string {
+	delim := byte('"')
+	if strings.Count(s, `"`) > strings.Count(s, "'") {
+		delim = '\''
+	}
+	var b strings.Builder
+	b.WriteByte(delim)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == delim || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c == 127:
+			// 总是写成三位数字，避免和后面的数字连在一起
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(delim)
+	return b.String()
+}
+
+/*
+可以写成 a.name 或 {name = v} 形式的键：由名字转换得到的 StringExp，以及工具创建的内容为名字的 StringExp
+*/
+func nameKey(exp Exp) (string, bool) {
+	if key, ok := exp.(*StringExp); ok && key.Text == "" && IsName(key.Str) {
+		return key.Str, true
+	}
+	return "", false
+}
+
+/* 优先级 */
+
+// 一元运算符的优先级，与 parser 一致
+const unaryPriority = 12
+
+/*
+二元运算符的左右优先级，与 parser 一致
+*/
+var binopPriority = map[int][2]int{
+	TOKEN_OP_OR:     {1, 1},
+	TOKEN_OP_AND:    {2, 2},
+	TOKEN_OP_LT:     {3, 3},
+	TOKEN_OP_GT:     {3, 3},
+	TOKEN_OP_LE:     {3, 3},
+	TOKEN_OP_GE:     {3, 3},
+	TOKEN_OP_NE:     {3, 3},
+	TOKEN_OP_EQ:     {3, 3},
+	TOKEN_OP_BOR:    {4, 4},
+	TOKEN_OP_BXOR:   {5, 5},
+	TOKEN_OP_BAND:   {6, 6},
+	TOKEN_OP_SHL:    {7, 7},
+	TOKEN_OP_SHR:    {7, 7},
+	TOKEN_OP_CONCAT: {9, 8},
+	TOKEN_OP_ADD:    {10, 10},
+	TOKEN_OP_SUB:    {10, 10},
+	TOKEN_OP_MUL:    {11, 11},
+	TOKEN_OP_DIV:    {11, 11},
+	TOKEN_OP_IDIV:   {11, 11},
+	TOKEN_OP_MOD:    {11, 11},
+	TOKEN_OP_POW:    {14, 13},
+}
+
+/*
+左优先级为 left 的运算符的左操作数是否需要括号：
+不加括号时，右优先级低于 left 的运算和 ^ 之前的一元运算会把这个运算符吸收进去
+*/
+func leftNeedsParens(exp Exp, left int) bool {
+	switch e := exp.(type) {
+	case *BinopExp:
+		return left > binopPriority[e.Op][1]
+	case *UnopExp:
+		return left > unaryPriority
+	}
+	return false
+}
+
+/*
+右优先级为 right 的运算符的右操作数（或者一元运算的操作数）是否需要括号，与 subexpr 一致：
+只有左优先级高于 right 的运算才会被解析到右操作数之中
+*/
+func rightNeedsParens(exp Exp, right int) bool {
+	e, ok := exp.(*BinopExp)
+	return ok && binopPriority[e.Op][0] <= right
+}
+
+func (self *printer) operand(exp Exp, parens bool) {
+	if parens {
+		self.write("(")
+		self.exp(exp)
+		self.write(")")
+	} else {
+		self.exp(exp)
+	}
+}
+
+/*
+函数调用和表访问的前缀只能是名字、括号表达式、函数调用或表访问，其余的表达式需要加上括号
+*/
+func (self *printer) prefixExp(exp Exp) {
+	switch exp.(type) {
+	case *NameExp, *ParensExp, *FuncCallExp, *TableAccessExp:
+		self.exp(exp)
+	default:
+		self.operand(exp, true)
+	}
+}
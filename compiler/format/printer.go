@@ -0,0 +1,502 @@
+package format
+
+import (
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+	"strings"
+)
+
+// 比任何源代码行号都大的行号，用于输出所有剩下的注释
+const maxLine = int(^uint(0) >> 1)
+
+/*
+把语法树和注释输出成格式化之后的源代码。
+注释按照所在的行插入：语句之前的注释单独成行，与语句的最后一行在同一行的注释放在语句之后；
+语法树中没有位置的注释（如表达式中间的注释）移到所在语句之后，不会丢失
+*/
+type printer struct {
+	b        strings.Builder
+	indent   int
+	comments []Comment
+	// 下一条还没有输出的注释
+	next int
+	// 上一个输出的语句、表的字段或注释在源代码中的最后一行，为 0 时表示代码块的开头
+	prevLine  int
+	lineStart bool
+}
+
+func (self *printer) write(s string) {
+	if self.lineStart {
+		self.b.WriteString(strings.Repeat("\t", self.indent))
+		self.lineStart = false
+	}
+	self.b.WriteString(s)
+}
+
+func (self *printer) newline() {
+	self.b.WriteByte('\n')
+	self.lineStart = true
+}
+
+/*
+源代码中 line 与上一个输出的内容之间有空行时输出一个空行，多个连续的空行合并成一个
+*/
+func (self *printer) space(line int) {
+	if self.prevLine > 0 && line-self.prevLine >= 2 {
+		self.newline()
+	}
+}
+
+func (self *printer) setPrevLine(line int) {
+	if line > self.prevLine {
+		self.prevLine = line
+	}
+}
+
+func commentText(c Comment) string {
+	if isLineComment(c) {
+		return strings.TrimRight(c.Text, " \t\r\v\f")
+	}
+	return c.Text
+}
+
+func isLineComment(c Comment) bool {
+	return c.Line == c.LastLine && !strings.HasPrefix(c.Text, "--[[") && !strings.HasPrefix(c.Text, "--[=")
+}
+
+func (self *printer) hasCommentBefore(line int) bool {
+	return self.next < len(self.comments) && self.comments[self.next].Line < line
+}
+
+/*
+把 line 之前的注释输出成单独的行
+*/
+func (self *printer) commentsBefore(line int) {
+	for self.hasCommentBefore(line) {
+		c := self.comments[self.next]
+		self.next++
+		self.space(c.Line)
+		self.write(commentText(c))
+		self.newline()
+		self.setPrevLine(c.LastLine)
+	}
+}
+
+/*
+把 line 及之前的注释放在当前行的末尾，单行注释之后不能再有其它内容
+*/
+func (self *printer) trailing(line int) {
+	for self.next < len(self.comments) && self.comments[self.next].Line <= line {
+		c := self.comments[self.next]
+		self.next++
+		self.write(" " + commentText(c))
+		self.setPrevLine(c.LastLine)
+		if isLineComment(c) {
+			break
+		}
+	}
+}
+
+/*
+代码块或表构造器的开头（如 then、do 和 {）所在的行上的注释，在内容从后面的行开始时放在开头之后
+*/
+func (self *printer) headerComments(headerLine, firstLine int) {
+	if headerLine < firstLine {
+		self.trailing(headerLine)
+	}
+}
+
+/* 语句 */
+
+/*
+endLine 为代码块结束的关键字（如 end）所在的行，与它在同一行的注释留给结束的关键字
+*/
+func (self *printer) stats(stats []Stat, endLine int) {
+	for i, stat := range stats {
+		if _, ok := stat.(*EmptyStat); ok {
+			continue
+		}
+		line := statLine(stat)
+		self.commentsBefore(line)
+		self.space(line)
+		self.stat(stat)
+		if i+1 < len(stats) && needSemicolon(stat, nextStat(stats[i+1:])) {
+			self.write(";")
+		}
+		last := lastStatLine(stat)
+		if last < endLine {
+			self.trailing(last)
+		}
+		self.newline()
+		self.setPrevLine(last)
+	}
+}
+
+func nextStat(stats []Stat) Stat {
+	for _, stat := range stats {
+		if _, ok := stat.(*EmptyStat); !ok {
+			return stat
+		}
+	}
+	return nil
+}
+
+/*
+以 ( 开头的语句会被当成上一条语句末尾的函数调用的参数，此时上一条语句之后需要保留分号
+*/
+func needSemicolon(stat, next Stat) bool {
+	switch stat.(type) {
+	case *LocalVarDeclStat, *AssignStat, *FuncCallStat, *RepeatStat:
+	default:
+		return false
+	}
+	var exp Exp
+	switch next := next.(type) {
+	case *FuncCallStat:
+		exp = next
+	case *AssignStat:
+		exp = next.VarList[0]
+	default:
+		return false
+	}
+	for {
+		switch x := exp.(type) {
+		case *NameExp:
+			return false
+		case *FuncCallExp:
+			exp = x.PrefixExp
+		case *TableAccessExp:
+			exp = x.PrefixExp
+		default:
+			// 括号表达式，或者输出时需要加上括号的表达式
+			return true
+		}
+	}
+}
+
+/*
+缩进一级的代码块，headerLine 为代码块开头的关键字所在的行；输出之后位于新的一行
+*/
+func (self *printer) block(block *Block, headerLine int) {
+	first := block.LastLine
+	if stat := nextStat(block.Stats); stat != nil {
+		first = statLine(stat)
+	}
+	self.headerComments(headerLine, first)
+	self.newline()
+	self.indent++
+	self.prevLine = 0
+	self.stats(block.Stats, block.LastLine)
+	self.commentsBefore(block.LastLine)
+	self.indent--
+}
+
+func (self *printer) stat(node Stat) {
+	switch stat := node.(type) {
+	case *BreakStat:
+		self.write("break")
+	case *LabelStat:
+		self.write("::" + stat.Name + "::")
+	case *GotoStat:
+		self.write("goto " + stat.Name)
+	case *DoStat:
+		self.write("do")
+		self.block(stat.Block, stat.Line)
+		self.write("end")
+	case *FuncCallStat:
+		self.exp(stat)
+	case *WhileStat:
+		self.write("while ")
+		self.exp(stat.Cond)
+		self.write(" do")
+		self.block(stat.Block, stat.DoLine)
+		self.write("end")
+	case *RepeatStat:
+		self.write("repeat")
+		self.block(stat.Block, stat.Line)
+		self.write("until ")
+		self.exp(stat.Cond)
+	case *IfStat:
+		for i, clause := range stat.Clauses {
+			if i == 0 {
+				self.write("if ")
+			} else {
+				self.write("elseif ")
+			}
+			self.exp(clause.Cond)
+			self.write(" then")
+			self.block(clause.Block, clause.ThenLine)
+		}
+		if stat.Else != nil {
+			self.write("else")
+			self.block(stat.Else, stat.ElseLine)
+		}
+		self.write("end")
+	case *ForNumStat:
+		self.write("for " + stat.VarName + " = ")
+		exps := []Exp{stat.Init, stat.Limit}
+		if stat.Step != nil {
+			exps = append(exps, stat.Step)
+		}
+		self.expList(exps)
+		self.write(" do")
+		self.block(stat.Block, stat.DoLine)
+		self.write("end")
+	case *ForInStat:
+		self.write("for " + strings.Join(stat.NameList, ", ") + " in ")
+		self.expList(stat.ExpList)
+		self.write(" do")
+		self.block(stat.Block, stat.DoLine)
+		self.write("end")
+	case *LocalVarDeclStat:
+		self.write("local ")
+		for i, name := range stat.NameList {
+			if i > 0 {
+				self.write(", ")
+			}
+			self.write(name)
+			if i < len(stat.AttribList) && stat.AttribList[i] != "" {
+				self.write(" <" + stat.AttribList[i] + ">")
+			}
+		}
+		if len(stat.ExpList) > 0 {
+			self.write(" = ")
+			self.expList(stat.ExpList)
+		}
+	case *AssignStat:
+		self.expList(stat.VarList)
+		self.write(" = ")
+		self.expList(stat.ExpList)
+	case *LocalFuncDefStat:
+		self.write("local function " + stat.Name)
+		self.funcBody(stat.Func)
+	case *FuncDefStat:
+		self.write("function ")
+		self.funcName(stat.Name, stat.IsMethod)
+		self.funcBody(stat.Func)
+	case *ReturnStat:
+		self.write("return")
+		if len(stat.ExpList) > 0 {
+			self.write(" ")
+			self.expList(stat.ExpList)
+		}
+	default:
+		panic("unreachable")
+	}
+}
+
+/*
+funcname ::= Name {'.' Name} [':' Name]
+*/
+func (self *printer) funcName(name Exp, isMethod bool) {
+	switch exp := name.(type) {
+	case *NameExp:
+		self.write(exp.Name)
+	case *TableAccessExp:
+		self.funcName(exp.PrefixExp, false)
+		if isMethod {
+			self.write(":")
+		} else {
+			self.write(".")
+		}
+		self.write(exp.KeyExp.(*StringExp).Str)
+	}
+}
+
+/* 表达式 */
+
+func (self *printer) expList(exps []Exp) {
+	for i, exp := range exps {
+		if i > 0 {
+			self.write(", ")
+		}
+		self.exp(exp)
+	}
+}
+
+func (self *printer) exp(node Exp) {
+	switch exp := node.(type) {
+	case *NilExp:
+		self.write("nil")
+	case *TrueExp:
+		self.write("true")
+	case *FalseExp:
+		self.write("false")
+	case *VarargExp:
+		self.write("...")
+	case *IntegerExp:
+		self.write(intText(exp))
+	case *FloatExp:
+		self.write(floatText(exp))
+	case *StringExp:
+		self.write(stringText(exp))
+	case *NameExp:
+		self.write(exp.Name)
+	case *UnopExp:
+		self.write(opText(exp.Op))
+		switch exp.Op {
+		case TOKEN_OP_NOT:
+			self.write(" ")
+		case TOKEN_OP_UNM:
+			// - -x 不能写成 --x，否则会变成注释
+			if x, ok := exp.Exp.(*UnopExp); ok && x.Op == TOKEN_OP_UNM {
+				self.write(" ")
+			}
+		}
+		self.operand(exp.Exp, rightNeedsParens(exp.Exp, unaryPriority))
+	case *BinopExp:
+		priority := binopPriority[exp.Op]
+		self.operand(exp.Exp1, leftNeedsParens(exp.Exp1, priority[0]))
+		self.write(" " + opText(exp.Op) + " ")
+		self.operand(exp.Exp2, rightNeedsParens(exp.Exp2, priority[1]))
+	case *ParensExp:
+		self.write("(")
+		self.exp(exp.Exp)
+		self.write(")")
+	case *TableAccessExp:
+		self.prefixExp(exp.PrefixExp)
+		if name, ok := nameKey(exp.KeyExp); ok {
+			self.write("." + name)
+		} else {
+			self.write("[")
+			self.exp(exp.KeyExp)
+			self.write("]")
+		}
+	case *FuncCallExp:
+		self.prefixExp(exp.PrefixExp)
+		if exp.NameExp != nil {
+			self.write(":" + exp.NameExp.Str)
+		}
+		self.write("(")
+		self.expList(exp.Args)
+		self.write(")")
+	case *FuncDefExp:
+		self.write("function")
+		self.funcBody(exp)
+	case *TableConstructorExp:
+		self.table(exp)
+	default:
+		panic("unreachable")
+	}
+}
+
+func opText(op int) string {
+	return strings.Trim(KindName(op), "'")
+}
+
+/*
+参数列表和函数体，空的函数体与参数列表写在同一行
+*/
+func (self *printer) funcBody(exp *FuncDefExp) {
+	params := exp.ParList
+	if exp.IsVararg {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	self.write("(" + strings.Join(params, ", ") + ")")
+	if len(exp.Block.Stats) == 0 && !self.hasCommentBefore(exp.LastLine) {
+		self.write(" end")
+		return
+	}
+	self.block(exp.Block, exp.Line)
+	self.write("end")
+}
+
+/*
+表构造器：源代码中跨越多行或者包含非空函数体的表每个字段占一行，并且每个字段之后都有逗号；
+其余的表写在一行中
+*/
+func (self *printer) table(exp *TableConstructorExp) {
+	multiLine := exp.Line != exp.LastLine || hasFuncBody(exp)
+	if !multiLine || len(exp.Fields) == 0 && !self.hasCommentBefore(exp.LastLine) {
+		self.write("{")
+		for i, field := range exp.Fields {
+			if i > 0 {
+				self.write(", ")
+			}
+			self.field(field)
+		}
+		self.write("}")
+		return
+	}
+
+	first := exp.LastLine
+	if len(exp.Fields) > 0 {
+		first = fieldLine(exp.Fields[0])
+	}
+	self.write("{")
+	self.headerComments(exp.Line, first)
+	self.newline()
+	self.indent++
+	self.prevLine = 0
+	for _, field := range exp.Fields {
+		line := fieldLine(field)
+		self.commentsBefore(line)
+		self.space(line)
+		self.field(field)
+		self.write(",")
+		last := lastExpLine(field.Value)
+		if last < exp.LastLine {
+			self.trailing(last)
+		}
+		self.newline()
+		self.setPrevLine(last)
+	}
+	self.commentsBefore(exp.LastLine)
+	self.indent--
+	self.write("}")
+}
+
+func (self *printer) field(field *TableField) {
+	if field.Key != nil {
+		if name, ok := nameKey(field.Key); ok {
+			self.write(name)
+		} else {
+			self.write("[")
+			self.exp(field.Key)
+			self.write("]")
+		}
+		self.write(" = ")
+	}
+	self.exp(field.Value)
+}
+
+func fieldLine(field *TableField) int {
+	if field.Key != nil {
+		return firstExpLine(field.Key)
+	}
+	return firstExpLine(field.Value)
+}
+
+/*
+表达式中是否有非空的函数体，这样的函数体会占据多行
+*/
+func hasFuncBody(node Exp) bool {
+	switch exp := node.(type) {
+	case *FuncDefExp:
+		return len(exp.Block.Stats) > 0
+	case *UnopExp:
+		return hasFuncBody(exp.Exp)
+	case *BinopExp:
+		return hasFuncBody(exp.Exp1) || hasFuncBody(exp.Exp2)
+	case *ParensExp:
+		return hasFuncBody(exp.Exp)
+	case *TableAccessExp:
+		return hasFuncBody(exp.PrefixExp) || hasFuncBody(exp.KeyExp)
+	case *FuncCallExp:
+		if hasFuncBody(exp.PrefixExp) {
+			return true
+		}
+		for _, arg := range exp.Args {
+			if hasFuncBody(arg) {
+				return true
+			}
+		}
+	case *TableConstructorExp:
+		for _, field := range exp.Fields {
+			if field.Key != nil && hasFuncBody(field.Key) || hasFuncBody(field.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
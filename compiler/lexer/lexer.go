@@ -0,0 +1,620 @@
+package lexer
+
+import (
+	"fmt"
+	"lua-vm/number"
+	"strings"
+)
+
+/*
+编译过程中发现的错误，格式与 Lua 一致："chunk:line: message near 'token'"；
+Column 为出错的词法单元在 Line 行中开始的列号（从 1 开始，按字节计算），跨行的词法单元为 1，未知时为 0。
+Msg 之后的字段只用于工具和 Render，不出现在 Error() 中
+*/
+type Error struct {
+	Chunk  string
+	Line   int
+	Column int
+	Msg    string
+	// near 部分的词法单元，如 'end' 或 <eof>，错误不带 near 部分时为空
+	Token string
+	// 此处可以出现的词法单元，如 'end'、<name>，没有时为 nil
+	Expected []string
+	// 未闭合的结构开始的词法单元（如 'function'）及其所在的行；
+	// 与出错的词法单元在同一行时 Msg 中没有 "to close" 部分，但这两个字段仍然会被填写
+	Opener     string
+	OpenerLine int
+}
+
+func (self *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", self.Chunk, self.Line, self.Msg)
+}
+
+/*
+与 luaO_chunkid 一致，把 Source 转换成错误信息中的 chunk 名：
+以 @ 开头时为文件名，以 = 开头时原样输出，其余为 [string "..."]，过长时截断
+*/
+func ChunkID(source string) string {
+	const idSize = 60 - 1
+	switch {
+	case strings.HasPrefix(source, "="):
+		if len(source)-1 <= idSize {
+			return source[1:]
+		}
+		return source[1 : 1+idSize]
+	case strings.HasPrefix(source, "@"):
+		if len(source)-1 <= idSize {
+			return source[1:]
+		}
+		return "..." + source[len(source)-(idSize-3):]
+	default:
+		const pre, rets, pos = "[string \"", "...", "\"]"
+		max := idSize - len(pre) - len(rets) - len(pos)
+		nl := strings.IndexByte(source, '\n')
+		if len(source) < max && nl < 0 {
+			return pre + source + pos
+		}
+		if nl >= 0 {
+			source = source[:nl]
+		}
+		if len(source) > max {
+			source = source[:max]
+		}
+		return pre + source + rets + pos
+	}
+}
+
+/*
+Lua 5.3 的词法分析器，规则与 llex.c 一致
+*/
+type Lexer struct {
+	chunk     string
+	chunkName string
+	pos       int
+	// 当前所在的行号以及当前行开始的位置，用于计算列号
+	line      int
+	lineStart int
+	ahead     *Token
+	comments  []Comment
+}
+
+/*
+注释，Text 为包括开头的 -- 在内的原文，Line 和 LastLine 分别为注释开始和结束的行
+*/
+type Comment struct {
+	Line     int
+	LastLine int
+	Column   int
+	Text     string
+}
+
+/*
+创建词法分析器，source 为函数原型的 Source（如 "@foo.lua"），用于生成错误信息中的 chunk 名
+*/
+func NewLexer(chunk, source string) *Lexer {
+	return &Lexer{chunk: chunk, chunkName: ChunkID(source), line: 1}
+}
+
+/*
+返回错误信息中使用的 chunk 名
+*/
+func (self *Lexer) ChunkName() string {
+	return self.chunkName
+}
+
+/*
+返回到目前为止读到的所有注释
+*/
+func (self *Lexer) Comments() []Comment {
+	return self.comments
+}
+
+/*
+返回下一个词法单元但不消耗它
+*/
+func (self *Lexer) LookAhead() Token {
+	if self.ahead == nil {
+		tok := self.scan()
+		self.ahead = &tok
+	}
+	return *self.ahead
+}
+
+/*
+返回下一个词法单元，到达文件末尾之后一直返回 TOKEN_EOF
+*/
+func (self *Lexer) NextToken() Token {
+	if self.ahead != nil {
+		tok := *self.ahead
+		self.ahead = nil
+		return tok
+	}
+	return self.scan()
+}
+
+/*
+以 tok 的位置抛出 "msg near 'tok'" 形式的错误
+*/
+func (self *Lexer) ErrorNear(tok Token, msg string) {
+	panic(self.NewError(tok, msg))
+}
+
+/*
+创建 "msg near 'tok'" 形式的错误但不抛出，用于在抛出之前补充 Expected 等信息
+*/
+func (self *Lexer) NewError(tok Token, msg string) *Error {
+	column := tok.Column
+	if tok.StartLine != 0 && tok.StartLine != tok.Line {
+		column = 1
+	}
+	return &Error{
+		Chunk: self.chunkName, Line: tok.Line, Column: column,
+		Msg: fmt.Sprintf("%s near %s", msg, tok), Token: tok.String(),
+	}
+}
+
+/*
+抛出不带 near 的错误，用于与具体词法单元无关的错误
+*/
+func (self *Lexer) Error(line int, msg string) {
+	panic(&Error{Chunk: self.chunkName, Line: line, Msg: msg})
+}
+
+/*
+词法错误：near 的内容为从 start 开始到当前位置为止的原文，到达文件末尾时为 <eof>，位置也在文件末尾
+*/
+func (self *Lexer) lexError(start int, msg string) {
+	kind := TOKEN_STRING
+	if self.atEOF() {
+		kind, start = TOKEN_EOF, self.pos
+	}
+	self.rawError(start, kind, msg)
+}
+
+func (self *Lexer) rawError(start, kind int, msg string) {
+	self.ErrorNear(Token{Kind: kind, Text: self.chunk[start:self.pos], Line: self.line, Column: self.column(start)}, msg)
+}
+
+func (self *Lexer) column(pos int) int {
+	if pos < self.lineStart {
+		return 1
+	}
+	return pos - self.lineStart + 1
+}
+
+func (self *Lexer) current() byte {
+	if self.pos < len(self.chunk) {
+		return self.chunk[self.pos]
+	}
+	return 0
+}
+
+func (self *Lexer) atEOF() bool {
+	return self.pos >= len(self.chunk)
+}
+
+func (self *Lexer) test(s string) bool {
+	return strings.HasPrefix(self.chunk[self.pos:], s)
+}
+
+func isNewline(c byte) bool {
+	return c == '\n' || c == '\r'
+}
+
+/*
+跳过一个换行，\n、\r、\n\r 和 \r\n 都算作一个换行
+*/
+func (self *Lexer) skipNewline() {
+	c := self.current()
+	self.pos++
+	if next := self.current(); isNewline(next) && next != c {
+		self.pos++
+	}
+	self.line++
+	self.lineStart = self.pos
+}
+
+/*
+跳过空白和注释
+*/
+func (self *Lexer) skipWhiteSpaces() {
+	for !self.atEOF() {
+		c := self.current()
+		switch {
+		case isNewline(c):
+			self.skipNewline()
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f':
+			self.pos++
+		case self.test("--"):
+			self.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (self *Lexer) skipComment() {
+	start := self.pos
+	line, column := self.line, self.column(start)
+	defer func() {
+		self.comments = append(self.comments, Comment{
+			Line: line, LastLine: self.line, Column: column, Text: self.chunk[start:self.pos],
+		})
+	}()
+	self.pos += 2
+	if self.current() == '[' {
+		if level := self.longBracketLevel(); level >= 0 {
+			self.readLongString(start, level, "comment")
+			return
+		}
+	}
+	for !self.atEOF() && !isNewline(self.current()) {
+		self.pos++
+	}
+}
+
+/*
+在 [ 处检查长括号 [==[ 的级别，是长括号时消耗开头的长括号并返回等号的个数，否则不消耗任何字符并返回 -1；
+[= 之后不是 [ 时返回 -2
+*/
+func (self *Lexer) longBracketLevel() int {
+	i := self.pos + 1
+	for i < len(self.chunk) && self.chunk[i] == '=' {
+		i++
+	}
+	if i < len(self.chunk) && self.chunk[i] == '[' {
+		level := i - self.pos - 1
+		self.pos = i + 1
+		return level
+	}
+	if i > self.pos+1 {
+		return -2
+	}
+	return -1
+}
+
+/*
+读取长字符串或长注释的内容，开头的长括号已经被消耗；紧跟在开头长括号之后的换行会被忽略，
+其余的换行统一成 \n
+*/
+func (self *Lexer) readLongString(start, level int, what string) string {
+	startLine := self.line
+	if isNewline(self.current()) {
+		self.skipNewline()
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	var b strings.Builder
+	for {
+		switch {
+		case self.atEOF():
+			self.lexError(start, fmt.Sprintf("unfinished long %s (starting at line %d)", what, startLine))
+		case self.test(closing):
+			self.pos += len(closing)
+			return b.String()
+		case isNewline(self.current()):
+			self.skipNewline()
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(self.current())
+			self.pos++
+		}
+	}
+}
+
+func (self *Lexer) scan() Token {
+	self.skipWhiteSpaces()
+	start := self.pos
+	startLine, column := self.line, self.column(start)
+	token := func(kind int, value interface{}) Token {
+		return Token{
+			Kind: kind, Text: self.chunk[start:self.pos], Value: value,
+			Line: self.line, StartLine: startLine, Column: column,
+		}
+	}
+	if self.atEOF() {
+		return token(TOKEN_EOF, nil)
+	}
+
+	c := self.current()
+	switch {
+	case c == '[':
+		switch level := self.longBracketLevel(); {
+		case level >= 0:
+			s := self.readLongString(start, level, "string")
+			return token(TOKEN_STRING, s)
+		case level == -2:
+			self.pos++
+			for self.current() == '=' {
+				self.pos++
+			}
+			self.lexError(start, "invalid long string delimiter")
+		}
+		self.pos++
+		return token(TOKEN_SEP_LBRACK, nil)
+	case c == '"' || c == '\'':
+		s := self.readString(start)
+		return token(TOKEN_STRING, s)
+	case c == '.' && !self.test("..") && isDigit(self.peek(1)), isDigit(c):
+		n := self.readNumber(start)
+		return token(TOKEN_NUMBER, n)
+	case c == '_' || isLetter(c):
+		for c := self.current(); c == '_' || isLetter(c) || isDigit(c); c = self.current() {
+			self.pos++
+		}
+		name := self.chunk[start:self.pos]
+		if kind, ok := keywords[name]; ok {
+			return token(kind, name)
+		}
+		return token(TOKEN_IDENTIFIER, name)
+	}
+
+	for _, op := range operators {
+		if self.test(op.text) {
+			self.pos += len(op.text)
+			return token(op.kind, nil)
+		}
+	}
+	self.pos++
+	self.ErrorNear(token(TOKEN_STRING, nil), "unexpected symbol")
+	panic("unreachable")
+}
+
+func (self *Lexer) peek(n int) byte {
+	if self.pos+n < len(self.chunk) {
+		return self.chunk[self.pos+n]
+	}
+	return 0
+}
+
+/*
+运算符和分隔符，较长的排在前面
+*/
+var operators = []struct {
+	text string
+	kind int
+}{
+	{"...", TOKEN_VARARG},
+	{"..", TOKEN_OP_CONCAT},
+	{"::", TOKEN_SEP_LABEL},
+	{"//", TOKEN_OP_IDIV},
+	{"<<", TOKEN_OP_SHL},
+	{">>", TOKEN_OP_SHR},
+	{"<=", TOKEN_OP_LE},
+	{">=", TOKEN_OP_GE},
+	{"==", TOKEN_OP_EQ},
+	{"~=", TOKEN_OP_NE},
+	{";", TOKEN_SEP_SEMI},
+	{",", TOKEN_SEP_COMMA},
+	{".", TOKEN_SEP_DOT},
+	{":", TOKEN_SEP_COLON},
+	{"(", TOKEN_SEP_LPAREN},
+	{")", TOKEN_SEP_RPAREN},
+	{"]", TOKEN_SEP_RBRACK},
+	{"{", TOKEN_SEP_LCURLY},
+	{"}", TOKEN_SEP_RCURLY},
+	{"=", TOKEN_OP_ASSIGN},
+	{"-", TOKEN_OP_MINUS},
+	{"~", TOKEN_OP_WAVE},
+	{"+", TOKEN_OP_ADD},
+	{"*", TOKEN_OP_MUL},
+	{"/", TOKEN_OP_DIV},
+	{"^", TOKEN_OP_POW},
+	{"%", TOKEN_OP_MOD},
+	{"&", TOKEN_OP_BAND},
+	{"|", TOKEN_OP_BOR},
+	{"<", TOKEN_OP_LT},
+	{">", TOKEN_OP_GT},
+	{"#", TOKEN_OP_LEN},
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+/*
+读取数字，与 read_numeral 一致：先尽可能多地读取十六进制数字、小数点和带符号的指数，再整体解析；
+能表示成整数的解析成 int64，十进制整数溢出时解析成 float64
+*/
+func (self *Lexer) readNumber(start int) interface{} {
+	expo := "Ee"
+	if self.test("0x") || self.test("0X") {
+		expo = "Pp"
+		self.pos += 2
+	}
+	for {
+		if c := self.current(); c != 0 && strings.IndexByte(expo, c) >= 0 {
+			self.pos++
+			if c := self.current(); c == '+' || c == '-' {
+				self.pos++
+			}
+		} else if isHexDigit(c) || c == '.' {
+			self.pos++
+		} else {
+			break
+		}
+	}
+
+	text := self.chunk[start:self.pos]
+	if i, ok := number.ParseInteger(text); ok {
+		return i
+	}
+	if f, ok := number.ParseFloat(text); ok {
+		return f
+	}
+	self.ErrorNear(Token{Kind: TOKEN_NUMBER, Text: text, Line: self.line, Column: self.column(start)}, "malformed number")
+	return nil
+}
+
+/*
+读取短字符串，处理所有的转义序列
+*/
+func (self *Lexer) readString(start int) string {
+	delim := self.current()
+	self.pos++
+	var b strings.Builder
+	for {
+		c := self.current()
+		switch {
+		case self.atEOF():
+			self.lexError(start, "unfinished string")
+		case isNewline(c):
+			self.lexError(start, "unfinished string")
+		case c == delim:
+			self.pos++
+			return b.String()
+		case c == '\\':
+			self.readEscape(start, &b)
+		default:
+			b.WriteByte(c)
+			self.pos++
+		}
+	}
+}
+
+/*
+读取一个转义序列，当前字符为反斜杠
+*/
+func (self *Lexer) readEscape(start int, b *strings.Builder) {
+	self.pos++
+	c := self.current()
+	// 与 esccheck 一致，出错时把当前字符也包括在 near 的内容中
+	fail := func(msg string) {
+		if self.atEOF() {
+			self.lexError(start, msg)
+		}
+		self.pos++
+		self.rawError(start, TOKEN_STRING, msg)
+	}
+
+	switch c {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '"', '\'':
+		b.WriteByte(c)
+	case '\n', '\r':
+		self.skipNewline()
+		b.WriteByte('\n')
+		return
+	case 'x':
+		r := 0
+		for i := 0; i < 2; i++ {
+			self.pos++
+			d := self.current()
+			if !isHexDigit(d) {
+				fail("hexadecimal digit expected")
+			}
+			r = r*16 + hexValue(d)
+		}
+		b.WriteByte(byte(r))
+	case 'z':
+		self.pos++
+		for !self.atEOF() {
+			if c := self.current(); isNewline(c) {
+				self.skipNewline()
+			} else if c == ' ' || c == '\t' || c == '\v' || c == '\f' {
+				self.pos++
+			} else {
+				break
+			}
+		}
+		return
+	case 'u':
+		self.readUTF8Escape(b, fail)
+	default:
+		if self.atEOF() {
+			// 字符串没有结束，交给 readString 报告
+			return
+		}
+		if !isDigit(c) {
+			fail("invalid escape sequence")
+		}
+		r := 0
+		for i := 0; i < 3 && isDigit(self.current()); i++ {
+			r = r*10 + int(self.current()-'0')
+			self.pos++
+		}
+		if r > 255 {
+			fail("decimal escape too large")
+		}
+		b.WriteByte(byte(r))
+		return
+	}
+	self.pos++
+}
+
+/*
+读取 \u{XXX}，编码成 UTF-8，与 Lua 5.3 一样允许最大 7FFFFFFF 的值
+*/
+func (self *Lexer) readUTF8Escape(b *strings.Builder, fail func(string)) {
+	self.pos++
+	if self.current() != '{' {
+		fail("missing '{'")
+	}
+	self.pos++
+	if !isHexDigit(self.current()) {
+		fail("hexadecimal digit expected")
+	}
+	r := 0
+	for isHexDigit(self.current()) {
+		r = r*16 + hexValue(self.current())
+		if r > 0x7FFFFFFF {
+			fail("UTF-8 value too large")
+		}
+		self.pos++
+	}
+	if self.current() != '}' {
+		fail("missing '}'")
+	}
+	b.WriteString(utf8Encode(r))
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
+
+/*
+与 luaO_utf8esc 一致，按照最长 6 字节的原始 UTF-8 规则编码
+*/
+func utf8Encode(x int) string {
+	if x < 0x80 {
+		return string([]byte{byte(x)})
+	}
+	var buf []byte
+	mfb := 0x3f
+	for {
+		buf = append([]byte{byte(0x80 | (x & 0x3f))}, buf...)
+		x >>= 6
+		mfb >>= 1
+		if x <= mfb {
+			break
+		}
+	}
+	return string(append([]byte{byte((^mfb << 1) | x)}, buf...))
+}
@@ -0,0 +1,131 @@
+package lexer
+
+import "testing"
+
+/*
+读出 chunk 中所有的词法单元，遇到词法错误时返回错误
+*/
+func scanAll(chunk string) (tokens []Token, err *Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(*Error)
+		}
+	}()
+	lexer := NewLexer(chunk, "@test.lua")
+	for {
+		tok := lexer.NextToken()
+		if tok.Kind == TOKEN_EOF {
+			return tokens, nil
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	src := "local s = a..'\\x41\\u{4E2D}\\z\n   b' -- note\nx = 0x10 + 1e2 // 3.5 ~= #t[ [==[\nlong]==] ] ::l::"
+	tokens, err := scanAll(src)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	want := []struct {
+		kind  int
+		value interface{}
+		line  int
+	}{
+		{TOKEN_KW_LOCAL, nil, 1},
+		{TOKEN_IDENTIFIER, "s", 1},
+		{TOKEN_OP_ASSIGN, nil, 1},
+		{TOKEN_IDENTIFIER, "a", 1},
+		{TOKEN_OP_CONCAT, nil, 1},
+		{TOKEN_STRING, "A\u4e2db", 2},
+		{TOKEN_IDENTIFIER, "x", 3},
+		{TOKEN_OP_ASSIGN, nil, 3},
+		{TOKEN_NUMBER, int64(16), 3},
+		{TOKEN_OP_ADD, nil, 3},
+		{TOKEN_NUMBER, 100.0, 3},
+		{TOKEN_OP_IDIV, nil, 3},
+		{TOKEN_NUMBER, 3.5, 3},
+		{TOKEN_OP_NE, nil, 3},
+		{TOKEN_OP_LEN, nil, 3},
+		{TOKEN_IDENTIFIER, "t", 3},
+		{TOKEN_SEP_LBRACK, nil, 3},
+		{TOKEN_STRING, "long", 4},
+		{TOKEN_SEP_RBRACK, nil, 4},
+		{TOKEN_SEP_LABEL, nil, 4},
+		{TOKEN_IDENTIFIER, "l", 4},
+		{TOKEN_SEP_LABEL, nil, 4},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %v", len(tokens), len(want), tokens)
+	}
+	for i, w := range want {
+		tok := tokens[i]
+		if tok.Kind != w.kind || tok.Line != w.line || (w.value != nil && tok.Value != w.value) {
+			t.Errorf("token %d: got %s (kind %d, value %#v, line %d), want kind %d, value %#v, line %d",
+				i, tok, tok.Kind, tok.Value, tok.Line, w.kind, w.value, w.line)
+		}
+	}
+	// 跨行的长字符串从第 3 行开始，到第 4 行结束
+	if long := tokens[17]; long.StartLine != 3 {
+		t.Errorf("long string starts at line %d, want 3", long.StartLine)
+	}
+}
+
+func TestComments(t *testing.T) {
+	lexer := NewLexer("-- one\nx = 1 --[[ two\nlines ]] y = 2", "@test.lua")
+	for lexer.NextToken().Kind != TOKEN_EOF {
+	}
+	comments := lexer.Comments()
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(comments))
+	}
+	if c := comments[0]; c.Text != "-- one" || c.Line != 1 || c.LastLine != 1 {
+		t.Errorf("first comment: %+v", c)
+	}
+	if c := comments[1]; c.Text != "--[[ two\nlines ]]" || c.Line != 2 || c.LastLine != 3 || c.Column != 7 {
+		t.Errorf("second comment: %+v", c)
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		src, msg string
+		column   int
+	}{
+		{"s = \"abc", "test.lua:1: unfinished string near <eof>", 9},
+		{"s = 'abc\nx'", "test.lua:1: unfinished string near ''abc'", 5},
+		{"s = [==[ abc\n", "test.lua:2: unfinished long string (starting at line 1) near <eof>", 1},
+		{"--[[ open", "test.lua:1: unfinished long comment (starting at line 1) near <eof>", 10},
+		{"s = \"\\q\"", "test.lua:1: invalid escape sequence near '\"\\q'", 5},
+		{"s = '\\300'", "test.lua:1: decimal escape too large near ''\\300''", 5},
+		{"s = '\\xZZ'", "test.lua:1: hexadecimal digit expected near ''\\xZ'", 5},
+		{"a @ b", "test.lua:1: unexpected symbol near '@'", 3},
+		{"x = 0x", "test.lua:1: malformed number near '0x'", 5},
+		{"x = 1e+", "test.lua:1: malformed number near '1e+'", 5},
+		{"[=x", "test.lua:1: invalid long string delimiter near '[='", 1},
+	}
+	for _, tt := range tests {
+		_, err := scanAll(tt.src)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.src)
+			continue
+		}
+		if err.Error() != tt.msg || err.Column != tt.column {
+			t.Errorf("%q: got %q at column %d, want %q at column %d", tt.src, err.Error(), err.Column, tt.msg, tt.column)
+		}
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	tests := []struct{ source, id string }{
+		{"@foo.lua", "foo.lua"},
+		{"=stdin", "stdin"},
+		{"x = 1", `[string "x = 1"]`},
+		{"x = 1\ny = 2", `[string "x = 1..."]`},
+	}
+	for _, tt := range tests {
+		if id := ChunkID(tt.source); id != tt.id {
+			t.Errorf("ChunkID(%q) = %q, want %q", tt.source, id, tt.id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+把错误渲染成带有源代码摘录的诊断信息，chunk 为出错的源代码：
+第一行与 Error() 相同，之后是出错的行，以及在出错的词法单元下方用 ^ 标出的位置；
+未闭合的结构开始于另一行时，在出错的行之前给出开始的那一行。结果以换行结尾
+*/
+func (self *Error) Render(chunk string) string {
+	lines := splitLines(chunk)
+	var b strings.Builder
+	b.WriteString(self.Error())
+	b.WriteByte('\n')
+
+	if self.Line < 1 || self.Line > len(lines)+1 {
+		return b.String()
+	}
+	width := len(fmt.Sprint(self.Line))
+	if n := self.OpenerLine; n >= 1 && n < self.Line {
+		writeLine(&b, fmt.Sprint(n), width, lines[n-1])
+		if n+1 < self.Line {
+			writeLine(&b, "", width, "...")
+		}
+	}
+	// 以换行结尾的 chunk 的 <eof> 位于最后一个换行之后的空行
+	line := ""
+	if self.Line <= len(lines) {
+		line = lines[self.Line-1]
+	}
+	writeLine(&b, fmt.Sprint(self.Line), width, line)
+	if self.Column >= 1 && self.Column <= len(line)+1 {
+		writeLine(&b, "", width, caretIndent(line[:self.Column-1])+caret(self.Token, len(line)-self.Column+1))
+	}
+	return b.String()
+}
+
+/*
+输出摘录中的一行，行号右对齐，空行的末尾不留空格
+*/
+func writeLine(b *strings.Builder, num string, width int, text string) {
+	fmt.Fprintf(b, "%*s |", width, num)
+	if text != "" {
+		b.WriteByte(' ')
+		b.WriteString(text)
+	}
+	b.WriteByte('\n')
+}
+
+/*
+按照与词法分析器相同的规则分行：\n、\r、\r\n 和 \n\r 都是一个换行
+*/
+func splitLines(chunk string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(chunk); i++ {
+		if c := chunk[i]; c == '\n' || c == '\r' {
+			lines = append(lines, chunk[start:i])
+			if i+1 < len(chunk) && chunk[i+1] != c && (chunk[i+1] == '\n' || chunk[i+1] == '\r') {
+				i++
+			}
+			start = i + 1
+		}
+	}
+	if start < len(chunk) {
+		lines = append(lines, chunk[start:])
+	}
+	return lines
+}
+
+/*
+^ 之前的缩进：制表符保持不变，其余每个字符换成一个空格，使 ^ 与源代码对齐
+*/
+func caretIndent(prefix string) string {
+	var b strings.Builder
+	for _, c := range prefix {
+		if c == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+/*
+标出词法单元的 ^~~~，长度为 token 在出错的行中的长度，不超过 max；
+token 为 Error.Token，即用单引号包裹的原文或 <eof>，跨行的词法单元只标出最后一行的部分
+*/
+func caret(token string, max int) string {
+	n := 1
+	if strings.HasPrefix(token, "'") && len(token) > 2 {
+		text := token[1 : len(token)-1]
+		if i := strings.LastIndexAny(text, "\r\n"); i >= 0 {
+			text = text[i+1:]
+		}
+		n = len(text)
+	}
+	if n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return "^" + strings.Repeat("~", n-1)
+}
@@ -0,0 +1,202 @@
+package lexer
+
+import "fmt"
+
+/*
+词法单元的种类
+*/
+const (
+	TOKEN_EOF         = iota // end-of-file
+	TOKEN_VARARG             // ...
+	TOKEN_SEP_SEMI           // ;
+	TOKEN_SEP_COMMA          // ,
+	TOKEN_SEP_DOT            // .
+	TOKEN_SEP_COLON          // :
+	TOKEN_SEP_LABEL          // ::
+	TOKEN_SEP_LPAREN         // (
+	TOKEN_SEP_RPAREN         // )
+	TOKEN_SEP_LBRACK         // [
+	TOKEN_SEP_RBRACK         // ]
+	TOKEN_SEP_LCURLY         // {
+	TOKEN_SEP_RCURLY         // }
+	TOKEN_OP_ASSIGN          // =
+	TOKEN_OP_MINUS           // - (sub or unm)
+	TOKEN_OP_WAVE            // ~ (bnot or bxor)
+	TOKEN_OP_ADD             // +
+	TOKEN_OP_MUL             // *
+	TOKEN_OP_DIV             // /
+	TOKEN_OP_IDIV            // //
+	TOKEN_OP_POW             // ^
+	TOKEN_OP_MOD             // %
+	TOKEN_OP_BAND            // &
+	TOKEN_OP_BOR             // |
+	TOKEN_OP_SHR             // >>
+	TOKEN_OP_SHL             // <<
+	TOKEN_OP_CONCAT          // ..
+	TOKEN_OP_LT              // <
+	TOKEN_OP_LE              // <=
+	TOKEN_OP_GT              // >
+	TOKEN_OP_GE              // >=
+	TOKEN_OP_EQ              // ==
+	TOKEN_OP_NE              // ~=
+	TOKEN_OP_LEN             // #
+	TOKEN_OP_AND             // and
+	TOKEN_OP_OR              // or
+	TOKEN_OP_NOT             // not
+	TOKEN_KW_BREAK           // break
+	TOKEN_KW_DO              // do
+	TOKEN_KW_ELSE            // else
+	TOKEN_KW_ELSEIF          // elseif
+	TOKEN_KW_END             // end
+	TOKEN_KW_FALSE           // false
+	TOKEN_KW_FOR             // for
+	TOKEN_KW_FUNCTION        // function
+	TOKEN_KW_GOTO            // goto
+	TOKEN_KW_IF              // if
+	TOKEN_KW_IN              // in
+	TOKEN_KW_LOCAL           // local
+	TOKEN_KW_NIL             // nil
+	TOKEN_KW_REPEAT          // repeat
+	TOKEN_KW_RETURN          // return
+	TOKEN_KW_THEN            // then
+	TOKEN_KW_TRUE            // true
+	TOKEN_KW_UNTIL           // until
+	TOKEN_KW_WHILE           // while
+	TOKEN_IDENTIFIER         // identifier
+	TOKEN_NUMBER             // number literal
+	TOKEN_STRING             // string literal
+	TOKEN_OP_UNM      = TOKEN_OP_MINUS
+	TOKEN_OP_SUB      = TOKEN_OP_MINUS
+	TOKEN_OP_BNOT     = TOKEN_OP_WAVE
+	TOKEN_OP_BXOR     = TOKEN_OP_WAVE
+)
+
+var keywords = map[string]int{
+	"and":      TOKEN_OP_AND,
+	"break":    TOKEN_KW_BREAK,
+	"do":       TOKEN_KW_DO,
+	"else":     TOKEN_KW_ELSE,
+	"elseif":   TOKEN_KW_ELSEIF,
+	"end":      TOKEN_KW_END,
+	"false":    TOKEN_KW_FALSE,
+	"for":      TOKEN_KW_FOR,
+	"function": TOKEN_KW_FUNCTION,
+	"goto":     TOKEN_KW_GOTO,
+	"if":       TOKEN_KW_IF,
+	"in":       TOKEN_KW_IN,
+	"local":    TOKEN_KW_LOCAL,
+	"nil":      TOKEN_KW_NIL,
+	"not":      TOKEN_OP_NOT,
+	"or":       TOKEN_OP_OR,
+	"repeat":   TOKEN_KW_REPEAT,
+	"return":   TOKEN_KW_RETURN,
+	"then":     TOKEN_KW_THEN,
+	"true":     TOKEN_KW_TRUE,
+	"until":    TOKEN_KW_UNTIL,
+	"while":    TOKEN_KW_WHILE,
+}
+
+/*
+一个词法单元；Text 为它在源代码中的原文，
+数字的 Value 为 int64 或 float64，字符串的 Value 为转义之后的内容，标识符的 Value 为其名字。
+Line 与 luac 一样是词法单元结束时所在的行，StartLine 和 Column 是它开始的位置，两者只在跨行的长字符串上不同
+*/
+type Token struct {
+	Kind      int
+	Text      string
+	Value     interface{}
+	Line      int
+	StartLine int
+	Column    int
+}
+
+/*
+返回词法单元在错误信息中的形式，与 luaX_token2str 一致：文件结束为 <eof>，其余用单引号包裹原文
+*/
+func (self Token) String() string {
+	if self.Kind == TOKEN_EOF {
+		return "<eof>"
+	}
+	return quoteToken(self.Text)
+}
+
+func quoteToken(text string) string {
+	if len(text) == 1 && (text[0] < ' ' || text[0] == 127) {
+		return fmt.Sprintf("'<\\%d>'", text[0])
+	}
+	return "'" + text + "'"
+}
+
+/*
+判断 s 是否可以用作名字：由字母、数字和下划线组成，不以数字开头，并且不是保留字
+*/
+func IsName(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c != '_' && !isLetter(c) && !isDigit(c) {
+			return false
+		}
+	}
+	_, reserved := keywords[s]
+	return !reserved
+}
+
+/*
+返回某一种词法单元的名字，用于 "'=' expected" 这样的错误信息
+*/
+func KindName(kind int) string {
+	for name, k := range keywords {
+		if k == kind {
+			return "'" + name + "'"
+		}
+	}
+	switch kind {
+	case TOKEN_EOF:
+		return "<eof>"
+	case TOKEN_IDENTIFIER:
+		return "<name>"
+	case TOKEN_NUMBER:
+		return "<number>"
+	case TOKEN_STRING:
+		return "<string>"
+	}
+	return "'" + symbols[kind] + "'"
+}
+
+var symbols = map[int]string{
+	TOKEN_VARARG:     "...",
+	TOKEN_SEP_SEMI:   ";",
+	TOKEN_SEP_COMMA:  ",",
+	TOKEN_SEP_DOT:    ".",
+	TOKEN_SEP_COLON:  ":",
+	TOKEN_SEP_LABEL:  "::",
+	TOKEN_SEP_LPAREN: "(",
+	TOKEN_SEP_RPAREN: ")",
+	TOKEN_SEP_LBRACK: "[",
+	TOKEN_SEP_RBRACK: "]",
+	TOKEN_SEP_LCURLY: "{",
+	TOKEN_SEP_RCURLY: "}",
+	TOKEN_OP_ASSIGN:  "=",
+	TOKEN_OP_MINUS:   "-",
+	TOKEN_OP_WAVE:    "~",
+	TOKEN_OP_ADD:     "+",
+	TOKEN_OP_MUL:     "*",
+	TOKEN_OP_DIV:     "/",
+	TOKEN_OP_IDIV:    "//",
+	TOKEN_OP_POW:     "^",
+	TOKEN_OP_MOD:     "%",
+	TOKEN_OP_BAND:    "&",
+	TOKEN_OP_BOR:     "|",
+	TOKEN_OP_SHR:     ">>",
+	TOKEN_OP_SHL:     "<<",
+	TOKEN_OP_CONCAT:  "..",
+	TOKEN_OP_LT:      "<",
+	TOKEN_OP_LE:      "<=",
+	TOKEN_OP_GT:      ">",
+	TOKEN_OP_GE:      ">=",
+	TOKEN_OP_EQ:      "==",
+	TOKEN_OP_NE:      "~=",
+	TOKEN_OP_LEN:     "#",
+}
@@ -0,0 +1,301 @@
+package parser
+
+import (
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+)
+
+// 一元运算符的优先级，与 UNARY_PRIORITY 一致
+const unaryPriority = 12
+
+/*
+二元运算符的左右优先级，与 lparser.c 中的 priority 表一致；
+右优先级低于左优先级的 .. 和 ^ 是右结合的
+*/
+var binopPriority = map[int][2]int{
+	TOKEN_OP_OR:     {1, 1},
+	TOKEN_OP_AND:    {2, 2},
+	TOKEN_OP_LT:     {3, 3},
+	TOKEN_OP_GT:     {3, 3},
+	TOKEN_OP_LE:     {3, 3},
+	TOKEN_OP_GE:     {3, 3},
+	TOKEN_OP_NE:     {3, 3},
+	TOKEN_OP_EQ:     {3, 3},
+	TOKEN_OP_BOR:    {4, 4},
+	TOKEN_OP_BXOR:   {5, 5},
+	TOKEN_OP_BAND:   {6, 6},
+	TOKEN_OP_SHL:    {7, 7},
+	TOKEN_OP_SHR:    {7, 7},
+	TOKEN_OP_CONCAT: {9, 8},
+	TOKEN_OP_ADD:    {10, 10},
+	TOKEN_OP_SUB:    {10, 10},
+	TOKEN_OP_MUL:    {11, 11},
+	TOKEN_OP_DIV:    {11, 11},
+	TOKEN_OP_IDIV:   {11, 11},
+	TOKEN_OP_MOD:    {11, 11},
+	TOKEN_OP_POW:    {14, 13},
+}
+
+/*
+explist ::= exp {',' exp}
+*/
+func (self *parser) parseExpList() []Exp {
+	exps := []Exp{self.parseExp()}
+	for self.testNext(TOKEN_SEP_COMMA) {
+		exps = append(exps, self.parseExp())
+	}
+	return exps
+}
+
+func (self *parser) parseExp() Exp {
+	exp, _ := self.parseSubExp(0)
+	return exp
+}
+
+/*
+与 subexpr 一致：解析左优先级高于 limit 的运算，返回表达式以及之后第一个没有被处理的二元运算符
+*/
+func (self *parser) parseSubExp(limit int) (Exp, int) {
+	self.enterLevel()
+	defer self.leaveLevel()
+
+	var exp Exp
+	start := self.pos()
+	switch op := self.t.Kind; op {
+	case TOKEN_OP_NOT, TOKEN_OP_MINUS, TOKEN_OP_WAVE, TOKEN_OP_LEN:
+		line := self.t.Line
+		self.next()
+		operand, _ := self.parseSubExp(unaryPriority)
+		exp = &UnopExp{Line: line, Op: op, Exp: operand}
+		self.setRange(exp, start)
+	default:
+		exp = self.parseSimpleExp()
+	}
+
+	op := self.t.Kind
+	for {
+		priority, ok := binopPriority[op]
+		if !ok || priority[0] <= limit {
+			return exp, op
+		}
+		line, binop := self.t.Line, op
+		self.next()
+		var exp2 Exp
+		exp2, op = self.parseSubExp(priority[1])
+		exp = &BinopExp{Line: line, Op: binop, Exp1: exp, Exp2: exp2}
+		self.setRange(exp, start)
+	}
+}
+
+/*
+simpleexp ::= FLT | INT | STRING | nil | true | false | '...' | constructor | function funcbody | suffixedexp
+*/
+func (self *parser) parseSimpleExp() Exp {
+	line, start := self.t.Line, self.pos()
+	var exp Exp
+	switch self.t.Kind {
+	case TOKEN_NUMBER:
+		if i, ok := self.t.Value.(int64); ok {
+			exp = &IntegerExp{Line: line, Val: i, Text: self.t.Text}
+		} else {
+			exp = &FloatExp{Line: line, Val: self.t.Value.(float64), Text: self.t.Text}
+		}
+	case TOKEN_STRING:
+		exp = &StringExp{Line: line, Str: self.t.Value.(string), Text: self.t.Text}
+	case TOKEN_KW_NIL:
+		exp = &NilExp{Line: line}
+	case TOKEN_KW_TRUE:
+		exp = &TrueExp{Line: line}
+	case TOKEN_KW_FALSE:
+		exp = &FalseExp{Line: line}
+	case TOKEN_VARARG:
+		if !self.fs().isVararg {
+			self.syntaxError("cannot use '...' outside a vararg function")
+		}
+		exp = &VarargExp{Line: line}
+	case TOKEN_SEP_LCURLY:
+		return self.parseTableConstructorExp()
+	case TOKEN_KW_FUNCTION:
+		self.next()
+		exp = self.parseFuncBody(self.t.Line)
+		// 函数构造器的范围包括 function
+		self.setRange(exp, start)
+		return exp
+	default:
+		return self.parseSuffixedExp()
+	}
+	self.next()
+	self.setRange(exp, start)
+	return exp
+}
+
+/*
+primaryexp ::= NAME | '(' expr ')'
+*/
+func (self *parser) parsePrimaryExp() Exp {
+	line, start := self.t.Line, self.pos()
+	switch self.t.Kind {
+	case TOKEN_IDENTIFIER:
+		return self.parseNameExp()
+	case TOKEN_SEP_LPAREN:
+		self.next()
+		exp := self.parseExp()
+		self.checkMatch(TOKEN_SEP_RPAREN, TOKEN_SEP_LPAREN, line)
+		parens := &ParensExp{Line: line, LastLine: self.lastLine, Exp: exp}
+		self.setRange(parens, start)
+		return parens
+	default:
+		self.syntaxError("unexpected symbol")
+		return nil
+	}
+}
+
+/*
+Name，用于变量
+*/
+func (self *parser) parseNameExp() *NameExp {
+	line, start := self.t.Line, self.pos()
+	exp := &NameExp{Line: line, Name: self.checkName()}
+	self.setRange(exp, start)
+	return exp
+}
+
+/*
+Name，用于表的键和方法名，转换成字符串
+*/
+func (self *parser) parseNameKey() *StringExp {
+	line, start := self.t.Line, self.pos()
+	exp := &StringExp{Line: line, Str: self.checkName()}
+	self.setRange(exp, start)
+	return exp
+}
+
+/*
+suffixedexp ::= primaryexp { '.' NAME | '[' exp ']' | ':' NAME funcargs | funcargs }
+*/
+func (self *parser) parseSuffixedExp() Exp {
+	line, start := self.t.Line, self.pos()
+	exp := self.parsePrimaryExp()
+	for {
+		switch self.t.Kind {
+		case TOKEN_SEP_DOT:
+			self.next()
+			key := self.parseNameKey()
+			exp = &TableAccessExp{LastLine: key.Line, PrefixExp: exp, KeyExp: key}
+		case TOKEN_SEP_LBRACK:
+			self.next()
+			key := self.parseExp()
+			self.checkNext(TOKEN_SEP_RBRACK)
+			exp = &TableAccessExp{LastLine: self.lastLine, PrefixExp: exp, KeyExp: key}
+		case TOKEN_SEP_COLON:
+			self.next()
+			name := self.parseNameKey()
+			exp = self.parseFuncArgs(line, exp, name)
+		case TOKEN_SEP_LPAREN, TOKEN_STRING, TOKEN_SEP_LCURLY:
+			exp = self.parseFuncArgs(line, exp, nil)
+		default:
+			return exp
+		}
+		self.setRange(exp, start)
+	}
+}
+
+/*
+funcargs ::= '(' [explist] ')' | constructor | STRING
+*/
+func (self *parser) parseFuncArgs(line int, prefix Exp, name *StringExp) *FuncCallExp {
+	call := &FuncCallExp{Line: line, PrefixExp: prefix, NameExp: name}
+	switch self.t.Kind {
+	case TOKEN_SEP_LPAREN:
+		self.next()
+		if !self.test(TOKEN_SEP_RPAREN) {
+			call.Args = self.parseExpList()
+		}
+		self.checkMatch(TOKEN_SEP_RPAREN, TOKEN_SEP_LPAREN, line)
+	case TOKEN_SEP_LCURLY:
+		call.Args = []Exp{self.parseTableConstructorExp()}
+	case TOKEN_STRING:
+		call.Args = []Exp{self.parseSimpleExp()}
+	default:
+		self.errorExpecting("function arguments expected", TOKEN_SEP_LPAREN, TOKEN_STRING, TOKEN_SEP_LCURLY)
+	}
+	call.LastLine = self.lastLine
+	return call
+}
+
+/*
+constructor ::= '{' [field {sep field} [sep]] '}'
+field ::= '[' exp ']' '=' exp | Name '=' exp | exp
+*/
+func (self *parser) parseTableConstructorExp() *TableConstructorExp {
+	line, start := self.t.Line, self.pos()
+	exp := &TableConstructorExp{Line: line}
+	self.checkNext(TOKEN_SEP_LCURLY)
+	for !self.test(TOKEN_SEP_RCURLY) {
+		exp.Fields = append(exp.Fields, self.parseField())
+		if !self.testNext(TOKEN_SEP_COMMA) && !self.testNext(TOKEN_SEP_SEMI) {
+			break
+		}
+	}
+	self.checkMatch(TOKEN_SEP_RCURLY, TOKEN_SEP_LCURLY, line)
+	exp.LastLine = self.lastLine
+	self.setRange(exp, start)
+	return exp
+}
+
+func (self *parser) parseField() *TableField {
+	start := self.pos()
+	field := &TableField{}
+	switch self.t.Kind {
+	case TOKEN_IDENTIFIER:
+		if self.lexer.LookAhead().Kind == TOKEN_OP_ASSIGN {
+			field.Key = self.parseNameKey()
+			self.checkNext(TOKEN_OP_ASSIGN)
+		}
+	case TOKEN_SEP_LBRACK:
+		self.next()
+		field.Key = self.parseExp()
+		self.checkNext(TOKEN_SEP_RBRACK)
+		self.checkNext(TOKEN_OP_ASSIGN)
+	}
+	field.Value = self.parseExp()
+	self.setRange(field, start)
+	return field
+}
+
+/*
+funcbody ::= '(' [parlist] ')' block end
+parlist ::= namelist [',' '...'] | '...'
+line 为函数开始的行，与 luac 的 linedefined 一致
+*/
+func (self *parser) parseFuncBody(line int) *FuncDefExp {
+	exp := &FuncDefExp{Line: line}
+	start := self.pos()
+	self.openFunc(line, false)
+	defer self.closeFunc()
+
+	self.checkNext(TOKEN_SEP_LPAREN)
+	if !self.test(TOKEN_SEP_RPAREN) {
+		for {
+			switch self.t.Kind {
+			case TOKEN_IDENTIFIER:
+				exp.ParList = append(exp.ParList, self.checkName())
+			case TOKEN_VARARG:
+				self.next()
+				exp.IsVararg = true
+				self.fs().isVararg = true
+			default:
+				self.errorExpecting("<name> or '...' expected", TOKEN_IDENTIFIER, TOKEN_VARARG)
+			}
+			if exp.IsVararg || !self.testNext(TOKEN_SEP_COMMA) {
+				break
+			}
+		}
+	}
+	self.checkNext(TOKEN_SEP_RPAREN)
+	exp.Block = self.parseBlock()
+	self.checkMatch(TOKEN_KW_END, TOKEN_KW_FUNCTION, line)
+	exp.LastLine = self.lastLine
+	self.setRange(exp, start)
+	return exp
+}
@@ -0,0 +1,320 @@
+package parser
+
+import (
+	"fmt"
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+)
+
+/*
+代码块结束的位置，与 block_follow 一致
+*/
+func (self *parser) blockFollow(withUntil bool) bool {
+	switch self.t.Kind {
+	case TOKEN_KW_ELSE, TOKEN_KW_ELSEIF, TOKEN_KW_END, TOKEN_EOF:
+		return true
+	case TOKEN_KW_UNTIL:
+		return withUntil
+	}
+	return false
+}
+
+/*
+block ::= {stat} [retstat]
+*/
+func (self *parser) parseBlock() *Block {
+	block, start := &Block{}, self.pos()
+	for !self.blockFollow(true) {
+		stat, statStart := Stat(nil), self.pos()
+		if self.test(TOKEN_KW_RETURN) {
+			stat = self.parseRetStat()
+		} else {
+			stat = self.parseStat()
+		}
+		self.setRange(stat, statStart)
+		block.Stats = append(block.Stats, stat)
+		if _, ok := stat.(*ReturnStat); ok {
+			break
+		}
+	}
+	block.LastLine = self.t.Line
+	if len(block.Stats) > 0 {
+		self.setRange(block, start)
+	} else if self.ranges != nil {
+		self.ranges[block] = Range{Start: start, End: start}
+	}
+	return block
+}
+
+/*
+retstat ::= return [explist] [';']
+*/
+func (self *parser) parseRetStat() *ReturnStat {
+	stat := &ReturnStat{Line: self.t.Line}
+	self.next()
+	if !self.blockFollow(true) && !self.test(TOKEN_SEP_SEMI) {
+		stat.ExpList = self.parseExpList()
+	}
+	stat.LastLine = self.lastLine
+	self.testNext(TOKEN_SEP_SEMI)
+	return stat
+}
+
+func (self *parser) parseStat() Stat {
+	self.enterLevel()
+	defer self.leaveLevel()
+
+	line := self.t.Line
+	switch self.t.Kind {
+	case TOKEN_SEP_SEMI:
+		self.next()
+		return &EmptyStat{Line: line}
+	case TOKEN_KW_IF:
+		return self.parseIfStat(line)
+	case TOKEN_KW_WHILE:
+		return self.parseWhileStat(line)
+	case TOKEN_KW_DO:
+		self.next()
+		block := self.parseBlock()
+		self.checkMatch(TOKEN_KW_END, TOKEN_KW_DO, line)
+		return &DoStat{Line: line, LastLine: self.lastLine, Block: block}
+	case TOKEN_KW_FOR:
+		return self.parseForStat(line)
+	case TOKEN_KW_REPEAT:
+		return self.parseRepeatStat(line)
+	case TOKEN_KW_FUNCTION:
+		return self.parseFuncDefStat(line)
+	case TOKEN_KW_LOCAL:
+		self.next()
+		if self.testNext(TOKEN_KW_FUNCTION) {
+			return self.parseLocalFuncDefStat(line)
+		}
+		return self.parseLocalVarDeclStat(line)
+	case TOKEN_SEP_LABEL:
+		self.next()
+		name := self.checkName()
+		self.checkNext(TOKEN_SEP_LABEL)
+		return &LabelStat{Line: line, Name: name}
+	case TOKEN_KW_BREAK:
+		self.next()
+		return &BreakStat{Line: line}
+	case TOKEN_KW_GOTO:
+		self.next()
+		return &GotoStat{Line: line, Name: self.checkName()}
+	default:
+		return self.parseExpStat(line)
+	}
+}
+
+/*
+if exp then block {elseif exp then block} [else block] end
+*/
+func (self *parser) parseIfStat(line int) *IfStat {
+	stat := &IfStat{Line: line}
+	for {
+		clause, start := &IfClause{Line: self.t.Line}, self.pos()
+		self.next()
+		clause.Cond = self.parseExp()
+		clause.ThenLine = self.t.Line
+		self.checkNext(TOKEN_KW_THEN)
+		clause.Block = self.parseBlock()
+		self.setRange(clause, start)
+		stat.Clauses = append(stat.Clauses, clause)
+		if !self.test(TOKEN_KW_ELSEIF) {
+			break
+		}
+	}
+	if self.test(TOKEN_KW_ELSE) {
+		stat.ElseLine = self.t.Line
+		self.next()
+		stat.Else = self.parseBlock()
+	}
+	self.checkMatch(TOKEN_KW_END, TOKEN_KW_IF, line)
+	stat.LastLine = self.lastLine
+	return stat
+}
+
+/*
+while exp do block end
+*/
+func (self *parser) parseWhileStat(line int) *WhileStat {
+	self.next()
+	stat := &WhileStat{Line: line, Cond: self.parseExp()}
+	stat.DoLine = self.t.Line
+	self.checkNext(TOKEN_KW_DO)
+	stat.Block = self.parseBlock()
+	self.checkMatch(TOKEN_KW_END, TOKEN_KW_WHILE, line)
+	stat.LastLine = self.lastLine
+	return stat
+}
+
+/*
+repeat block until exp
+*/
+func (self *parser) parseRepeatStat(line int) *RepeatStat {
+	self.next()
+	stat := &RepeatStat{Line: line, Block: self.parseBlock()}
+	stat.UntilLine = self.t.Line
+	self.checkMatch(TOKEN_KW_UNTIL, TOKEN_KW_REPEAT, line)
+	stat.Cond = self.parseExp()
+	return stat
+}
+
+/*
+for Name = exp, exp [, exp] do block end
+for namelist in explist do block end
+*/
+func (self *parser) parseForStat(line int) Stat {
+	self.next()
+	name := self.checkName()
+	var stat Stat
+	switch self.t.Kind {
+	case TOKEN_OP_ASSIGN:
+		stat = self.parseForNumStat(line, name)
+	case TOKEN_SEP_COMMA, TOKEN_KW_IN:
+		stat = self.parseForInStat(line, name)
+	default:
+		self.errorExpecting("'=' or 'in' expected", TOKEN_OP_ASSIGN, TOKEN_KW_IN)
+	}
+	self.checkMatch(TOKEN_KW_END, TOKEN_KW_FOR, line)
+	switch stat := stat.(type) {
+	case *ForNumStat:
+		stat.LastLine = self.lastLine
+	case *ForInStat:
+		stat.LastLine = self.lastLine
+	}
+	return stat
+}
+
+func (self *parser) parseForNumStat(line int, name string) *ForNumStat {
+	stat := &ForNumStat{Line: line, VarName: name}
+	self.next()
+	stat.Init = self.parseExp()
+	self.checkNext(TOKEN_SEP_COMMA)
+	stat.Limit = self.parseExp()
+	if self.testNext(TOKEN_SEP_COMMA) {
+		stat.Step = self.parseExp()
+	}
+	stat.DoLine = self.t.Line
+	self.checkNext(TOKEN_KW_DO)
+	stat.Block = self.parseBlock()
+	return stat
+}
+
+func (self *parser) parseForInStat(line int, name string) *ForInStat {
+	stat := &ForInStat{Line: line, NameList: []string{name}}
+	for self.testNext(TOKEN_SEP_COMMA) {
+		stat.NameList = append(stat.NameList, self.checkName())
+	}
+	self.checkNext(TOKEN_KW_IN)
+	stat.ExpList = self.parseExpList()
+	stat.DoLine = self.t.Line
+	self.checkNext(TOKEN_KW_DO)
+	stat.Block = self.parseBlock()
+	return stat
+}
+
+/*
+function funcname funcbody
+funcname ::= Name {'.' Name} [':' Name]
+*/
+func (self *parser) parseFuncDefStat(line int) *FuncDefStat {
+	self.next()
+	stat, start := &FuncDefStat{Line: line}, self.pos()
+	stat.Name = self.parseNameExp()
+	for self.test(TOKEN_SEP_DOT) || self.test(TOKEN_SEP_COLON) {
+		isMethod := self.test(TOKEN_SEP_COLON)
+		self.next()
+		key := self.parseNameKey()
+		stat.Name = &TableAccessExp{LastLine: key.Line, PrefixExp: stat.Name, KeyExp: key}
+		self.setRange(stat.Name, start)
+		if isMethod {
+			stat.IsMethod = true
+			break
+		}
+	}
+	stat.Func = self.parseFuncBody(line)
+	return stat
+}
+
+/*
+local function Name funcbody，函数从 ( 所在的行开始
+*/
+func (self *parser) parseLocalFuncDefStat(line int) *LocalFuncDefStat {
+	name := self.checkName()
+	return &LocalFuncDefStat{Line: line, Name: name, Func: self.parseFuncBody(self.t.Line)}
+}
+
+/*
+local attnamelist ['=' explist]，与 Lua 5.4 的 localstat 一致，一个语句中最多只能有一个 close 变量
+*/
+func (self *parser) parseLocalVarDeclStat(line int) *LocalVarDeclStat {
+	stat := &LocalVarDeclStat{Line: line}
+	toClose := false
+	for {
+		stat.NameList = append(stat.NameList, self.checkName())
+		attrib := self.parseAttrib()
+		if attrib == "close" {
+			if toClose {
+				self.semError("multiple to-be-closed variables in local list")
+			}
+			toClose = true
+		}
+		stat.AttribList = append(stat.AttribList, attrib)
+		if !self.testNext(TOKEN_SEP_COMMA) {
+			break
+		}
+	}
+	if self.testNext(TOKEN_OP_ASSIGN) {
+		stat.ExpList = self.parseExpList()
+	}
+	stat.LastLine = self.lastLine
+	return stat
+}
+
+/*
+attrib ::= ['<' Name '>']，与 getlocalattribute 一致
+*/
+func (self *parser) parseAttrib() string {
+	if !self.testNext(TOKEN_OP_LT) {
+		return ""
+	}
+	attrib := self.checkName()
+	self.checkNext(TOKEN_OP_GT)
+	if attrib != "const" && attrib != "close" {
+		self.semError(fmt.Sprintf("unknown attribute '%s'", attrib))
+	}
+	return attrib
+}
+
+/*
+函数调用语句或赋值语句，与 exprstat 和 restassign 一致
+*/
+func (self *parser) parseExpStat(line int) Stat {
+	exp := self.parseSuffixedExp()
+	if !self.test(TOKEN_OP_ASSIGN) && !self.test(TOKEN_SEP_COMMA) {
+		call, ok := exp.(*FuncCallExp)
+		if !ok {
+			self.syntaxError("syntax error")
+		}
+		return call
+	}
+
+	stat := &AssignStat{Line: line}
+	for {
+		switch exp.(type) {
+		case *NameExp, *TableAccessExp:
+		default:
+			self.syntaxError("syntax error")
+		}
+		stat.VarList = append(stat.VarList, exp)
+		if !self.testNext(TOKEN_SEP_COMMA) {
+			break
+		}
+		exp = self.parseSuffixedExp()
+	}
+	self.checkNext(TOKEN_OP_ASSIGN)
+	stat.ExpList = self.parseExpList()
+	stat.LastLine = self.lastLine
+	return stat
+}
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"fmt"
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+	"strings"
+)
+
+// 语句和表达式的最大嵌套层数，与 LUAI_MAXCCALLS 一致
+const maxLevels = 200
+
+/*
+把 Lua 源代码解析成语法树，规则与 lparser.c 一致。
+source 为函数原型的 Source（如 "@foo.lua"），用于生成错误信息；出错时返回 *lexer.Error
+*/
+func Parse(chunk, source string) (*Block, error) {
+	return parse(chunk, source, nil)
+}
+
+/*
+与 Parse 相同，同时记录每个节点在源代码中的范围，用于编写重构等工具。
+函数定义语句中的函数构造器从 ( 开始，其余的函数构造器从 function 开始
+*/
+func ParseFile(chunk, source string) (*File, error) {
+	ranges := map[Node]Range{}
+	block, err := parse(chunk, source, ranges)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Block: block, Ranges: ranges}, nil
+}
+
+func parse(chunk, source string, ranges map[Node]Range) (block *Block, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			block, err = nil, e
+		}
+	}()
+
+	p := &parser{lexer: NewLexer(chunk, source), ranges: ranges}
+	p.openFunc(0, true)
+	p.next()
+	block = p.parseBlock()
+	p.check(TOKEN_EOF)
+	return block, nil
+}
+
+/*
+语法分析器的状态，与 LexState 一样保存当前的词法单元和上一个词法单元所在的行
+*/
+type parser struct {
+	lexer    *Lexer
+	t        Token
+	lastLine int
+	level    int
+	funcs    []funcState
+	// 上一个词法单元结束之后的位置
+	lastEnd Pos
+	// 节点的范围，为 nil 时不记录
+	ranges map[Node]Range
+}
+
+/*
+正在解析的函数，用于检查 ... 的使用和生成 "in function at line N" 这样的错误信息
+*/
+type funcState struct {
+	line     int
+	isVararg bool
+}
+
+func (self *parser) openFunc(line int, isVararg bool) {
+	self.funcs = append(self.funcs, funcState{line, isVararg})
+}
+
+func (self *parser) closeFunc() {
+	self.funcs = self.funcs[:len(self.funcs)-1]
+}
+
+func (self *parser) fs() *funcState {
+	return &self.funcs[len(self.funcs)-1]
+}
+
+/*
+读取下一个词法单元，与 luaX_next 一致
+*/
+func (self *parser) next() {
+	self.lastLine = self.t.Line
+	self.lastEnd = tokenEnd(self.t)
+	self.t = self.lexer.NextToken()
+}
+
+/*
+词法单元结束之后的位置，跨行的长字符串结束于最后一行
+*/
+func tokenEnd(tok Token) Pos {
+	if i := strings.LastIndexAny(tok.Text, "\r\n"); i >= 0 {
+		return Pos{Line: tok.Line, Column: len(tok.Text) - i}
+	}
+	return Pos{Line: tok.Line, Column: tok.Column + len(tok.Text)}
+}
+
+/*
+当前词法单元开始的位置
+*/
+func (self *parser) pos() Pos {
+	return Pos{Line: self.t.StartLine, Column: self.t.Column}
+}
+
+/*
+记录节点的范围：从 start 开始，到上一个词法单元结束为止
+*/
+func (self *parser) setRange(node Node, start Pos) {
+	if self.ranges != nil {
+		self.ranges[node] = Range{Start: start, End: self.lastEnd}
+	}
+}
+
+func (self *parser) test(kind int) bool {
+	return self.t.Kind == kind
+}
+
+/*
+当前词法单元为 kind 时消耗它并返回 true
+*/
+func (self *parser) testNext(kind int) bool {
+	if self.t.Kind == kind {
+		self.next()
+		return true
+	}
+	return false
+}
+
+func (self *parser) check(kind int) {
+	if self.t.Kind != kind {
+		self.errorExpected(kind)
+	}
+}
+
+func (self *parser) checkNext(kind int) {
+	self.check(kind)
+	self.next()
+}
+
+/*
+检查成对出现的关键字，what 与 who 不在同一行时在错误信息中注明 who 所在的行
+*/
+func (self *parser) checkMatch(what, who, line int) {
+	if self.testNext(what) {
+		return
+	}
+	msg := KindName(what) + " expected"
+	if line != self.t.Line {
+		msg = fmt.Sprintf("%s (to close %s at line %d)", msg, KindName(who), line)
+	}
+	err := self.newError(msg, what)
+	err.Opener, err.OpenerLine = KindName(who), line
+	panic(err)
+}
+
+func (self *parser) checkName() string {
+	self.check(TOKEN_IDENTIFIER)
+	name := self.t.Value.(string)
+	self.next()
+	return name
+}
+
+func (self *parser) syntaxError(msg string) {
+	self.lexer.ErrorNear(self.t, msg)
+}
+
+/*
+抛出不带 near 部分的语义错误，与 luaK_semerror 一致
+*/
+func (self *parser) semError(msg string) {
+	self.lexer.Error(self.t.Line, msg)
+}
+
+func (self *parser) errorExpected(kind int) {
+	panic(self.newError(KindName(kind)+" expected", kind))
+}
+
+/*
+抛出语法错误并在其中记录此处可以出现的词法单元
+*/
+func (self *parser) errorExpecting(msg string, kinds ...int) {
+	panic(self.newError(msg, kinds...))
+}
+
+func (self *parser) newError(msg string, kinds ...int) *Error {
+	err := self.lexer.NewError(self.t, msg)
+	for _, kind := range kinds {
+		err.Expected = append(err.Expected, KindName(kind))
+	}
+	return err
+}
+
+/*
+与 errorlimit 一致，报告超出限制的错误
+*/
+func (self *parser) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := self.fs().line; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	self.syntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (self *parser) enterLevel() {
+	self.level++
+	if self.level > maxLevels {
+		self.errorLimit(maxLevels, "C levels")
+	}
+}
+
+func (self *parser) leaveLevel() {
+	self.level--
+}
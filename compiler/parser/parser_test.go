@@ -0,0 +1,118 @@
+package parser
+
+import (
+	. "lua-vm/compiler/ast"
+	. "lua-vm/compiler/lexer"
+	"strings"
+	"testing"
+)
+
+func parseStat(t *testing.T, src string) Stat {
+	t.Helper()
+	block, err := Parse(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	if len(block.Stats) != 1 {
+		t.Fatalf("parse %q: got %d statements, want 1", src, len(block.Stats))
+	}
+	return block.Stats[0]
+}
+
+func TestPrecedence(t *testing.T) {
+	// x = 1 + 2 * -3 ^ 2 .. "a" .. "b"，.. 和 ^ 是右结合的，一元运算符的优先级低于 ^
+	stat := parseStat(t, `x = 1 + 2 * -3 ^ 2 .. "a" .. "b"`).(*AssignStat)
+	concat := stat.ExpList[0].(*BinopExp)
+	if concat.Op != TOKEN_OP_CONCAT {
+		t.Fatalf("top operator is %d, want ..", concat.Op)
+	}
+	if right := concat.Exp2.(*BinopExp); right.Op != TOKEN_OP_CONCAT {
+		t.Errorf(".. is not right associative")
+	}
+	add := concat.Exp1.(*BinopExp)
+	if add.Op != TOKEN_OP_ADD {
+		t.Fatalf("left of .. is %d, want +", add.Op)
+	}
+	mul := add.Exp2.(*BinopExp)
+	unm := mul.Exp2.(*UnopExp)
+	if pow := unm.Exp.(*BinopExp); unm.Op != TOKEN_OP_UNM || pow.Op != TOKEN_OP_POW {
+		t.Errorf("-3 ^ 2 is not parsed as -(3 ^ 2)")
+	}
+
+	cmp := parseStat(t, "x = a or b and c == d").(*AssignStat).ExpList[0].(*BinopExp)
+	if and := cmp.Exp2.(*BinopExp); cmp.Op != TOKEN_OP_OR || and.Op != TOKEN_OP_AND || and.Exp2.(*BinopExp).Op != TOKEN_OP_EQ {
+		t.Errorf("a or b and c == d is not parsed as a or (b and (c == d))")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	fn := parseStat(t, "function a.b:c(x, ...) return x end").(*FuncDefStat)
+	if !fn.IsMethod || !fn.Func.IsVararg || len(fn.Func.ParList) != 1 {
+		t.Errorf("bad method definition %+v", fn)
+	}
+
+	local := parseStat(t, "local x <const>, y = 1").(*LocalVarDeclStat)
+	if strings.Join(local.NameList, ",") != "x,y" || strings.Join(local.AttribList, ",") != "const," || len(local.ExpList) != 1 {
+		t.Errorf("bad local declaration %+v", local)
+	}
+
+	loop := parseStat(t, "for k, v in pairs(t) do\nbreak\nend").(*ForInStat)
+	if len(loop.NameList) != 2 || loop.LastLine != 3 {
+		t.Errorf("bad generic for %+v", loop)
+	}
+	if _, ok := loop.Block.Stats[0].(*BreakStat); !ok {
+		t.Errorf("loop body is %T, want *BreakStat", loop.Block.Stats[0])
+	}
+
+	table := parseStat(t, `t = {1, x = 2, ["y"] = 3; f()}`).(*AssignStat).ExpList[0].(*TableConstructorExp)
+	if len(table.Fields) != 4 {
+		t.Fatalf("got %d table fields, want 4", len(table.Fields))
+	}
+	if table.Fields[0].Key != nil || table.Fields[1].Key.(*StringExp).Str != "x" {
+		t.Errorf("bad table fields %+v", table.Fields)
+	}
+}
+
+func TestParseFileRanges(t *testing.T) {
+	src := "local x = 1\nif x then\n  print(x + 2)\nend\n"
+	file, err := ParseFile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	ifStat := file.Block.Stats[1].(*IfStat)
+	if r, ok := file.Range(ifStat); !ok || r.String() != "2:1-4:4" {
+		t.Errorf("if statement range %v, %v", r, ok)
+	}
+	call := ifStat.Clauses[0].Block.Stats[0].(*FuncCallExp)
+	sum := call.Args[0]
+	if r, ok := file.Range(sum); !ok || r.String() != "3:9-3:14" {
+		t.Errorf("x + 2 range %v, %v", r, ok)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ src, msg string }{
+		{"x = ", "test.lua:1: unexpected symbol near <eof>"},
+		{"if x then", "test.lua:1: 'end' expected near <eof>"},
+		{"local function f()\nx = 1\n", "test.lua:3: 'end' expected (to close 'function' at line 1) near <eof>"},
+		{"x = 1 = 2", "test.lua:1: unexpected symbol near '='"},
+		{"for i = 1 do end", "test.lua:1: ',' expected near 'do'"},
+		{"x = (1", "test.lua:1: ')' expected near <eof>"},
+		{"a.b:c = 1", "test.lua:1: function arguments expected near '='"},
+		{"f() = 1", "test.lua:1: syntax error near '='"},
+		{"local function f() return 1 x = 2 end", "test.lua:1: 'end' expected near 'x'"},
+		{"local 1 = 2", "test.lua:1: <name> expected near '1'"},
+		{"local x <foo> = 1", "test.lua:1: unknown attribute 'foo'"},
+		{"x = " + strings.Repeat("(", 300) + "1", "test.lua:1: too many C levels (limit is 200) in main function near '('"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src, "@test.lua")
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("Parse(%.30q): got error %v, want %q", tt.src, err, tt.msg)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("Parse(%.30q): error is %T, want *lexer.Error", tt.src, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package decompiler
+
+/*
+反编译过程中使用的简化版 Lua 语法树，只包含输出源代码所需的信息
+*/
+
+type expr interface{}
+
+type nilExpr struct{}
+type trueExpr struct{}
+type falseExpr struct{}
+
+/*
+变长参数 `...`，multi 为 false 时表示只取第一个值，输出时需要加上括号
+*/
+type varargExpr struct {
+	multi bool
+}
+
+/*
+多返回值中除第一个值以外的部分，只在合并多重赋值时使用，不会被输出
+*/
+type contExpr struct{}
+
+type integerExpr struct {
+	val int64
+}
+
+type floatExpr struct {
+	val float64
+}
+
+type stringExpr struct {
+	val string
+}
+
+/*
+局部变量、Upvalue 或全局变量的名字
+*/
+type nameExpr struct {
+	name string
+}
+
+/*
+表访问 obj[key]，key 为合法标识符时输出成 obj.key
+*/
+type indexExpr struct {
+	obj expr
+	key expr
+}
+
+/*
+函数调用，method 不为空时表示 obj:method(args) 形式的调用，此时 fn 为 obj；
+multi 为 false 时表示只取第一个返回值，在表达式列表的末尾时输出时需要加上括号
+*/
+type callExpr struct {
+	fn     expr
+	method string
+	args   []expr
+	multi  bool
+}
+
+/*
+SELF 指令产生的中间结果，随后的 CALL 指令会把它变成方法调用
+*/
+type methodExpr struct {
+	obj  expr
+	name string
+}
+
+type binopExpr struct {
+	op   string
+	a, b expr
+}
+
+type unopExpr struct {
+	op string
+	a  expr
+}
+
+/*
+表构造器，hash 部分与数组部分按照原本的顺序排列在 fields 中
+*/
+type tableExpr struct {
+	fields []tableField
+}
+
+/*
+表构造器中的一项，key 为 nil 时表示数组部分
+*/
+type tableField struct {
+	key expr
+	val expr
+	// 字段在指令表中的位置，用于恢复数组部分和 hash 部分之间的顺序
+	order int
+}
+
+/*
+函数构造器
+*/
+type funcExpr struct {
+	params   []string
+	isVararg bool
+	body     []stmt
+}
+
+type stmt interface{}
+
+/*
+local names = exprs
+*/
+type localStmt struct {
+	names []string
+	exprs []expr
+}
+
+/*
+local function name(...) ... end
+*/
+type localFuncStmt struct {
+	name string
+	fn   *funcExpr
+}
+
+/*
+targets = exprs，多重赋值时 targets 和 exprs 一一对应
+*/
+type assignStmt struct {
+	targets []expr
+	exprs   []expr
+}
+
+type callStmt struct {
+	call *callExpr
+}
+
+type returnStmt struct {
+	exprs []expr
+}
+
+type breakStmt struct{}
+
+type gotoStmt struct {
+	label string
+}
+
+type labelStmt struct {
+	label string
+}
+
+/*
+do ... end，只在其中的局部变量的作用域比外层的语句块结束得更早时输出
+*/
+type doStmt struct {
+	body []stmt
+}
+
+/*
+if 语句，elseif 会在输出时从 els 中恢复出来
+*/
+type ifStmt struct {
+	cond expr
+	then []stmt
+	els  []stmt
+}
+
+type whileStmt struct {
+	cond expr
+	body []stmt
+}
+
+type repeatStmt struct {
+	body []stmt
+	cond expr
+}
+
+type numericForStmt struct {
+	name              string
+	init, limit, step expr
+	body              []stmt
+}
+
+type genericForStmt struct {
+	names []string
+	exprs []expr
+	body  []stmt
+}
+
+/*
+repeat 循环末尾的 until 条件，只在反编译过程中使用，会被合并到 repeatStmt 中
+*/
+type untilStmt struct {
+	cond expr
+}
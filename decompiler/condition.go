@@ -0,0 +1,781 @@
+package decompiler
+
+import (
+	"lua-vm/analysis"
+	. "lua-vm/vm"
+)
+
+/*
+条件跳转链中的一个节点：[start, test) 中计算操作数，test 指令之后的 JMP 在条件成立时跳转到 target
+*/
+type condNode struct {
+	start  int
+	test   int
+	target int
+}
+
+func (self condNode) jmp() int {
+	return self.test + 1
+}
+
+// 条件跳转的目标对整个条件表达式的意义
+const (
+	exitNone = iota
+	exitTrue
+	exitFalse
+)
+
+// 用于表示“到达了条件成立/不成立的位置”，在合并表达式时会被化简掉
+var condTrue = &trueExpr{}
+var condFalse = &falseExpr{}
+
+/*
+对表达式取反，尽量去掉多余的 not
+*/
+func negate(e expr) expr {
+	switch x := e.(type) {
+	case *unopExpr:
+		if x.op == "not" {
+			return x.a
+		}
+	case *binopExpr:
+		switch x.op {
+		case "==":
+			return &binopExpr{"~=", x.a, x.b}
+		case "~=":
+			return &binopExpr{"==", x.a, x.b}
+		}
+	case *trueExpr:
+		if x == condTrue {
+			return condFalse
+		}
+		return &falseExpr{}
+	case *falseExpr:
+		if x == condFalse {
+			return condTrue
+		}
+		return &trueExpr{}
+	}
+	return &unopExpr{"not", e}
+}
+
+func and(a, b expr) expr {
+	if b == condTrue {
+		return a
+	}
+	return &binopExpr{"and", a, b}
+}
+
+func or(a, b expr) expr {
+	if b == condFalse {
+		return a
+	}
+	return &binopExpr{"or", a, b}
+}
+
+/*
+根据条件跳转链重建 and/or 表达式，conds 为 nil 时只检查结构是否合法
+*/
+type condBuilder struct {
+	nodes []condNode
+	conds []expr
+	// 返回第 k 个节点跳转到链外的 target 时对应的结果
+	kind func(k, target int) int
+	// 链结束的位置，即最后一个 JMP 的下一条指令
+	fall int
+}
+
+func (self *condBuilder) pos(k int) int {
+	if k == len(self.nodes) {
+		return self.fall
+	}
+	return self.nodes[k].start
+}
+
+/*
+返回跳转目标对应的节点，不是任何节点的开头时返回 -1
+*/
+func (self *condBuilder) nodeAt(target int) int {
+	for k := 1; k <= len(self.nodes); k++ {
+		if self.pos(k) == target {
+			return k
+		}
+	}
+	return -1
+}
+
+func (self *condBuilder) cond(k int) expr {
+	if self.conds == nil {
+		return &nilExpr{}
+	}
+	return self.conds[k]
+}
+
+/*
+返回节点 k 跳转到 target 时的意义，target 为 j 号位置时由 endVal 决定
+*/
+func (self *condBuilder) kindAt(k, target, j int, endVal expr) int {
+	if target == self.pos(j) {
+		switch endVal {
+		case condTrue:
+			return exitTrue
+		case condFalse:
+			return exitFalse
+		}
+		return exitNone
+	}
+	if self.nodeAt(target) >= 0 {
+		return exitNone
+	}
+	return self.kind(k, target)
+}
+
+/*
+构造节点 [i, j) 对应的表达式，从最后一个节点顺序执行下去到达 j 号位置时表达式的值为 endVal
+*/
+func (self *condBuilder) build(i, j int, endVal expr) (expr, bool) {
+	if i == j {
+		return endVal, true
+	}
+	t := self.nodes[i].target
+
+	if k := self.nodeAt(t); k > i+1 && k < j {
+		// [i, k) 组成一个子表达式，其中的节点要么跳转到 k，要么跳转到同一个链外的位置
+		groupKind := exitNone
+		for m := i; m < k; m++ {
+			tm := self.nodes[m].target
+			if n := self.nodeAt(tm); tm == self.pos(k) || n > m && n < k {
+				continue
+			}
+			km := self.kindAt(m, tm, j, endVal)
+			if km == exitNone || groupKind != exitNone && km != groupKind {
+				return nil, false
+			}
+			groupKind = km
+		}
+		if groupKind == exitNone {
+			return nil, false
+		}
+		rest, ok := self.build(k, j, endVal)
+		if !ok {
+			return nil, false
+		}
+		if groupKind == exitFalse {
+			group, ok := self.build(i, k, condTrue)
+			return and(group, rest), ok
+		}
+		group, ok := self.build(i, k, condFalse)
+		return or(group, rest), ok
+	}
+
+	kind := self.kindAt(i, t, j, endVal)
+	if kind == exitNone {
+		return nil, false
+	}
+	c := self.cond(i)
+	rest, ok := self.build(i+1, j, endVal)
+	if !ok {
+		return nil, false
+	}
+	if kind == exitTrue {
+		return or(c, rest), true
+	}
+	return and(negate(c), rest), true
+}
+
+/*
+判断 pc 处的指令是否只计算中间结果，可以作为条件表达式的一部分；
+write 为允许写入的局部变量寄存器，-1 表示不允许
+*/
+func (self *funcState) isPure(pc, write int) bool {
+	i := self.instruction(pc)
+	a, b, c := i.ABC()
+	switch i.Opcode() {
+	case OP_MOVE, OP_LOADK, OP_GETUPVAL, OP_GETTABUP, OP_GETTABLE, OP_NEWTABLE, OP_CLOSURE,
+		OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV, OP_BAND, OP_BOR, OP_BXOR,
+		OP_SHL, OP_SHR, OP_UNM, OP_BNOT, OP_NOT, OP_LEN, OP_CONCAT, OP_VARARG, OP_SELF:
+	case OP_LOADBOOL:
+		if c != 0 {
+			return false
+		}
+	case OP_LOADNIL:
+		if b != 0 {
+			return false
+		}
+	case OP_CALL:
+		if c == 1 {
+			return false
+		}
+	case OP_SETTABLE, OP_SETLIST:
+		// 表构造器
+		p := self.pending[a]
+		if p == nil {
+			return false
+		}
+		if _, ok := p.e.(*tableExpr); !ok {
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+	self.pc = pc
+	return a == write || !self.writesVar(a)
+}
+
+/*
+判断 [start, end) 中的指令是否都只计算中间结果，并且中间没有局部变量开始或者 goto 的目标；
+其中的 NEWTABLE 之后对同一个寄存器的 SETTABLE/SETLIST 属于表构造器
+*/
+func (self *funcState) isPureRange(start, end, write int) bool {
+	saved := self.pc
+	defer func() { self.pc = saved }()
+	tables := map[int]bool{}
+	for pc := start; pc < end; pc++ {
+		if pc > start && self.labels[pc] {
+			return false
+		}
+		i := self.instruction(pc)
+		a, _, _ := i.ABC()
+		switch op := i.Opcode(); {
+		case op == OP_NEWTABLE:
+			tables[a] = true
+		case (op == OP_SETTABLE || op == OP_SETLIST) && tables[a]:
+			continue
+		}
+		if !self.isPure(pc, write) {
+			return false
+		}
+	}
+	return !self.localStarts(start, end-1)
+}
+
+/*
+判断 [start, end) 中最后写入寄存器 reg 的是否为 end-1 处的指令，表构造器以最后一条 SETTABLE/SETLIST 结束
+*/
+func (self *funcState) tailWrites(start, end, reg int) bool {
+	if self.writes(end-1, reg) {
+		return true
+	}
+	if !self.isTableStore(end - 1) {
+		return false
+	}
+	if a, _, _ := self.instruction(end - 1).ABC(); a != reg {
+		return false
+	}
+	for pc := end - 2; pc >= start; pc-- {
+		if self.writes(pc, reg) {
+			return self.instruction(pc).Opcode() == OP_NEWTABLE
+		}
+	}
+	return false
+}
+
+/*
+判断是否有局部变量在 (start, end] 中开始
+*/
+func (self *funcState) localStarts(start, end int) bool {
+	for _, v := range self.proto.LocVars {
+		if int(v.StartPc) > start && int(v.StartPc) <= end {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+从 pc 处的 test 指令开始收集条件跳转链中所有可能的节点
+*/
+func (self *funcState) collectNodes(pc, end int) []condNode {
+	nodes := []condNode{{start: pc, test: pc, target: self.target(pc + 1)}}
+	start := pc + 2
+	for t := start; t+1 < end; t++ {
+		i := self.instruction(t)
+		if i.IsTest() && self.instruction(t+1).Opcode() == OP_JMP {
+			if !self.isPureRange(start, t, -1) || self.labels[t] && t > start || self.localStarts(start, t) {
+				break
+			}
+			nodes = append(nodes, condNode{start: start, test: t, target: self.target(t + 1)})
+			start = t + 2
+			t++
+			continue
+		}
+		if i.Opcode() == OP_JMP || !self.isPure(t, -1) {
+			break
+		}
+	}
+	return nodes
+}
+
+/*
+计算各个节点的条件：先执行节点前面计算操作数的指令，再根据 test 指令构造条件成立时的表达式
+*/
+func (self *funcState) nodeConds(nodes []condNode) []expr {
+	conds := make([]expr, len(nodes))
+	for k, node := range nodes {
+		for pc := node.start; pc < node.test; {
+			pc = self.exec(pc)
+		}
+		self.pc = node.test
+		i := self.instruction(node.test)
+		a, b, c := i.ABC()
+		switch i.Opcode() {
+		case OP_TEST, OP_TESTSET:
+			reg := a
+			if i.Opcode() == OP_TESTSET {
+				reg = b
+			}
+			conds[k] = self.read(reg)
+			if c == 0 {
+				conds[k] = negate(conds[k])
+			}
+		case OP_EQ, OP_LT, OP_LE:
+			x := self.rk(b)
+			y := self.rk(c)
+			op := map[int]string{OP_EQ: "==", OP_LT: "<", OP_LE: "<="}[i.Opcode()]
+			conds[k] = &binopExpr{op, x, y}
+			if a == 0 {
+				conds[k] = negate(conds[k])
+			}
+		}
+	}
+	return conds
+}
+
+/*
+返回节点中跳转到链外的所有目标
+*/
+func externalTargets(b *condBuilder) []int {
+	var targets []int
+	seen := map[int]bool{}
+	for _, node := range b.nodes {
+		t := node.target
+		if t == b.fall || b.nodeAt(t) >= 0 || seen[t] {
+			continue
+		}
+		seen[t] = true
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+/*
+反编译从 pc 处的 test 指令开始的条件结构：先尝试作为 and/or 表达式的值，再尝试作为 if 语句的条件
+*/
+func (self *funcState) condition(pc, end int) int {
+	nodes := self.collectNodes(pc, end)
+	for n := len(nodes); n >= 1; n-- {
+		if next, ok := self.valueRegion(nodes[:n], end); ok {
+			return next
+		}
+	}
+	for n := len(nodes); n >= 1; n-- {
+		if next, ok := self.ifChain(nodes[:n], end); ok {
+			return next
+		}
+	}
+
+	// 无法识别的结构，输出成 goto
+	node := nodes[0]
+	if self.instruction(node.test).Opcode() == OP_TESTSET {
+		self.testSetJump(node)
+		return node.jmp() + 1
+	}
+	self.keepTested(node)
+	cond := self.nodeConds(nodes[:1])[0]
+	self.emit(&ifStmt{cond: cond, then: []stmt{self.gotoStmt(node.target)}})
+	return node.jmp() + 1
+}
+
+/*
+TEST 测试的中间结果在跳转之后仍然是寄存器的值，没有被识别成 and/or 表达式时要先把它保存到变量中
+*/
+func (self *funcState) keepTested(node condNode) {
+	a, _, _ := self.instruction(node.test).ABC()
+	if self.pending[a] == nil || !self.testedLive(node) {
+		return
+	}
+	self.pc = node.test
+	self.assign(&nameExpr{self.regNameAt(a, node.test)}, self.read(a))
+	self.flush()
+}
+
+/*
+判断 TEST 测试的中间结果在测试之后是否还会被读取
+*/
+func (self *funcState) testedLive(node condNode) bool {
+	i := self.instruction(node.test)
+	a, _, _ := i.ABC()
+	if i.Opcode() != OP_TEST {
+		return false
+	}
+	if self.valueTests[node.test] {
+		return true
+	}
+	self.pc = node.test
+	return !self.isLocalReg(a) && (self.regLive(node.target, a) || self.regLive(node.jmp()+1, a))
+}
+
+/*
+判断从 pc 开始执行时，寄存器 reg 在被写入之前是否可能被读取
+*/
+func (self *funcState) regLive(pc, reg int) bool {
+	seen := map[int]bool{}
+	work := []int{pc}
+	for len(work) > 0 {
+		p := work[len(work)-1]
+		work = work[:len(work)-1]
+		if p < 0 || p >= len(self.proto.Code) || seen[p] {
+			continue
+		}
+		seen[p] = true
+		uses, defs := self.regUses(p)
+		if containsReg(uses, reg) {
+			return true
+		}
+		if containsReg(defs, reg) {
+			continue
+		}
+		work = append(work, analysis.Successors(self.proto, p)...)
+	}
+	return false
+}
+
+/*
+把无法识别的 TESTSET 输出成 goto，跳转之前的赋值放在 if 语句中，使跳转目标处的寄存器总是有值
+*/
+func (self *funcState) testSetJump(node condNode) {
+	a, b, c := self.instruction(node.test).ABC()
+	self.pc = node.test
+	val := self.read(b)
+	if _, ok := val.(*nameExpr); !ok {
+		// 保存到变量中，以免条件和赋值重复计算
+		self.fallback[b] = true
+		self.assign(&nameExpr{self.regName(b)}, val)
+		val = &nameExpr{self.regName(b)}
+	}
+	cond := val
+	if c == 0 {
+		cond = negate(val)
+	}
+	set := &assignStmt{targets: []expr{&nameExpr{self.regNameAt(a, node.test)}}, exprs: []expr{val}}
+	self.emit(&ifStmt{cond: cond, then: []stmt{set, self.gotoStmt(node.target)}})
+}
+
+/*
+条件跳转链作为 if、while、repeat 或 break 的条件
+*/
+func (self *funcState) ifChain(nodes []condNode, end int) (int, bool) {
+	// TESTSET 在跳转时写入寄存器，只会出现在 and/or 表达式的值中
+	for k, node := range nodes {
+		if self.instruction(node.test).Opcode() == OP_TESTSET || k > 0 && self.testedLive(node) {
+			return 0, false
+		}
+	}
+	b := &condBuilder{nodes: nodes, fall: nodes[len(nodes)-1].jmp() + 1}
+	targets := externalTargets(b)
+	if len(targets) != 1 {
+		return 0, false
+	}
+	e := targets[0]
+	b.kind = func(k, target int) int {
+		if target == e {
+			return exitFalse
+		}
+		return exitNone
+	}
+	if _, ok := b.build(0, len(nodes), condTrue); !ok {
+		return 0, false
+	}
+
+	f := b.fall
+	loop := self.innerLoop()
+	switch {
+	case loop != nil && loop.repeat && e == loop.head && f == end:
+	case loop != nil && e == loop.exit:
+		// 嵌套的 if ... else break end 紧挨在外层的 break 之前时，最后的节点属于内层的 if
+		if p, ok := self.elseBreak(f, end, e); ok && len(nodes) > 1 && self.isElseBreak(p-2, p-1, e) {
+			return 0, false
+		}
+	case e > f && e <= end:
+	default:
+		if len(nodes) > 1 {
+			return 0, false
+		}
+	}
+
+	self.keepTested(nodes[0])
+	b.conds = self.nodeConds(nodes)
+	cond, _ := b.build(0, len(nodes), condTrue)
+
+	switch {
+	case loop != nil && loop.repeat && e == loop.head && f == end:
+		self.emit(&untilStmt{cond})
+		return f, true
+	case loop != nil && e == loop.exit:
+		if p, ok := self.elseBreak(f, end, e); ok {
+			self.flush()
+			s := &ifStmt{cond: cond, els: []stmt{&breakStmt{}}}
+			s.then = self.block(f, p-1)
+			self.emit(s)
+			return p + 1, true
+		}
+		self.emit(&ifStmt{cond: negate(cond), then: []stmt{&breakStmt{}}})
+		return f, true
+	case e > f && e <= end:
+		thenEnd, next := e, e
+		if j := e - 1; j >= f && self.isUncondJump(j) {
+			if x := self.target(j); x > e && x <= end {
+				thenEnd, next = j, x
+			}
+		}
+		self.flush()
+		s := &ifStmt{cond: cond}
+		s.then = self.block(f, thenEnd)
+		if next > e {
+			s.els = self.block(e, next)
+		}
+		self.emit(s)
+		return next, true
+	default:
+		self.emit(&ifStmt{cond: negate(cond), then: []stmt{self.gotoStmt(e)}})
+		return f, true
+	}
+}
+
+/*
+if c then ... else break end：条件不成立时本应跳转到 else 中的 break，luac 把它直接指向了循环的出口，
+then 部分的末尾是一条跳过 break 的 JMP。返回 [f, end) 中第一个这样的 break 的位置 p，
+then 部分为 [f, p-1)；作为 end 之前最后一条语句时，跳过 break 的 JMP 可能被合并到了 end 处外层的 JMP 中
+*/
+func (self *funcState) elseBreak(f, end, exit int) (int, bool) {
+	for p := f + 1; p < end; p++ {
+		if self.isElseBreak(p, end, exit) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+func (self *funcState) isElseBreak(p, end, exit int) bool {
+	if p < 1 || !self.isUncondJump(p) || self.target(p) != exit || !self.isUncondJump(p-1) || self.isJumpTarget(p) {
+		return false
+	}
+	x := self.target(p - 1)
+	return x == p+1 || p+1 == end && self.isUncondJump(end) && self.target(end) == x
+}
+
+/*
+判断是否有跳转指令以 pc 为目标
+*/
+func (self *funcState) isJumpTarget(pc int) bool {
+	for p := range self.proto.Code {
+		switch self.instruction(p).OpMode() {
+		case IAsBx:
+			if self.target(p) == pc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+判断 pc 处是否为 LOADBOOL A b c
+*/
+func (self *funcState) isLoadBool(pc, b, c int) bool {
+	if pc < 0 || pc >= len(self.proto.Code) {
+		return false
+	}
+	i := self.instruction(pc)
+	_, ib, ic := i.ABC()
+	return i.Opcode() == OP_LOADBOOL && (ib != 0) == (b != 0) && (ic != 0) == (c != 0)
+}
+
+/*
+条件跳转链用于计算一个值（a and b、a or b、a < b 等），结果保存在同一个寄存器中
+*/
+func (self *funcState) valueRegion(nodes []condNode, end int) (int, bool) {
+	b := &condBuilder{nodes: nodes, fall: nodes[len(nodes)-1].jmp() + 1}
+	targets := externalTargets(b)
+	if len(targets) == 0 {
+		return 0, false
+	}
+
+	// LOADBOOL R 0 1; LOADBOOL R 1 0 把比较的结果转换成布尔值
+	q := -1
+	for _, t := range targets {
+		if self.isLoadBool(t, 0, 1) && self.isLoadBool(t+1, 1, 0) {
+			q = t
+		} else if self.isLoadBool(t, 1, 0) && self.isLoadBool(t-1, 0, 1) {
+			q = t - 1
+		}
+	}
+
+	reg := -1
+	tailEnd, valueEnd := -1, -1
+	endVal := expr(nil)
+	if q >= 0 {
+		reg, _, _ = self.instruction(q).ABC()
+		if r, _, _ := self.instruction(q + 1).ABC(); r != reg {
+			return 0, false
+		}
+		valueEnd = q + 2
+		switch {
+		case q == b.fall:
+			tailEnd = q
+			endVal = condFalse
+		case q-1 > b.fall && self.isUncondJump(q-1) && self.target(q-1) == valueEnd:
+			tailEnd = q - 1
+		default:
+			return 0, false
+		}
+		for _, t := range targets {
+			if t != q && t != q+1 && t != valueEnd {
+				return 0, false
+			}
+		}
+	} else {
+		if len(targets) != 1 {
+			return 0, false
+		}
+		valueEnd = targets[0]
+		tailEnd = valueEnd
+		if valueEnd <= b.fall {
+			return 0, false
+		}
+	}
+	if valueEnd > end {
+		return 0, false
+	}
+
+	// 跳转到 valueEnd 的节点必须是对同一个寄存器的 TEST/TESTSET
+	for k, node := range nodes {
+		if node.target != valueEnd {
+			continue
+		}
+		i := self.instruction(node.test)
+		a, _, _ := i.ABC()
+		switch {
+		case i.Opcode() == OP_TESTSET:
+		case i.Opcode() == OP_TEST:
+			if k == 0 && self.pending[a] == nil {
+				return 0, false
+			}
+			self.pc = node.test
+			if self.isLocalReg(a) && !self.valueTests[node.test] {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+		if reg >= 0 && a != reg {
+			return 0, false
+		}
+		reg = a
+	}
+	if reg < 0 {
+		return 0, false
+	}
+	if endVal == nil && (tailEnd <= b.fall || !self.isPureRange(b.fall, tailEnd, reg) || !self.tailWrites(b.fall, tailEnd, reg)) {
+		return 0, false
+	}
+
+	// a and b or c 与 (a or b) and c：跳转到 fall 的节点直接去计算最后的值，前面的节点组成 or 或 and 的左操作数；
+	// 跳转到 valueEnd 的节点在条件成立时跳转（c 为 1）的为 or，否则为 and，两者混合时无法表示
+	tailKind := exitNone
+	if endVal == nil && jumpsTo(nodes, b.fall) {
+		for _, node := range nodes {
+			if node.target != valueEnd {
+				continue
+			}
+			kind := exitFalse
+			if _, _, c := self.instruction(node.test).ABC(); c != 0 {
+				kind = exitTrue
+			}
+			if tailKind != exitNone && kind != tailKind {
+				return 0, false
+			}
+			tailKind = kind
+		}
+		if tailKind == exitNone {
+			return 0, false
+		}
+	}
+
+	b.kind = func(k, target int) int {
+		switch {
+		case q >= 0 && target == q:
+			return exitFalse
+		case q >= 0 && target == q+1:
+			return exitTrue
+		case target == valueEnd:
+			if _, _, c := self.instruction(nodes[k].test).ABC(); c != 0 {
+				return exitTrue
+			}
+			return exitFalse
+		}
+		return exitNone
+	}
+	probe := endVal
+	switch tailKind {
+	case exitTrue:
+		probe = condFalse
+	case exitFalse:
+		probe = condTrue
+	}
+	if probe == nil {
+		probe = &nilExpr{}
+	}
+	if _, ok := b.build(0, len(nodes), probe); !ok {
+		return 0, false
+	}
+
+	b.conds = self.nodeConds(nodes)
+	if endVal == nil {
+		self.capture = reg
+		for pc := b.fall; pc < tailEnd; {
+			pc = self.exec(pc)
+		}
+		self.capture = -1
+		endVal = self.read(reg)
+	}
+	var value expr
+	switch tailKind {
+	case exitTrue:
+		group, _ := b.build(0, len(nodes), condFalse)
+		value = or(group, endVal)
+	case exitFalse:
+		group, _ := b.build(0, len(nodes), condTrue)
+		value = and(group, endVal)
+	default:
+		value, _ = b.build(0, len(nodes), endVal)
+	}
+	self.pc = valueEnd - 1
+	self.setReg(reg, value)
+	if !self.hasPending() {
+		self.flush()
+	}
+	return valueEnd, true
+}
+
+/*
+判断是否有节点跳转到 target
+*/
+func jumpsTo(nodes []condNode, target int) bool {
+	for _, node := range nodes {
+		if node.target == target {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+判断 pc 处的指令是否写入了寄存器 reg
+*/
+func (self *funcState) writes(pc, reg int) bool {
+	i := self.instruction(pc)
+	a, _, _ := i.ABC()
+	return i.SetsA() && a == reg
+}
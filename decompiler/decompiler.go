@@ -0,0 +1,31 @@
+package decompiler
+
+import (
+	"fmt"
+	"io"
+	. "lua-vm/binchunk"
+)
+
+/*
+把 Prototype 反编译成 Lua 源代码写入 w：
+局部变量的名字来自 LocVars，Upvalue 的名字来自 UpvalueNames；
+被 `luac -s` 剔除了调试信息的函数使用 r0、u1 这样的合成名字；
+if/while/repeat/for 等结构从控制流中恢复，无法恢复的跳转输出成 goto。
+反编译之前会先用 Verify 检查 Prototype，不合法的字节码返回对应的错误
+*/
+func Decompile(w io.Writer, f *Prototype) (err error) {
+	if err := Verify(f); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("decompile: %v", r)
+		}
+	}()
+
+	fn := decompileFunc(f, nil, 0)
+	p := &printer{}
+	p.block(fn.body)
+	_, err = io.WriteString(w, p.b.String())
+	return err
+}
@@ -0,0 +1,184 @@
+package decompiler
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+/*
+编译 src，反编译之后再编译一遍，返回反编译得到的源代码以及重新编译得到的 Prototype
+*/
+func roundTrip(t *testing.T, src string, strip bool) (string, *Prototype) {
+	t.Helper()
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if strip {
+		stripDebug(proto)
+	}
+	var b strings.Builder
+	if err := Decompile(&b, proto); err != nil {
+		t.Fatalf("decompile: %v", err)
+	}
+	out := b.String()
+	again, err := compiler.Compile(out, "@test.lua")
+	if err != nil {
+		t.Fatalf("recompile: %v\n%s", err, out)
+	}
+	return out, again
+}
+
+/*
+反编译再编译之后，除了行号以外的字节码应当与原来相同
+*/
+func checkSameCode(t *testing.T, src string) string {
+	t.Helper()
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	out, again := roundTrip(t, src, false)
+	if want, got := listing(proto), listing(again); want != got {
+		t.Fatalf("bytecode differs after round trip\n%s\nwant:\n%s\ngot:\n%s", out, want, got)
+	}
+	return out
+}
+
+/*
+不含行号的指令列表，包括所有的子函数
+*/
+func listing(f *Prototype) string {
+	var b strings.Builder
+	opts := DisasmOptions{Address: func(*Prototype) string { return "function" }}
+	var list func(f *Prototype)
+	list = func(f *Prototype) {
+		fmt.Fprintf(&b, "function %d params %d slots\n", f.NumParams, f.MaxStackSize)
+		for pc := range f.Code {
+			fmt.Fprintln(&b, FormatInstruction(f, pc, opts))
+		}
+		for _, k := range f.Constants {
+			fmt.Fprintf(&b, "K %d %v\n", k.Tag, k.Value)
+		}
+		for _, p := range f.Protos {
+			list(p)
+		}
+	}
+	list(f)
+	return b.String()
+}
+
+func stripDebug(f *Prototype) {
+	f.Source = ""
+	f.LineInfo = nil
+	f.LocVars = nil
+	f.UpvalueNames = nil
+	for _, p := range f.Protos {
+		stripDebug(p)
+	}
+}
+
+func TestLoopElseBreak(t *testing.T) {
+	tests := []string{
+		"local s = 0\nfor i = 10, 1, -1 do if i % 2 == 0 then s = s + i else break end end\nprint(s)\n",
+		"local s = 0\nfor i = 1, 10 do if i > 3 then if i < 8 then s = s + i else break end else break end end\n",
+		"local s = 0\nfor i = 1, 10 do if i > 3 then s = s + 1 else break end if i < 8 then s = s - 1 else break end end\n",
+		"local s = 0\nfor i = 1, 10 do if i > 3 and i < 8 then s = s + i else break end end\n",
+		"local s = 0\nfor k, v in pairs({}) do if v then s = s + v else break end end\n",
+		"local s = 0\nrepeat if s then s = s + 1 else break end until s > 100\n",
+	}
+	for _, src := range tests {
+		out := checkSameCode(t, src)
+		if strings.Contains(out, "goto") {
+			t.Errorf("unexpected goto in\n%s", out)
+		}
+	}
+}
+
+func TestGotoLabelAtLoopEnd(t *testing.T) {
+	src := "local s = 0\nfor i = 1, 3 do\n  if i == 2 then goto continue end\n  s = s + i\n  ::continue::\nend\n"
+	checkSameCode(t, src)
+}
+
+func TestStrippedValueChains(t *testing.T) {
+	src := `local a, b, c = ...
+local q = (a or b) and c
+local r = a and b or c
+local u = (g or h) and k
+local w = t.x and t.y or f()
+local y = (a > 1) and "big" or "small"
+local v = t.a or t.b and t.c or {1, 2}
+x = (a or b) and c
+print(q, r, u, w, y, v)
+`
+	out, _ := roundTrip(t, src, true)
+	for _, want := range []string{
+		"r3 = (r0 or r1) and r2",
+		"r4 = r0 and r1 or r2",
+		"r5 = (g or h) and k",
+		"r6 = t.x and t.y or f()",
+		`r7 = 1 < r0 and "big" or "small"`,
+		"r8 = t.a or t.b and t.c or {1, 2}",
+		"x = (r0 or r1) and r2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestTestedValueKept(t *testing.T) {
+	// 表构造器中的比较无法合并成表达式，t.a 的值在跳转之后仍然是 u 的值
+	src := "local u = t.a or {t.b == 1}\nprint(u)\n"
+	for _, strip := range []bool{false, true} {
+		out, _ := roundTrip(t, src, strip)
+		if !strings.Contains(out, "r0 = t.a\n") {
+			t.Errorf("value of t.a is lost in\n%s", out)
+		}
+	}
+}
+
+func TestDoBlock(t *testing.T) {
+	src := `local x = 1
+do local z = 5 print(z) end
+do local a = 1 do local b = 2 print(a, b) end print(a) end
+for i = 1, 3 do
+  do local t = i * 2 print(t) end
+  print(i)
+end
+do local y = x f = function() return y end end
+do local function g() return g end print(g) end
+local z = 7
+print(x, z)
+`
+	out := checkSameCode(t, src)
+	n := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "do" {
+			n++
+		}
+	}
+	if n != 6 {
+		t.Errorf("got %d do blocks, want 6\n%s", n, out)
+	}
+}
+
+func TestDecompileInvalid(t *testing.T) {
+	proto, err := compiler.Compile("local x = 1\nreturn x\n", "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	// 把末尾的 RETURN 换成 LOADK 之后 Verify 会拒绝这个函数，反编译不输出任何内容
+	proto.Code[len(proto.Code)-1] = proto.Code[0]
+	var b strings.Builder
+	err = Decompile(&b, proto)
+	if err == nil || !strings.Contains(err.Error(), "code does not end with RETURN") {
+		t.Errorf("got error %v, want code does not end with RETURN", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("wrote %q for an invalid function", b.String())
+	}
+}
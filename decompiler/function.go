@@ -0,0 +1,852 @@
+package decompiler
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+	"sort"
+	"strings"
+)
+
+/*
+寄存器中尚未被使用的中间结果，被读取一次之后就会被删除
+*/
+type pendingValue struct {
+	e expr
+	// 写入该值的指令，用于恢复表构造器中各项的顺序
+	pc int
+	// 多返回值的第一个寄存器中记录返回值的数量，-1 表示一直到栈顶；其余的寄存器 cont 为 true
+	count int
+	cont  bool
+	// 表构造器已经赋值给了变量，之后的 SETTABLE/SETLIST 仍然加入到构造器中，读取时得到变量名；
+	// stmts 为赋值时的语句数量，之后又有语句输出时就不能再加入到构造器中了
+	alias bool
+	stmts int
+}
+
+/*
+正在反编译的循环，break 会跳转到 exit
+*/
+type loopInfo struct {
+	head   int
+	exit   int
+	repeat bool
+}
+
+/*
+单个函数的反编译状态
+*/
+type funcState struct {
+	proto     *Prototype
+	parent    *funcState
+	closurePc int
+	depth     int
+
+	params     []string
+	upvalNames []string
+	// 每个局部变量所在的寄存器，与 luaF_getlocalname 的规则一致
+	locRegs  []int
+	declared []bool
+
+	// 被 `luac -s` 剔除了调试信息的函数中被当作变量使用的寄存器，
+	// 对于带有调试信息的函数则是其中被多次读取的临时寄存器
+	stripped       bool
+	strippedLocals map[int]bool
+	// 被剔除了调试信息的函数中只保存中间结果的写入，键为指令的位置和寄存器
+	tempDefs map[[2]int]bool
+	// 被剔除了调试信息的函数中测试 and/or 表达式中间结果的 TEST 指令
+	valueTests map[int]bool
+	// 因为找不到值或名字而使用 rN 作为名字的寄存器，会在函数开头声明
+	fallback map[int]bool
+	// 使用合成名字的循环变量
+	scoped map[int]int
+
+	pc      int
+	pending map[int]*pendingValue
+	top     int
+	capture int
+	buffer  []*assignStmt
+	out     []stmt
+	// 到目前为止输出和缓存的语句数量
+	stmts int
+
+	loops       []*loopInfo
+	activeLoops map[[2]int]bool
+	labels      map[int]bool
+	emitted     map[int]bool
+}
+
+/*
+反编译一个函数，goto 的目标在第一遍反编译时才能确定，因此需要时会再反编译一遍
+*/
+func decompileFunc(proto *Prototype, parent *funcState, closurePc int) *funcExpr {
+	labels := map[int]bool{}
+	for pass := 0; ; pass++ {
+		self := newFuncState(proto, parent, closurePc, labels)
+		fn := self.decompile()
+		if pass > 0 || len(self.labels) == len(labels) {
+			return fn
+		}
+		labels = self.labels
+	}
+}
+
+func newFuncState(proto *Prototype, parent *funcState, closurePc int, labels map[int]bool) *funcState {
+	self := &funcState{
+		proto:       proto,
+		parent:      parent,
+		closurePc:   closurePc,
+		locRegs:     make([]int, len(proto.LocVars)),
+		declared:    make([]bool, len(proto.LocVars)),
+		stripped:    len(proto.LocVars) == 0,
+		fallback:    map[int]bool{},
+		scoped:      map[int]int{},
+		pending:     map[int]*pendingValue{},
+		top:         -1,
+		capture:     -1,
+		activeLoops: map[[2]int]bool{},
+		labels:      map[int]bool{},
+		emitted:     map[int]bool{},
+	}
+	for pc := range labels {
+		self.labels[pc] = true
+	}
+	if parent != nil {
+		self.depth = parent.depth + 1
+	}
+
+	for i, v := range proto.LocVars {
+		for j := 0; j < i; j++ {
+			w := proto.LocVars[j]
+			if w.StartPc <= v.StartPc && v.StartPc < w.EndPc {
+				self.locRegs[i]++
+			}
+		}
+	}
+
+	for i := 0; i < int(proto.NumParams); i++ {
+		if self.stripped {
+			self.params = append(self.params, self.regName(i))
+		} else if i < len(proto.LocVars) {
+			self.params = append(self.params, proto.LocVars[i].VarName)
+			self.declared[i] = true
+		}
+	}
+	if self.stripped {
+		self.strippedLocals = self.classifyRegisters()
+	} else {
+		self.strippedLocals = self.sharedTemporaries()
+	}
+
+	self.upvalNames = make([]string, len(proto.Upvalues))
+	for i := range proto.Upvalues {
+		self.upvalNames[i] = self.deriveUpvalName(i)
+	}
+	return self
+}
+
+func (self *funcState) decompile() *funcExpr {
+	body := self.block(0, len(self.proto.Code))
+
+	var names []string
+	for reg := int(self.proto.NumParams); reg < 256; reg++ {
+		if self.fallback[reg] || self.strippedLocals[reg] {
+			names = append(names, self.regName(reg))
+		}
+	}
+	if len(names) > 0 {
+		body = append([]stmt{&localStmt{names: names}}, body...)
+	}
+	return &funcExpr{params: self.params, isVararg: self.proto.IsVararg != 0, body: body}
+}
+
+/*
+没有名字的寄存器使用合成的名字，嵌套函数中的名字带上嵌套深度，以免遮蔽外层函数中被捕获的变量
+*/
+func (self *funcState) regName(reg int) string {
+	if self.depth == 0 {
+		return fmt.Sprintf("r%d", reg)
+	}
+	return fmt.Sprintf("r%d_%d", self.depth, reg)
+}
+
+func labelName(pc int) string {
+	return fmt.Sprintf("L%d", pc+1)
+}
+
+/*
+推导 Upvalue 的名字：调试信息被剔除时根据外层函数在创建闭包时的局部变量和 Upvalue 推导，
+主函数唯一的 Upvalue 总是 _ENV
+*/
+func (self *funcState) deriveUpvalName(idx int) string {
+	if idx < len(self.proto.UpvalueNames) && self.proto.UpvalueNames[idx] != "" {
+		return self.proto.UpvalueNames[idx]
+	}
+	if self.parent == nil {
+		if idx == 0 {
+			return "_ENV"
+		}
+		return fmt.Sprintf("u%d", idx)
+	}
+	uv := self.proto.Upvalues[idx]
+	if uv.Instack != 0 {
+		return self.parent.regNameAt(int(uv.Idx), self.closurePc)
+	}
+	if int(uv.Idx) < len(self.parent.upvalNames) {
+		return self.parent.upvalNames[uv.Idx]
+	}
+	return fmt.Sprintf("u%d", idx)
+}
+
+/*
+返回 pc 处寄存器 reg 对应的局部变量的名字
+*/
+func (self *funcState) localName(reg, pc int) (string, bool) {
+	if self.stripped {
+		if reg < int(self.proto.NumParams) || self.strippedLocals[reg] {
+			return self.regName(reg), true
+		}
+	} else {
+		for i := len(self.proto.LocVars) - 1; i >= 0; i-- {
+			v := self.proto.LocVars[i]
+			if self.locRegs[i] == reg && int(v.StartPc) <= pc && pc < int(v.EndPc) {
+				if strings.HasPrefix(v.VarName, "(") {
+					return "", false
+				}
+				return v.VarName, true
+			}
+		}
+		if self.strippedLocals[reg] {
+			return self.regName(reg), true
+		}
+	}
+	if self.scoped[reg] > 0 {
+		return self.regName(reg), true
+	}
+	return "", false
+}
+
+func (self *funcState) isLocalReg(reg int) bool {
+	_, ok := self.localName(reg, self.pc)
+	return ok
+}
+
+/*
+判断当前指令对寄存器 reg 的写入是否是对变量的赋值
+*/
+func (self *funcState) writesVar(reg int) bool {
+	return !self.tempDefs[[2]int{self.pc, reg}] && self.isLocalReg(reg)
+}
+
+/*
+返回寄存器在 pc 处的名字，没有对应的局部变量时使用合成的名字
+*/
+func (self *funcState) regNameAt(reg, pc int) string {
+	if name, ok := self.localName(reg, pc); ok {
+		return name
+	}
+	self.fallback[reg] = true
+	return self.regName(reg)
+}
+
+/*
+判断名字是否被 pc 处的局部变量或 Upvalue 遮蔽，此时全局变量需要通过 _ENV 访问
+*/
+func (self *funcState) isShadowed(name string) bool {
+	for i, v := range self.proto.LocVars {
+		if v.VarName == name && int(v.StartPc) <= self.pc && self.pc < int(v.EndPc) && self.declared[i] {
+			return true
+		}
+	}
+	for _, upval := range self.upvalNames {
+		if upval == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+输出一条语句，之前缓存的赋值语句先输出
+*/
+func (self *funcState) emit(s stmt) {
+	self.stmts++
+	self.flush()
+	self.out = append(self.out, s)
+}
+
+/*
+缓存一次赋值，等到所有中间结果都被使用之后再合并成一条多重赋值语句输出
+*/
+func (self *funcState) assign(target, e expr) {
+	self.stmts++
+	self.buffer = append(self.buffer, &assignStmt{targets: []expr{target}, exprs: []expr{e}})
+}
+
+/*
+输出缓存的赋值语句，luac 按照从右到左的顺序给变量赋值，因此需要反转回来
+*/
+func (self *funcState) flush() {
+	if len(self.buffer) == 0 {
+		return
+	}
+	s := &assignStmt{}
+	for i := len(self.buffer) - 1; i >= 0; i-- {
+		s.targets = append(s.targets, self.buffer[i].targets...)
+		for _, e := range self.buffer[i].exprs {
+			if _, ok := e.(*contExpr); !ok {
+				s.exprs = append(s.exprs, e)
+			}
+		}
+	}
+	if len(s.exprs) == 0 {
+		s.exprs = []expr{&nilExpr{}}
+	}
+	self.buffer = nil
+	self.out = append(self.out, s)
+}
+
+/*
+把 [start, end) 中产生但没有被使用的中间结果保存到合成的变量中，以免丢失其中的副作用；
+flush 会反转赋值的顺序，因此按照产生的顺序倒序处理，使输出的表达式按照原本的顺序求值
+*/
+func (self *funcState) materialize(start, end int) {
+	var regs []int
+	for reg, p := range self.pending {
+		if p.pc >= start && p.pc < end {
+			regs = append(regs, reg)
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		pi, pj := self.pending[regs[i]].pc, self.pending[regs[j]].pc
+		return pi > pj || pi == pj && regs[i] > regs[j]
+	})
+	for _, reg := range regs {
+		if self.pending[reg].cont || self.pending[reg].alias {
+			delete(self.pending, reg)
+			continue
+		}
+		e := self.read(reg)
+		self.fallback[reg] = true
+		self.assign(&nameExpr{self.regName(reg)}, e)
+	}
+	self.flush()
+}
+
+/*
+判断是否还有尚未被使用的中间结果
+*/
+func (self *funcState) hasPending() bool {
+	for _, p := range self.pending {
+		if !p.alias {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+表构造器结束，之后对变量的修改不再属于构造器
+*/
+func (self *funcState) dropAliases() {
+	for reg, p := range self.pending {
+		if p.alias {
+			delete(self.pending, reg)
+		}
+	}
+}
+
+/*
+读取寄存器中的值，中间结果被读取之后即被删除
+*/
+func (self *funcState) read(reg int) expr {
+	if p := self.pending[reg]; p != nil {
+		delete(self.pending, reg)
+		if !p.cont && !p.alias {
+			return p.e
+		}
+	}
+	return &nameExpr{self.regNameAt(reg, self.pc)}
+}
+
+/*
+读取用于赋值的值，多返回值的后续部分返回 contExpr，在合并多重赋值时会被去掉
+*/
+func (self *funcState) readValue(reg int) expr {
+	if p := self.pending[reg]; p != nil && p.cont {
+		delete(self.pending, reg)
+		return &contExpr{}
+	}
+	return self.read(reg)
+}
+
+/*
+读取从 reg 开始的 n 个寄存器，多返回值只保留第一个表达式
+*/
+func (self *funcState) readList(reg, n int) []expr {
+	var exprs []expr
+	for r := reg; r < reg+n; r++ {
+		if p := self.pending[r]; p != nil && p.cont {
+			delete(self.pending, r)
+			continue
+		}
+		exprs = append(exprs, self.read(r))
+	}
+	return exprs
+}
+
+/*
+读取从 reg 开始一直到栈顶的所有值
+*/
+func (self *funcState) readOpen(reg int) []expr {
+	top := self.top
+	self.top = -1
+	if top < reg {
+		return nil
+	}
+	return self.readList(reg, top-reg+1)
+}
+
+func (self *funcState) rk(x int) expr {
+	if ISK(x) {
+		return self.constant(INDEXK(x))
+	}
+	return self.read(x)
+}
+
+func (self *funcState) rkValue(x int) expr {
+	if ISK(x) {
+		return self.constant(INDEXK(x))
+	}
+	return self.readValue(x)
+}
+
+func (self *funcState) constant(idx int) expr {
+	switch x := self.proto.Constants[idx].Value.(type) {
+	case bool:
+		if x {
+			return &trueExpr{}
+		}
+		return &falseExpr{}
+	case int64:
+		return &integerExpr{x}
+	case float64:
+		return &floatExpr{x}
+	case string:
+		return &stringExpr{x}
+	default:
+		return &nilExpr{}
+	}
+}
+
+/*
+写入寄存器：写入局部变量时产生赋值语句，否则作为中间结果保存起来
+*/
+func (self *funcState) setReg(reg int, e expr) {
+	if reg != self.capture && !self.tempDefs[[2]int{self.pc, reg}] {
+		if name, ok := self.localName(reg, self.pc); ok {
+			delete(self.pending, reg)
+			self.assign(&nameExpr{name}, e)
+			if _, ok := e.(*tableExpr); ok {
+				self.pending[reg] = &pendingValue{e: e, pc: self.pc, alias: true, stmts: self.stmts}
+			}
+			return
+		}
+	}
+	self.pending[reg] = &pendingValue{e: e, pc: self.pc, count: 1}
+}
+
+/*
+把 n 个返回值写入从 reg 开始的寄存器，n 为 -1 时表示一直到栈顶
+*/
+func (self *funcState) setMulti(reg, n int, e expr) {
+	if n < 0 {
+		self.pending[reg] = &pendingValue{e: e, pc: self.pc, count: -1}
+		self.top = reg
+		return
+	}
+	if name, ok := self.localName(reg, self.pc); ok && !self.tempDefs[[2]int{self.pc, reg}] {
+		s := &assignStmt{targets: []expr{&nameExpr{name}}, exprs: []expr{e}}
+		for r := reg + 1; r < reg+n; r++ {
+			s.targets = append(s.targets, &nameExpr{self.regNameAt(r, self.pc)})
+		}
+		self.stmts++
+		self.buffer = append(self.buffer, s)
+		return
+	}
+	self.pending[reg] = &pendingValue{e: e, pc: self.pc, count: n}
+	for r := reg + 1; r < reg+n; r++ {
+		self.pending[r] = &pendingValue{pc: self.pc, cont: true}
+	}
+}
+
+/*
+访问 Upvalue 中的字段，_ENV 中以合法标识符为键的字段即全局变量
+*/
+func (self *funcState) upvalIndex(idx int, key expr) expr {
+	name := self.upvalName(idx)
+	if s, ok := key.(*stringExpr); ok && name == "_ENV" && isIdentifier(s.val) && !self.isShadowed(s.val) {
+		return &nameExpr{s.val}
+	}
+	return &indexExpr{&nameExpr{name}, key}
+}
+
+func (self *funcState) upvalName(idx int) string {
+	if idx < len(self.upvalNames) {
+		return self.upvalNames[idx]
+	}
+	return fmt.Sprintf("u%d", idx)
+}
+
+/*
+在 pc 处开始的局部变量在此处声明，返回 true 表示 pc 处的 CLOSURE 已经作为 local function 处理
+*/
+func (self *funcState) declareLocals(pc int) bool {
+	var names []string
+	var regs []int
+	for i, v := range self.proto.LocVars {
+		if int(v.StartPc) != pc || self.declared[i] {
+			continue
+		}
+		self.declared[i] = true
+		if strings.HasPrefix(v.VarName, "(") {
+			// for 循环内部使用的变量
+			continue
+		}
+		names = append(names, v.VarName)
+		regs = append(regs, self.locRegs[i])
+	}
+	if len(names) == 0 {
+		return false
+	}
+
+	i := Instruction(self.proto.Code[pc])
+	if a, _ := i.ABx(); len(names) == 1 && i.Opcode() == OP_CLOSURE && a == regs[0] && self.pending[a] == nil {
+		self.pc = pc
+		self.emit(&localFuncStmt{name: names[0], fn: self.closure(pc)})
+		return true
+	}
+
+	// local function f 在函数体之后才开始 f 的作用域，与 local f = function 的区别在于闭包捕获了 f 自己
+	if len(names) == 1 && pc > 0 && self.capturesSelf(pc-1, regs[0]) {
+		if p := self.pending[regs[0]]; p != nil && p.pc == pc-1 {
+			if fn, ok := p.e.(*funcExpr); ok {
+				delete(self.pending, regs[0])
+				self.pc = pc
+				self.emit(&localFuncStmt{name: names[0], fn: fn})
+				return false
+			}
+		}
+	}
+
+	self.pc = pc - 1
+	exprs := self.readList(regs[0], len(regs))
+	allNil := true
+	for _, e := range exprs {
+		if _, ok := e.(*nilExpr); !ok {
+			allNil = false
+		}
+	}
+	if allNil {
+		exprs = nil
+	}
+	self.emit(&localStmt{names: names, exprs: exprs})
+	return false
+}
+
+/*
+判断 pc 处是否为把闭包写入寄存器 reg 的 CLOSURE 指令，并且闭包把 reg 作为 Upvalue 捕获
+*/
+func (self *funcState) capturesSelf(pc, reg int) bool {
+	i := self.instruction(pc)
+	a, bx := i.ABx()
+	if i.Opcode() != OP_CLOSURE || a != reg || bx >= len(self.proto.Protos) {
+		return false
+	}
+	for _, uv := range self.proto.Protos[bx].Upvalues {
+		if uv.Instack != 0 && int(uv.Idx) == reg {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+反编译 pc 处的 CLOSURE 指令创建的函数
+*/
+func (self *funcState) closure(pc int) *funcExpr {
+	_, bx := Instruction(self.proto.Code[pc]).ABx()
+	return decompileFunc(self.proto.Protos[bx], self, pc)
+}
+
+/*
+构造 CALL/TAILCALL 指令对应的函数调用表达式
+*/
+func (self *funcState) callExpr(a, b int) *callExpr {
+	call := &callExpr{}
+	first := a + 1
+	if p := self.pending[a]; p != nil {
+		if m, ok := p.e.(*methodExpr); ok {
+			delete(self.pending, a)
+			delete(self.pending, a+1)
+			call.fn, call.method = m.obj, m.name
+			first = a + 2
+		}
+	}
+	if call.fn == nil {
+		call.fn = self.read(a)
+	}
+	if b == 0 {
+		call.args = self.readOpen(first)
+	} else {
+		call.args = self.readList(first, a+b-first)
+	}
+	return call
+}
+
+// 与 lopcodes.h 中的定义一致，每条 SETLIST 指令最多设置的数组元素数量
+const LFIELDS_PER_FLUSH = 50
+
+var arithOps = map[int]string{
+	OP_ADD: "+", OP_SUB: "-", OP_MUL: "*", OP_MOD: "%", OP_POW: "^", OP_DIV: "/", OP_IDIV: "//",
+	OP_BAND: "&", OP_BOR: "|", OP_BXOR: "~", OP_SHL: "<<", OP_SHR: ">>",
+}
+
+var unaryOps = map[int]string{
+	OP_UNM: "-", OP_BNOT: "~", OP_NOT: "not", OP_LEN: "#",
+}
+
+/*
+反编译一条不影响控制流的指令，返回下一条要处理的指令
+*/
+func (self *funcState) exec(pc int) int {
+	self.pc = pc
+	code := self.proto.Code
+	i := Instruction(code[pc])
+	a, b, c := i.ABC()
+	_, bx := i.ABx()
+
+	switch op := i.Opcode(); op {
+	case OP_MOVE:
+		if self.writesVar(a) && a != self.capture {
+			self.assign(&nameExpr{self.regNameAt(a, pc)}, self.readValue(b))
+		} else {
+			self.setReg(a, self.read(b))
+		}
+	case OP_LOADK:
+		self.setReg(a, self.constant(bx))
+	case OP_LOADKX:
+		if pc+1 < len(code) {
+			self.setReg(a, self.constant(Instruction(code[pc+1]).Ax()))
+		}
+		return pc + 2
+	case OP_LOADBOOL:
+		if b != 0 {
+			self.setReg(a, &trueExpr{})
+		} else {
+			self.setReg(a, &falseExpr{})
+		}
+		if c != 0 {
+			// 只会出现在无法识别的条件表达式中
+			self.emit(self.gotoStmt(pc + 2))
+		}
+	case OP_LOADNIL:
+		for r := a; r <= a+b; r++ {
+			self.setReg(r, &nilExpr{})
+		}
+	case OP_GETUPVAL:
+		self.setReg(a, &nameExpr{self.upvalName(b)})
+	case OP_GETTABUP:
+		self.setReg(a, self.upvalIndex(b, self.rk(c)))
+	case OP_GETTABLE:
+		obj := self.read(b)
+		self.setReg(a, &indexExpr{obj, self.rk(c)})
+	case OP_SETTABUP:
+		key := self.rk(b)
+		self.assign(self.upvalIndex(a, key), self.rkValue(c))
+	case OP_SETUPVAL:
+		self.assign(&nameExpr{self.upvalName(b)}, self.readValue(a))
+	case OP_SETTABLE:
+		if t := self.foldTable(a, b, c); t != nil {
+			key := self.rk(b)
+			t.fields = append(t.fields, tableField{key: key, val: self.rk(c), order: pc})
+			break
+		}
+		obj := self.tableVar(a)
+		key := self.rk(b)
+		self.assign(&indexExpr{obj, key}, self.rkValue(c))
+	case OP_NEWTABLE:
+		self.setReg(a, &tableExpr{})
+	case OP_SELF:
+		obj := self.read(b)
+		key := self.rk(c)
+		if s, ok := key.(*stringExpr); ok && isIdentifier(s.val) {
+			self.pending[a] = &pendingValue{e: &methodExpr{obj, s.val}, pc: pc, count: 1}
+			self.pending[a+1] = &pendingValue{pc: pc, cont: true}
+		} else {
+			self.setReg(a, &indexExpr{obj, key})
+			self.setReg(a+1, obj)
+		}
+	case OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR:
+		x := self.rk(b)
+		y := self.rk(c)
+		self.setReg(a, &binopExpr{arithOps[op], x, y})
+	case OP_UNM, OP_BNOT, OP_NOT, OP_LEN:
+		self.setReg(a, &unopExpr{unaryOps[op], self.read(b)})
+	case OP_CONCAT:
+		exprs := self.readList(b, c-b+1)
+		e := exprs[len(exprs)-1]
+		for k := len(exprs) - 2; k >= 0; k-- {
+			e = &binopExpr{"..", exprs[k], e}
+		}
+		self.setReg(a, e)
+	case OP_CALL:
+		call := self.callExpr(a, b)
+		switch {
+		case c == 1:
+			self.emit(&callStmt{call})
+		case c == 0:
+			call.multi = true
+			self.setMulti(a, -1, call)
+		case c == 2:
+			self.setReg(a, call)
+		default:
+			call.multi = true
+			self.setMulti(a, c-1, call)
+		}
+	case OP_TAILCALL:
+		call := self.callExpr(a, b)
+		call.multi = true
+		self.emit(&returnStmt{[]expr{call}})
+		if pc+1 < len(code) && Instruction(code[pc+1]).Opcode() == OP_RETURN {
+			return pc + 2
+		}
+	case OP_RETURN:
+		if b == 1 && pc == len(code)-1 {
+			// 函数末尾隐含的 return
+			break
+		}
+		var exprs []expr
+		if b == 0 {
+			exprs = self.readOpen(a)
+		} else {
+			exprs = self.readList(a, b-1)
+		}
+		self.emit(&returnStmt{exprs})
+	case OP_SETLIST:
+		if c == 0 {
+			if pc+1 < len(code) {
+				self.setList(a, b, Instruction(code[pc+1]).Ax())
+			}
+			return pc + 2
+		}
+		self.setList(a, b, c)
+	case OP_CLOSURE:
+		self.setReg(a, self.closure(pc))
+	case OP_VARARG:
+		switch {
+		case b == 0:
+			self.setMulti(a, -1, &varargExpr{multi: true})
+		case b == 2:
+			self.setReg(a, &varargExpr{})
+		default:
+			self.setMulti(a, b-1, &varargExpr{multi: true})
+		}
+	}
+	return pc + 1
+}
+
+/*
+返回 SETTABLE/SETLIST 可以合并进去的寄存器 a 中的表构造器，不能合并时返回 nil。
+构造器在写入 a 的位置求值：已经赋值给变量的构造器之后不能再有其他语句，
+regs 中的变量不能在这之后被赋值，否则合并之后就会读到赋值之前的值；中间结果会随构造器一起求值，不受限制
+*/
+func (self *funcState) foldTable(a int, regs ...int) *tableExpr {
+	p := self.pending[a]
+	if p == nil {
+		return nil
+	}
+	t, ok := p.e.(*tableExpr)
+	if !ok || p.alias && p.stmts != self.stmts {
+		return nil
+	}
+	for _, r := range regs {
+		if ISK(r) {
+			continue
+		}
+		if q := self.pending[r]; q != nil && !q.alias {
+			continue
+		}
+		for pc := p.pc + 1; pc < self.pc; pc++ {
+			if _, defs := self.regUses(pc); containsReg(defs, r) {
+				return nil
+			}
+		}
+	}
+	return t
+}
+
+func containsReg(regs []int, reg int) bool {
+	for _, r := range regs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+返回 SETTABLE/SETLIST 中的表：不能合并到构造器中的表构造器先保存到合成的变量中
+*/
+func (self *funcState) tableVar(a int) expr {
+	if p := self.pending[a]; p != nil && !p.alias && !p.cont {
+		if _, ok := p.e.(*tableExpr); ok {
+			self.fallback[a] = true
+			self.assign(&nameExpr{self.regName(a)}, self.read(a))
+			self.flush()
+		}
+	}
+	return self.read(a)
+}
+
+/*
+把 SETLIST 设置的值作为数组部分加入到表构造器中，并按照指令的顺序与 hash 部分排列
+*/
+func (self *funcState) setList(a, b, c int) {
+	last := a + b
+	if b == 0 {
+		last = self.top
+		self.top = -1
+	}
+	var regs []int
+	for r := a + 1; r <= last; r++ {
+		regs = append(regs, r)
+	}
+	t := self.foldTable(a, regs...)
+	if t == nil {
+		obj := self.tableVar(a)
+		for k, val := range self.readList(a+1, last-a) {
+			idx := int64((c-1)*LFIELDS_PER_FLUSH + k + 1)
+			self.assign(&indexExpr{obj, &integerExpr{idx}}, val)
+		}
+		return
+	}
+
+	var fields []tableField
+	for r := a + 1; r <= last; r++ {
+		order := self.pc
+		if p := self.pending[r]; p != nil {
+			order = p.pc
+			if p.cont {
+				delete(self.pending, r)
+				continue
+			}
+		}
+		fields = append(fields, tableField{val: self.read(r), order: order})
+	}
+	t.fields = append(t.fields, fields...)
+	sort.SliceStable(t.fields, func(i, j int) bool {
+		return t.fields[i].order < t.fields[j].order
+	})
+}
@@ -0,0 +1,444 @@
+package decompiler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+Lua 中的保留字，不能作为标识符使用
+*/
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+/*
+判断字符串是否是合法的标识符
+*/
+func isIdentifier(s string) bool {
+	if s == "" || keywords[s] {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+/*
+二元运算符的左右优先级，与 lparser.c 中的 priority 表一致
+*/
+var binopPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"|": {4, 4}, "~": {5, 5}, "&": {6, 6}, "<<": {7, 7}, ">>": {7, 7},
+	"..": {9, 8}, "+": {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "//": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+// 一元运算符的优先级
+const unaryPriority = 12
+
+// 不需要加括号的表达式（名字、常量、函数调用等）的优先级
+const atomPriority = 100
+
+/*
+把语法树输出成 Lua 源代码
+*/
+type printer struct {
+	b      strings.Builder
+	indent int
+}
+
+func (self *printer) line(format string, a ...interface{}) {
+	self.b.WriteString(strings.Repeat("  ", self.indent))
+	fmt.Fprintf(&self.b, format, a...)
+	self.b.WriteByte('\n')
+}
+
+/*
+以 quote 的形式输出字符串常量，不可打印的字符使用 \ddd 转义
+*/
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			b.WriteString("\\\"")
+		case '\\':
+			b.WriteString("\\\\")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		case '\f':
+			b.WriteString("\\f")
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\v':
+			b.WriteString("\\v")
+		default:
+			if c >= 0x20 && c != 0x7F {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "\\%03d", c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+/*
+输出浮点数，保证结果在 Lua 中仍然会被解析成浮点数
+*/
+func formatFloat(f float64) (string, int) {
+	switch {
+	case math.IsInf(f, 1):
+		return "1/0", 11
+	case math.IsInf(f, -1):
+		return "-1/0", 11
+	case math.IsNaN(f):
+		return "0/0", 11
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	if s[0] == '-' {
+		return s, unaryPriority
+	}
+	return s, atomPriority
+}
+
+/*
+返回表达式的源代码以及其左右优先级，用于决定外层是否需要加括号
+*/
+func (self *printer) exprPrio(e expr) (string, int, int) {
+	switch x := e.(type) {
+	case *nilExpr:
+		return "nil", atomPriority, atomPriority
+	case *trueExpr:
+		return "true", atomPriority, atomPriority
+	case *falseExpr:
+		return "false", atomPriority, atomPriority
+	case *varargExpr:
+		return "...", atomPriority, atomPriority
+	case *integerExpr:
+		if x.val == math.MinInt64 {
+			// 该值无法直接写成字面量，否则会被解析成浮点数
+			return "(-9223372036854775807 - 1)", atomPriority, atomPriority
+		}
+		if x.val < 0 {
+			return strconv.FormatInt(x.val, 10), unaryPriority, unaryPriority
+		}
+		return strconv.FormatInt(x.val, 10), atomPriority, atomPriority
+	case *floatExpr:
+		s, prio := formatFloat(x.val)
+		return s, prio, prio
+	case *stringExpr:
+		return quote(x.val), atomPriority, atomPriority
+	case *nameExpr:
+		return x.name, atomPriority, atomPriority
+	case *indexExpr:
+		return self.indexString(x), atomPriority, atomPriority
+	case *callExpr:
+		return self.callString(x), atomPriority, atomPriority
+	case *methodExpr:
+		return self.prefixString(x.obj) + ":" + x.name, atomPriority, atomPriority
+	case *tableExpr:
+		return self.tableString(x), atomPriority, atomPriority
+	case *funcExpr:
+		return self.funcString("function", x), atomPriority, atomPriority
+	case *unopExpr:
+		s, l, _ := self.exprPrio(x.a)
+		if l <= unaryPriority {
+			s = "(" + s + ")"
+		}
+		if x.op == "not" {
+			return "not " + s, unaryPriority, unaryPriority
+		}
+		return x.op + s, unaryPriority, unaryPriority
+	case *binopExpr:
+		prio := binopPriority[x.op]
+		ls, _, lr := self.exprPrio(x.a)
+		if prio[0] > lr {
+			ls = "(" + ls + ")"
+		}
+		rs, rl, _ := self.exprPrio(x.b)
+		if rl <= prio[1] {
+			rs = "(" + rs + ")"
+		}
+		return ls + " " + x.op + " " + rs, prio[0], prio[1]
+	default:
+		panic(fmt.Sprintf("unknown expression %T", e))
+	}
+}
+
+func (self *printer) expr(e expr) string {
+	s, _, _ := self.exprPrio(e)
+	return s
+}
+
+/*
+输出作为前缀表达式（被调用或被索引的对象）使用的表达式，
+只有名字、表访问和函数调用可以直接作为前缀，其余的需要加上括号
+*/
+func (self *printer) prefixString(e expr) string {
+	switch e.(type) {
+	case *nameExpr, *indexExpr, *callExpr:
+		return self.expr(e)
+	default:
+		return "(" + self.expr(e) + ")"
+	}
+}
+
+func (self *printer) indexString(x *indexExpr) string {
+	obj := self.prefixString(x.obj)
+	if key, ok := x.key.(*stringExpr); ok && isIdentifier(key.val) {
+		return obj + "." + key.val
+	}
+	return obj + "[" + self.expr(x.key) + "]"
+}
+
+func (self *printer) callString(x *callExpr) string {
+	fn := self.prefixString(x.fn)
+	if x.method != "" {
+		fn += ":" + x.method
+	}
+	return fn + "(" + self.exprList(x.args) + ")"
+}
+
+/*
+输出表达式列表，如果最后一项是只取一个值的函数调用或变长参数，那么需要加上括号
+*/
+func (self *printer) exprList(exprs []expr) string {
+	return self.valueList(len(exprs)+1, exprs)
+}
+
+/*
+输出赋值给 n 个变量的表达式列表，表达式的数量不少于变量数量时多余的返回值本来就会被丢弃，
+因此最后一项不需要加上括号
+*/
+func (self *printer) valueList(n int, exprs []expr) string {
+	strs := make([]string, len(exprs))
+	for i, e := range exprs {
+		strs[i] = self.expr(e)
+		if i == len(exprs)-1 && i < n-1 && isTruncated(e) {
+			strs[i] = "(" + strs[i] + ")"
+		}
+	}
+	return strings.Join(strs, ", ")
+}
+
+/*
+判断表达式是否是一个被截断成单个值的多值表达式
+*/
+func isTruncated(e expr) bool {
+	switch x := e.(type) {
+	case *callExpr:
+		return !x.multi
+	case *varargExpr:
+		return !x.multi
+	}
+	return false
+}
+
+func (self *printer) tableString(x *tableExpr) string {
+	if len(x.fields) == 0 {
+		return "{}"
+	}
+	strs := make([]string, len(x.fields))
+	for i, field := range x.fields {
+		val := self.expr(field.val)
+		switch key := field.key.(type) {
+		case nil:
+			if i == len(x.fields)-1 && isTruncated(field.val) {
+				val = "(" + val + ")"
+			}
+			strs[i] = val
+		case *stringExpr:
+			if isIdentifier(key.val) {
+				strs[i] = key.val + " = " + val
+			} else {
+				strs[i] = "[" + self.expr(key) + "] = " + val
+			}
+		default:
+			strs[i] = "[" + self.expr(key) + "] = " + val
+		}
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
+
+/*
+输出函数构造器，head 为 "function"、"function name" 或 "local function name"
+*/
+func (self *printer) funcString(head string, fn *funcExpr) string {
+	params := append([]string{}, fn.params...)
+	if fn.isVararg {
+		params = append(params, "...")
+	}
+
+	sub := &printer{indent: self.indent + 1}
+	sub.block(fn.body)
+	body := sub.b.String()
+	return head + "(" + strings.Join(params, ", ") + ")\n" +
+		body + strings.Repeat("  ", self.indent) + "end"
+}
+
+/*
+如果表达式是由名字和合法标识符组成的路径（比如 a.b.c），返回其源代码
+*/
+func (self *printer) funcName(e expr) (string, bool) {
+	switch x := e.(type) {
+	case *nameExpr:
+		return x.name, true
+	case *indexExpr:
+		key, ok := x.key.(*stringExpr)
+		if !ok || !isIdentifier(key.val) {
+			return "", false
+		}
+		if obj, ok := self.funcName(x.obj); ok {
+			return obj + "." + key.val, true
+		}
+	}
+	return "", false
+}
+
+func (self *printer) block(stmts []stmt) {
+	for _, s := range stmts {
+		self.stmt(s)
+	}
+}
+
+func (self *printer) stmt(s stmt) {
+	switch x := s.(type) {
+	case *localStmt:
+		if len(x.exprs) == 0 {
+			self.line("local %s", strings.Join(x.names, ", "))
+		} else {
+			self.line("local %s = %s", strings.Join(x.names, ", "), self.valueList(len(x.names), x.exprs))
+		}
+	case *localFuncStmt:
+		self.line("%s", self.funcString("local function "+x.name, x.fn))
+	case *assignStmt:
+		self.assignStmt(x)
+	case *callStmt:
+		call := self.callString(x.call)
+		if strings.HasPrefix(call, "(") {
+			// 避免和上一条语句连在一起被解析成函数调用
+			call = ";" + call
+		}
+		self.line("%s", call)
+	case *returnStmt:
+		if len(x.exprs) == 0 {
+			self.line("return")
+		} else {
+			self.line("return %s", self.exprList(x.exprs))
+		}
+	case *breakStmt:
+		self.line("break")
+	case *gotoStmt:
+		self.line("goto %s", x.label)
+	case *labelStmt:
+		self.line("::%s::", x.label)
+	case *doStmt:
+		self.line("do")
+		self.nested(x.body)
+		self.line("end")
+	case *ifStmt:
+		self.ifStmt(x)
+	case *whileStmt:
+		self.line("while %s do", self.expr(x.cond))
+		self.nested(x.body)
+		self.line("end")
+	case *repeatStmt:
+		self.line("repeat")
+		self.nested(x.body)
+		self.line("until %s", self.expr(x.cond))
+	case *numericForStmt:
+		if _, ok := x.step.(*integerExpr); ok && x.step.(*integerExpr).val == 1 {
+			self.line("for %s = %s, %s do", x.name, self.expr(x.init), self.expr(x.limit))
+		} else {
+			self.line("for %s = %s, %s, %s do", x.name, self.expr(x.init), self.expr(x.limit), self.expr(x.step))
+		}
+		self.nested(x.body)
+		self.line("end")
+	case *genericForStmt:
+		self.line("for %s in %s do", strings.Join(x.names, ", "), self.exprList(x.exprs))
+		self.nested(x.body)
+		self.line("end")
+	default:
+		panic(fmt.Sprintf("unknown statement %T", s))
+	}
+}
+
+func (self *printer) nested(stmts []stmt) {
+	self.indent++
+	self.block(stmts)
+	self.indent--
+}
+
+/*
+输出赋值语句，把函数赋值恢复成 `function a.b()` 或 `function a:b()` 的形式
+*/
+func (self *printer) assignStmt(x *assignStmt) {
+	if len(x.targets) == 1 && len(x.exprs) == 1 {
+		if fn, ok := x.exprs[0].(*funcExpr); ok {
+			if name, ok := self.funcName(x.targets[0]); ok {
+				if idx, ok := x.targets[0].(*indexExpr); ok && len(fn.params) > 0 && fn.params[0] == "self" {
+					obj, _ := self.funcName(idx.obj)
+					method := *fn
+					method.params = fn.params[1:]
+					self.line("%s", self.funcString("function "+obj+":"+idx.key.(*stringExpr).val, &method))
+					return
+				}
+				self.line("%s", self.funcString("function "+name, fn))
+				return
+			}
+		}
+	}
+
+	targets := make([]string, len(x.targets))
+	for i, t := range x.targets {
+		targets[i] = self.expr(t)
+	}
+	self.line("%s = %s", strings.Join(targets, ", "), self.valueList(len(targets), x.exprs))
+}
+
+/*
+输出 if 语句，else 分支中只有一条 if 语句时输出成 elseif
+*/
+func (self *printer) ifStmt(x *ifStmt) {
+	self.line("if %s then", self.expr(x.cond))
+	self.nested(x.then)
+	for len(x.els) > 0 {
+		if next, ok := x.els[0].(*ifStmt); ok && len(x.els) == 1 {
+			self.line("elseif %s then", self.expr(next.cond))
+			self.nested(next.then)
+			x = next
+			continue
+		}
+		self.line("else")
+		self.nested(x.els)
+		break
+	}
+	self.line("end")
+}
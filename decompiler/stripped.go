@@ -0,0 +1,367 @@
+package decompiler
+
+import (
+	"lua-vm/analysis"
+	. "lua-vm/vm"
+)
+
+/*
+返回反编译时认为 pc 处的指令读取和写入的寄存器。
+与实际的语义相比，for 循环内部使用的寄存器只在循环开始时被读取一次，
+循环变量则由 for 语句自己声明，这样它们就不会被当作普通的变量
+*/
+func (self *funcState) regUses(pc int) (uses, defs []int) {
+	code := self.proto.Code
+	i := Instruction(code[pc])
+	a, b, c := i.ABC()
+	span := func(from, to int) []int {
+		var regs []int
+		for r := from; r <= to; r++ {
+			regs = append(regs, r)
+		}
+		return regs
+	}
+	rk := func(regs []int, x int) []int {
+		if !ISK(x) {
+			regs = append(regs, x)
+		}
+		return regs
+	}
+	// B 或 C 为 0 时一直使用到栈顶，栈顶由前面最近一条 CALL 或 VARARG 决定
+	top := func() int {
+		for p := pc - 1; p >= 0; p-- {
+			j := Instruction(code[p])
+			ja, jb, jc := j.ABC()
+			if j.Opcode() == OP_CALL && jc == 0 || j.Opcode() == OP_VARARG && jb == 0 {
+				return ja
+			}
+		}
+		return a
+	}
+
+	switch op := i.Opcode(); op {
+	case OP_MOVE, OP_UNM, OP_BNOT, OP_NOT, OP_LEN:
+		return []int{b}, []int{a}
+	case OP_LOADK, OP_LOADKX, OP_LOADBOOL, OP_GETUPVAL, OP_NEWTABLE:
+		return nil, []int{a}
+	case OP_LOADNIL:
+		return nil, span(a, a+b)
+	case OP_GETTABUP:
+		return rk(nil, c), []int{a}
+	case OP_GETTABLE:
+		return rk([]int{b}, c), []int{a}
+	case OP_SETTABUP:
+		return rk(rk(nil, b), c), nil
+	case OP_SETUPVAL:
+		return []int{a}, nil
+	case OP_SETTABLE:
+		return rk(rk([]int{a}, b), c), nil
+	case OP_SELF:
+		return rk([]int{b}, c), []int{a, a + 1}
+	case OP_ADD, OP_SUB, OP_MUL, OP_MOD, OP_POW, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR:
+		return rk(rk(nil, b), c), []int{a}
+	case OP_CONCAT:
+		return span(b, c), []int{a}
+	case OP_JMP:
+		if self.isGenericFor(pc) {
+			ta, _, _ := self.instruction(self.target(pc)).ABC()
+			return span(ta, ta+2), nil
+		}
+	case OP_EQ, OP_LT, OP_LE:
+		return rk(rk(nil, b), c), nil
+	case OP_TEST:
+		return []int{a}, nil
+	case OP_TESTSET:
+		return []int{b}, []int{a}
+	case OP_CALL, OP_TAILCALL:
+		if b == 0 {
+			uses = span(a, top())
+		} else {
+			uses = span(a, a+b-1)
+		}
+		switch {
+		case op == OP_TAILCALL || c == 1:
+		case c == 0:
+			defs = []int{a}
+		default:
+			defs = span(a, a+c-2)
+		}
+		return uses, defs
+	case OP_RETURN:
+		if b == 0 {
+			return span(a, top()), nil
+		}
+		return span(a, a+b-2), nil
+	case OP_FORPREP:
+		return span(a, a+2), nil
+	case OP_SETLIST:
+		if b == 0 {
+			return span(a, top()), nil
+		}
+		return span(a, a+b), nil
+	case OP_CLOSURE:
+		_, bx := i.ABx()
+		if bx < len(self.proto.Protos) {
+			for _, uv := range self.proto.Protos[bx].Upvalues {
+				if uv.Instack != 0 {
+					uses = append(uses, int(uv.Idx))
+				}
+			}
+		}
+		return uses, []int{a}
+	case OP_VARARG:
+		if b == 0 {
+			return nil, []int{a}
+		}
+		return nil, span(a, a+b-2)
+	}
+	return nil, nil
+}
+
+/*
+为被剔除了调试信息的函数找出需要作为变量的寄存器：
+一个值如果只在同一个基本块中被使用一次，就可以直接内联到使用它的表达式中，
+否则（被使用多次、跨越基本块或被闭包捕获）写入它的寄存器就要当作变量。
+同一个寄存器可能在一处保存变量，在另一处只保存中间结果，后者记录在 tempDefs 中
+*/
+func (self *funcState) classifyRegisters() map[int]bool {
+	locals := map[int]bool{}
+	self.tempDefs = map[[2]int]bool{}
+	cfg := analysis.BuildCFG(self.proto)
+	n := len(cfg.Blocks)
+	if n == 0 {
+		return locals
+	}
+
+	uses := make([][]int, len(self.proto.Code))
+	defs := make([][]int, len(self.proto.Code))
+	// 活跃变量分析中被覆盖的寄存器，比 defs 多出 for 循环写入的循环变量
+	kills := make([][]int, len(self.proto.Code))
+	captured := map[int]bool{}
+	for pc := range self.proto.Code {
+		uses[pc], defs[pc] = self.regUses(pc)
+		kills[pc] = defs[pc]
+		i := self.instruction(pc)
+		a, _, c := i.ABC()
+		switch i.Opcode() {
+		case OP_CLOSURE:
+			for _, r := range uses[pc] {
+				locals[r] = true
+				captured[r] = true
+			}
+		case OP_FORLOOP:
+			kills[pc] = []int{a + 3}
+		case OP_TFORCALL:
+			for r := a + 3; r <= a+2+c; r++ {
+				kills[pc] = append(kills[pc], r)
+			}
+		}
+	}
+
+	// 活跃变量分析
+	liveIn := make([][256]bool, n)
+	liveOut := make([][256]bool, n)
+	for changed := true; changed; {
+		changed = false
+		for k := n - 1; k >= 0; k-- {
+			block := cfg.Blocks[k]
+			var live [256]bool
+			for _, succ := range block.Succs {
+				for r := range live {
+					live[r] = live[r] || liveIn[succ.Index][r]
+				}
+			}
+			liveOut[k] = live
+			for pc := block.Last(); pc >= block.Start; pc-- {
+				for _, r := range kills[pc] {
+					live[r] = false
+				}
+				for _, r := range uses[pc] {
+					live[r] = true
+				}
+			}
+			if live != liveIn[k] {
+				liveIn[k] = live
+				changed = true
+			}
+		}
+	}
+
+	// and/or 表达式的值：跳转到 merge 处的 TEST/TESTSET 把寄存器中的值带到 merge 处，
+	// 与 merge 之前最后一条指令写入的值合并成一个值，各个分支中写入的只是中间结果，
+	// 合并之后的值（记在 merge 之前的那条指令上）是否为中间结果取决于 merge 之后的使用
+	partial := map[[2]int]bool{}
+	merged := map[[2]int]int{}
+	self.valueTests = map[int]bool{}
+	for pc := 1; pc < len(self.proto.Code); pc++ {
+		test, r, m, ok := self.valueJump(pc, defs)
+		if !ok {
+			continue
+		}
+		if self.instruction(test).Opcode() == OP_TESTSET {
+			partial[[2]int{test, r}] = true
+		} else {
+			// TEST 读取的值由同一个基本块中前面的指令写入，并且在跳转之前没有别的用处
+			block := cfg.BlockAt(test)
+			def := -1
+			for p := test - 1; p >= block.Start; p-- {
+				if containsReg(kills[p], r) {
+					def = p
+					break
+				}
+				if containsReg(uses[p], r) {
+					break
+				}
+			}
+			if def < 0 || !containsReg(defs[def], r) || liveIn[cfg.BlockAt(pc+1).Index][r] {
+				continue
+			}
+			partial[[2]int{def, r}] = true
+			self.valueTests[test] = true
+		}
+		last := m - 1
+		for !containsReg(defs[last], r) {
+			// 表构造器的值由 NEWTABLE 写入
+			last--
+		}
+		merged[[2]int{last, r}] = m
+	}
+
+	for k, block := range cfg.Blocks {
+		for pc := block.Start; pc < block.End; pc++ {
+			for _, r := range defs[pc] {
+				if partial[[2]int{pc, r}] && !captured[r] {
+					self.tempDefs[[2]int{pc, r}] = true
+					continue
+				}
+				// 没有被读取的值也当作变量，否则它会一直等到被合并时才输出
+				count, escapes := self.countUses(block.End, pc, r, uses, kills, liveOut[k][r])
+				if m, ok := merged[[2]int{pc, r}]; ok {
+					mb := cfg.BlockAt(m)
+					count, escapes = self.countUses(mb.End, pc, r, uses, kills, liveOut[mb.Index][r])
+				}
+				if captured[r] || count != 1 || escapes {
+					locals[r] = true
+				} else {
+					self.tempDefs[[2]int{pc, r}] = true
+				}
+			}
+		}
+	}
+	return locals
+}
+
+/*
+判断 pc 处是否为 and/or 表达式中带着值跳转的 JMP：前面是对寄存器 r 的 TEST 或 TESTSET，
+跳转到的 merge 之前的指令写入 r（或者是 r 中表构造器的最后一条指令），
+并且跳过的指令只计算 r 及更高的寄存器中的中间结果
+*/
+func (self *funcState) valueJump(pc int, defs [][]int) (test, r, merge int, ok bool) {
+	test = pc - 1
+	i := self.instruction(test)
+	if self.instruction(pc).Opcode() != OP_JMP || i.Opcode() != OP_TEST && i.Opcode() != OP_TESTSET {
+		return 0, 0, 0, false
+	}
+	r, _, _ = i.ABC()
+	merge = self.target(pc)
+	if merge <= pc+1 || !self.tailWrites(pc+1, merge, r) {
+		return 0, 0, 0, false
+	}
+	for p := pc + 1; p < merge; p++ {
+		j := self.instruction(p)
+		a, _, c := j.ABC()
+		switch j.Opcode() {
+		case OP_JMP:
+			if t := self.target(p); t <= p || t > merge {
+				return 0, 0, 0, false
+			}
+		case OP_EQ, OP_LT, OP_LE, OP_TEST, OP_TESTSET:
+		case OP_SETTABLE, OP_SETLIST:
+			if a < r {
+				return 0, 0, 0, false
+			}
+		case OP_CALL:
+			if c == 1 {
+				return 0, 0, 0, false
+			}
+		case OP_SETTABUP, OP_SETUPVAL, OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP,
+			OP_TFORCALL, OP_TFORLOOP, OP_EXTRAARG:
+			return 0, 0, 0, false
+		}
+		for _, d := range defs[p] {
+			if d < r {
+				return 0, 0, 0, false
+			}
+		}
+	}
+	return test, r, merge, true
+}
+
+/*
+统计 pc 处写入寄存器 r 的值在基本块结束或者被覆盖之前被使用的次数，escapes 表示它在基本块结束后依然活跃；
+NEWTABLE 之后的 SETTABLE/SETLIST 会被合并到表构造器中，不算作使用
+*/
+func (self *funcState) countUses(blockEnd, pc, r int, uses, defs [][]int, liveOut bool) (count int, escapes bool) {
+	newTable := self.instruction(pc).Opcode() == OP_NEWTABLE
+	for p := pc + 1; p < blockEnd; p++ {
+		for k, u := range uses[p] {
+			if u == r && !(newTable && k == 0 && self.isTableStore(p)) {
+				count++
+			}
+		}
+		for _, d := range defs[p] {
+			if d == r {
+				return count, false
+			}
+		}
+	}
+	return count, liveOut
+}
+
+/*
+为带有调试信息的函数找出同一个值在基本块中被读取了多次的临时寄存器。
+luac 不会生成这样的代码，但经过优化或者手写的字节码中可能出现，这些寄存器只能当作变量
+*/
+func (self *funcState) sharedTemporaries() map[int]bool {
+	shared := map[int]bool{}
+	cfg := analysis.BuildCFG(self.proto)
+	uses := make([][]int, len(self.proto.Code))
+	defs := make([][]int, len(self.proto.Code))
+	for pc := range self.proto.Code {
+		uses[pc], defs[pc] = self.regUses(pc)
+	}
+	for _, block := range cfg.Blocks {
+		for pc := block.Start; pc < block.End; pc++ {
+			for _, r := range defs[pc] {
+				if self.hasLocVar(r, pc) || self.hasLocVar(r, pc+1) {
+					continue
+				}
+				if count, _ := self.countUses(block.End, pc, r, uses, defs, false); count > 1 {
+					shared[r] = true
+				}
+			}
+		}
+	}
+	return shared
+}
+
+/*
+判断寄存器 reg 在 pc 处是否属于调试信息中的某个局部变量
+*/
+func (self *funcState) hasLocVar(reg, pc int) bool {
+	for i, v := range self.proto.LocVars {
+		if self.locRegs[i] == reg && int(v.StartPc) <= pc && pc < int(v.EndPc) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+判断 pc 处是否为 SETTABLE 或 SETLIST，它们的第一个使用的寄存器是表
+*/
+func (self *funcState) isTableStore(pc int) bool {
+	op := self.instruction(pc).Opcode()
+	return op == OP_SETTABLE || op == OP_SETLIST
+}
@@ -0,0 +1,278 @@
+package decompiler
+
+import (
+	. "lua-vm/vm"
+	"strings"
+)
+
+/*
+反编译 [start, end) 中的指令，返回得到的语句
+*/
+func (self *funcState) block(start, end int) []stmt {
+	saved := self.out
+	self.out = nil
+	self.dropAliases()
+	for pc := start; pc < end; {
+		pc = self.step(pc, end)
+	}
+	self.materialize(start, end)
+	self.dropAliases()
+	body := self.out
+	self.out = saved
+	return body
+}
+
+/*
+反编译循环体 [start, end)，end 处为循环末尾的指令，不会再由 step 处理，
+跳转到那里的 goto 的标签放在循环体的最后
+*/
+func (self *funcState) loopBody(start, end int) []stmt {
+	body := self.block(start, end)
+	if self.labels[end] && !self.emitted[end] {
+		self.emitted[end] = true
+		body = append(body, &labelStmt{labelName(end)})
+	}
+	return body
+}
+
+/*
+反编译从 pc 开始的一条语句或一个控制结构，返回下一条要处理的指令
+*/
+func (self *funcState) step(pc, end int) int {
+	if self.labels[pc] && !self.emitted[pc] {
+		self.emitted[pc] = true
+		self.materialize(0, len(self.proto.Code))
+		self.emit(&labelStmt{labelName(pc)})
+	}
+	if e, ok := self.scopeEnd(pc, end); ok {
+		self.flush()
+		self.emit(&doStmt{self.block(pc, e)})
+		return e
+	}
+	if self.declareLocals(pc) {
+		return pc + 1
+	}
+	if next, ok := self.loop(pc, end); ok {
+		return next
+	}
+
+	i := self.instruction(pc)
+	if op := i.Opcode(); op == OP_FORPREP || op == OP_JMP || i.IsTest() {
+		self.dropAliases()
+	}
+	switch {
+	case i.Opcode() == OP_FORPREP:
+		return self.numericFor(pc)
+	case i.Opcode() == OP_JMP:
+		if self.isGenericFor(pc) {
+			return self.genericFor(pc)
+		}
+		return self.jump(pc)
+	case i.IsTest() && pc+1 < end && self.instruction(pc+1).Opcode() == OP_JMP:
+		return self.condition(pc, end)
+	}
+
+	next := self.exec(pc)
+	if !self.hasPending() {
+		self.flush()
+	}
+	return next
+}
+
+/*
+在 pc 处开始的局部变量的作用域在 end 之前就结束了，说明它们声明在 do ... end 中，返回作用域的末尾
+*/
+func (self *funcState) scopeEnd(pc, end int) (int, bool) {
+	for i, v := range self.proto.LocVars {
+		if int(v.StartPc) == pc && !self.declared[i] && !strings.HasPrefix(v.VarName, "(") && int(v.EndPc) < end {
+			return int(v.EndPc), true
+		}
+	}
+	return 0, false
+}
+
+func (self *funcState) instruction(pc int) Instruction {
+	return Instruction(self.proto.Code[pc])
+}
+
+/*
+返回 pc 处跳转指令的目标
+*/
+func (self *funcState) target(pc int) int {
+	_, sbx := self.instruction(pc).AsBx()
+	return pc + 1 + sbx
+}
+
+/*
+判断 pc 处是否是一条无条件跳转指令（前面不是 test 指令的 JMP）
+*/
+func (self *funcState) isUncondJump(pc int) bool {
+	if self.instruction(pc).Opcode() != OP_JMP {
+		return false
+	}
+	return pc == 0 || !self.instruction(pc-1).IsTest()
+}
+
+func (self *funcState) innerLoop() *loopInfo {
+	if len(self.loops) == 0 {
+		return nil
+	}
+	return self.loops[len(self.loops)-1]
+}
+
+/*
+识别以 pc 为开头的 while 或 repeat 循环：循环的末尾有一条跳回 pc 的 JMP，
+无条件跳转为 while 循环，前面有 test 指令的为 repeat 循环
+*/
+func (self *funcState) loop(pc, end int) (int, bool) {
+	last := -1
+	for p := end - 1; p >= pc; p-- {
+		if self.instruction(p).Opcode() == OP_JMP && self.target(p) == pc {
+			last = p
+			break
+		}
+	}
+	if last < 0 || self.activeLoops[[2]int{pc, last}] {
+		return 0, false
+	}
+
+	key := [2]int{pc, last}
+	self.activeLoops[key] = true
+	self.dropAliases()
+	defer delete(self.activeLoops, key)
+	self.flush()
+
+	if self.isUncondJump(last) {
+		self.loops = append(self.loops, &loopInfo{head: pc, exit: last + 1})
+		body := self.loopBody(pc, last)
+		self.loops = self.loops[:len(self.loops)-1]
+
+		var cond expr = &trueExpr{}
+		if len(body) > 0 {
+			if s, ok := body[0].(*ifStmt); ok && len(s.els) == 0 && len(s.then) == 1 {
+				if _, ok := s.then[0].(*breakStmt); ok {
+					cond = negate(s.cond)
+					body = body[1:]
+				}
+			}
+		}
+		self.emit(&whileStmt{cond: cond, body: body})
+		return last + 1, true
+	}
+
+	self.loops = append(self.loops, &loopInfo{head: pc, exit: last + 1, repeat: true})
+	body := self.block(pc, last+1)
+	self.loops = self.loops[:len(self.loops)-1]
+
+	if n := len(body); n > 0 {
+		if s, ok := body[n-1].(*untilStmt); ok {
+			self.emit(&repeatStmt{body: body[:n-1], cond: s.cond})
+			return last + 1, true
+		}
+	}
+	// 无法识别 until 条件，此时跳回开头的指令已经被输出成了 goto
+	self.flush()
+	self.out = append(self.out, body...)
+	return last + 1, true
+}
+
+/*
+pc 处的 FORPREP 开始一个数值 for 循环，FORPREP 跳转到的 FORLOOP 为循环的末尾
+*/
+func (self *funcState) numericFor(pc int) int {
+	self.pc = pc
+	a, _, _ := self.instruction(pc).ABC()
+	forloop := self.target(pc)
+	init := self.read(a)
+	limit := self.read(a + 1)
+	step := self.read(a + 2)
+	self.flush()
+
+	name := self.loopVarName(pc+1, a+3)
+	self.loops = append(self.loops, &loopInfo{head: pc + 1, exit: forloop + 1})
+	body := self.loopBody(pc+1, forloop)
+	self.loops = self.loops[:len(self.loops)-1]
+	self.scoped[a+3]--
+
+	self.emit(&numericForStmt{name: name, init: init, limit: limit, step: step, body: body})
+	return forloop + 1
+}
+
+/*
+判断 pc 处的 JMP 是否跳转到泛型 for 循环末尾的 TFORCALL
+*/
+func (self *funcState) isGenericFor(pc int) bool {
+	t := self.target(pc)
+	if t <= pc || t+1 >= len(self.proto.Code) {
+		return false
+	}
+	return self.instruction(t).Opcode() == OP_TFORCALL &&
+		self.instruction(t+1).Opcode() == OP_TFORLOOP && self.target(t+1) == pc+1
+}
+
+func (self *funcState) genericFor(pc int) int {
+	self.pc = pc
+	t := self.target(pc)
+	a, _, c := self.instruction(t).ABC()
+	exprs := self.readList(a, 3)
+	for len(exprs) > 1 {
+		if _, ok := exprs[len(exprs)-1].(*nilExpr); !ok {
+			break
+		}
+		exprs = exprs[:len(exprs)-1]
+	}
+	self.flush()
+
+	var names []string
+	for r := a + 3; r <= a+2+c; r++ {
+		names = append(names, self.loopVarName(pc+1, r))
+	}
+	self.loops = append(self.loops, &loopInfo{head: pc + 1, exit: t + 2})
+	body := self.loopBody(pc+1, t)
+	self.loops = self.loops[:len(self.loops)-1]
+	for r := a + 3; r <= a+2+c; r++ {
+		self.scoped[r]--
+	}
+
+	self.emit(&genericForStmt{names: names, exprs: exprs, body: body})
+	return t + 2
+}
+
+/*
+返回从 pc 开始、位于寄存器 reg 中的循环变量的名字，调用者负责在循环结束后减少 scoped 计数
+*/
+func (self *funcState) loopVarName(pc, reg int) string {
+	self.scoped[reg]++
+	for i, v := range self.proto.LocVars {
+		if int(v.StartPc) == pc && self.locRegs[i] == reg && !self.declared[i] {
+			self.declared[i] = true
+			return v.VarName
+		}
+	}
+	return self.regName(reg)
+}
+
+/*
+返回跳转到 pc 的 goto 语句；跳转目标处可能还会用到尚未使用的中间结果，因此先把它们保存到变量中
+*/
+func (self *funcState) gotoStmt(pc int) stmt {
+	self.labels[pc] = true
+	self.materialize(0, len(self.proto.Code))
+	return &gotoStmt{labelName(pc)}
+}
+
+/*
+无条件跳转：跳出最内层循环的为 break，其余的输出成 goto
+*/
+func (self *funcState) jump(pc int) int {
+	t := self.target(pc)
+	switch loop := self.innerLoop(); {
+	case t == pc+1:
+		// 只用于关闭 Upvalue 的 JMP
+	case loop != nil && t == loop.exit:
+		self.emit(&breakStmt{})
+	default:
+		self.emit(self.gotoStmt(t))
+	}
+	return pc + 1
+}
@@ -0,0 +1,274 @@
+package diff
+
+import (
+	"fmt"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+	"strings"
+)
+
+type EditKind int
+
+const (
+	Equal EditKind = iota
+	Delete
+	Insert
+)
+
+/*
+编辑脚本中的一项，Delete 的 NewIndex 以及 Insert 的 OldIndex 为 -1；
+Text 为该项在旧（Delete、Equal）或新（Insert）函数中的文本形式
+*/
+type Edit struct {
+	Kind     EditKind
+	OldIndex int
+	NewIndex int
+	Text     string
+}
+
+/*
+函数头中发生变化的字段
+*/
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+/*
+一对相互匹配的函数之间的差异；新增的函数 Old 为 nil，被删除的函数 New 为 nil，
+此时各个表的编辑脚本中只有 Insert 或者只有 Delete
+*/
+type FunctionDiff struct {
+	// 形如 "main <foo.lua:0,0>" 或 "function <foo.lua:3,5>"，与 luac 的函数头一致，取自新函数（被删除时取自旧函数）
+	Name      string
+	Old       *Prototype
+	New       *Prototype
+	Header    []FieldChange
+	Code      []Edit
+	Constants []Edit
+	Upvalues  []Edit
+	Locals    []Edit
+}
+
+/*
+判断这一对函数是否有差异
+*/
+func (self *FunctionDiff) Changed() bool {
+	if self.Old == nil || self.New == nil || len(self.Header) > 0 {
+		return true
+	}
+	for _, edits := range [][]Edit{self.Code, self.Constants, self.Upvalues, self.Locals} {
+		for _, e := range edits {
+			if e.Kind != Equal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+两棵函数原型树之间的差异，Functions 按照函数的先序排列，包括没有变化的函数；
+新增和删除的函数排在同一父函数中相互匹配的子函数之后
+*/
+type Result struct {
+	Functions []*FunctionDiff
+}
+
+/*
+判断两棵函数原型树是否完全相同（不考虑行号）
+*/
+func (self *Result) Equal() bool {
+	for _, f := range self.Functions {
+		if f.Changed() {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+比较两棵函数原型树。子函数按照 Source 和 LineDefined 匹配，同一位置有多个函数时按照出现的顺序匹配；
+相互匹配的函数之间比较函数头、指令、常量、Upvalue 和局部变量，各个表都用最短编辑脚本对齐。
+指令按照操作码和操作数比较，不考虑行号以及随位置变化的跳转目标注释，这样插入一行代码不会让之后的指令全部变成差异
+*/
+func Compare(old, new *Prototype) *Result {
+	r := &Result{}
+	r.compare(old, new)
+	return r
+}
+
+func (self *Result) compare(old, new *Prototype) {
+	fd := &FunctionDiff{Name: functionName(new), Old: old, New: new}
+	self.Functions = append(self.Functions, fd)
+	fd.Header = compareHeader(old, new)
+	fd.Code = compareTable(codeTexts(old, true), codeTexts(new, true), codeTexts(old, false), codeTexts(new, false))
+	fd.Constants = compareTable(constantTexts(old), constantTexts(new), nil, nil)
+	fd.Upvalues = compareTable(upvalueTexts(old), upvalueTexts(new), nil, nil)
+	fd.Locals = compareTable(localTexts(old), localTexts(new), nil, nil)
+
+	// 按照 (Source, LineDefined) 匹配子函数，剩下的视为新增或删除
+	used := make([]bool, len(new.Protos))
+	matched := make([]int, len(old.Protos))
+	for i, p := range old.Protos {
+		matched[i] = -1
+		for j, q := range new.Protos {
+			if !used[j] && p.Source == q.Source && p.LineDefined == q.LineDefined {
+				matched[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+	for i, p := range old.Protos {
+		if matched[i] >= 0 {
+			self.compare(p, new.Protos[matched[i]])
+		} else {
+			self.single(p, true)
+		}
+	}
+	for j, q := range new.Protos {
+		if !used[j] {
+			self.single(q, false)
+		}
+	}
+}
+
+/*
+记录整个被删除（removed 为 true）或新增的函数及其子函数
+*/
+func (self *Result) single(f *Prototype, removed bool) {
+	fd := &FunctionDiff{Name: functionName(f)}
+	kind := Insert
+	if removed {
+		fd.Old, kind = f, Delete
+	} else {
+		fd.New = f
+	}
+	fd.Code = wholeTable(codeTexts(f, false), kind)
+	fd.Constants = wholeTable(constantTexts(f), kind)
+	fd.Upvalues = wholeTable(upvalueTexts(f), kind)
+	fd.Locals = wholeTable(localTexts(f), kind)
+	self.Functions = append(self.Functions, fd)
+	for _, p := range f.Protos {
+		self.single(p, removed)
+	}
+}
+
+func wholeTable(texts []string, kind EditKind) []Edit {
+	edits := make([]Edit, len(texts))
+	for i, text := range texts {
+		edits[i] = Edit{Kind: kind, OldIndex: -1, NewIndex: -1, Text: text}
+		if kind == Delete {
+			edits[i].OldIndex = i
+		} else {
+			edits[i].NewIndex = i
+		}
+	}
+	return edits
+}
+
+/*
+按照 keys 对齐两张表，texts 为 nil 时直接使用 keys 作为文本
+*/
+func compareTable(oldKeys, newKeys, oldTexts, newTexts []string) []Edit {
+	if oldTexts == nil {
+		oldTexts, newTexts = oldKeys, newKeys
+	}
+	edits := align(oldKeys, newKeys)
+	for i := range edits {
+		if e := &edits[i]; e.Kind == Insert {
+			e.Text = newTexts[e.NewIndex]
+		} else {
+			e.Text = oldTexts[e.OldIndex]
+		}
+	}
+	return edits
+}
+
+func compareHeader(old, new *Prototype) []FieldChange {
+	var changes []FieldChange
+	field := func(name string, x, y interface{}) {
+		if a, b := fmt.Sprint(x), fmt.Sprint(y); a != b {
+			changes = append(changes, FieldChange{name, a, b})
+		}
+	}
+	field("Source", old.Source, new.Source)
+	field("LineDefined", old.LineDefined, new.LineDefined)
+	field("LastLineDefined", old.LastLineDefined, new.LastLineDefined)
+	field("NumParams", old.NumParams, new.NumParams)
+	field("IsVararg", old.IsVararg, new.IsVararg)
+	field("MaxStackSize", old.MaxStackSize, new.MaxStackSize)
+	return changes
+}
+
+func functionName(f *Prototype) string {
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+	return fmt.Sprintf("%s <%s:%d,%d>", funcType, SourceName(f.Source), f.LineDefined, f.LastLineDefined)
+}
+
+/*
+指令的文本形式，与 luac 的反汇编输出一致，子函数以其在 Protos 中的下标表示；
+key 为 true 时去掉行号以及跳转目标的注释，用于对齐
+*/
+func codeTexts(f *Prototype, key bool) []string {
+	opts := DisasmOptions{Address: func(p *Prototype) string {
+		for i, sub := range f.Protos {
+			if sub == p {
+				return fmt.Sprintf("function[%d]", i)
+			}
+		}
+		return "?"
+	}}
+	texts := make([]string, len(f.Code))
+	for pc := range f.Code {
+		text := FormatInstruction(f, pc, opts)
+		switch op := Instruction(f.Code[pc]).Opcode(); {
+		case key && (op == OP_JMP || op == OP_FORLOOP || op == OP_FORPREP || op == OP_TFORLOOP):
+			text = text[:strings.Index(text, "\t; to ")]
+		case !key:
+			line := "-"
+			if l := f.LineAt(pc); l > 0 {
+				line = fmt.Sprintf("%d", l)
+			}
+			text = fmt.Sprintf("[%s]\t%s", line, text)
+		}
+		texts[pc] = text
+	}
+	return texts
+}
+
+func constantTexts(f *Prototype) []string {
+	texts := make([]string, len(f.Constants))
+	for i, k := range f.Constants {
+		texts[i] = fmt.Sprintf("%s %s", k.TypeName(), k)
+	}
+	return texts
+}
+
+func upvalueTexts(f *Prototype) []string {
+	texts := make([]string, len(f.Upvalues))
+	for i, upval := range f.Upvalues {
+		name := f.UpvalueName(i)
+		if name == "" {
+			name = "-"
+		}
+		texts[i] = fmt.Sprintf("%s\t%d\t%d", name, upval.Instack, upval.Idx)
+	}
+	return texts
+}
+
+/*
+局部变量的 pc 范围与 luac 的输出一样加 1
+*/
+func localTexts(f *Prototype) []string {
+	texts := make([]string, len(f.LocVars))
+	for i, v := range f.LocVars {
+		texts[i] = fmt.Sprintf("%s\t%d\t%d", v.VarName, v.StartPc+1, v.EndPc+1)
+	}
+	return texts
+}
@@ -0,0 +1,114 @@
+package diff
+
+import (
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, src string) *Prototype {
+	t.Helper()
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return proto
+}
+
+func unified(r *Result) string {
+	var b strings.Builder
+	r.WriteUnified(&b, "old", "new")
+	return b.String()
+}
+
+func TestCompareIgnoresLines(t *testing.T) {
+	old := compile(t, "local x = 1\nprint(x)\n")
+	new := compile(t, "\n\nlocal x = 1\n\nprint(x)\n")
+	r := Compare(old, new)
+	if !r.Equal() {
+		t.Errorf("only line numbers changed, got\n%s", unified(r))
+	}
+	if out := unified(r); out != "" {
+		t.Errorf("WriteUnified of equal chunks: %q", out)
+	}
+}
+
+func TestCompareCode(t *testing.T) {
+	old := compile(t, "local x = 1\nfor i = 1, 3 do x = x + i end\n")
+	new := compile(t, "local x = 1\nprint(x)\nfor i = 1, 3 do x = x + i end\n")
+	r := Compare(old, new)
+	if r.Equal() || len(r.Functions) != 1 {
+		t.Fatalf("got %d functions, equal %v", len(r.Functions), r.Equal())
+	}
+	// 插入的三条指令之后的跳转指令的操作数不变，不算作差异
+	var inserted, deleted []string
+	for _, e := range r.Functions[0].Code {
+		switch e.Kind {
+		case Insert:
+			inserted = append(inserted, strings.Fields(e.Text)[1])
+		case Delete:
+			deleted = append(deleted, strings.Fields(e.Text)[1])
+		}
+	}
+	// 常量 3 的序号因为 "print" 而改变
+	if got := strings.Join(inserted, " "); got != "GETTABUP MOVE CALL LOADK" {
+		t.Errorf("inserted %s", got)
+	}
+	if got := strings.Join(deleted, " "); got != "LOADK" {
+		t.Errorf("deleted %s", got)
+	}
+}
+
+func TestCompareFunctions(t *testing.T) {
+	old := compile(t, "local function f() return 1 end\n")
+	new := compile(t, "local function f() return 1 end\nlocal function g() end\n")
+	r := Compare(old, new)
+	if len(r.Functions) != 3 {
+		t.Fatalf("got %d functions, want 3", len(r.Functions))
+	}
+	if f := r.Functions[1]; f.Changed() {
+		t.Errorf("%s changed", f.Name)
+	}
+	g := r.Functions[2]
+	if g.Old != nil || g.New == nil || g.Name != "function <test.lua:2,2>" {
+		t.Errorf("added function: %+v", g)
+	}
+	if out := unified(r); !strings.Contains(out, "@@ function <test.lua:2,2> added @@\n") {
+		t.Errorf("missing added function in\n%s", out)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var b strings.Builder
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	new := "1\n2\nx\n4\n5\n6\n7\n8\n9\n10\n"
+	if err := WriteText(&b, "a.lua", "b.lua", old, new); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	// 两处差异之间只有 6 行，与 diff -u 一样合并成一个 hunk
+	want := "--- a.lua\n+++ b.lua\n@@ -1,9 +1,10 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n 7\n 8\n 9\n+10\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	WriteText(&b, "a.lua", "b.lua", old, old)
+	if b.Len() != 0 {
+		t.Errorf("WriteText of equal texts: %q", b.String())
+	}
+}
+
+func TestAlignShortest(t *testing.T) {
+	// Myers 论文中的例子，最短编辑脚本有 5 项删除或插入
+	edits := align(strings.Split("abcabba", ""), strings.Split("cbabac", ""))
+	n := 0
+	for _, e := range edits {
+		if e.Kind != Equal {
+			n++
+		}
+	}
+	if n != 5 {
+		t.Errorf("got %d edits, want 5", n)
+	}
+}
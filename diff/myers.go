@@ -0,0 +1,79 @@
+package diff
+
+/*
+用 Myers 的 O(ND) 算法求 a 和 b 的最短编辑脚本，返回的 Edit 只填写了 Kind 和下标。
+每一步只保存 k 在 [-d-1, d+1] 范围内的 V 数组，内存占用为 O(D^2)
+*/
+func align(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	max := n + m
+	// v[k] 表示在对角线 k 上能够到达的最远的 x
+	v := map[int]int{1: 0}
+	var trace []map[int]int
+
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		saved := make(map[int]int, 2*d+3)
+		for k := -d - 1; k <= d+1; k++ {
+			if x, ok := v[k]; ok {
+				saved[k] = x
+			}
+		}
+		trace = append(trace, saved)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1] < v[k+1]) {
+				x = v[k+1]
+			} else {
+				x = v[k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	var edits []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1] < v[k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Kind: Equal, OldIndex: x, NewIndex: y})
+		}
+		if x == prevX {
+			edits = append(edits, Edit{Kind: Insert, OldIndex: -1, NewIndex: prevY})
+		} else {
+			edits = append(edits, Edit{Kind: Delete, OldIndex: prevX, NewIndex: -1})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, Edit{Kind: Equal, OldIndex: x, NewIndex: y})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
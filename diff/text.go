@@ -0,0 +1,29 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+按行比较两段文本，以 unified diff 的格式输出差异，可以直接交给 patch 使用；两者相同时不输出任何内容
+*/
+func WriteText(w io.Writer, oldName, newName, old, new string) error {
+	if old == new {
+		return nil
+	}
+	oldLines, newLines := splitLines(old), splitLines(new)
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	writeHunks(&b, "", compareTable(oldLines, newLines, nil, nil))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 每个 hunk 前后保留的相同项的数量，与 diff -u 的缺省值一致
+const contextLines = 3
+
+/*
+以 unified diff 的格式输出差异，oldName 和 newName 出现在开头的 --- 和 +++ 行中。
+每个有差异的表输出为若干 hunk，hunk 头中注明所在的函数和表，范围是表中从 1 开始的序号：
+
+	@@ function <foo.lua:3,5> code -4,6 +4,7 @@
+
+函数头的变化以 "字段: 值" 的形式输出，新增或删除的函数输出其全部内容；两者完全相同时不输出任何内容
+*/
+func (self *Result) WriteUnified(w io.Writer, oldName, newName string) error {
+	if self.Equal() {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, f := range self.Functions {
+		if !f.Changed() {
+			continue
+		}
+		switch {
+		case f.Old == nil:
+			fmt.Fprintf(&b, "@@ %s added @@\n", f.Name)
+		case f.New == nil:
+			fmt.Fprintf(&b, "@@ %s removed @@\n", f.Name)
+		}
+		if len(f.Header) > 0 {
+			fmt.Fprintf(&b, "@@ %s header @@\n", f.Name)
+			for _, c := range f.Header {
+				fmt.Fprintf(&b, "-%s: %s\n+%s: %s\n", c.Field, c.Old, c.Field, c.New)
+			}
+		}
+		writeHunks(&b, f.Name+" code ", f.Code)
+		writeHunks(&b, f.Name+" constants ", f.Constants)
+		writeHunks(&b, f.Name+" upvalues ", f.Upvalues)
+		writeHunks(&b, f.Name+" locals ", f.Locals)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+/*
+把编辑脚本分成带有上下文的 hunk，相距不超过两倍上下文的差异合并到同一个 hunk 中；
+label 出现在 hunk 头中的范围之前，为空时 hunk 头与 diff -u 完全一致
+*/
+func writeHunks(b *strings.Builder, label string, edits []Edit) {
+	for start := 0; start < len(edits); {
+		// 找到下一处差异
+		first := start
+		for first < len(edits) && edits[first].Kind == Equal {
+			first++
+		}
+		if first == len(edits) {
+			return
+		}
+		last := first
+		for i := first; i < len(edits); i++ {
+			if edits[i].Kind != Equal {
+				last = i
+			} else if i-last > 2*contextLines {
+				break
+			}
+		}
+		from := first - contextLines
+		if from < start {
+			from = start
+		}
+		to := last + contextLines + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+		writeHunk(b, label, edits[from:to])
+		start = to
+	}
+}
+
+func writeHunk(b *strings.Builder, label string, edits []Edit) {
+	oldStart, newStart, oldCount, newCount := -1, -1, 0, 0
+	for _, e := range edits {
+		if e.Kind != Insert {
+			if oldStart < 0 {
+				oldStart = e.OldIndex
+			}
+			oldCount++
+		}
+		if e.Kind != Delete {
+			if newStart < 0 {
+				newStart = e.NewIndex
+			}
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ %s-%s +%s @@\n", label,
+		hunkRange(oldStart, oldCount, edits, true), hunkRange(newStart, newCount, edits, false))
+
+	for _, e := range edits {
+		prefix := " "
+		switch e.Kind {
+		case Delete:
+			prefix = "-"
+		case Insert:
+			prefix = "+"
+		}
+		fmt.Fprintf(b, "%s%s\n", prefix, e.Text)
+	}
+}
+
+/*
+hunk 头中的范围，为空时与 diff -u 一样使用空范围之前的序号
+*/
+func hunkRange(start, count int, edits []Edit, old bool) string {
+	if count > 0 {
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+	// 空范围：取这个 hunk 之前最后一项的序号
+	pos := 0
+	for _, e := range edits {
+		index := e.NewIndex
+		if old {
+			index = e.OldIndex
+		}
+		if index >= 0 {
+			pos = index + 1
+		}
+	}
+	return fmt.Sprintf("%d,0", pos)
+}
@@ -0,0 +1,261 @@
+package lint
+
+import (
+	"fmt"
+	"lua-vm/analysis"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+	"sort"
+	"strings"
+)
+
+/*
+一条警告，String 的格式为 "文件:行号: 信息"，与编译器的错误信息一致，方便编辑器和 pre-commit 钩子解析
+*/
+type Warning struct {
+	Source string
+	// 没有行号信息时为 0
+	Line int
+	Msg  string
+}
+
+func (self Warning) String() string {
+	line := "-"
+	if self.Line > 0 {
+		line = fmt.Sprintf("%d", self.Line)
+	}
+	return fmt.Sprintf("%s:%s: %s", SourceName(self.Source), line, self.Msg)
+}
+
+/*
+检查选项，为 0 的限制使用缺省值
+*/
+type Options struct {
+	// 单个函数最多使用的寄存器个数，缺省为 200，即 luac 允许的局部变量个数
+	MaxRegisters int
+	// 单个函数最多使用的 Upvalue 个数，缺省为 60
+	MaxUpvalues int
+}
+
+const (
+	defaultMaxRegisters = 200
+	defaultMaxUpvalues  = 60
+)
+
+/*
+检查整个函数原型树，按照源文件和行号的顺序返回警告：
+
+	未使用的局部变量，以及只被赋值、从未被读取的局部变量
+	不可达的指令
+	在非主函数中对全局变量赋值（多半是漏写了 local）
+	遮蔽了本函数或外层函数中同名局部变量的局部变量
+	使用的寄存器或 Upvalue 超过限制的函数
+
+与局部变量有关的检查依赖调试信息，被 `luac -s` 剔除之后不再进行。
+以 _ 开头的局部变量以及 luac 生成的 (for index) 等内部变量不参与局部变量的检查
+*/
+func Lint(f *Prototype, opts Options) []Warning {
+	if opts.MaxRegisters <= 0 {
+		opts.MaxRegisters = defaultMaxRegisters
+	}
+	if opts.MaxUpvalues <= 0 {
+		opts.MaxUpvalues = defaultMaxUpvalues
+	}
+	l := &linter{opts: opts}
+	l.lintFunction(f, nil)
+
+	for _, access := range analysis.FindGlobals(f) {
+		if access.Write && access.Unresolved == "" && len(access.Path) > 0 {
+			l.warnAt(access.Func, access.Pc, "assignment to global '%s' in a function", access.Name)
+		}
+	}
+
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		x, y := l.warnings[i], l.warnings[j]
+		if x.Source != y.Source {
+			return x.Source < y.Source
+		}
+		return x.Line < y.Line
+	})
+	return l.warnings
+}
+
+type linter struct {
+	opts     Options
+	warnings []Warning
+}
+
+func (self *linter) warn(f *Prototype, line int, format string, a ...interface{}) {
+	self.warnings = append(self.warnings, Warning{
+		Source: f.Source, Line: line, Msg: fmt.Sprintf(format, a...),
+	})
+}
+
+/*
+以 pc 处指令的行号发出警告
+*/
+func (self *linter) warnAt(f *Prototype, pc int, format string, a ...interface{}) {
+	self.warn(f, f.LineAt(pc), format, a...)
+}
+
+/*
+检查一个函数及其子函数，outer 为定义该函数时外层函数中有效的局部变量名
+*/
+func (self *linter) lintFunction(f *Prototype, outer map[string]bool) {
+	self.checkBudgets(f)
+	self.checkUnreachable(f)
+	self.checkLocals(f, outer)
+	// 每个子函数的 CLOSURE 所在的位置，没有被 CLOSURE 引用的子函数视为在函数开头定义
+	closureAt := make([]int, len(f.Protos))
+	for pc := len(f.Code) - 1; pc >= 0; pc-- {
+		if i := Instruction(f.Code[pc]); i.Opcode() == OP_CLOSURE {
+			if _, bx := i.ABx(); bx < len(closureAt) {
+				closureAt[bx] = pc
+			}
+		}
+	}
+	for idx, child := range f.Protos {
+		scope := map[string]bool{}
+		for name := range outer {
+			scope[name] = true
+		}
+		for _, v := range f.LocVars {
+			if pc := closureAt[idx]; int(v.StartPc) <= pc && pc < int(v.EndPc) {
+				scope[v.VarName] = true
+			}
+		}
+		self.lintFunction(child, scope)
+	}
+}
+
+/*
+寄存器和 Upvalue 的数量，警告位于函数定义的行号
+*/
+func (self *linter) checkBudgets(f *Prototype) {
+	line := int(f.LineDefined)
+	if line == 0 {
+		line = f.LineAt(0)
+	}
+	if n := int(f.MaxStackSize); n > self.opts.MaxRegisters {
+		self.warn(f, line, "function uses %d registers (limit %d)", n, self.opts.MaxRegisters)
+	}
+	if n := len(f.Upvalues); n > self.opts.MaxUpvalues {
+		self.warn(f, line, "function uses %d upvalues (limit %d)", n, self.opts.MaxUpvalues)
+	}
+}
+
+/*
+不可达的基本块，每个块只报告一次。
+luac 总会在函数末尾生成 RETURN，也会在以 return 结尾的 if 分支之后生成 JMP，
+只由这些指令构成的块即使不可达也不是源代码的问题
+*/
+func (self *linter) checkUnreachable(f *Prototype) {
+	cfg := analysis.BuildCFG(f)
+	for _, block := range cfg.Blocks {
+		if block.Reachable() {
+			continue
+		}
+		for pc := block.Start; pc < block.End; pc++ {
+			op := Instruction(f.Code[pc]).Opcode()
+			if op != OP_JMP && !(op == OP_RETURN && pc == len(f.Code)-1) {
+				self.warnAt(f, pc, "unreachable code")
+				break
+			}
+		}
+	}
+}
+
+/*
+局部变量的检查：在局部变量有效的范围内读取或写入其寄存器的指令。
+local function 的 CLOSURE 位于局部变量开始的位置，它写入并捕获了自身，不算作使用
+*/
+func (self *linter) checkLocals(f *Prototype, outer map[string]bool) {
+	regs := analysis.LocalRegisters(f)
+	for i, v := range f.LocVars {
+		if i < int(f.NumParams) && v.StartPc == 0 {
+			continue
+		}
+		if ignoredLocal(v.VarName) {
+			continue
+		}
+		// 局部变量在初始化它的指令之后开始，local function 则从它的 CLOSURE 开始；
+		// CLOSURE 的行号是函数末尾 end 所在的行，函数定义的局部变量使用函数开始的行
+		line := f.LineAt(int(v.StartPc) - 1)
+		if v.StartPc == 0 {
+			line = f.LineAt(0)
+		}
+		for _, pc := range []int{int(v.StartPc) - 1, int(v.StartPc)} {
+			if pc >= 0 && isClosureOf(f, pc, regs[i]) {
+				line = closureLine(f, pc, line)
+				break
+			}
+		}
+
+		for j := 0; j < i; j++ {
+			w := f.LocVars[j]
+			if w.VarName == v.VarName && w.StartPc <= v.StartPc && v.StartPc < w.EndPc {
+				self.warn(f, line, "local '%s' shadows a local of the same name", v.VarName)
+				break
+			}
+		}
+		if outer[v.VarName] {
+			self.warn(f, line, "local '%s' shadows a local of an enclosing function", v.VarName)
+		}
+
+		read, written := false, false
+		for pc := int(v.StartPc); pc < int(v.EndPc) && pc < len(f.Code); pc++ {
+			if pc == int(v.StartPc) && isClosureOf(f, pc, regs[i]) {
+				continue
+			}
+			uses, defs := analysis.RegisterUses(f, pc)
+			read = read || contains(uses, regs[i])
+			written = written || contains(defs, regs[i])
+		}
+		switch {
+		case read:
+		case written:
+			self.warn(f, line, "local '%s' is assigned but never read", v.VarName)
+		default:
+			self.warn(f, line, "unused local '%s'", v.VarName)
+		}
+	}
+}
+
+/*
+以 _ 开头的变量按照惯例表示有意不使用，以 ( 开头的是 luac 生成的内部变量
+*/
+func ignoredLocal(name string) bool {
+	return name == "" || strings.HasPrefix(name, "_") || strings.HasPrefix(name, "(")
+}
+
+/*
+判断 pc 处是否为写入 reg 的 CLOSURE，即 local function 的定义
+*/
+func isClosureOf(f *Prototype, pc, reg int) bool {
+	if pc >= len(f.Code) {
+		return false
+	}
+	i := Instruction(f.Code[pc])
+	a, _, _ := i.ABC()
+	return i.Opcode() == OP_CLOSURE && a == reg
+}
+
+/*
+返回 pc 处的 CLOSURE 创建的函数开始的行，没有行号信息时返回 line
+*/
+func closureLine(f *Prototype, pc, line int) int {
+	_, bx := Instruction(f.Code[pc]).ABx()
+	if bx < len(f.Protos) && f.Protos[bx].LineDefined > 0 {
+		return int(f.Protos[bx].LineDefined)
+	}
+	return line
+}
+
+func contains(regs []int, reg int) bool {
+	for _, r := range regs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
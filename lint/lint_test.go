@@ -0,0 +1,83 @@
+package lint
+
+import (
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+func lint(t *testing.T, src string, opts Options) string {
+	t.Helper()
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	var list []string
+	for _, w := range Lint(proto, opts) {
+		list = append(list, w.String())
+	}
+	return strings.Join(list, "\n")
+}
+
+func TestLint(t *testing.T) {
+	src := `local unused = 1
+local written = 2
+written = 3
+local _ignored = 4
+local function helper() end
+local function f(x)
+  local x = x + 1
+  g = x
+  local unused = 5
+  return unused
+end
+for i = 1, 3 do print(i) end
+do return f end
+print("never")
+`
+	want := `test.lua:1: unused local 'unused'
+test.lua:2: local 'written' is assigned but never read
+test.lua:5: unused local 'helper'
+test.lua:7: local 'x' shadows a local of the same name
+test.lua:8: assignment to global 'g' in a function
+test.lua:9: local 'unused' shadows a local of an enclosing function
+test.lua:14: unreachable code`
+	if got := lint(t, src, Options{}); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	// 以 return 结尾的 if 分支之后的 JMP 以及参数都不产生警告
+	src := `local function sign(n, _)
+  if n < 0 then return -1 else return 1 end
+end
+return sign(-2)
+`
+	if got := lint(t, src, Options{}); got != "" {
+		t.Errorf("got warnings\n%s", got)
+	}
+}
+
+func TestLintBudgets(t *testing.T) {
+	src := "local a, b, c = 1, 2, 3\nreturn function() return a, b, c end\n"
+	// 主函数用 4 个寄存器（闭包在寄存器 3 中），子函数的三个返回值用 3 个
+	want := `test.lua:1: function uses 4 registers (limit 3)
+test.lua:2: function uses 3 upvalues (limit 2)`
+	if got := lint(t, src, Options{MaxRegisters: 3, MaxUpvalues: 2}); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLintStripped(t *testing.T) {
+	// 剔除调试信息之后不再检查局部变量，警告也没有行号
+	proto, err := compiler.Compile("local x = 1\ndo return end\nprint(x)\n", "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	proto.LineInfo, proto.LocVars, proto.UpvalueNames = nil, nil, nil
+	warnings := Lint(proto, Options{})
+	if len(warnings) != 1 || warnings[0].String() != "test.lua:-: unreachable code" {
+		t.Errorf("got %v", warnings)
+	}
+}
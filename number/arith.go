@@ -0,0 +1,125 @@
+package number
+
+import "math"
+
+/*
+算术运算和位运算，与 lua.h 中的 LUA_OPADD 等一致
+*/
+const (
+	LUA_OPADD = iota
+	LUA_OPSUB
+	LUA_OPMUL
+	LUA_OPMOD
+	LUA_OPPOW
+	LUA_OPDIV
+	LUA_OPIDIV
+	LUA_OPBAND
+	LUA_OPBOR
+	LUA_OPBXOR
+	LUA_OPSHL
+	LUA_OPSHR
+	LUA_OPUNM
+	LUA_OPBNOT
+)
+
+/*
+按照 Lua 5.3 的语义（luaO_arith）计算 x op y，x 和 y 只能是 int64 或 float64；
+与 luac 一样，一元运算的 y 传入整数 0。
+运算在运行时会出错（位运算的操作数不能转换成整数、整数除以 0）时返回 false
+*/
+func Arith(op int, x, y interface{}) (interface{}, bool) {
+	switch op {
+	case LUA_OPBAND, LUA_OPBOR, LUA_OPBXOR, LUA_OPSHL, LUA_OPSHR, LUA_OPBNOT:
+		i1, ok1 := toInteger(x)
+		i2, ok2 := toInteger(y)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		switch op {
+		case LUA_OPBAND:
+			return i1 & i2, true
+		case LUA_OPBOR:
+			return i1 | i2, true
+		case LUA_OPBXOR:
+			return i1 ^ i2, true
+		case LUA_OPSHL:
+			return ShiftLeft(i1, i2), true
+		case LUA_OPSHR:
+			return ShiftRight(i1, i2), true
+		default:
+			return ^i1, true
+		}
+	}
+
+	i1, isInt1 := x.(int64)
+	i2, isInt2 := y.(int64)
+	if isInt1 && isInt2 {
+		switch op {
+		case LUA_OPADD:
+			return i1 + i2, true
+		case LUA_OPSUB:
+			return i1 - i2, true
+		case LUA_OPMUL:
+			return i1 * i2, true
+		case LUA_OPMOD:
+			if i2 == 0 {
+				return nil, false
+			}
+			return IMod(i1, i2), true
+		case LUA_OPIDIV:
+			if i2 == 0 {
+				return nil, false
+			}
+			return IFloorDiv(i1, i2), true
+		case LUA_OPUNM:
+			return -i1, true
+		}
+	}
+
+	f1, ok1 := toFloat(x)
+	f2, ok2 := toFloat(y)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	switch op {
+	case LUA_OPADD:
+		return f1 + f2, true
+	case LUA_OPSUB:
+		return f1 - f2, true
+	case LUA_OPMUL:
+		return f1 * f2, true
+	case LUA_OPMOD:
+		return FMod(f1, f2), true
+	case LUA_OPPOW:
+		return math.Pow(f1, f2), true
+	case LUA_OPDIV:
+		return f1 / f2, true
+	case LUA_OPIDIV:
+		return FFloorDiv(f1, f2), true
+	case LUA_OPUNM:
+		return -f1, true
+	}
+	return nil, false
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch x := val.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	default:
+		return 0, false
+	}
+}
+
+func toInteger(val interface{}) (int64, bool) {
+	switch x := val.(type) {
+	case int64:
+		return x, true
+	case float64:
+		return FloatToInteger(x)
+	default:
+		return 0, false
+	}
+}
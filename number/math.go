@@ -0,0 +1,80 @@
+package number
+
+import "math"
+
+/*
+整数的向下取整除法，对应 Lua 中的 //，除数不能为 0
+*/
+func IFloorDiv(a, b int64) int64 {
+	if a > 0 && b > 0 || a < 0 && b < 0 || a%b == 0 {
+		return a / b
+	}
+	return a/b - 1
+}
+
+/*
+浮点数的向下取整除法
+*/
+func FFloorDiv(a, b float64) float64 {
+	return math.Floor(a / b)
+}
+
+/*
+整数取模，结果的符号与除数相同，除数不能为 0
+*/
+func IMod(a, b int64) int64 {
+	return a - IFloorDiv(a, b)*b
+}
+
+/*
+浮点数取模，结果的符号与除数相同，与 luai_nummod 一致
+*/
+func FMod(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if m*b < 0 {
+		m += b
+	}
+	return m
+}
+
+/*
+左移，n 为负数时右移；移动的位数的绝对值大于等于 64 时结果为 0
+*/
+func ShiftLeft(a, n int64) int64 {
+	if n >= 0 {
+		if n >= 64 {
+			return 0
+		}
+		return a << uint64(n)
+	}
+	if n <= -64 {
+		return 0
+	}
+	return ShiftRight(a, -n)
+}
+
+/*
+逻辑右移，n 为负数时左移
+*/
+func ShiftRight(a, n int64) int64 {
+	if n >= 0 {
+		if n >= 64 {
+			return 0
+		}
+		return int64(uint64(a) >> uint64(n))
+	}
+	if n <= -64 {
+		return 0
+	}
+	return ShiftLeft(a, -n)
+}
+
+/*
+把浮点数转换成整数，只有小数部分为 0 并且不超出整数范围时才能转换
+*/
+func FloatToInteger(f float64) (int64, bool) {
+	if f >= -(1<<63) && f < 1<<63 && math.Floor(f) == f {
+		return int64(f), true
+	}
+	return 0, false
+}
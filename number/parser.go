@@ -0,0 +1,187 @@
+package number
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+把字符串解析成整数，规则与 Lua 5.3 的 l_str2int 一致：
+允许前后的空白和负号，十六进制整数溢出时回绕，十进制整数溢出时解析失败（应当改为解析成浮点数）
+*/
+func ParseInteger(str string) (int64, bool) {
+	str = strings.TrimSpace(str)
+	neg := false
+	if strings.HasPrefix(str, "-") {
+		neg, str = true, str[1:]
+	}
+	if str == "" {
+		return 0, false
+	}
+
+	var a uint64
+	if isHexPrefix(str) {
+		str = str[2:]
+		if str == "" {
+			return 0, false
+		}
+		for i := 0; i < len(str); i++ {
+			d, ok := hexDigit(str[i])
+			if !ok {
+				return 0, false
+			}
+			a = a*16 + uint64(d)
+		}
+	} else {
+		for i := 0; i < len(str); i++ {
+			c := str[i]
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+			d := uint64(c - '0')
+			// 超出 int64 的范围，负数可以多 1
+			if a >= maxBy10 && (a > maxBy10 || d > maxLastD+negD(neg)) {
+				return 0, false
+			}
+			a = a*10 + d
+		}
+	}
+	if neg {
+		a = -a
+	}
+	return int64(a), true
+}
+
+/*
+把字符串解析成浮点数，规则与 Lua 5.3 的 l_str2d 一致：
+支持十进制和十六进制（可以没有指数部分）的写法，不接受 inf 和 nan，溢出时得到无穷大
+*/
+func ParseFloat(str string) (float64, bool) {
+	str = strings.TrimSpace(str)
+	if strings.ContainsAny(str, "nN") {
+		return 0, false
+	}
+	neg := false
+	body := str
+	if strings.HasPrefix(body, "-") || strings.HasPrefix(body, "+") {
+		neg, body = body[0] == '-', body[1:]
+	}
+	if isHexPrefix(body) {
+		f, ok := parseHexFloat(body[2:])
+		if neg {
+			f = -f
+		}
+		return f, ok
+	}
+	if body == "" || strings.ContainsAny(body, "xX_") {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			return f, true
+		}
+		return 0, false
+	}
+	return f, true
+}
+
+/*
+解析十六进制浮点数（不含 0x 前缀），与 lua_strx2number 一样最多保留 30 位有效数字
+*/
+func parseHexFloat(str string) (float64, bool) {
+	const maxSigDig = 30
+	r, e := 0.0, 0
+	sigDig, nonSigDig := 0, 0
+	hasDot, any := false, false
+	i := 0
+	for ; i < len(str); i++ {
+		c := str[i]
+		if c == '.' {
+			if hasDot {
+				break
+			}
+			hasDot = true
+			continue
+		}
+		d, ok := hexDigit(c)
+		if !ok {
+			break
+		}
+		any = true
+		if sigDig == 0 && d == 0 {
+			// 前导的 0 不计入有效数字
+			nonSigDig++
+		} else if sigDig++; sigDig <= maxSigDig {
+			r = r*16 + float64(d)
+		} else {
+			// 有效数字过多，忽略之后的数字，只修正指数
+			e++
+		}
+		if hasDot {
+			e--
+		}
+	}
+	if !any {
+		return 0, false
+	}
+	e *= 4
+
+	if i < len(str) && (str[i] == 'p' || str[i] == 'P') {
+		i++
+		exp, expNeg := 0, false
+		if i < len(str) && (str[i] == '+' || str[i] == '-') {
+			expNeg = str[i] == '-'
+			i++
+		}
+		if i >= len(str) {
+			return 0, false
+		}
+		for ; i < len(str); i++ {
+			c := str[i]
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+			if exp < 1<<20 {
+				exp = exp*10 + int(c-'0')
+			}
+		}
+		if expNeg {
+			exp = -exp
+		}
+		e += exp
+	}
+	if i != len(str) {
+		return 0, false
+	}
+	return math.Ldexp(r, e), true
+}
+
+const (
+	maxBy10  = math.MaxInt64 / 10
+	maxLastD = math.MaxInt64 % 10
+)
+
+func negD(neg bool) uint64 {
+	if neg {
+		return 1
+	}
+	return 0
+}
+
+func isHexPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+func hexDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package optimizer
+
+import (
+	. "lua-vm/binchunk"
+	"lua-vm/number"
+	. "lua-vm/vm"
+	"math"
+)
+
+/*
+常量折叠：B 和 C 都是数字常量的算术和位运算指令在编译期求值，替换成 LOADK；
+与 luac 中的 constfolding 一致，不折叠除数为 0 的除法和取模、无法转换成整数的位运算，
+以及结果为 NaN 或 0 的浮点运算
+*/
+func (self *optimizer) foldConstants() bool {
+	f := self.f
+	changed := false
+	for pc := range f.Code {
+		i := self.instruction(pc)
+		op := i.Opcode()
+		if op < OP_ADD || op > OP_SHR {
+			continue
+		}
+		a, b, c := i.ABC()
+		if !ISK(b) || !ISK(c) {
+			continue
+		}
+		val, ok := arith(op, f.Constants[INDEXK(b)].Value, f.Constants[INDEXK(c)].Value)
+		if !ok {
+			continue
+		}
+		if idx := self.constant(val); idx <= MAXARG_Bx {
+			f.Code[pc] = uint32(CreateABx(OP_LOADK, a, idx))
+			changed = true
+		}
+	}
+	return changed
+}
+
+/*
+返回常量在常量表中的索引，不存在时加入常量表
+*/
+func (self *optimizer) constant(val interface{}) int {
+	k := NewConstant(val)
+	for i, x := range self.f.Constants {
+		if x.Tag == k.Tag && x.Value == k.Value {
+			return i
+		}
+	}
+	self.f.Constants = append(self.f.Constants, k)
+	return len(self.f.Constants) - 1
+}
+
+/*
+按照 Lua 5.3 的语义计算 x op y，x 和 y 只能是 int64 或 float64；不能在编译期求值时返回 false
+*/
+func arith(op int, x, y interface{}) (interface{}, bool) {
+	if (op == OP_DIV || op == OP_IDIV || op == OP_MOD) && (y == int64(0) || y == float64(0)) {
+		return nil, false
+	}
+	val, ok := number.Arith(number.LUA_OPADD+op-OP_ADD, x, y)
+	if n, isFloat := val.(float64); ok && isFloat && (math.IsNaN(n) || n == 0) {
+		return nil, false
+	}
+	return val, ok
+}
@@ -0,0 +1,37 @@
+package optimizer
+
+import (
+	. "lua-vm/vm"
+)
+
+/*
+跳转穿透：如果 JMP 的目标是一条不关闭 Upvalue 的无条件 JMP，那么直接跳到后者的目标，
+对跳转链重复这一过程；FORPREP、FORLOOP 和 TFORLOOP 的目标保持不变
+*/
+func (self *optimizer) threadJumps() bool {
+	f := self.f
+	changed := false
+	for pc := range f.Code {
+		i := self.instruction(pc)
+		if i.Opcode() != OP_JMP {
+			continue
+		}
+		a, sbx := i.AsBx()
+		target := pc + 1 + sbx
+		visited := map[int]bool{pc: true}
+		for !visited[target] {
+			visited[target] = true
+			j := self.instruction(target)
+			ja, jsbx := j.AsBx()
+			if j.Opcode() != OP_JMP || ja != 0 {
+				break
+			}
+			target = target + 1 + jsbx
+		}
+		if target != pc+1+sbx {
+			f.Code[pc] = uint32(CreateAsBx(OP_JMP, a, target-(pc+1)))
+			changed = true
+		}
+	}
+	return changed
+}
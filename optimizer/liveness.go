@@ -0,0 +1,57 @@
+package optimizer
+
+import "lua-vm/analysis"
+
+/*
+活跃变量分析，返回每条指令执行之后仍然活跃（之后还会被读取）的寄存器
+*/
+func (self *optimizer) liveness(cfg *analysis.CFG) [][256]bool {
+	n := len(cfg.Blocks)
+	uses := make([][]int, len(self.f.Code))
+	defs := make([][]int, len(self.f.Code))
+	for pc := range self.f.Code {
+		uses[pc], defs[pc] = analysis.RegisterUses(self.f, pc)
+	}
+	// 从 live 开始，逆序经过 pc 处的指令，得到执行它之前活跃的寄存器
+	transfer := func(live *[256]bool, pc int) {
+		for _, r := range defs[pc] {
+			live[r] = false
+		}
+		for _, r := range uses[pc] {
+			live[r] = true
+		}
+	}
+
+	liveIn := make([][256]bool, n)
+	blockOut := make([][256]bool, n)
+	for changed := true; changed; {
+		changed = false
+		for k := n - 1; k >= 0; k-- {
+			block := cfg.Blocks[k]
+			var live [256]bool
+			for _, succ := range block.Succs {
+				for r := range live {
+					live[r] = live[r] || liveIn[succ.Index][r]
+				}
+			}
+			blockOut[k] = live
+			for pc := block.Last(); pc >= block.Start; pc-- {
+				transfer(&live, pc)
+			}
+			if live != liveIn[k] {
+				liveIn[k] = live
+				changed = true
+			}
+		}
+	}
+
+	liveOut := make([][256]bool, len(self.f.Code))
+	for k, block := range cfg.Blocks {
+		live := blockOut[k]
+		for pc := block.Last(); pc >= block.Start; pc-- {
+			liveOut[pc] = live
+			transfer(&live, pc)
+		}
+	}
+	return liveOut
+}
@@ -0,0 +1,105 @@
+package optimizer
+
+import (
+	"lua-vm/analysis"
+	. "lua-vm/vm"
+)
+
+/*
+可以把写入的目标寄存器直接改成后面 MOVE 的目标的指令，它们只写入寄存器 A，
+并且写入之前就已经读取了全部操作数
+*/
+func retargetable(i Instruction) bool {
+	switch op := i.Opcode(); {
+	case op == OP_MOVE, op == OP_LOADK, op == OP_GETUPVAL, op == OP_GETTABUP, op == OP_GETTABLE,
+		op == OP_NEWTABLE, op == OP_UNM, op == OP_BNOT, op == OP_NOT, op == OP_LEN, op == OP_CONCAT:
+		return true
+	case op >= OP_ADD && op <= OP_SHR:
+		return true
+	case op == OP_LOADBOOL:
+		_, _, c := i.ABC()
+		return c == 0
+	}
+	return false
+}
+
+/*
+MOVE 消除：
+
+	MOVE A A 直接删除；
+	MOVE A B 之后紧跟的 MOVE B A 是多余的；
+	X T ...; MOVE A T 中，如果 T 在 MOVE 之后不再被使用，那么改写成 X A ... 并删除 MOVE。
+
+被子函数捕获的寄存器以及局部变量所在的寄存器不会被当作临时寄存器，
+这样 Upvalue 和调试信息中看到的值都保持不变
+*/
+func (self *optimizer) removeMoves() bool {
+	f := self.f
+	cfg := analysis.BuildCFG(f)
+	liveOut := self.liveness(cfg)
+	pinned := self.pinnedRegisters()
+	changed := false
+	touched := make([]bool, len(f.Code))
+
+	for pc := range f.Code {
+		i := self.instruction(pc)
+		if i.Opcode() != OP_MOVE || touched[pc] || self.pairedWithPrev(pc) {
+			continue
+		}
+		a, b, _ := i.ABC()
+		if a == b {
+			self.remove(pc)
+			touched[pc] = true
+			changed = true
+			continue
+		}
+		// MOVE 不能是基本块的第一条指令，否则它可能从别处跳转过来
+		if pc == 0 || cfg.BlockAt(pc).Start == pc || touched[pc-1] {
+			continue
+		}
+
+		prev := self.instruction(pc - 1)
+		pa, pb, _ := prev.ABC()
+		if prev.Opcode() == OP_MOVE && pa == b && pb == a {
+			self.remove(pc)
+			touched[pc] = true
+			changed = true
+			continue
+		}
+		if retargetable(prev) && pa == b && !pinned[b] && !liveOut[pc][b] {
+			f.Code[pc-1] = uint32(setA(prev, a))
+			self.remove(pc)
+			touched[pc-1], touched[pc] = true, true
+			changed = true
+		}
+	}
+	return changed
+}
+
+/*
+替换指令的 A 操作数，A 在所有指令模式中都位于第 6 到 13 位
+*/
+func setA(i Instruction, a int) Instruction {
+	return i&^(MAXARG_A<<6) | Instruction(a)<<6
+}
+
+/*
+返回不能被当作临时寄存器的寄存器：被子函数以 instack 方式捕获的寄存器以及局部变量所在的寄存器
+*/
+func (self *optimizer) pinnedRegisters() []bool {
+	f := self.f
+	pinned := make([]bool, 256)
+	for _, child := range f.Protos {
+		for _, upval := range child.Upvalues {
+			if upval.Instack == 1 {
+				pinned[upval.Idx] = true
+			}
+		}
+	}
+	for _, reg := range analysis.LocalRegisters(f) {
+		if reg < len(pinned) {
+			pinned[reg] = true
+		}
+	}
+	return pinned
+}
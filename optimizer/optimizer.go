@@ -0,0 +1,198 @@
+package optimizer
+
+import (
+	"fmt"
+	"lua-vm/analysis"
+	. "lua-vm/binchunk"
+	. "lua-vm/vm"
+)
+
+/*
+对函数原型（包括所有子函数）进行窥孔优化和死代码消除，返回优化之后的副本，原来的 Prototype 保持不变：
+
+	跳转穿透：跳转到无条件 JMP 的跳转直接指向最终的目标
+	常量折叠：两个操作数都是数字常量的算术和位运算指令替换成 LOADK
+	MOVE 消除：删除 MOVE A A，并把只被 MOVE 使用一次的临时寄存器合并到 MOVE 的目标中
+	死代码消除：删除不可达的基本块以及跳转到下一条指令的 JMP
+	寄存器收缩：根据剩下的指令重新计算 MaxStackSize
+
+删除指令之后，跳转偏移、行号表以及局部变量的 pc 范围都会被相应地修正。
+输入会先经过 Verify 检查，优化的结果也会再次检查，确保它仍然是合法的 5.3 字节码
+*/
+func Optimize(f *Prototype) (*Prototype, error) {
+	if err := Verify(f); err != nil {
+		return nil, err
+	}
+	p := optimizeProto(f)
+	if err := Verify(p); err != nil {
+		return nil, fmt.Errorf("optimize: %v", err)
+	}
+	return p, nil
+}
+
+func optimizeProto(f *Prototype) *Prototype {
+	p := *f
+	p.Code = append([]uint32(nil), f.Code...)
+	p.Constants = append([]Constant(nil), f.Constants...)
+	if f.LineInfo != nil {
+		p.LineInfo = append([]uint32(nil), f.LineInfo...)
+	}
+	if f.LocVars != nil {
+		p.LocVars = append([]LocVar(nil), f.LocVars...)
+	}
+	p.Protos = nil
+	for _, child := range f.Protos {
+		p.Protos = append(p.Protos, optimizeProto(child))
+	}
+
+	o := &optimizer{f: &p}
+	for {
+		changed := o.foldConstants()
+		changed = o.threadJumps() || changed
+		changed = o.removeMoves() || changed
+		changed = o.removeDeadCode() || changed
+		if !changed {
+			break
+		}
+	}
+	if size := analysis.StackSize(&p); size < int(p.MaxStackSize) {
+		p.MaxStackSize = byte(size)
+	}
+	return &p
+}
+
+/*
+优化过程中的状态，dead 记录了本轮中被删除的指令，每一轮结束时统一从指令表中移除
+*/
+type optimizer struct {
+	f    *Prototype
+	dead []bool
+}
+
+func (self *optimizer) instruction(pc int) Instruction {
+	return Instruction(self.f.Code[pc])
+}
+
+/*
+标记 pc 处的指令为待删除
+*/
+func (self *optimizer) remove(pc int) {
+	if self.dead == nil {
+		self.dead = make([]bool, len(self.f.Code))
+	}
+	self.dead[pc] = true
+}
+
+/*
+判断 pc 处的指令是否必须紧跟在前一条指令之后：
+test 之后的 JMP、LOADKX 和 SETLIST 之后的 EXTRAARG、TFORCALL 之后的 TFORLOOP，
+以及 C 不为 0 的 LOADBOOL 所跳过的指令
+*/
+func (self *optimizer) pairedWithPrev(pc int) bool {
+	if pc == 0 {
+		return false
+	}
+	prev := self.instruction(pc - 1)
+	_, _, c := prev.ABC()
+	switch prev.Opcode() {
+	case OP_LOADKX, OP_TFORCALL:
+		return true
+	case OP_SETLIST:
+		return c == 0
+	case OP_LOADBOOL:
+		return c != 0
+	default:
+		return prev.IsTest()
+	}
+}
+
+/*
+死代码消除：删除不可达的指令，以及只跳到下一条指令、也不关闭 Upvalue 的 JMP；
+最后一条 RETURN 总是保留，与其前一条指令成对出现的指令随前一条指令一起保留。
+之后把所有被标记的指令从指令表中移除
+*/
+func (self *optimizer) removeDeadCode() bool {
+	f := self.f
+	cfg := analysis.BuildCFG(f)
+	for _, block := range cfg.Blocks {
+		if !block.Reachable() {
+			for pc := block.Start; pc < block.End; pc++ {
+				self.remove(pc)
+			}
+		}
+	}
+	for pc := range f.Code {
+		i := self.instruction(pc)
+		if a, sbx := i.AsBx(); i.Opcode() == OP_JMP && a == 0 && sbx == 0 && !self.pairedWithPrev(pc) {
+			// 保留泛型 for 循环开头跳到 TFORCALL 的 JMP，维持 luac 生成的代码结构
+			if pc+1 < len(f.Code) && self.instruction(pc+1).Opcode() == OP_TFORCALL {
+				continue
+			}
+			self.remove(pc)
+		}
+	}
+	if self.dead == nil {
+		return false
+	}
+
+	self.dead[len(f.Code)-1] = false
+	for pc := 1; pc < len(f.Code); pc++ {
+		if !self.dead[pc-1] && self.pairedWithPrev(pc) {
+			self.dead[pc] = false
+		}
+	}
+	changed := false
+	for _, dead := range self.dead {
+		changed = changed || dead
+	}
+	if changed {
+		self.compact()
+	}
+	self.dead = nil
+	return changed
+}
+
+/*
+移除被标记的指令，并修正跳转偏移、行号表以及局部变量的 pc 范围；
+指向被删除指令的跳转改为指向其后第一条保留的指令
+*/
+func (self *optimizer) compact() {
+	f := self.f
+	n := len(f.Code)
+	newPc := make([]int, n+1)
+	k := 0
+	for pc := 0; pc < n; pc++ {
+		newPc[pc] = k
+		if !self.dead[pc] {
+			k++
+		}
+	}
+	newPc[n] = k
+
+	code := make([]uint32, 0, k)
+	var lineInfo []uint32
+	for pc := 0; pc < n; pc++ {
+		if self.dead[pc] {
+			continue
+		}
+		i := self.instruction(pc)
+		switch op := i.Opcode(); op {
+		case OP_JMP, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP:
+			a, sbx := i.AsBx()
+			target := newPc[pc+1+sbx]
+			i = CreateAsBx(op, a, target-(newPc[pc]+1))
+		}
+		code = append(code, uint32(i))
+		if pc < len(f.LineInfo) {
+			lineInfo = append(lineInfo, f.LineInfo[pc])
+		}
+	}
+	f.Code = code
+	if f.LineInfo != nil {
+		f.LineInfo = lineInfo
+	}
+	for i := range f.LocVars {
+		f.LocVars[i].StartPc = uint32(newPc[f.LocVars[i].StartPc])
+		f.LocVars[i].EndPc = uint32(newPc[f.LocVars[i].EndPc])
+	}
+}
@@ -0,0 +1,160 @@
+package optimizer
+
+import (
+	"lua-vm/asm"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+func assemble(t *testing.T, src string) *Prototype {
+	t.Helper()
+	proto, err := asm.Assemble(strings.NewReader(src), "t.lasm")
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	return proto
+}
+
+/*
+返回 "[行号] 操作码 操作数" 形式的指令列表，省略注释
+*/
+func code(p *Prototype) string {
+	var b strings.Builder
+	Disassemble(&b, p, DisasmOptions{})
+	var list []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(strings.SplitN(line, ";", 2)[0])
+		list = append(list, strings.Join(fields[1:], " "))
+	}
+	return strings.Join(list, "\n")
+}
+
+func optimize(t *testing.T, p *Prototype, want string) *Prototype {
+	t.Helper()
+	before := code(p)
+	got, err := Optimize(p)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if code(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", code(got), want)
+	}
+	if code(p) != before {
+		t.Errorf("Optimize modified its input")
+	}
+	return got
+}
+
+func TestThreadJumps(t *testing.T) {
+	p := assemble(t, `
+.maxstack 2
+.line 1
+	LOADK 0 #1
+	TEST 0 0
+.line 2
+	JMP 0 a
+	LOADK 0 #2
+.line 3
+a:	JMP 0 b
+	LOADK 0 #3
+.line 4
+b:	RETURN 0 2
+`)
+	// JMP a 直接跳到 RETURN，之后 a 处的 JMP 只跳过死代码，与死代码一起被删除
+	optimize(t, p, `[1] LOADK 0 -1
+[1] TEST 0 0
+[2] JMP 0 1
+[2] LOADK 0 -2
+[4] RETURN 0 2`)
+}
+
+func TestFoldAndMoves(t *testing.T) {
+	p := assemble(t, `
+.maxstack 6
+.local x 1 5
+	ADD 0 #2 #3
+	MOVE 1 0
+	MOVE 1 1
+	RETURN 1 2
+	RETURN 0 1
+`)
+	// x 占用的寄存器 0 不能合并到 MOVE 中，MOVE 1 1 被删除
+	got := optimize(t, p, `[-] LOADK 0 -3
+[-] MOVE 1 0
+[-] RETURN 1 2
+[-] RETURN 0 1`)
+	if k := got.Constants[2].Value; k != int64(5) {
+		t.Errorf("folded constant is %#v, want 5", k)
+	}
+	if got.MaxStackSize != 2 {
+		t.Errorf("MaxStackSize is %d, want 2", got.MaxStackSize)
+	}
+	if v := got.LocVars[0]; v.StartPc != 0 || v.EndPc != 3 {
+		t.Errorf("x lives in [%d, %d), want [0, 3)", v.StartPc, v.EndPc)
+	}
+}
+
+func TestDeadCode(t *testing.T) {
+	p := assemble(t, `
+	LOADK 2 #1
+	JMP 0 end
+	LOADK 1 #2
+	LOADK 0 #3
+end:	RETURN 0 1
+`)
+	got := optimize(t, p, `[-] LOADK 2 -1
+[-] RETURN 0 1`)
+	if got.MaxStackSize != 3 {
+		t.Errorf("MaxStackSize is %d, want 3", got.MaxStackSize)
+	}
+}
+
+func TestNoFold(t *testing.T) {
+	// 整数除以 0 是运行时错误，不能折叠
+	p := assemble(t, `
+	IDIV 0 #1 #0
+	RETURN 0 2
+`)
+	optimize(t, p, `[-] IDIV 0 -1 -2
+[-] RETURN 0 2`)
+}
+
+func TestOptimizeInvalid(t *testing.T) {
+	p := assemble(t, `
+	LOADK 0 #1
+	JMP 0 l1
+l2:	RETURN 0 1
+l1:	JMP 0 l2
+`)
+	if _, err := Optimize(p); err == nil || !strings.Contains(err.Error(), "code does not end with RETURN") {
+		t.Errorf("got error %v, want code does not end with RETURN", err)
+	}
+}
+
+func TestOptimizeCompiled(t *testing.T) {
+	src := `local t = {}
+for i = 1, 10 do
+  if i % 2 == 0 then t[#t + 1] = i * (2 ^ 3) else goto continue end
+  ::continue::
+end
+local function f(a, b) local c = a; return c + 1 - 1, b end
+return f(t[1], 3 // 2)
+`
+	proto, err := compiler.Compile(src, "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	got, err := Optimize(proto)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	again := Undump(Dump(got))
+	if code(again) != code(got) {
+		t.Errorf("round trip changed the code:\n%s\nwant\n%s", code(again), code(got))
+	}
+}
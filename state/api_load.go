@@ -0,0 +1,80 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	. "lua-vm/api"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+)
+
+/*
+加载 chunk 并把得到的函数压入栈顶，返回 LUA_OK；与 lua_load 一样根据第一个字节是否为 LUA_SIGNATURE 的第一个字节
+判断 chunk 是二进制的还是文本的。mode 为 "b"、"t" 或 "bt"，分别表示只允许二进制 chunk、只允许文本 chunk
+以及两者都允许，为空时等同于 "bt"；加载不可信的代码时应当使用 "t"，因为格式正确但内容恶意的字节码可以破坏解释器。
+chunkName 为函数原型的 Source，为空时使用 "?"。
+chunk 不符合 mode、有语法错误或者二进制格式错误（包括二进制 chunk 之后还有多余的字节）时不会 panic，而是把错误信息压入栈顶并返回 LUA_ERRSYNTAX
+*/
+func (self *luaState) Load(chunk []byte, chunkName, mode string) (status int) {
+	if chunkName == "" {
+		chunkName = "?"
+	}
+	if mode == "" {
+		mode = "bt"
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			self.stack.push(fmt.Sprint(r))
+			status = LUA_ERRSYNTAX
+		}
+	}()
+
+	var proto *Prototype
+	var err error
+	if len(chunk) > 0 && chunk[0] == LUA_SIGNATURE[0] {
+		if err = checkMode(mode, "binary"); err == nil {
+			r := bytes.NewReader(chunk)
+			proto, err = UndumpReader(r, DefaultLimits)
+			if err == nil && r.Len() != 0 {
+				err = fmt.Errorf("%d extra bytes after the chunk", r.Len())
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: bad binary format (%v)", binaryName(chunkName), err)
+			}
+		}
+	} else {
+		if err = checkMode(mode, "text"); err == nil {
+			proto, err = compiler.Compile(string(chunk), chunkName)
+		}
+	}
+	if err != nil {
+		self.stack.push(err.Error())
+		return LUA_ERRSYNTAX
+	}
+	self.stack.push(newLuaClosure(proto))
+	return LUA_OK
+}
+
+/*
+与 ldo.c 的 checkmode 一致，what 为 "binary" 或 "text"
+*/
+func checkMode(mode, what string) error {
+	if !strings.Contains(mode, what[:1]) {
+		return fmt.Errorf("attempt to load a %s chunk (mode is '%s')", what, mode)
+	}
+	return nil
+}
+
+/*
+与 lundump.c 一致，二进制 chunk 的错误信息中的 chunk 名：去掉 @ 或 = 前缀，以 LUA_SIGNATURE 开头时为 "binary string"
+*/
+func binaryName(chunkName string) string {
+	switch {
+	case strings.HasPrefix(chunkName, "@"), strings.HasPrefix(chunkName, "="):
+		return chunkName[1:]
+	case strings.HasPrefix(chunkName, LUA_SIGNATURE[:1]):
+		return "binary string"
+	}
+	return chunkName
+}
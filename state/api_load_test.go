@@ -0,0 +1,55 @@
+package state
+
+import (
+	. "lua-vm/api"
+	. "lua-vm/binchunk"
+	"lua-vm/compiler"
+	"strings"
+	"testing"
+)
+
+func TestLoadBinary(t *testing.T) {
+	proto, err := compiler.Compile("return 1 + 2", "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	chunk := Dump(proto)
+
+	ls := New()
+	if status := ls.Load(chunk, "@test.lua", "b"); status != LUA_OK {
+		t.Fatalf("Load: %s", ls.ToString(-1))
+	}
+	if tp := ls.Type(-1); tp != LUA_TFUNCTION {
+		t.Fatalf("got %s, want function", ls.TypeName(tp))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	proto, err := compiler.Compile("return 1", "@test.lua")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	chunk := Dump(proto)
+
+	tests := []struct {
+		chunk []byte
+		mode  string
+		msg   string
+	}{
+		{append(chunk[:len(chunk):len(chunk)], 0, 0), "bt", "test.lua: bad binary format (2 extra bytes after the chunk)"},
+		{chunk[:len(chunk)-1], "bt", "test.lua: bad binary format"},
+		{chunk, "t", "attempt to load a binary chunk (mode is 't')"},
+		{[]byte("return 1"), "b", "attempt to load a text chunk (mode is 'b')"},
+		{[]byte("return +"), "bt", "test.lua:1:"},
+	}
+	for _, tt := range tests {
+		ls := New()
+		if status := ls.Load(tt.chunk, "@test.lua", tt.mode); status != LUA_ERRSYNTAX {
+			t.Errorf("Load(%q, %q): got status %d, want LUA_ERRSYNTAX", tt.chunk, tt.mode, status)
+			continue
+		}
+		if msg := ls.ToString(-1); !strings.Contains(msg, tt.msg) {
+			t.Errorf("Load(%q, %q): got error %q, want %q", tt.chunk, tt.mode, msg, tt.msg)
+		}
+	}
+}
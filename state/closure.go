@@ -0,0 +1,14 @@
+package state
+
+import . "lua-vm/binchunk"
+
+/*
+Lua 函数，目前只有函数原型，还没有 Upvalue
+*/
+type closure struct {
+	proto *Prototype
+}
+
+func newLuaClosure(proto *Prototype) *closure {
+	return &closure{proto: proto}
+}
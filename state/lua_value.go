@@ -10,6 +10,7 @@ import . "lua-vm/api"
 	integer int64
 	float   float64
 	string  string
+	function *closure
 */
 type luaValue interface{}
 
@@ -28,6 +29,8 @@ func typeOf(val luaValue) LuaType {
 		return LUA_TNUMBER
 	case string:
 		return LUA_TSTRING
+	case *closure:
+		return LUA_TFUNCTION
 	default:
 		panic("Todo")
 	}
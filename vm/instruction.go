@@ -1,13 +1,94 @@
 package vm
 
+import "fmt"
+
+// A 操作数所能表示的最大数
+const MAXARG_A = 1<<8 - 1
+
+// B、C 操作数所能表示的最大数，作为 RK 操作数时第 9 位表示常量
+const MAXARG_B = 1<<9 - 1
+const MAXARG_C = 1<<9 - 1
+
+// Ax 操作数所能表示的最大数
+const MAXARG_Ax = 1<<26 - 1
+
 // Bx 操作数所能表示的最大数，其整体范围为 [0, 262143]
 const MAXARG_Bx = 1<<18 - 1
 
 // sBx 操作数所能表示的最大数，其整体范围为 [-131071, 131072]
 const MAXARG_sBx = MAXARG_Bx >> 1
 
+// RK 操作数的第 9 位为 1 时表示常量表索引，否则表示寄存器索引
+const BITRK = 1 << 8
+
+// RK 操作数所能表示的最大常量表索引
+const MAXINDEXRK = BITRK - 1
+
 type Instruction uint32
 
+/*
+判断 RK 操作数是否表示常量表索引
+*/
+func ISK(x int) bool {
+	return x&BITRK != 0
+}
+
+/*
+从 RK 操作数中取出常量表索引
+*/
+func INDEXK(x int) int {
+	return x & ^BITRK
+}
+
+/*
+检查操作码是否属于指定的模式，以及操作数是否落在 [min, max] 中，不满足时 panic
+*/
+func checkOperand(op int, mode byte, name string, x, min, max int) {
+	if op < 0 || op >= NUM_OPCODES || opcodes[op].opMode != mode {
+		panic(fmt.Sprintf("Instruction Error: bad opcode %d for this mode", op))
+	}
+	if x < min || x > max {
+		panic(fmt.Sprintf("Instruction Error: %s %s = %d out of range [%d, %d]",
+			opcodes[op].name, name, x, min, max))
+	}
+}
+
+/*
+创建一条 iABC 模式的指令，与 ABC 相反
+*/
+func CreateABC(op, a, b, c int) Instruction {
+	checkOperand(op, IABC, "A", a, 0, MAXARG_A)
+	checkOperand(op, IABC, "B", b, 0, MAXARG_B)
+	checkOperand(op, IABC, "C", c, 0, MAXARG_C)
+	return Instruction(op | a<<6 | c<<14 | b<<23)
+}
+
+/*
+创建一条 iABx 模式的指令，与 ABx 相反
+*/
+func CreateABx(op, a, bx int) Instruction {
+	checkOperand(op, IABx, "A", a, 0, MAXARG_A)
+	checkOperand(op, IABx, "Bx", bx, 0, MAXARG_Bx)
+	return Instruction(op | a<<6 | bx<<14)
+}
+
+/*
+创建一条 iAsBx 模式的指令，与 AsBx 相反，sBx 以加上 MAXARG_sBx 之后的形式保存
+*/
+func CreateAsBx(op, a, sbx int) Instruction {
+	checkOperand(op, IAsBx, "A", a, 0, MAXARG_A)
+	checkOperand(op, IAsBx, "sBx", sbx, -MAXARG_sBx, MAXARG_Bx-MAXARG_sBx)
+	return Instruction(op | a<<6 | (sbx+MAXARG_sBx)<<14)
+}
+
+/*
+创建一条 iAx 模式的指令，与 Ax 相反
+*/
+func CreateAx(op, ax int) Instruction {
+	checkOperand(op, IAx, "Ax", ax, 0, MAXARG_Ax)
+	return Instruction(op | ax<<6)
+}
+
 /*
 取指令的低 6 位，也就是操作码部分
 */
@@ -49,30 +130,63 @@ func (self Instruction) Ax() int {
 	return int(self >> 6)
 }
 
+/*
+判断当前指令的操作码是否是合法的 Lua 5.3 指令，
+6 比特的操作码可以表示 64 个值，但只有 0～46 有意义
+*/
+func (self Instruction) IsValid() bool {
+	return self.Opcode() < NUM_OPCODES
+}
+
+/*
+获得当前指令对应的基本信息，对于非法的操作码返回一个不使用任何操作数的占位信息，
+防止越界访问 opcodes 表
+*/
+func (self Instruction) info() opcode {
+	if !self.IsValid() {
+		return unknownOpcode
+	}
+	return opcodes[self.Opcode()]
+}
+
 /*
 用于获得当前指令的名字
 */
 func (self Instruction) OpName() string {
-	return opcodes[self.Opcode()].name
+	return self.info().name
 }
 
 /*
 用于获得当前指令的模式
 */
 func (self Instruction) OpMode() byte {
-	return opcodes[self.Opcode()].opMode
+	return self.info().opMode
 }
 
 /*
 用于获得 B 操作数的模式
 */
 func (self Instruction) BMode() byte {
-	return opcodes[self.Opcode()].argBMode
+	return self.info().argBMode
 }
 
 /*
 用于获得 C 操作数的模式
 */
 func (self Instruction) CMode() byte {
-	return opcodes[self.Opcode()].argCMode
+	return self.info().argCMode
+}
+
+/*
+判断当前指令是否是一个 test，如果是那么下一条指令必须是 JMP
+*/
+func (self Instruction) IsTest() bool {
+	return self.info().testFlag == 1
+}
+
+/*
+判断当前指令是否会设置寄存器 A
+*/
+func (self Instruction) SetsA() bool {
+	return self.info().setAFlag == 1
 }